@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// autoExplain turns on auto_explain on every target while queries are
+	// timed and attaches the slowest plans to the results.
+	autoExplain = false
+	// autoExplainMin is auto_explain.log_min_duration: executions faster
+	// than this are never logged, which keeps the logging overhead down.
+	autoExplainMin = 10 * time.Millisecond
+)
+
+// slowPlanShare is the fraction of executions whose plans are kept.
+const slowPlanShare = 0.01
+
+// autoExplainSettings are applied with ALTER SYSTEM while the run lasts.
+// auto_explain is added to the libraries already preloaded. Plans are
+// logged without ANALYZE so the timings aren't skewed by instrumentation.
+func autoExplainSettings(preload []string) map[string]string {
+	libraries := append([]string{}, preload...)
+	if !slices.Contains(libraries, "auto_explain") {
+		libraries = append(libraries, "auto_explain")
+	}
+	return map[string]string{
+		"session_preload_libraries":          strings.Join(libraries, ", "),
+		"auto_explain.log_min_duration":      strconv.FormatInt(autoExplainMin.Milliseconds(), 10),
+		"auto_explain.log_format":            "json",
+		"auto_explain.log_analyze":           "off",
+		"auto_explain.log_nested_statements": "off",
+	}
+}
+
+// listSettings take a list of values, each quoted on its own in ALTER SYSTEM.
+var listSettings = map[string]bool{"session_preload_libraries": true}
+
+// settingList splits a list setting as SHOW and pg_file_settings print it.
+func settingList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), `"`); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// alterSystemSet renders ALTER SYSTEM SET for one setting.
+func alterSystemSet(name, value string) string {
+	if !listSettings[name] {
+		return fmt.Sprintf("ALTER SYSTEM SET %s = %s", name, pq.QuoteLiteral(value))
+	}
+	items := settingList(value)
+	if len(items) == 0 {
+		return fmt.Sprintf("ALTER SYSTEM SET %s = ''", name)
+	}
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = pq.QuoteLiteral(item)
+	}
+	return fmt.Sprintf("ALTER SYSTEM SET %s = %s", name, strings.Join(quoted, ", "))
+}
+
+// alterSystemValues returns the settings set with ALTER SYSTEM, as
+// postgresql.auto.conf holds them.
+func alterSystemValues(t *benchTarget) (map[string]string, error) {
+	rows, err := t.DB.Query("SELECT name, setting FROM pg_file_settings WHERE sourcefile LIKE '%postgresql.auto.conf'")
+	if err != nil {
+		return nil, fmt.Errorf("could not read pg_file_settings: %v", err)
+	}
+	defer rows.Close()
+	values := map[string]string{}
+	for rows.Next() {
+		var name, setting string
+		if err := rows.Scan(&name, &setting); err != nil {
+			return nil, err
+		}
+		values[name] = setting
+	}
+	return values, rows.Err()
+}
+
+// enableAutoExplain switches auto_explain on for new sessions. The returned
+// restore puts back what ALTER SYSTEM had set for those settings before,
+// resetting the ones it hadn't, so the operator's own values survive.
+func enableAutoExplain(t *benchTarget) (restore func() error, err error) {
+	before, err := alterSystemValues(t)
+	if err != nil {
+		return nil, err
+	}
+	var preload string
+	if err := t.DB.QueryRow("SHOW session_preload_libraries").Scan(&preload); err != nil {
+		return nil, fmt.Errorf("could not read session_preload_libraries: %v", err)
+	}
+	settings := autoExplainSettings(settingList(preload))
+
+	restore = func() error {
+		var errs []string
+		for name := range settings {
+			stmt := fmt.Sprintf("ALTER SYSTEM RESET %s", name)
+			if value, ok := before[name]; ok {
+				stmt = alterSystemSet(name, value)
+			}
+			if _, err := t.DB.Exec(stmt); err != nil {
+				errs = append(errs, fmt.Sprintf("could not %s: %v", stmt, err))
+			}
+		}
+		if err := reloadSettings(t); err != nil {
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%s", strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
+	for name, value := range settings {
+		stmt := alterSystemSet(name, value)
+		if _, err := t.DB.Exec(stmt); err != nil {
+			if rerr := restore(); rerr != nil {
+				warnf("%s: %v", t.Label, rerr)
+			}
+			return nil, fmt.Errorf("could not %s: %v", stmt, err)
+		}
+	}
+	if err := reloadSettings(t); err != nil {
+		return nil, err
+	}
+	return restore, nil
+}
+
+// reloadSettings reloads the server configuration and recycles the idle
+// pooled connections so new sessions pick it up.
+func reloadSettings(t *benchTarget) error {
+	if _, err := t.DB.Exec("SELECT pg_reload_conf()"); err != nil {
+		return fmt.Errorf("could not reload configuration: %v", err)
+	}
+	// The postmaster reloads asynchronously
+	time.Sleep(500 * time.Millisecond)
+	t.DB.SetMaxIdleConns(0)
+	t.DB.SetMaxIdleConns(t.Config.maxIdleConns())
+	return nil
+}
+
+// onInterrupt runs cleanup and exits if the process is interrupted before
+// the returned stop is called, so settings changed on a server are put
+// back even when a run is cut short.
+func onInterrupt(cleanup func()) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			cleanup()
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
+
+// composeLogsAvailable reports why a target's server log can't be read.
+// Logs are read with docker compose, so only targets that are a running
+// compose service on this machine have them.
+func composeLogsAvailable(t *benchTarget) error {
+	if t.Service == "" {
+		return fmt.Errorf("it has no docker compose service")
+	}
+	switch t.Config.Host {
+	case "localhost", "127.0.0.1", "::1", "host.docker.internal", t.Service:
+	default:
+		return fmt.Errorf("it connects to %s rather than the local compose service %s", t.Config.Host, t.Service)
+	}
+	out, err := exec.Command("docker", "compose", "ps", "-q", t.Service).Output()
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		return fmt.Errorf("compose service %s is not running here", t.Service)
+	}
+	return nil
+}
+
+var autoExplainLine = regexp.MustCompile(`^(.*?)\s*(?:\[\d+\]\s*)?LOG:\s+duration: ([0-9.]+) ms\s+plan:\s*(.*)$`)
+
+// loggedPlan is one auto_explain entry scraped from a container log.
+type loggedPlan struct {
+	manifest.SlowPlan
+	QueryText string
+}
+
+// scrapeAutoExplain reads the plans a service logged since a point in time.
+func scrapeAutoExplain(service string, since time.Time) ([]loggedPlan, error) {
+	out, err := exec.Command("docker", "compose", "logs", "--no-log-prefix", "--since", since.UTC().Format(time.RFC3339), service).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not read logs of %s: %v", service, err)
+	}
+	return parseAutoExplainLog(out), nil
+}
+
+// parseAutoExplainLog finds "duration: X ms  plan:" entries; the JSON plan
+// follows on indented continuation lines.
+func parseAutoExplainLog(log []byte) []loggedPlan {
+	var plans []loggedPlan
+	var cur *loggedPlan
+	var body strings.Builder
+
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		var doc struct {
+			QueryText  string          `json:"Query Text"`
+			Parameters string          `json:"Query Parameters"`
+			Plan       json.RawMessage `json:"Plan"`
+		}
+		if err := json.Unmarshal([]byte(body.String()), &doc); err == nil && doc.Plan != nil {
+			cur.QueryText = doc.QueryText
+			cur.Parameters = doc.Parameters
+			cur.Plan = doc.Plan
+			plans = append(plans, *cur)
+		}
+		cur = nil
+		body.Reset()
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if cur != nil && (strings.HasPrefix(line, "\t") || strings.HasPrefix(line, " ")) {
+			body.WriteString(line)
+			body.WriteByte('\n')
+			continue
+		}
+		flush()
+		m := autoExplainLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		ms, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			continue
+		}
+		cur = &loggedPlan{SlowPlan: manifest.SlowPlan{Duration: time.Duration(ms * float64(time.Millisecond)), LoggedAt: m[1]}}
+		body.WriteString(m[3])
+	}
+	flush()
+	return plans
+}
+
+// pgParameters renders arguments the way auto_explain logs them.
+func pgParameters(args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = fmt.Sprintf("$%d = '%s'", i+1, strings.ReplaceAll(fmt.Sprint(a), "'", "''"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// attachSlowPlans keeps the slowest 1% of a target's timed executions that
+// auto_explain logged and attaches each to the result it came from. Plans
+// are matched by the target's statement and the arguments as bound.
+func attachSlowPlans(results []manifest.QueryResult, w *Workload, t *benchTarget, plans []loggedPlan) int {
+	executions := 0
+	for _, r := range results {
+		if r.Engine == t.Name {
+			executions += r.Stats.Count
+		}
+	}
+	keep := int(math.Ceil(float64(executions) * slowPlanShare))
+	sort.Slice(plans, func(i, j int) bool { return plans[i].Duration > plans[j].Duration })
+	if len(plans) > keep {
+		plans = plans[:keep]
+	}
+
+	queryBySQL := map[string]WorkloadQuery{}
+	for _, q := range w.Queries {
+		if stmt, ok := q.SQL[t.sqlName()]; ok {
+			queryBySQL[strings.TrimSpace(stmt)] = q
+		}
+	}
+	attached := 0
+	for _, p := range plans {
+		q, ok := queryBySQL[strings.TrimSpace(p.QueryText)]
+		if !ok {
+			continue
+		}
+		target := -1
+		for i, r := range results {
+			if r.Engine != t.Name || r.Query != q.Name {
+				continue
+			}
+			if target < 0 {
+				target = i
+			}
+			if p.Parameters != "" && p.Parameters == pgParameters(q.bind(t.sqlName(), r.Args)) {
+				target = i
+				break
+			}
+		}
+		if target >= 0 {
+			results[target].SlowPlans = append(results[target].SlowPlans, p.SlowPlan)
+			attached++
+		}
+	}
+	return attached
+}
+
+// runProfiledWorkload is runWorkload with auto_explain on around it when
+// -auto-explain is set, and the server logs read after it for -server-logs.
+func runProfiledWorkload(w *Workload, targets []*benchTarget, sp sampling, planProblems map[string][]string) []manifest.QueryResult {
+	if !autoExplain && !captureServerLogs {
+		return runWorkload(w, targets, sp, planProblems)
+	}
+
+	since := time.Now()
+	var enabled []*benchTarget
+	restores := map[*benchTarget]func() error{}
+	for _, t := range targets {
+		if !autoExplain {
+			break
+		}
+		if err := composeLogsAvailable(t); err != nil {
+			warnf("%s: auto_explain not enabled, its plans could not be read: %v", t.Label, err)
+			continue
+		}
+		restore, err := enableAutoExplain(t)
+		if err != nil {
+			warnf("%s: auto_explain not enabled: %v", t.Label, err)
+			continue
+		}
+		enabled = append(enabled, t)
+		restores[t] = restore
+	}
+	restoreAll := func() {
+		for _, t := range enabled {
+			if err := restores[t](); err != nil {
+				warnf("%s: could not restore the auto_explain settings: %v", t.Label, err)
+			}
+		}
+	}
+	stop := onInterrupt(restoreAll)
+	takeCellWindows()
+
+	results := runWorkload(w, targets, sp, planProblems)
+
+	stop()
+	restoreAll()
+	for _, t := range enabled {
+		plans, err := scrapeAutoExplain(t.Service, since)
+		if err != nil {
+			warnf("%s: %v", t.Label, err)
+			continue
+		}
+		n := attachSlowPlans(results, w, t, plans)
+		infof("📌 %s: attached %d slow plan(s) from %d auto_explain entries", t.Label, n, len(plans))
+	}
+	if captureServerLogs {
+		correlateServerLogs(results, w, targets, since)
+	}
+	return results
+}
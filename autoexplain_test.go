@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"pg_search_benchmark/manifest"
+	"pg_search_benchmark/searchquery"
+)
+
+// TestAttachSlowPlans matches plans logged by a config target, whose
+// statements are the parade ones and whose arguments are escaped before
+// binding, to the results of the argument sets they ran with.
+func TestAttachSlowPlans(t *testing.T) {
+	stmt := "SELECT id FROM products WHERE id @@@ paradedb.parse($1) LIMIT 10"
+	w := &Workload{Queries: []WorkloadQuery{{
+		Name:   "fulltext",
+		SQL:    map[string]string{"parade": stmt},
+		Args:   [][]interface{}{{"usb-c cable"}, {"AND OR"}},
+		Escape: map[string]string{"parade": "tantivy"},
+	}}}
+	target := &benchTarget{Name: "parade-tuned", SQLEngine: "parade"}
+	results := []manifest.QueryResult{
+		{Query: "fulltext", Engine: "parade-tuned", Args: []interface{}{"usb-c cable"}, Stats: manifest.LatencyStats{Count: 100}},
+		{Query: "fulltext", Engine: "parade-tuned", Args: []interface{}{"AND OR"}, Stats: manifest.LatencyStats{Count: 100}},
+	}
+	plan := func(d time.Duration, arg string) loggedPlan {
+		p := loggedPlan{QueryText: stmt}
+		p.Duration = d
+		p.Parameters = pgParameters([]interface{}{searchquery.Tantivy(arg)})
+		return p
+	}
+	plans := []loggedPlan{
+		plan(30*time.Millisecond, "AND OR"),
+		plan(20*time.Millisecond, "usb-c cable"),
+		// Beyond the slowest 1% of 200 executions
+		plan(10*time.Millisecond, "usb-c cable"),
+	}
+
+	if n := attachSlowPlans(results, w, target, plans); n != 2 {
+		t.Fatalf("attached %d plans, want 2", n)
+	}
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond} {
+		if got := results[i].SlowPlans; len(got) != 1 || got[0].Duration != want {
+			t.Errorf("%v: slow plans %+v, want one of %v", results[i].Args, got, want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// BaselinesDir holds named copies of the results files in the data
+// directory, one subdirectory per baseline.
+const BaselinesDir = "baselines"
+
+// compareTo names the baseline bench diffs its results against.
+var compareTo = ""
+
+// regressionThreshold is the relative mean latency change that is colored
+// as a regression or improvement.
+const regressionThreshold = 0.10
+
+func baselinePath(dir, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid baseline name %q", name)
+	}
+	return filepath.Join(dir, BaselinesDir, name), nil
+}
+
+// baselineCommand implements "baseline save|list|delete".
+func baselineCommand(args []string) {
+	fs := flag.NewFlagSet("baseline", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . baseline save|list|delete [name] [flags]")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	action, args := args[0], args[1:]
+	name := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	var err error
+	switch action {
+	case "save":
+		err = saveBaseline(dataDir, name)
+	case "list":
+		err = listBaselines(dataDir)
+	case "delete":
+		err = deleteBaseline(dataDir, name)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("Failed to %s baseline: %v", action, err)
+	}
+}
+
+// saveBaseline copies every results file in dir to the named baseline,
+// replacing it if it exists.
+func saveBaseline(dir, name string) error {
+	dest, err := baselinePath(dir, name)
+	if err != nil {
+		return err
+	}
+	files, err := filepath.Glob(resultsFile(dir, "*"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no results in %s, run a benchmark first", dir)
+	}
+
+	if err := os.RemoveAll(dest); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	for _, src := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dest, filepath.Base(src)), data, 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✅ Saved baseline %s (%d results file(s))\n", name, len(files))
+	return nil
+}
+
+func listBaselines(dir string) error {
+	entries, err := os.ReadDir(filepath.Join(dir, BaselinesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	table := newConsoleTable("Baseline", "Scenarios", "Saved")
+	saved := 0
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		saved++
+		files, _ := filepath.Glob(resultsFile(filepath.Join(dir, BaselinesDir, e.Name()), "*"))
+		var names []string
+		var newest time.Time
+		for _, path := range files {
+			f, err := manifest.Read(path)
+			if err != nil {
+				warnf("Warning: Skipping %s: %v", path, err)
+				continue
+			}
+			names = append(names, f.Metadata.Scenario)
+			if f.Metadata.SavedAt.After(newest) {
+				newest = f.Metadata.SavedAt
+			}
+		}
+		sort.Strings(names)
+		when := "-"
+		if !newest.IsZero() {
+			when = newest.Format(time.RFC3339)
+		}
+		table.addRow(e.Name(), strings.Join(names, ", "), when)
+	}
+	if saved == 0 {
+		fmt.Println("No baselines saved")
+		return nil
+	}
+	table.render(os.Stdout)
+	return nil
+}
+
+func deleteBaseline(dir, name string) error {
+	path, err := baselinePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no baseline named %s", name)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Deleted baseline %s\n", name)
+	return nil
+}
+
+// resultDelta is one query/argument set/engine's mean latency in a baseline
+// and now.
+type resultDelta struct {
+	Query, Args, Engine string
+	Base, Current       time.Duration
+}
+
+// Change is the relative change in mean latency; positive is slower.
+func (d resultDelta) Change() (float64, bool) {
+	if d.Base <= 0 || d.Current <= 0 {
+		return 0, false
+	}
+	return float64(d.Current-d.Base) / float64(d.Base), true
+}
+
+// diffQueries pairs up results by query, arguments and engine, in the
+// current order.
+// Results missing on either side get a zero latency.
+func diffQueries(base, current []manifest.QueryResult) []resultDelta {
+	key := func(r manifest.QueryResult) string { return r.Query + "/" + argsLabel(r.Args) + "/" + r.Engine }
+	mean := func(r manifest.QueryResult) time.Duration {
+		if r.Error != "" || r.Stats.Count == 0 {
+			return 0
+		}
+		return r.Stats.Mean
+	}
+
+	baseMeans := map[string]time.Duration{}
+	for _, r := range base {
+		baseMeans[key(r)] = mean(r)
+	}
+	var deltas []resultDelta
+	for _, r := range current {
+		deltas = append(deltas, resultDelta{Query: r.Query, Args: argsLabel(r.Args), Engine: r.Engine, Base: baseMeans[key(r)], Current: mean(r)})
+	}
+	return deltas
+}
+
+func formatChange(d resultDelta) string {
+	change, ok := d.Change()
+	if !ok {
+		return "-"
+	}
+	s := fmt.Sprintf("%+.1f%%", change*100)
+	switch {
+	case change >= regressionThreshold:
+		return colorize(s, ansiRed)
+	case change <= -regressionThreshold:
+		return colorize(s, ansiGreen)
+	}
+	return s
+}
+
+// compareToBaseline prints how the query scenarios in dir moved relative to
+// the named baseline.
+func compareToBaseline(dir, name string, targets []*benchTarget) error {
+	path, err := baselinePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no baseline named %s", name)
+	}
+
+	compared := 0
+	for _, scenario := range []string{"read-only", "mixed"} {
+		current, err := loadResults(dir, scenario)
+		if err != nil {
+			continue
+		}
+		base, err := loadResults(path, scenario)
+		if err != nil {
+			continue
+		}
+		currentResults, err := current.Queries()
+		if err != nil {
+			return err
+		}
+		baseResults, err := base.Queries()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("\n%s vs baseline %s (saved %s):\n", scenario, name, base.Metadata.SavedAt.Format(time.RFC3339))
+		table := newConsoleTable("Query", "Args", "Engine", "Baseline", "Current", "Change")
+		for _, d := range diffQueries(baseResults, currentResults) {
+			baseCell := "-"
+			if d.Base > 0 {
+				baseCell = formatDuration(d.Base)
+			}
+			currentCell := "error"
+			if d.Current > 0 {
+				currentCell = formatDuration(d.Current)
+			}
+			table.addRow(d.Query, d.Args, engineLabel(d.Engine, targets), baseCell, currentCell, formatChange(d))
+		}
+		table.render(os.Stdout)
+		compared++
+	}
+	if compared == 0 {
+		return fmt.Errorf("baseline %s and the current results share no query scenarios", name)
+	}
+	fmt.Printf("Change: mean latency relative to the baseline, red = %.0f%%+ slower\n", regressionThreshold*100)
+	return nil
+}
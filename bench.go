@@ -0,0 +1,416 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// benchTarget is a database the benchmark runs against, keyed by the engine
+// name used in workload files.
+type benchTarget struct {
+	Name  string
+	Label string
+	// Service is the docker-compose service running the target
+	Service    string
+	Config     DBConfig
+	IsParadeDB bool
+	DB         *sql.DB
+	// Shards, when set, are queried together with scatter-gather; DB is
+	// then the first shard, used for EXPLAIN and memory sampling.
+	Shards []*sql.DB
+	// SQLEngine is the engine whose workload statements the target runs,
+	// if not its own name.
+	SQLEngine string
+	// Indexes, when set, are built after the load instead of the engine's
+	// (see indexDDL)
+	Indexes []string
+	// BM25Syntax names the BM25 template the target must use, and BM25 is
+	// the one its preflight picked; unset, it uses bm25DDL
+	BM25Syntax string
+	BM25       *bm25Template
+}
+
+// sqlName is the engine name the target's statements are keyed by.
+func (t *benchTarget) sqlName() string {
+	if t.SQLEngine != "" {
+		return t.SQLEngine
+	}
+	return t.Name
+}
+
+// timeQuery times a query on the target, across its shards if it has any.
+func (t *benchTarget) timeQuery(query string, args []interface{}) (time.Duration, int, error) {
+	if len(t.Shards) > 0 {
+		return scatterGather(t.Shards, query, args)
+	}
+	return timeQuery(t.DB, query, args)
+}
+
+// targetNames are the targets to run against, comma-separated: vanilla,
+// parade and the other targets of the -config file.
+var targetNames = "vanilla,parade"
+
+// selectedTargetNames splits targetNames.
+func selectedTargetNames() []string {
+	var names []string
+	for _, name := range strings.Split(targetNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkTargetNames rejects a -targets list that is empty, repeats a target
+// or names one that is neither built in nor in the config file.
+func checkTargetNames() error {
+	names := selectedTargetNames()
+	if len(names) == 0 {
+		return fmt.Errorf("-targets is empty")
+	}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			return fmt.Errorf("-targets lists %s twice", name)
+		}
+		seen[name] = true
+		if _, ok := targetConfigs()[name]; !ok {
+			return fmt.Errorf("unknown target %q in -targets (want vanilla, parade or a target of -config)", name)
+		}
+	}
+	return nil
+}
+
+// defaultTargets returns the targets -targets selects, in its order, with
+// the index strategy the config file gives each.
+func defaultTargets() []*benchTarget {
+	var targets []*benchTarget
+	for _, name := range selectedTargetNames() {
+		var t *benchTarget
+		tc := runConfig.Targets[name]
+		switch name {
+		case "vanilla":
+			t = &benchTarget{Name: "vanilla", Label: "Vanilla", Service: "postgres-vanilla", Config: vanillaConfig}
+		case "parade":
+			t = &benchTarget{Name: "parade", Label: "ParadeDB", Service: "postgres-paradedb", Config: paradeConfig, IsParadeDB: true}
+		default:
+			cfg, ok := extraTargetConfigs[name]
+			if !ok {
+				continue
+			}
+			label := tc.Label
+			if label == "" {
+				label = name
+			}
+			t = &benchTarget{Name: name, Label: label, Service: tc.Service, Config: *cfg,
+				IsParadeDB: tc.Engine == "parade", SQLEngine: tc.Engine}
+		}
+		t.Indexes, t.BM25Syntax = tc.Indexes, tc.BM25Syntax
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// warnSharedTables warns about targets that load into the same table. A
+// load replaces the table, so only the target loaded last has the data
+// and indexes it expects; targets differing only in settings may share.
+func warnSharedTables(targets []*benchTarget) {
+	if fakeEngines {
+		return
+	}
+	first := map[string]*benchTarget{}
+	for _, t := range targets {
+		id := lockIdentity(t.Config)
+		other, ok := first[id]
+		if !ok {
+			first[id] = t
+			continue
+		}
+		if other.IsParadeDB != t.IsParadeDB || strings.Join(other.indexDDL(), ";") != strings.Join(t.indexDDL(), ";") {
+			warnf("Warning: %s and %s load into the same table in %s; give each its own database", other.Label, t.Label, id)
+		}
+	}
+}
+
+// bm25 is the BM25 index template for the target's pg_search release.
+func (t *benchTarget) bm25() bm25Template {
+	if t.BM25 != nil {
+		return *t.BM25
+	}
+	return bm25DDL
+}
+
+func argsLabel(args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = fmt.Sprintf("%v", a)
+	}
+	return fmt.Sprintf("%q", strings.Join(parts, ", "))
+}
+
+// timeQuery runs a query to completion and returns its wall time and rows.
+func timeQuery(db *sql.DB, query string, args []interface{}) (time.Duration, int, error) {
+	start := time.Now()
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	return time.Since(start), n, nil
+}
+
+// checkWorkloadPlans runs EXPLAIN for every query that declares plan
+// expectations and returns the problems keyed by query and engine.
+func checkWorkloadPlans(w *Workload, targets []*benchTarget) map[string][]string {
+	problems := map[string][]string{}
+	for _, q := range w.Queries {
+		for _, t := range targets {
+			expect, ok := q.ExpectPlan[t.Name]
+			stmt, hasSQL := q.SQL[t.sqlName()]
+			if !ok || !hasSQL {
+				continue
+			}
+			key := q.Name + "/" + t.Name
+			for _, args := range q.Args {
+				plan, err := explainPlan(t.DB, stmt, q.bind(t.sqlName(), args))
+				if err != nil {
+					problems[key] = append(problems[key], fmt.Sprintf("EXPLAIN failed: %v", err))
+					break
+				}
+				for _, p := range checkPlan(plan, expect) {
+					problems[key] = append(problems[key], fmt.Sprintf("%s %s", argsLabel(args), p))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// sampling decides how many timed runs each query and engine gets: a fixed
+// number of iterations, as many as fit in Duration so engines with very
+// different latencies still get comparable sample sizes, or, with CIWidth,
+// until the p95 is known precisely enough (or MaxTime runs out).
+type sampling struct {
+	Iterations int
+	Warmup     int
+	Duration   time.Duration
+	CIWidth    float64
+	MaxTime    time.Duration
+}
+
+// adaptiveCheckEvery spaces out the sorts needed to check convergence
+const adaptiveCheckEvery = 10
+
+// done reports whether the samples taken over elapsed are enough.
+func (s sampling) done(samples []time.Duration, elapsed time.Duration) bool {
+	n := len(samples)
+	switch {
+	case s.CIWidth > 0:
+		if n > 0 && s.MaxTime > 0 && elapsed >= s.MaxTime {
+			return true
+		}
+		if n < s.Iterations || n%adaptiveCheckEvery != 0 {
+			return false
+		}
+		width, ok := p95RelativeWidth(samples)
+		return ok && width <= s.CIWidth
+	case s.Duration > 0:
+		return n > 0 && elapsed >= s.Duration
+	}
+	return n >= s.Iterations
+}
+
+func (s sampling) String() string {
+	switch {
+	case s.CIWidth > 0:
+		return fmt.Sprintf("at least %d iterations per query and engine, until the p95 95%% CI is within %.0f%% (at most %v)", s.Iterations, s.CIWidth*100, s.MaxTime)
+	case s.Duration > 0:
+		return fmt.Sprintf("%v per query and engine", s.Duration)
+	}
+	return fmt.Sprintf("%d iterations per query and engine", s.Iterations)
+}
+
+// queryCell is the timing state of one query, argument set and engine.
+type queryCell struct {
+	t          *benchTarget
+	stmt       string
+	args       []interface{}
+	res        manifest.QueryResult
+	samples    []time.Duration
+	executions []manifest.Execution
+	started    time.Time
+}
+
+func newQueryCell(q WorkloadQuery, args []interface{}, t *benchTarget, planProblems map[string][]string) (*queryCell, bool) {
+	stmt, ok := q.SQL[t.sqlName()]
+	if !ok {
+		return nil, false
+	}
+	return &queryCell{
+		t:    t,
+		stmt: stmt,
+		args: q.bind(t.sqlName(), args),
+		res: manifest.QueryResult{
+			Query:        q.Name,
+			Category:     q.Category,
+			Engine:       t.Name,
+			Args:         args,
+			PlanProblems: planProblems[q.Name+"/"+t.Name],
+		},
+		started: time.Now(),
+	}, true
+}
+
+func (c *queryCell) warm(n int) {
+	for i := 0; i < n && c.res.Error == ""; i++ {
+		if _, _, err := c.t.timeQuery(c.stmt, c.args); err != nil {
+			c.res.Error = err.Error()
+		}
+	}
+}
+
+// run times one execution; a failure ends the cell.
+func (c *queryCell) run() {
+	if c.res.Error != "" {
+		return
+	}
+	startedAt := time.Now()
+	d, n, err := c.t.timeQuery(c.stmt, c.args)
+	if err != nil {
+		c.res.Error = err.Error()
+		return
+	}
+	c.samples = append(c.samples, d)
+	c.executions = append(c.executions, manifest.Execution{Duration: d, StartedAt: startedAt.UTC()})
+	c.res.Rows = n
+}
+
+func (c *queryCell) finish() manifest.QueryResult {
+	c.res.Stats = summarize(c.samples)
+	c.res.Slowest = slowestExecutions(c.executions, keepSlowest)
+	if captureServerLogs {
+		recordCellWindow(c.res, c.started, time.Now())
+	}
+	if sampleMemory && c.res.Error == "" {
+		sampleQueryMemory(&c.res, c.t, c.stmt, c.args)
+	}
+	if sampleRowEstimates && c.res.Error == "" {
+		sampleQueryEstimates(&c.res, c.t, c.stmt, c.args)
+	}
+	return c.res
+}
+
+func (c *queryCell) describe(sp sampling) string {
+	res := c.res
+	if res.Error != "" {
+		return fmt.Sprintf("%s ERROR (%s)", c.t.Label, res.Error)
+	}
+	cell := fmt.Sprintf("%s %s p95 %s (%d)", c.t.Label,
+		formatDuration(res.Stats.Mean), formatDuration(res.Stats.P95), res.Rows)
+	if res.MaxBackendMemory > 0 {
+		cell += " mem " + formatBytes(uint64(res.MaxBackendMemory))
+	}
+	if sp.Duration > 0 || sp.CIWidth > 0 {
+		cell += fmt.Sprintf(" x%d", res.Stats.Count)
+	}
+	if width, ok := p95RelativeWidth(c.samples); sp.CIWidth > 0 && (!ok || width > sp.CIWidth) {
+		cell += " [NOT CONVERGED]"
+	}
+	if len(res.PlanProblems) > 0 {
+		cell += " [PLAN MISMATCH]"
+	}
+	return cell
+}
+
+// runWorkload times every query in the -order mode.
+func runWorkload(w *Workload, targets []*benchTarget, sp sampling, planProblems map[string][]string) []manifest.QueryResult {
+	infof("Timing %s after %d warmup run(s), %s order", sp, sp.Warmup, queryOrder)
+	if queryOrder != "blocked" {
+		return runShuffledWorkload(w, targets, sp, planProblems)
+	}
+
+	pass := journal.nextPass()
+	last := map[string]int{}
+	for i, q := range w.Queries {
+		last[q.Category] = i
+	}
+	var results []manifest.QueryResult
+	cells := map[string][]manifest.QueryResult{}
+	category := ""
+	for i, q := range w.Queries {
+		if q.Category != category {
+			category = q.Category
+			infof("--- %s ---", strings.ToUpper(category))
+		}
+
+		for _, args := range q.Args {
+			line := make([]string, 0, len(targets))
+			for _, t := range targets {
+				if _, done := journal.finished(pass, t.Name, q.Category); done {
+					continue
+				}
+				c, ok := newQueryCell(q, args, t, planProblems)
+				if !ok {
+					continue
+				}
+				c.warm(sp.Warmup)
+				start := time.Now()
+				for c.res.Error == "" && !sp.done(c.samples, time.Since(start)) {
+					c.run()
+				}
+				res := c.finish()
+				results = append(results, res)
+				cells[t.Name+"/"+q.Category] = append(cells[t.Name+"/"+q.Category], res)
+				line = append(line, c.describe(sp))
+			}
+			if len(line) > 0 {
+				infof("%s %s: %s", q.Name, argsLabel(args), strings.Join(line, " | "))
+			}
+		}
+
+		// The category's last query finishes its cell on every engine
+		if i != last[q.Category] {
+			continue
+		}
+		for _, t := range targets {
+			if prior, done := journal.finished(pass, t.Name, q.Category); done {
+				infof("⏭️  %s %s: kept from the interrupted run", t.Label, q.Category)
+				results = append(results, prior...)
+				continue
+			}
+			journal.record(pass, t.Name, q.Category, cells[t.Name+"/"+q.Category])
+		}
+	}
+	return results
+}
+
+// checkPlansOrFail prints every plan assertion failure and returns an error
+// if the workload asks to stop on them.
+func checkPlansOrFail(w *Workload, targets []*benchTarget) (map[string][]string, error) {
+	infof("Checking plans for %s...", w.Name)
+	planProblems := checkWorkloadPlans(w, targets)
+	for key, problems := range planProblems {
+		for _, p := range problems {
+			warnf("❌ %s: %s", key, p)
+		}
+	}
+	if len(planProblems) > 0 && w.PlanMismatch == "fail" {
+		return nil, fmt.Errorf("plan assertions failed, not timing anything (set plan_mismatch: flag to time anyway)")
+	}
+	if len(planProblems) == 0 {
+		infof("✅ All plan assertions hold")
+	}
+	return planProblems, nil
+}
@@ -1,10 +1,15 @@
 import pg from 'pg';
+import fs from 'fs';
+import path from 'path';
 import { performance } from 'perf_hooks';
 
 // Command line arguments
 const args = process.argv.slice(2);
 const runReads = !args.includes('--writes-only');
 const runWrites = !args.includes('--reads-only');
+// The Go loader's -data-dir, where it writes ingest-status.json
+const dataDirArg = args.indexOf('--data-dir');
+const dataDir = dataDirArg >= 0 && args[dataDirArg + 1] ? args[dataDirArg + 1] : (process.env.PGB_DATA_DIR || '.');
 
 const { Pool } = pg;
 
@@ -123,6 +128,18 @@ async function verifySetup() {
   const paradeCount = await paradePool.query('SELECT COUNT(*) FROM products');
   
   console.log(`ParadeDB: ${paradeIndexes.rows.length} indexes, ${Number(paradeCount.rows[0].count).toLocaleString()} products`);
+
+  // The loader records every fallback it took in ingest-status.json
+  const statusPath = path.join(dataDir, 'ingest-status.json');
+  if (fs.existsSync(statusPath)) {
+    const status = JSON.parse(fs.readFileSync(statusPath, 'utf8'));
+    if (status.status === 'degraded') {
+      console.log(`\n⚠️  DEGRADED RUN: data was loaded with ${status.degradations.length} fallback(s), do not publish these numbers`);
+      for (const d of status.degradations) {
+        console.log(`   - ${d.engine} ${d.step}: ${d.detail}`);
+      }
+    }
+  }
   
   return vanillaIndexes.rows.length >= 11 && paradeIndexes.rows.length >= 2 && vanillaCount.rows[0].count > 1000000;
 }
@@ -673,11 +690,14 @@ Options:
   --help              Show this help message
   --reads-only        Run only read performance tests
   --writes-only       Run only write performance tests
+  --data-dir <dir>    The loader's -data-dir, to read ingest-status.json from
+                      (default: $PGB_DATA_DIR, or the current directory)
 
 Examples:
   node benchmark.js                    # Run all tests
   node benchmark.js --reads-only       # Test search performance only  
   node benchmark.js --writes-only      # Test write performance only
+  node benchmark.js --data-dir ~/pgsb  # Flag a degraded load kept in ~/pgsb
 `);
   process.exit(0);
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// bm25Template is the BM25 index DDL for a range of pg_search releases; the
+// syntax has changed several times. %[1]s is the table name and the index is
+// always named <table>_search_idx.
+type bm25Template struct {
+	Name       string
+	MinVersion string
+	Index      string
+	Fallback   string
+}
+
+// bm25Templates is ordered newest first.
+var bm25Templates = []bm25Template{
+	{
+		// 0.20 moved tokenizer configuration into typed column expressions
+		Name:       "typed",
+		MinVersion: "0.20.0",
+		Index: `
+			CREATE INDEX IF NOT EXISTS %[1]s_search_idx ON %[1]s
+			USING bm25 (
+				id,
+				(title::pdb.simple('stemmer=english')),
+				(description::pdb.simple('stemmer=english')),
+				(brand::pdb.literal_normalized)
+			)
+			WITH (key_field='id')
+		`,
+		Fallback: `
+			CREATE INDEX IF NOT EXISTS %[1]s_search_idx ON %[1]s
+			USING bm25 (id, title, description, brand)
+			WITH (key_field='id')
+		`,
+	},
+	{
+		Name:       "options",
+		MinVersion: "0.13.0",
+		Index:      bm25IndexDDL,
+		Fallback:   bm25FallbackDDL,
+	},
+	{
+		// Before 0.13 indexes were created through a procedure
+		Name:       "procedure",
+		MinVersion: "0.10.0",
+		Index: `
+			CALL paradedb.create_bm25(
+				index_name => '%[1]s_search_idx',
+				table_name => '%[1]s',
+				key_field => 'id',
+				text_fields =>
+					paradedb.field('title', tokenizer => paradedb.tokenizer('en_stem'), record => 'position') ||
+					paradedb.field('description', tokenizer => paradedb.tokenizer('en_stem'), record => 'position') ||
+					paradedb.field('brand', tokenizer => paradedb.tokenizer('raw'), record => 'basic')
+			)
+		`,
+		Fallback: `
+			CALL paradedb.create_bm25(
+				index_name => '%[1]s_search_idx',
+				table_name => '%[1]s',
+				key_field => 'id',
+				text_fields => paradedb.field('title') || paradedb.field('description') || paradedb.field('brand')
+			)
+		`,
+	},
+}
+
+var (
+	// bm25Syntax overrides version detection with a template name
+	bm25Syntax = ""
+
+	// bm25DDL is the template used for the ParadeDB index, chosen at startup
+	bm25DDL = bm25Templates[1]
+)
+
+// selectBM25Template picks the template for a pg_search version, or the one
+// named by a target's bm25_syntax or the -bm25-syntax override.
+func selectBM25Template(version, syntax string) (bm25Template, error) {
+	if syntax == "" {
+		syntax = bm25Syntax
+	}
+	if syntax != "" {
+		names := make([]string, 0, len(bm25Templates))
+		for _, t := range bm25Templates {
+			if t.Name == syntax {
+				return t, nil
+			}
+			names = append(names, t.Name)
+		}
+		return bm25Template{}, fmt.Errorf("unknown BM25 syntax %q (want one of %s)", syntax, strings.Join(names, ", "))
+	}
+
+	for _, t := range bm25Templates {
+		if compareVersions(version, t.MinVersion) >= 0 {
+			return t, nil
+		}
+	}
+	oldest := bm25Templates[len(bm25Templates)-1]
+	return bm25Template{}, fmt.Errorf("pg_search %s is too old, need %s or newer", version, oldest.MinVersion)
+}
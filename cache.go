@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cacheDir is -cache-dir, kept across data directories and runs: the
+// downloaded corpora under <dataset>/, under parsed/ the records each
+// (dataset, version, sample size) loaded, so a repeated load neither
+// downloads nor parses again, and under terms/ their term dictionaries.
+// Empty turns the cache off.
+var cacheDir = defaultCacheDir()
+
+// parsedCorpus is the parsed records prepareCorpus found or wrote for this
+// run, streamed instead of the dataset; empty streams the dataset.
+var parsedCorpus = ""
+
+// defaultCacheDir is pg_search_benchmark in the user's cache directory
+// (~/.cache on Linux), or none when there is no home to put it in.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pg_search_benchmark")
+}
+
+// cachedDatasetPath is where the named dataset's download is kept: a copy
+// already in dir from before the cache is used where it is, otherwise the
+// cache holds it.
+func cachedDatasetPath(name, dir string) string {
+	local := datasets[name].Path(dir)
+	if _, err := os.Stat(local); err == nil || cacheDir == "" || local == "" {
+		return local
+	}
+	return datasets[name].Path(filepath.Join(cacheDir, name))
+}
+
+// derivedCachePath names a file derived from the dataset version with the
+// given fingerprint at the current sample size, kept under kind.
+func derivedCachePath(kind, fingerprint, ext string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	sample := "all"
+	if SampleSize > 0 {
+		sample = fmt.Sprintf("%d", SampleSize)
+	}
+	name := fmt.Sprintf("%s-%s-%s%s", datasetName, hex.EncodeToString(sum[:])[:12], sample, ext)
+	return filepath.Join(cacheDir, kind, name)
+}
+
+// cachedProduct is a parsed record as the cache keeps it, with the
+// provenance of its line in the dataset.
+type cachedProduct struct {
+	Product
+	Provenance string `json:"provenance"`
+}
+
+// prepareCorpus sets parsedCorpus to the cached records of the corpus at
+// path, parsing it into the cache first when this version and sample size
+// have not been seen. Without a cache or a fingerprint it leaves the
+// dataset to be streamed.
+func prepareCorpus(path string) error {
+	parsedCorpus = ""
+	if cacheDir == "" || datasetFingerprint == "" {
+		return nil
+	}
+	cached := derivedCachePath("parsed", datasetFingerprint, ".jsonl.gz")
+	if _, err := os.Stat(cached); err == nil {
+		infof("Using the parsed %s records cached in %s", datasetName, cached)
+		parsedCorpus = cached
+		return nil
+	}
+	if isRemoteDump(path) {
+		// -stream is for machines without room for the corpus on disk
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(cached), 0o755); err != nil {
+		return err
+	}
+
+	infof("Parsing %s into %s...", filepath.Base(path), cached)
+	tmp := cached + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	enc := json.NewEncoder(gz)
+	written := 0
+	err = activeDataset.Stream(path, func(product Product, err error) error {
+		if err != nil {
+			return nil
+		}
+		if err := enc.Encode(cachedProduct{product, product.Provenance}); err != nil {
+			return err
+		}
+		written++
+		if SampleSize > 0 && written >= int(SampleSize) {
+			return errStopStream
+		}
+		return nil
+	})
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp, cached)
+	}
+	if err != nil {
+		return fmt.Errorf("cache parsed records: %v", err)
+	}
+	infof("Cached %d parsed records", written)
+	parsedCorpus = cached
+	return nil
+}
+
+// streamCorpus streams the records a load takes from the corpus at path:
+// the parsed cache when prepareCorpus set one, else the dataset itself.
+func streamCorpus(path string, emit func(Product, error) error) error {
+	if parsedCorpus == "" {
+		return activeDataset.Stream(path, emit)
+	}
+	f, err := openDump(parsedCorpus)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var c cachedProduct
+		err := json.Unmarshal(scanner.Bytes(), &c)
+		c.Product.Provenance = c.Provenance
+		if err := emit(c.Product, err); err != nil {
+			if err == errStopStream {
+				return nil
+			}
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// targetCatalog is what the runner reads about a target besides timing its
+// queries: settings, roles, extensions, sizes and rows. Databases answer in
+// SQL; -fake engines answer with canned values, so the fake driver only
+// has to run the workload's statements.
+type targetCatalog interface {
+	preloadLibraries() (string, error)
+	isSuperuser() (bool, error)
+	// extensionVersions returns sql.ErrNoRows for an extension the server
+	// doesn't ship
+	extensionVersions(name string) (available, installed sql.NullString, err error)
+	relationSizes(table string) (heap, indexes int64, err error)
+	// countProducts counts the products whose ASIN starts with prefix, or
+	// all of them
+	countProducts(asinPrefix string) (int64, error)
+	// idRange returns the lowest and highest id of the products whose ASIN
+	// starts with prefix, or 0, 0 when there are none
+	idRange(asinPrefix string) (lo, hi int64, err error)
+	asinsByID(ids []int64) (map[int64]string, error)
+	queryMemory(query string, args []interface{}) (int64, error)
+}
+
+// catalogOf returns the catalog of the target db connects to.
+func catalogOf(db *sql.DB) targetCatalog {
+	if _, ok := db.Driver().(*fakeDriver); ok {
+		return fakeCatalog{}
+	}
+	return sqlCatalog{db}
+}
+
+type sqlCatalog struct {
+	db *sql.DB
+}
+
+func (c sqlCatalog) preloadLibraries() (string, error) {
+	var preload string
+	err := c.db.QueryRow("SHOW shared_preload_libraries").Scan(&preload)
+	return preload, err
+}
+
+func (c sqlCatalog) isSuperuser() (bool, error) {
+	var super bool
+	err := c.db.QueryRow("SELECT rolsuper FROM pg_roles WHERE rolname = current_user").Scan(&super)
+	return super, err
+}
+
+func (c sqlCatalog) extensionVersions(name string) (available, installed sql.NullString, err error) {
+	err = c.db.QueryRow("SELECT default_version, installed_version FROM pg_available_extensions WHERE name = $1", name).Scan(&available, &installed)
+	return available, installed, err
+}
+
+func (c sqlCatalog) relationSizes(table string) (heap, indexes int64, err error) {
+	err = c.db.QueryRow("SELECT pg_relation_size($1::regclass), pg_indexes_size($1::regclass)", table).Scan(&heap, &indexes)
+	return heap, indexes, err
+}
+
+func (c sqlCatalog) countProducts(asinPrefix string) (int64, error) {
+	var n int64
+	if asinPrefix == "" {
+		err := c.db.QueryRow("SELECT COUNT(*) FROM " + productsTable).Scan(&n)
+		return n, err
+	}
+	err := c.db.QueryRow("SELECT COUNT(*) FROM "+productsTable+" WHERE asin LIKE $1", asinPrefix+"%").Scan(&n)
+	return n, err
+}
+
+func (c sqlCatalog) idRange(asinPrefix string) (lo, hi int64, err error) {
+	var min, max sql.NullInt64
+	err = c.db.QueryRow("SELECT min(id), max(id) FROM "+productsTable+" WHERE asin LIKE $1", asinPrefix+"%").Scan(&min, &max)
+	return min.Int64, max.Int64, err
+}
+
+// asinsByID maps ids to ASINs, which unlike ids are the same on every load
+// of the dump.
+func (c sqlCatalog) asinsByID(ids []int64) (map[int64]string, error) {
+	rows, err := c.db.Query("SELECT id, asin FROM "+productsTable+" WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	asins := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var asin sql.NullString
+		if err := rows.Scan(&id, &asin); err != nil {
+			return nil, err
+		}
+		asins[id] = asin.String
+	}
+	return asins, rows.Err()
+}
+
+// backendMemoryQuery totals the memory contexts of the current backend.
+const backendMemoryQuery = `SELECT COALESCE(sum(total_bytes), 0)::bigint FROM pg_backend_memory_contexts`
+
+// queryMemory returns the most memory a query made its backend hold, over a
+// few runs on one dedicated connection. The query is materialized in a CTE
+// and the backend's memory contexts are read in the same statement, while
+// the executor state still exists; the idle backend's memory is subtracted.
+// This sees what is held at the end of execution, so memory freed earlier
+// (e.g. by a finished sort) is missed.
+func (c sqlCatalog) queryMemory(query string, args []interface{}) (int64, error) {
+	ctx := context.Background()
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	wrapped := fmt.Sprintf(`WITH q AS MATERIALIZED (%s)
+SELECT (SELECT count(*) FROM q), (%s)`, query, backendMemoryQuery)
+
+	var peak int64
+	for i := 0; i < memorySamples; i++ {
+		var idle, held, rows int64
+		if err := conn.QueryRowContext(ctx, backendMemoryQuery).Scan(&idle); err != nil {
+			return 0, fmt.Errorf("pg_backend_memory_contexts: %v", err)
+		}
+		if err := conn.QueryRowContext(ctx, wrapped, args...).Scan(&rows, &held); err != nil {
+			return 0, err
+		}
+		if held-idle > peak {
+			peak = held - idle
+		}
+	}
+	return peak, nil
+}
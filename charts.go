@@ -0,0 +1,462 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// writeCharts makes "run" render charts of the stored results once the
+// scenarios are done.
+var writeCharts = false
+
+// chartsDir is where charts are written, inside the results directory.
+const chartsDir = "charts"
+
+// chartColors are the series colors, in engine order.
+var chartColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b"}
+
+// Chart geometry in SVG pixels; the legend sits right of the plot.
+const (
+	chartWidth, chartHeight = 720, 420
+	chartLeft, chartTop     = 80, 40
+	chartPlotWidth          = chartWidth - chartLeft - 160
+	chartPlotHeight         = chartHeight - chartTop - 50
+	chartTicks              = 5
+)
+
+// chartPoint is one point of a series, in data units.
+type chartPoint struct{ X, Y float64 }
+
+// chartSeries is one line of a chart, usually one engine.
+type chartSeries struct {
+	Name   string
+	Points []chartPoint
+}
+
+// lineChart is a line chart rendered as a standalone SVG. With LogX the x
+// axis is logarithmic; XTicks, when set, places the x ticks at those
+// values; XTick and YTick format tick labels.
+type lineChart struct {
+	Title, XLabel, YLabel string
+	LogX                  bool
+	XTicks                []float64
+	XTick, YTick          func(float64) string
+	Series                []chartSeries
+}
+
+// bounds returns the data range over all series. Linear axes start at zero
+// and end on a tick; the log axis is in powers of ten.
+func (c *lineChart) bounds() (x0, x1, y0, y1 float64) {
+	x0, y0 = math.Inf(1), math.Inf(1)
+	x1, y1 = math.Inf(-1), math.Inf(-1)
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			x0, x1 = math.Min(x0, p.X), math.Max(x1, p.X)
+			y0, y1 = math.Min(y0, p.Y), math.Max(y1, p.Y)
+		}
+	}
+	if c.LogX {
+		x0, x1 = math.Floor(math.Log10(x0)), math.Ceil(math.Log10(x1))
+	}
+	if x0 == x1 {
+		x0, x1 = x0-1, x1+1
+	}
+	if y0 == y1 {
+		y0, y1 = y0-1, y1+1
+	}
+	if !c.LogX {
+		x0, x1 = math.Min(x0, 0), x1+tickStep(x0, x1)/2
+	}
+	y0 = math.Min(y0, 0)
+	y1 = math.Ceil(y1/tickStep(y0, y1)) * tickStep(y0, y1)
+	return x0, x1, y0, y1
+}
+
+// tickStep is a round step (1, 2 or 5 times a power of ten) giving about
+// chartTicks ticks between lo and hi.
+func tickStep(lo, hi float64) float64 {
+	raw := (hi - lo) / chartTicks
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// ticks returns the tick positions of an axis: every power of ten on a log
+// axis, multiples of a round step otherwise.
+func ticks(lo, hi float64, log bool) []float64 {
+	var out []float64
+	if log {
+		for e := lo; e <= hi; e++ {
+			out = append(out, e)
+		}
+		return out
+	}
+	step := tickStep(lo, hi)
+	for t := math.Ceil(lo/step) * step; t <= hi+step/1e6; t += step {
+		out = append(out, t)
+	}
+	return out
+}
+
+// render writes the chart as SVG.
+func (c *lineChart) render(w io.Writer) error {
+	x0, x1, y0, y1 := c.bounds()
+	px := func(x float64) float64 {
+		if c.LogX {
+			x = math.Log10(x)
+		}
+		return chartLeft + (x-x0)/(x1-x0)*chartPlotWidth
+	}
+	py := func(y float64) float64 {
+		return chartTop + chartPlotHeight - (y-y0)/(y1-y0)*chartPlotHeight
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="14" font-weight="bold">%s</text>`+"\n", chartLeft, chartTop-15, html.EscapeString(c.Title))
+
+	xTicks := c.XTicks
+	if xTicks == nil {
+		for _, t := range ticks(x0, x1, c.LogX) {
+			if c.LogX {
+				t = math.Pow(10, t)
+			}
+			xTicks = append(xTicks, t)
+		}
+	}
+	for _, v := range xTicks {
+		x := px(v)
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#ddd"/>`+"\n", x, chartTop, x, chartTop+chartPlotHeight)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`+"\n", x, chartTop+chartPlotHeight+16, html.EscapeString(c.XTick(v)))
+	}
+	for _, t := range ticks(y0, y1, false) {
+		y := py(t)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`+"\n", chartLeft, y, chartLeft+chartPlotWidth, y)
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`+"\n", chartLeft-6, y, html.EscapeString(c.YTick(t)))
+	}
+	fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#333"/>`+"\n", chartLeft, chartTop, chartPlotWidth, chartPlotHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`+"\n", chartLeft+chartPlotWidth/2, chartHeight-10, html.EscapeString(c.XLabel))
+	fmt.Fprintf(&b, `<text transform="translate(16 %d) rotate(-90)" text-anchor="middle">%s</text>`+"\n", chartTop+chartPlotHeight/2, html.EscapeString(c.YLabel))
+
+	for i, s := range c.Series {
+		color := chartColors[i%len(chartColors)]
+		points := make([]string, len(s.Points))
+		for j, p := range s.Points {
+			points[j] = fmt.Sprintf("%.1f,%.1f", px(p.X), py(p.Y))
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", strings.Join(points, " "), color)
+		for _, p := range s.Points {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`+"\n", px(p.X), py(p.Y), color)
+		}
+		lx, ly := chartLeft+chartPlotWidth+12, chartTop+10+i*20
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2"/>`+"\n", lx, ly, lx+16, ly, color)
+		fmt.Fprintf(&b, `<text x="%d" y="%d" dominant-baseline="middle">%s</text>`+"\n", lx+22, ly, html.EscapeString(s.Name))
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// geoMean is the geometric mean of positive durations, which keeps one slow
+// query from dominating a category's curve.
+func geoMean(ds []time.Duration) float64 {
+	sum, n := 0.0, 0
+	for _, d := range ds {
+		if d > 0 {
+			sum += math.Log(float64(d))
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return math.Exp(sum / float64(n))
+}
+
+// latencyCDFs returns one latency CDF chart per category. Only percentiles
+// are stored, so each engine's curve joins min, p50, p95, p99 and max,
+// each the geometric mean over the category's queries.
+func latencyCDFs(scenario string, results []manifest.QueryResult, targets []*benchTarget) map[string]*lineChart {
+	type key struct{ category, engine string }
+	stats := map[key][]manifest.LatencyStats{}
+	var categories, engines []string
+	seen := map[string]bool{}
+	for _, r := range results {
+		if r.Error != "" || r.Stats.Count == 0 {
+			continue
+		}
+		if !seen["c/"+r.Category] {
+			seen["c/"+r.Category] = true
+			categories = append(categories, r.Category)
+		}
+		if !seen["e/"+r.Engine] {
+			seen["e/"+r.Engine] = true
+			engines = append(engines, r.Engine)
+		}
+		k := key{r.Category, r.Engine}
+		stats[k] = append(stats[k], r.Stats)
+	}
+
+	charts := map[string]*lineChart{}
+	for _, cat := range categories {
+		c := &lineChart{
+			Title:  fmt.Sprintf("%s: %s latency", scenario, cat),
+			XLabel: "latency (log scale)", YLabel: "fraction of executions",
+			LogX:  true,
+			XTick: func(v float64) string { return formatDuration(time.Duration(v)) },
+			YTick: func(v float64) string { return fmt.Sprintf("%.0f%%", v*100) },
+		}
+		for _, e := range engines {
+			ss, ok := stats[key{cat, e}]
+			if !ok {
+				continue
+			}
+			pick := func(f func(manifest.LatencyStats) time.Duration) float64 {
+				ds := make([]time.Duration, len(ss))
+				for i, s := range ss {
+					ds[i] = f(s)
+				}
+				return geoMean(ds)
+			}
+			var points []chartPoint
+			for _, q := range []struct {
+				fraction float64
+				value    func(manifest.LatencyStats) time.Duration
+			}{
+				{0, func(s manifest.LatencyStats) time.Duration { return s.Min }},
+				{0.50, func(s manifest.LatencyStats) time.Duration { return s.P50 }},
+				{0.95, func(s manifest.LatencyStats) time.Duration { return s.P95 }},
+				{0.99, func(s manifest.LatencyStats) time.Duration { return s.P99 }},
+				{1, func(s manifest.LatencyStats) time.Duration { return s.Max }},
+			} {
+				if v := pick(q.value); v > 0 {
+					points = append(points, chartPoint{v, q.fraction})
+				}
+			}
+			if len(points) > 0 {
+				c.Series = append(c.Series, chartSeries{Name: engineLabel(e, targets), Points: points})
+			}
+		}
+		if len(c.Series) > 0 {
+			charts[cat] = c
+		}
+	}
+	return charts
+}
+
+// throughputCurve returns a chart of total operations per second against
+// workers per engine, or nil when the results weren't timed at several
+// worker counts.
+func throughputCurve(scenario string, results []manifest.QueryResult, targets []*benchTarget) *lineChart {
+	total := map[string]map[int]float64{}
+	var engines []string
+	levels := map[int]bool{}
+	for _, r := range results {
+		if r.Workers == 0 {
+			continue
+		}
+		if total[r.Engine] == nil {
+			total[r.Engine] = map[int]float64{}
+			engines = append(engines, r.Engine)
+		}
+		total[r.Engine][r.Workers] += r.Throughput
+		levels[r.Workers] = true
+	}
+	if len(levels) < 2 {
+		return nil
+	}
+
+	c := &lineChart{
+		Title:  fmt.Sprintf("%s: throughput by concurrency", scenario),
+		XLabel: "workers", YLabel: "operations per second",
+		XTick: func(v float64) string { return fmt.Sprintf("%.0f", v) },
+		YTick: func(v float64) string { return fmt.Sprintf("%.0f", v) },
+	}
+	for n := range levels {
+		c.XTicks = append(c.XTicks, float64(n))
+	}
+	sort.Float64s(c.XTicks)
+	for _, e := range engines {
+		var points []chartPoint
+		for n, ops := range total[e] {
+			points = append(points, chartPoint{float64(n), ops})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].X < points[j].X })
+		c.Series = append(c.Series, chartSeries{Name: engineLabel(e, targets), Points: points})
+	}
+	return c
+}
+
+// corpusCurve returns a chart of latency against corpus size per engine:
+// the geometric mean over the queries of their mean latency. It is nil when
+// the results weren't timed at several corpus sizes.
+func corpusCurve(scenario string, results []manifest.QueryResult, targets []*benchTarget) *lineChart {
+	means := map[string]map[int64][]time.Duration{}
+	var engines []string
+	sizes := map[int64]bool{}
+	for _, r := range results {
+		if r.CorpusRows == 0 || r.Error != "" {
+			continue
+		}
+		if means[r.Engine] == nil {
+			means[r.Engine] = map[int64][]time.Duration{}
+			engines = append(engines, r.Engine)
+		}
+		means[r.Engine][r.CorpusRows] = append(means[r.Engine][r.CorpusRows], r.Stats.Mean)
+		sizes[r.CorpusRows] = true
+	}
+	if len(sizes) < 2 {
+		return nil
+	}
+
+	c := &lineChart{
+		Title:  fmt.Sprintf("%s: latency by corpus size", scenario),
+		XLabel: "products", YLabel: "mean latency (geometric mean over queries)",
+		XTick: func(v float64) string { return fmt.Sprintf("%.0f", v) },
+		YTick: func(v float64) string { return formatDuration(time.Duration(v)) },
+	}
+	for _, e := range engines {
+		var points []chartPoint
+		for rows, ds := range means[e] {
+			points = append(points, chartPoint{float64(rows), geoMean(ds)})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].X < points[j].X })
+		c.Series = append(c.Series, chartSeries{Name: engineLabel(e, targets), Points: points})
+	}
+	return c
+}
+
+// chartFileName makes a chart name safe to use as a file name.
+func chartFileName(parts ...string) string {
+	name := strings.Join(parts, "-")
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name) + ".svg"
+}
+
+// saveChart renders a chart into dir.
+func saveChart(dir, name string, c *lineChart) (string, error) {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := c.render(f); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// renderCharts writes latency CDFs of every query results file in dir, and
+// throughput and corpus size curves where results were timed at several
+// worker counts or corpus sizes, into dir's charts directory. It returns
+// the files written.
+func renderCharts(dir string, targets []*benchTarget) ([]string, error) {
+	files, err := filepath.Glob(resultsFile(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	out := filepath.Join(dir, chartsDir)
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, path := range files {
+		f, err := manifest.Read(path)
+		if err != nil {
+			return written, fmt.Errorf("%s: %v", path, err)
+		}
+		if f.Kind != manifest.KindQueries {
+			continue
+		}
+		results, err := f.Queries()
+		if err != nil {
+			return written, fmt.Errorf("%s: %v", path, err)
+		}
+		scenario := f.Metadata.Scenario
+		cdfs := latencyCDFs(scenario, results, targets)
+		var categories []string
+		for cat := range cdfs {
+			categories = append(categories, cat)
+		}
+		sort.Strings(categories)
+		for _, cat := range categories {
+			p, err := saveChart(out, chartFileName("latency", scenario, cat), cdfs[cat])
+			if err != nil {
+				return written, err
+			}
+			written = append(written, p)
+		}
+		if c := throughputCurve(scenario, results, targets); c != nil {
+			p, err := saveChart(out, chartFileName("throughput", scenario), c)
+			if err != nil {
+				return written, err
+			}
+			written = append(written, p)
+		}
+		if c := corpusCurve(scenario, results, targets); c != nil {
+			p, err := saveChart(out, chartFileName("corpus", scenario), c)
+			if err != nil {
+				return written, err
+			}
+			written = append(written, p)
+		}
+	}
+	return written, nil
+}
+
+// chartCommand implements "chart": render charts of the latest results or
+// of a baseline.
+func chartCommand(args []string) {
+	fs := flag.NewFlagSet("chart", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	run := fs.String("run", "", "baseline name to chart instead of the latest results")
+	fs.Parse(args)
+
+	dir := dataDir
+	if *run != "" {
+		path, err := baselinePath(dataDir, *run)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		dir = path
+	}
+	written, err := renderCharts(dir, defaultTargets())
+	if err != nil {
+		log.Fatalf("Failed to render charts: %v", err)
+	}
+	reportCharts(written)
+}
+
+// reportCharts lists the charts written.
+func reportCharts(written []string) {
+	if len(written) == 0 {
+		fmt.Println("No query results to chart")
+		return
+	}
+	fmt.Printf("Wrote %d chart(s):\n", len(written))
+	for _, p := range written {
+		fmt.Printf("  %s\n", p)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// citusDSN is the coordinator of the Citus cluster the citus scenario
+// distributes products on.
+var citusDSN = ""
+
+// citusEngine is the engine name of the Citus target; it runs the vanilla
+// statements of the workload.
+const citusEngine = "citus"
+
+// citusDistributeSQL makes products a hash-distributed table. The id is the
+// distribution column because Citus requires it in the primary key.
+var citusDistributeSQL = []string{
+	"CREATE EXTENSION IF NOT EXISTS citus",
+	"ALTER TABLE products SET LOGGED",
+	"SELECT create_distributed_table('products', 'id')",
+}
+
+// runCitusScenario loads products into a distributed table on the -citus
+// coordinator, builds the vanilla indexes (Citus creates them on every
+// shard), and times the workload against it next to ParadeDB.
+func runCitusScenario(st *runState) error {
+	if citusDSN == "" {
+		return fmt.Errorf("-citus is required: the connection string of the coordinator")
+	}
+	var parade *benchTarget
+	for _, t := range st.Targets {
+		if t.IsParadeDB {
+			parade = t
+		}
+	}
+	if parade == nil {
+		return fmt.Errorf("no ParadeDB target to compare Citus with")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDSN(citusDSN)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the Citus coordinator: %v", err)
+	}
+	defer db.Close()
+	citus := &benchTarget{Name: citusEngine, Label: "Citus", Config: vanillaConfig, DB: db, SQLEngine: "vanilla"}
+
+	start := time.Now()
+	if err := setupTables(citus); err != nil {
+		return fmt.Errorf("%s: %v", citus.Label, err)
+	}
+	for _, stmt := range citusDistributeSQL {
+		if _, err := db.Exec(productsSQL(stmt)); err != nil {
+			return fmt.Errorf("%s: %s: %v", citus.Label, stmt, err)
+		}
+	}
+	if err := prepareCorpus(st.MetadataPath); err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	loadDataset(citus, st.MetadataPath, nil, nil, &wg)
+	wg.Add(1)
+	finalizeTable(citus, &wg)
+	infof("%s: Loaded and indexed in %v", citus.Label, time.Since(start).Round(time.Second))
+
+	targets := []*benchTarget{citus, parade}
+	planProblems, err := checkPlansOrFail(w, targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "citus", manifest.KindQueries, st.Targets, results)
+}
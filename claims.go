@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+
+	"pg_search_benchmark/manifest"
+)
+
+// speedClaim is an expected speed relationship a workload declares, such
+// as "parade >= 5x vanilla on fulltext": Engine's mean latency on Category
+// is at least (>=) or at most (<=) Factor times faster than Than's.
+// Category "all" compares the geometric mean over every shared category.
+type speedClaim struct {
+	Text     string
+	Engine   string
+	Op       string
+	Factor   float64
+	Than     string
+	Category string
+}
+
+// claimPattern reads "<engine> >= <factor>x [faster than] <engine> on
+// <category>".
+var claimPattern = regexp.MustCompile(`^\s*([\w-]+)\s*(>=|<=)\s*([0-9]*\.?[0-9]+)x\s+(?:faster\s+than\s+)?([\w-]+)\s+on\s+([\w-]+)\s*$`)
+
+func parseClaim(s string) (speedClaim, error) {
+	m := claimPattern.FindStringSubmatch(s)
+	if m == nil {
+		return speedClaim{}, fmt.Errorf("claim %q is not '<engine> >= <n>x <engine> on <category>'", s)
+	}
+	factor, err := strconv.ParseFloat(m[3], 64)
+	if err != nil || factor <= 0 {
+		return speedClaim{}, fmt.Errorf("claim %q needs a positive factor", s)
+	}
+	if m[1] == m[4] {
+		return speedClaim{}, fmt.Errorf("claim %q compares %s with itself", s, m[1])
+	}
+	return speedClaim{Text: s, Engine: m[1], Op: m[2], Factor: factor, Than: m[4], Category: m[5]}, nil
+}
+
+// claimOutcome is how a claim fared on the stored results. Speedup is how
+// many times faster Engine was than Than; it is zero when the results
+// cannot decide the claim, and Problem says why.
+type claimOutcome struct {
+	Claim   speedClaim
+	Speedup float64
+	Held    bool
+	Problem string
+}
+
+// checkClaim measures a claim against the category means.
+func checkClaim(c speedClaim, sum categorySummary) claimOutcome {
+	out := claimOutcome{Claim: c}
+	if c.Category == "all" {
+		if speedup, n := sum.relativeSpeed(c.Engine, c.Than); n > 0 {
+			out.Speedup = speedup
+		}
+	} else {
+		engine, okE := sum.Means[c.Category][c.Engine]
+		than, okT := sum.Means[c.Category][c.Than]
+		if okE && okT && engine > 0 {
+			out.Speedup = float64(than) / float64(engine)
+		}
+	}
+	if out.Speedup == 0 {
+		out.Problem = fmt.Sprintf("no results for both %s and %s on %s", c.Engine, c.Than, c.Category)
+		return out
+	}
+	if c.Op == ">=" {
+		out.Held = out.Speedup >= c.Factor
+	} else {
+		out.Held = out.Speedup <= c.Factor
+	}
+	return out
+}
+
+// checkClaims measures every claim against a set of query results.
+func checkClaims(claims []speedClaim, results []manifest.QueryResult) []claimOutcome {
+	sum := summarizeCategories(results)
+	var outcomes []claimOutcome
+	for _, c := range claims {
+		outcomes = append(outcomes, checkClaim(c, sum))
+	}
+	return outcomes
+}
+
+// status renders an outcome for a table.
+func (o claimOutcome) status() string {
+	switch {
+	case o.Problem != "":
+		return "not measured: " + o.Problem
+	case o.Held:
+		return "held"
+	}
+	return "did not hold"
+}
+
+// workloadClaims are the claims of the run's workload, if it can be read.
+func (st *runState) workloadClaims() []speedClaim {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return nil
+	}
+	return w.claims
+}
+
+// printClaims lists which of the workload's claims held on these results,
+// on this hardware and data.
+func printClaims(claims []speedClaim, results []manifest.QueryResult) {
+	if len(claims) == 0 {
+		return
+	}
+	outcomes := checkClaims(claims, results)
+	table := newConsoleTable("Claim", "Measured", "Result")
+	held := 0
+	for _, o := range outcomes {
+		measured := "-"
+		if o.Problem == "" {
+			measured = fmt.Sprintf("%.2fx", o.Speedup)
+		}
+		if o.Held {
+			held++
+		}
+		table.addRow(o.Claim.Text, measured, o.status())
+	}
+	fmt.Printf("\nCLAIMS (%d of %d held):\n", held, len(outcomes))
+	table.render(os.Stdout)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// cleanTables lists the tables clean drops from every target. The
+// materialized view goes with products.
+func cleanTables() []string {
+	tables := []string{productsTable, SoftDeleteTable, PartitionedTable, RetentionTable, CodeTable, LogTable, EmailTable}
+	for _, l := range marcLanguages {
+		tables = append(tables, marcTable(l))
+	}
+	return tables
+}
+
+// cleanCommand drops the benchmark's tables from the targets and deletes
+// the results and scenario state, so the next run starts from nothing.
+// Named baselines are kept; the dataset only with -dataset, the cache only
+// with -cache.
+func cleanCommand(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	fs.StringVar(&configPath, "config", configPath, "YAML or TOML (.toml) run configuration file")
+	fs.DurationVar(&lockWait, "lock-wait", lockWait, "how long to wait for a benchmark run holding a target to finish before giving up")
+	tables := fs.Bool("tables", true, "drop the benchmark's tables from both targets")
+	results := fs.Bool("results", true, "delete the results, charts, ingest status and scenario state")
+	dataset := fs.Bool("dataset", false, "also delete the downloaded datasets")
+	fs.StringVar(&cacheDir, "cache-dir", cacheDir, "dataset cache -cache deletes")
+	cache := fs.Bool("cache", false, "also delete the dataset cache: downloads and parsed records kept across runs")
+	fs.Parse(args)
+
+	var err error
+	if runConfig, err = loadRunConfig(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := configureTargets(fs, runConfig); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if *tables {
+		for _, t := range defaultTargets() {
+			db, err := getDB(t.Config)
+			if err != nil {
+				log.Fatalf("Failed to connect to %s: %v", t.Label, err)
+			}
+			if err := lockTarget(t.Label, t.Config); err != nil {
+				db.Close()
+				log.Fatalf("%v", err)
+			}
+			for _, table := range cleanTables() {
+				if _, err := db.Exec("DROP TABLE IF EXISTS " + table + " CASCADE"); err != nil {
+					db.Close()
+					log.Fatalf("%s: Failed to drop %s: %v", t.Label, table, err)
+				}
+			}
+			unlockTargets()
+			db.Close()
+			fmt.Printf("🧹 %s: Dropped %d tables\n", t.Label, len(cleanTables()))
+		}
+	}
+
+	var paths []string
+	if *results {
+		matches, err := filepath.Glob(resultsFile(dataDir, "*"))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		journals, err := filepath.Glob(journalFile(dataDir, "*"))
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		paths = append(append(matches, journals...),
+			filepath.Join(dataDir, ScenarioStateFile),
+			filepath.Join(dataDir, EffectiveConfigFile),
+			filepath.Join(dataDir, IngestStatusFile),
+			filepath.Join(dataDir, IngestWatermarksFile),
+			filepath.Join(dataDir, chartsDir),
+			filepath.Join(dataDir, "smoke"))
+	}
+	if *dataset {
+		// Every dataset's download; a custom corpus is the user's own file
+		for _, name := range datasetNames() {
+			if path := datasets[name].Path(dataDir); name != "custom" && path != "" {
+				paths = append(paths, path)
+			}
+		}
+		for _, l := range marcLanguages {
+			paths = append(paths, marcFile(l))
+		}
+	}
+	if *cache && cacheDir != "" {
+		paths = append(paths, cacheDir)
+	}
+	removed := 0
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Fatalf("Failed to remove %s: %v", path, err)
+		}
+		removed++
+	}
+	if len(paths) > 0 {
+		fmt.Printf("🧹 Removed %d files and directories from %s\n", removed, dataDir)
+	}
+}
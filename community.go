@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// communityMinPeers is how many comparable community runs a position needs
+// before it is worth more than a hint.
+const communityMinPeers = 5
+
+// communityOutlier is how far into either tail of the community results a
+// local value has to fall to be flagged as an outlier.
+const communityOutlier = 0.05
+
+// fetchCommunity reads the published aggregate dataset, a JSON array of the
+// summaries -share-results sends, from a URL or a local file.
+func fetchCommunity(source string) ([]sharedSummary, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("%s answered %s", source, resp.Status)
+		}
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else if data, err = os.ReadFile(source); err != nil {
+		return nil, err
+	}
+	var runs []sharedSummary
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, fmt.Errorf("%s is not a list of shared summaries: %v", source, err)
+	}
+	return runs, nil
+}
+
+// comparablePeers are the community runs on the same dataset at about the
+// same scale: within a factor of two of the local row count, when it is
+// known.
+func comparablePeers(local *sharedSummary, runs []sharedSummary) []sharedSummary {
+	var peers []sharedSummary
+	for _, r := range runs {
+		if r.SchemaVersion != sharedSummarySchema || r.Dataset != local.Dataset || r.Degraded {
+			continue
+		}
+		if local.Rows > 0 && (r.Rows < local.Rows/2 || r.Rows > local.Rows*2) {
+			continue
+		}
+		peers = append(peers, r)
+	}
+	return peers
+}
+
+// communityPosition places a local value among the community's: the
+// share of community values it beats, where lower is better for latencies
+// and higher for speedups.
+type communityPosition struct {
+	Peers  int
+	Median float64
+	P10    float64
+	P90    float64
+	Beats  float64
+}
+
+func positionAmong(local float64, values []float64, lowerIsBetter bool) communityPosition {
+	sort.Float64s(values)
+	pos := communityPosition{Peers: len(values)}
+	if len(values) == 0 {
+		return pos
+	}
+	at := func(p float64) float64 { return values[int(p*float64(len(values)-1)+0.5)] }
+	pos.Median, pos.P10, pos.P90 = at(0.5), at(0.1), at(0.9)
+	beaten := 0
+	for _, v := range values {
+		if (lowerIsBetter && local < v) || (!lowerIsBetter && local > v) {
+			beaten++
+		}
+	}
+	pos.Beats = float64(beaten) / float64(len(values))
+	return pos
+}
+
+// verdict says whether a position is in the body of the distribution.
+func (p communityPosition) verdict() string {
+	switch {
+	case p.Peers < communityMinPeers:
+		return "too few peers"
+	case p.Beats >= 1-communityOutlier:
+		return "outlier (faster)"
+	case p.Beats <= communityOutlier:
+		return "outlier (slower)"
+	}
+	return "typical"
+}
+
+// compareCommand positions the local results among the community's.
+func compareCommand(args []string) {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	against := fs.String("against", "", "what to compare the local results with: community")
+	source := fs.String("community-url", "", "URL or file of the published community results, a JSON array of -share-results summaries (default: $PGB_COMMUNITY_URL)")
+	fs.Parse(args)
+	applyFlagEnv(fs, "community-url", "PGB_COMMUNITY_URL", source)
+	if *against != "community" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *source == "" {
+		log.Fatalf("No community results to compare with: pass -community-url, or set PGB_COMMUNITY_URL, to where they are published")
+	}
+	if err := configureTargets(fs, RunConfig{}); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// The targets are only asked for the row count, which picks the peers
+	// at the same scale; without it every run on the dataset is a peer
+	targets := defaultTargets()
+	for _, t := range targets {
+		db, err := getDB(t.Config)
+		if err != nil {
+			warnf("Warning: %s: %v; comparing with every scale of the dataset", t.Label, err)
+			continue
+		}
+		t.DB = db
+		defer db.Close()
+	}
+	local, err := buildSharedSummary(dataDir, targets)
+	if err != nil {
+		log.Fatalf("Failed to summarize the local results: %v", err)
+	}
+	runs, err := fetchCommunity(*source)
+	if err != nil {
+		log.Fatalf("Failed to fetch the community results: %v", err)
+	}
+
+	peers := comparablePeers(local, runs)
+	dataset := local.Dataset
+	if dataset == "" {
+		dataset = "an unnamed dataset"
+	}
+	scale := "any scale"
+	if local.Rows > 0 {
+		scale = fmt.Sprintf("about %d rows", local.Rows)
+	}
+	sameHardware := 0
+	for _, p := range peers {
+		if p.Hardware == local.Hardware {
+			sameHardware++
+		}
+	}
+	fmt.Printf("%d of %d community run(s) are on %s at %s, %d of them on the same hardware class (%s/%s, %s CPUs)\n",
+		len(peers), len(runs), dataset, scale, sameHardware, local.Hardware.OS, local.Hardware.Arch, local.Hardware.CPUs)
+	if len(peers) == 0 {
+		return
+	}
+
+	var categories []string
+	for cat := range local.MeanMs {
+		categories = append(categories, cat)
+	}
+	sort.Strings(categories)
+	table := newConsoleTable("Category", "Engine", "Local", "Community median", "p10-p90", "Peers", "Beats", "Position")
+	for _, cat := range categories {
+		for _, e := range local.Engines {
+			mean, ok := local.MeanMs[cat][e.ID]
+			if !ok {
+				continue
+			}
+			var values []float64
+			for _, p := range peers {
+				if v, ok := p.MeanMs[cat][e.ID]; ok {
+					values = append(values, v)
+				}
+			}
+			pos := positionAmong(mean, values, true)
+			addPositionRow(table, cat, e.ID, pos, mean, "%.2fms")
+		}
+	}
+	for _, e := range local.Engines {
+		speedup, ok := local.Speedups[e.ID]
+		if !ok {
+			continue
+		}
+		var values []float64
+		for _, p := range peers {
+			if v, ok := p.Speedups[e.ID]; ok && p.Baseline == local.Baseline {
+				values = append(values, v)
+			}
+		}
+		pos := positionAmong(speedup, values, false)
+		addPositionRow(table, "speedup vs "+local.Baseline, e.ID, pos, speedup, "%.2fx")
+	}
+	fmt.Println("\nLOCAL RESULTS AMONG THE COMMUNITY'S:")
+	table.render(os.Stdout)
+}
+
+func addPositionRow(table *consoleTable, cat, engine string, pos communityPosition, local float64, format string) {
+	if pos.Peers == 0 {
+		table.addRow(cat, engine, fmt.Sprintf(format, local), "-", "-", "0", "-", "no peers")
+		return
+	}
+	table.addRow(cat, engine, fmt.Sprintf(format, local), fmt.Sprintf(format, pos.Median),
+		fmt.Sprintf(format+"-"+format, pos.P10, pos.P90), fmt.Sprintf("%d", pos.Peers),
+		fmt.Sprintf("%.0f%%", pos.Beats*100), pos.verdict())
+}
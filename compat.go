@@ -0,0 +1,133 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var skipCompatCheck = false
+
+// compareVersions compares dotted numeric versions, ignoring any suffix such
+// as "-dev" or "+build".
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// checkParadeDBCompat verifies a ParadeDB target can run the benchmark as
+// configured: a recent enough pg_search, the library preloaded, and the
+// BM25 index options accepted. Any failure here would otherwise surface as
+// a silently degraded index and meaningless comparisons. The BM25 template
+// picked for its pg_search version is kept on the target.
+func checkParadeDBCompat(t *benchTarget, db *sql.DB) error {
+	var available sql.NullString
+	err := db.QueryRow("SELECT default_version FROM pg_available_extensions WHERE name = 'pg_search'").Scan(&available)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("pg_search is not installed on this server")
+	}
+	if err != nil {
+		return fmt.Errorf("could not look up pg_search: %v", err)
+	}
+
+	var installed sql.NullString
+	err = db.QueryRow("SELECT extversion FROM pg_extension WHERE extname = 'pg_search'").Scan(&installed)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("could not look up pg_search: %v", err)
+	}
+	version := available.String
+	if installed.Valid {
+		version = installed.String
+	}
+	tmpl, err := selectBM25Template(version, t.BM25Syntax)
+	if err != nil {
+		return err
+	}
+	t.BM25 = &tmpl
+
+	preload, err := catalogOf(db).preloadLibraries()
+	if err != nil {
+		return fmt.Errorf("could not read shared_preload_libraries: %v", err)
+	}
+	if !isPreloaded(preload, "pg_search") {
+		return fmt.Errorf("pg_search is not in shared_preload_libraries (%q)", preload)
+	}
+
+	if err := checkBM25Options(db, tmpl); err != nil {
+		return fmt.Errorf("pg_search %s rejects the BM25 index options: %v", version, err)
+	}
+
+	infof("%s: pg_search %s OK (%s index syntax)", t.Label, version, tmpl.Name)
+	return nil
+}
+
+// checkBM25Options builds the real index definition on an empty scratch
+// table inside a transaction that is always rolled back.
+func checkBM25Options(db *sql.DB, tmpl bm25Template) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE EXTENSION IF NOT EXISTS pg_search"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		CREATE TABLE pgsb_preflight (
+			id SERIAL PRIMARY KEY,
+			title TEXT,
+			description TEXT,
+			brand VARCHAR(200)
+		)
+	`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(tmpl.Index, "pgsb_preflight"))
+	return err
+}
+
+// checkVanillaCompat verifies pg_trgm is available for the fuzzy indexes.
+func checkVanillaCompat(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'pg_trgm')").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("could not look up pg_trgm: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("pg_trgm is not installed on this server")
+	}
+	return nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// RunConfig is the optional YAML or TOML file passed with -config.
+type RunConfig struct {
+	// Targets override the connection of each engine (vanilla, parade)
+	// or add targets under other names, selected with -targets.
+	Targets map[string]TargetConfig `yaml:"targets" toml:"targets"`
+	// BatchSize, Workers and SampleSize tune the load; zero keeps the
+	// default.
+	BatchSize  int   `yaml:"batch_size" toml:"batch_size"`
+	Workers    int   `yaml:"workers" toml:"workers"`
+	SampleSize int32 `yaml:"sample_size" toml:"sample_size"`
+	// Table and Schema are where the corpus is loaded and searched, like
+	// -table and -schema.
+	Table  string `yaml:"table" toml:"table"`
+	Schema string `yaml:"schema" toml:"schema"`
+	// Hooks are keyed by target name (vanilla, parade or another target).
+	Hooks map[string]EngineHooks `yaml:"hooks" toml:"hooks"`
+}
+
+// TargetConfig is where one engine runs; empty fields keep the default.
+type TargetConfig struct {
+	// Engine is vanilla or parade, whose statements and indexes a target
+	// under another name gets; its connection starts from that engine's
+	Engine string `yaml:"engine" toml:"engine"`
+	// Label names the target in output, and Service is its docker compose
+	// service; both only apply to targets under other names
+	Label   string `yaml:"label" toml:"label"`
+	Service string `yaml:"service" toml:"service"`
+
+	Host     string `yaml:"host" toml:"host"`
+	Port     int    `yaml:"port" toml:"port"`
+	Database string `yaml:"database" toml:"database"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	// PasswordFile is a file holding only the password, such as a mounted
+	// secret; it wins over every other source of the password
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	// DSN is a full connection string; the fields above override its parts
+	DSN string `yaml:"dsn" toml:"dsn"`
+	// SSLMode, SSLRootCert, SSLCert and SSLKey set up TLS, as in libpq
+	SSLMode     string `yaml:"sslmode" toml:"sslmode"`
+	SSLRootCert string `yaml:"sslrootcert" toml:"sslrootcert"`
+	SSLCert     string `yaml:"sslcert" toml:"sslcert"`
+	SSLKey      string `yaml:"sslkey" toml:"sslkey"`
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime size the target's
+	// connection pool (default 50, 10 and 5m), e.g. to stay under a
+	// managed instance's max_connections
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+
+	// Settings are run-time parameters (work_mem, jit, ...) set on every
+	// connection, so targets on one server can differ in configuration
+	Settings map[string]string `yaml:"settings" toml:"settings"`
+	// Indexes replace the engine's indexes built after the load, written
+	// against products like workload statements
+	Indexes []string `yaml:"indexes" toml:"indexes"`
+	// BM25Syntax picks the BM25 index template of a parade target, like
+	// -bm25-syntax; by default it follows the target's pg_search version
+	BM25Syntax string `yaml:"bm25_syntax"`
+}
+
+// EngineHooks are SQL scripts run against one engine around each phase, for
+// experiments that need GUC tweaks, extra extensions or stats resets.
+type EngineHooks struct {
+	BeforeSetup    []HookScript `yaml:"before_setup" toml:"before_setup"`
+	AfterSetup     []HookScript `yaml:"after_setup" toml:"after_setup"`
+	BeforeScenario []HookScript `yaml:"before_scenario" toml:"before_scenario"`
+	AfterScenario  []HookScript `yaml:"after_scenario" toml:"after_scenario"`
+}
+
+// HookScript is inline SQL or a path to a .sql file; a script may contain
+// several statements.
+type HookScript struct {
+	SQL  string `yaml:"sql" toml:"sql"`
+	File string `yaml:"file" toml:"file"`
+}
+
+var (
+	configPath = ""
+	runConfig  RunConfig
+)
+
+var (
+	// productsTable is the table the corpus is loaded into and searched;
+	// statements are written against products and renamed by productsSQL
+	productsTable = "products"
+	// dbSchema, when set, is created if missing and put first on the
+	// search_path of every connection, so each corpus can keep its own
+	dbSchema = ""
+)
+
+// sqlIdentifier matches the table and schema names accepted, which need no
+// quoting.
+var sqlIdentifier = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// productsTableName matches products as a whole word, the indexes named
+// after it (its BM25 index, primary key and asin constraint) and the vanilla
+// idx_* indexes, leaving copies such as products_soft alone.
+var productsTableName = regexp.MustCompile(`\bproducts(_search_idx|_pkey|_asin_unique)?\b|\bidx_\w+`)
+
+// productsSQL points a statement or index name written against products at
+// productsTable. The idx_* indexes become <table>_idx_*, so two tables in
+// one schema don't share index names.
+func productsSQL(stmt string) string {
+	if productsTable == "products" {
+		return stmt
+	}
+	return productsTableName.ReplaceAllStringFunc(stmt, func(name string) string {
+		if strings.HasPrefix(name, "idx_") {
+			return productsTable + "_" + name
+		}
+		return productsTable + strings.TrimPrefix(name, "products")
+	})
+}
+
+// maxIdentifierLength is the most bytes of a name PostgreSQL keeps; longer
+// ones are truncated.
+const maxIdentifierLength = 63
+
+// trgmExtensionDDL installs pg_trgm. With -schema it goes in public, which
+// every schema's search_path includes, rather than the first schema to load.
+func trgmExtensionDDL() string {
+	if dbSchema != "" {
+		return "CREATE EXTENSION IF NOT EXISTS pg_trgm SCHEMA public"
+	}
+	return "CREATE EXTENSION IF NOT EXISTS pg_trgm"
+}
+
+// ensureSchema creates dbSchema if it doesn't exist. It checks first, so a
+// role without CREATE on the database can use an existing schema.
+func ensureSchema(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow("SELECT to_regnamespace($1) IS NOT NULL", dbSchema).Scan(&exists); err != nil || exists {
+		return err
+	}
+	_, err := db.Exec("CREATE SCHEMA IF NOT EXISTS " + dbSchema)
+	return err
+}
+
+// checkTableFlags rejects a -table or -schema that would need quoting.
+func checkTableFlags() error {
+	for flagName, name := range map[string]string{"table": productsTable, "schema": dbSchema} {
+		if name != "" && !sqlIdentifier.MatchString(name) {
+			return fmt.Errorf("-%s %q is not a lowercase SQL identifier", flagName, name)
+		}
+	}
+	if productsTable == "" {
+		return fmt.Errorf("-table can't be empty")
+	}
+	// The renamed indexes would be truncated to names nothing looks for
+	if idx := productsSQL("idx_combined_fulltext"); len(idx) > maxIdentifierLength {
+		return fmt.Errorf("-table %q is too long: its index %s would exceed %d bytes", productsTable, idx, maxIdentifierLength)
+	}
+	return nil
+}
+
+// samplePresets are the -preset sample sizes; full (0) loads everything.
+var samplePresets = map[string]int32{"small": 10000, "medium": 200000, "full": 0}
+
+var (
+	// sampleSizeFlag and samplePreset are -sample-size and -preset, which
+	// win over sample_size in the config file
+	sampleSizeFlag = 0
+	samplePreset   = ""
+)
+
+// applySampleFlags sets SampleSize from -sample-size or -preset when
+// either was given.
+func applySampleFlags(setFlags map[string]bool) error {
+	if setFlags["sample-size"] && setFlags["preset"] {
+		return fmt.Errorf("-sample-size and -preset both set the sample size, pick one")
+	}
+	switch {
+	case setFlags["sample-size"]:
+		if sampleSizeFlag < 0 || sampleSizeFlag > math.MaxInt32 {
+			return fmt.Errorf("-sample-size must be between 0 (all) and %d, got %d", math.MaxInt32, sampleSizeFlag)
+		}
+		SampleSize = int32(sampleSizeFlag)
+	case setFlags["preset"]:
+		n, ok := samplePresets[samplePreset]
+		if !ok {
+			return fmt.Errorf("unknown -preset %q (want small, medium or full)", samplePreset)
+		}
+		SampleSize = n
+	}
+	return nil
+}
+
+func loadRunConfig(path string) (RunConfig, error) {
+	var cfg RunConfig
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	// yaml.v3 would read most TOML as one bare string, so the extension
+	// picks the decoder
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		if _, err := toml.Decode(string(data), &cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %v", path, err)
+		}
+	} else if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, tc := range cfg.Targets {
+		switch {
+		case name == "vanilla" || name == "parade":
+			if tc.Engine != "" && tc.Engine != name {
+				return cfg, fmt.Errorf("%s: target %s can't change its engine to %q", path, name, tc.Engine)
+			}
+			if tc.Label != "" || tc.Service != "" {
+				return cfg, fmt.Errorf("%s: target %s can't change its label or service", path, name)
+			}
+		case tc.Engine != "vanilla" && tc.Engine != "parade":
+			return cfg, fmt.Errorf("%s: target %q needs engine: vanilla or parade", path, name)
+		case strings.ContainsAny(name, ", "):
+			return cfg, fmt.Errorf("%s: target name %q can't contain commas or spaces", path, name)
+		}
+	}
+	if cfg.BatchSize < 0 || cfg.Workers < 0 || cfg.SampleSize < 0 {
+		return cfg, fmt.Errorf("%s: batch_size, workers and sample_size can't be negative", path)
+	}
+	for name, tc := range cfg.Targets {
+		if tc.BM25Syntax != "" && name != "parade" && tc.Engine != "parade" {
+			return cfg, fmt.Errorf("%s: target %s: bm25_syntax only applies to parade targets", path, name)
+		}
+		for setting := range tc.Settings {
+			if !sqlIdentifier.MatchString(strings.ReplaceAll(setting, ".", "_")) {
+				return cfg, fmt.Errorf("%s: target %s: %q is not a setting name", path, name, setting)
+			}
+		}
+	}
+	for _, name := range []string{cfg.Table, cfg.Schema} {
+		if name != "" && !sqlIdentifier.MatchString(name) {
+			return cfg, fmt.Errorf("%s: %q is not a lowercase SQL identifier", path, name)
+		}
+	}
+	for engine, hooks := range cfg.Hooks {
+		for _, phase := range [][]HookScript{hooks.BeforeSetup, hooks.AfterSetup, hooks.BeforeScenario, hooks.AfterScenario} {
+			for _, h := range phase {
+				if (h.SQL == "") == (h.File == "") {
+					return cfg, fmt.Errorf("%s: %s hook needs exactly one of sql or file", path, engine)
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// applyRunConfig points the targets and load settings at what the config
+// file gives. Flags set on the command line win over the file.
+func applyRunConfig(cfg RunConfig, setFlags map[string]bool) error {
+	for engine, tc := range cfg.Targets {
+		if _, ok := targetConfigs()[engine]; !ok {
+			c := *targetConfigs()[tc.Engine]
+			c.DSN = ""
+			extraTargetConfigs[engine] = &c
+		}
+		if tc.DSN != "" && !setFlags[engine+"-dsn"] {
+			if err := useDSN(engine, tc.DSN, setFlags); err != nil {
+				return err
+			}
+		}
+		dst := targetConfigs()[engine]
+		if tc.Host != "" && !setFlags[engine+"-host"] {
+			dst.Host = tc.Host
+		}
+		if tc.Port != 0 && !setFlags[engine+"-port"] {
+			dst.Port = tc.Port
+		}
+		if tc.Database != "" {
+			dst.Database = tc.Database
+		}
+		if tc.User != "" {
+			dst.User = tc.User
+		}
+		if tc.Password != "" {
+			dst.Password = tc.Password
+		}
+		if tc.PasswordFile != "" && !setFlags[engine+"-password-file"] {
+			dst.PasswordFile = tc.PasswordFile
+		}
+		src := DBConfig{SSLMode: tc.SSLMode, SSLRootCert: tc.SSLRootCert, SSLCert: tc.SSLCert, SSLKey: tc.SSLKey}
+		overrideTLS(dst, &src)
+		if tc.MaxOpenConns < 0 || tc.MaxIdleConns < 0 || tc.ConnMaxLifetime < 0 {
+			return fmt.Errorf("%s: max_open_conns, max_idle_conns and conn_max_lifetime must not be negative", engine)
+		}
+		if tc.MaxOpenConns > 0 {
+			dst.MaxOpenConns = tc.MaxOpenConns
+		}
+		if tc.MaxIdleConns > 0 {
+			dst.MaxIdleConns = tc.MaxIdleConns
+		}
+		if tc.ConnMaxLifetime > 0 {
+			dst.ConnMaxLifetime = tc.ConnMaxLifetime
+		}
+		if len(tc.Settings) > 0 {
+			dst.Settings = tc.Settings
+		}
+	}
+	if cfg.Table != "" && !setFlags["table"] {
+		productsTable = cfg.Table
+	}
+	if cfg.Schema != "" && !setFlags["schema"] {
+		dbSchema = cfg.Schema
+	}
+	if cfg.BatchSize > 0 {
+		BatchSize = cfg.BatchSize
+	}
+	if cfg.Workers > 0 {
+		MaxWorkers = cfg.Workers
+	}
+	if cfg.SampleSize > 0 {
+		SampleSize = cfg.SampleSize
+	}
+	return nil
+}
+
+// runHooks executes the scripts for one engine and phase in order, stopping
+// at the first failure.
+func runHooks(db *sql.DB, engine, phase string, scripts []HookScript) error {
+	for i, h := range scripts {
+		script := h.SQL
+		name := fmt.Sprintf("%s hook %d", phase, i+1)
+		if h.File != "" {
+			data, err := os.ReadFile(h.File)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			script = string(data)
+			name = fmt.Sprintf("%s hook %s", phase, h.File)
+		}
+
+		infof("%s: Running %s", engine, name)
+		if err := execScript(db, script); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// execScript runs each statement of script on its own, on one connection.
+// Sent in one Exec, several statements would form an implicit transaction,
+// in which ALTER SYSTEM and VACUUM fail; an explicit BEGIN still groups the
+// statements up to its COMMIT.
+func execScript(db *sql.DB, script string) error {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stmts := splitSQLStatements(script)
+	for i, stmt := range stmts {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			if len(stmts) == 1 {
+				return err
+			}
+			return fmt.Errorf("statement %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// splitSQLStatements splits a script at the semicolons outside quotes,
+// dollar quotes and comments, dropping statements that are only comments.
+func splitSQLStatements(script string) []string {
+	var stmts []string
+	start, code := 0, false
+	flush := func(end int) {
+		if code {
+			stmts = append(stmts, strings.TrimSpace(script[start:end]))
+		}
+		start, code = end+1, false
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == ';':
+			flush(i)
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			if end := strings.IndexByte(script[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(script)
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			// Block comments nest in PostgreSQL
+			depth := 0
+			for ; i < len(script); i++ {
+				if strings.HasPrefix(script[i:], "/*") {
+					depth++
+					i++
+				} else if strings.HasPrefix(script[i:], "*/") {
+					depth--
+					i++
+					if depth == 0 {
+						break
+					}
+				}
+			}
+		case c == '\'' || c == '"':
+			code = true
+			// E'' strings may escape their quote with a backslash
+			escapes := c == '\'' && i > 0 && (script[i-1] == 'E' || script[i-1] == 'e')
+			for i++; i < len(script); i++ {
+				if escapes && script[i] == '\\' {
+					i++
+				} else if script[i] == c {
+					// A doubled quote stands for itself
+					if i+1 < len(script) && script[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case c == '$':
+			code = true
+			// A $ inside an identifier, as in a$b$, opens nothing
+			if i > 0 && sqlIdentifierChar(script[i-1]) {
+				break
+			}
+			if tag := dollarQuoteTag.FindString(script[i:]); tag != "" {
+				if end := strings.Index(script[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(script)
+				}
+			}
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			code = true
+		}
+	}
+	flush(len(script))
+	return stmts
+}
+
+func sqlIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// dollarQuoteTag matches the opening of a dollar-quoted string, $$ or
+// $tag$; $1 is a parameter.
+var dollarQuoteTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadRunConfigFormat(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "benchmark.yaml")
+	if err := os.WriteFile(yamlPath, []byte("batch_size: 500\nworkers: 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadRunConfig(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BatchSize != 500 || cfg.Workers != 2 {
+		t.Errorf("batch_size %d, workers %d", cfg.BatchSize, cfg.Workers)
+	}
+
+	tomlPath := filepath.Join(dir, "benchmark.toml")
+	toml := `batch_size = 500
+workers = 2
+
+[targets.vanilla]
+host = "pg-vanilla.internal"
+conn_max_lifetime = "10m"
+
+[targets.vanilla.settings]
+work_mem = "64MB"
+
+[targets.replica]
+engine = "vanilla"
+port = 5440
+
+[[hooks.vanilla.before_scenario]]
+sql = "SELECT pg_stat_reset()"
+`
+	if err := os.WriteFile(tomlPath, []byte(toml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = loadRunConfig(tomlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vanilla := cfg.Targets["vanilla"]
+	if cfg.BatchSize != 500 || cfg.Workers != 2 {
+		t.Errorf("TOML batch_size %d, workers %d", cfg.BatchSize, cfg.Workers)
+	}
+	if vanilla.Host != "pg-vanilla.internal" || vanilla.ConnMaxLifetime != 10*time.Minute || vanilla.Settings["work_mem"] != "64MB" {
+		t.Errorf("TOML vanilla target %+v", vanilla)
+	}
+	if replica := cfg.Targets["replica"]; replica.Engine != "vanilla" || replica.Port != 5440 {
+		t.Errorf("TOML replica target %+v", replica)
+	}
+	if hooks := cfg.Hooks["vanilla"].BeforeScenario; len(hooks) != 1 || hooks[0].SQL != "SELECT pg_stat_reset()" {
+		t.Errorf("TOML hooks %+v", hooks)
+	}
+
+	if err := os.WriteFile(tomlPath, []byte("batch_size = \"lots\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRunConfig(tomlPath); err == nil || !strings.Contains(err.Error(), tomlPath) {
+		t.Errorf("invalid TOML config: %v", err)
+	}
+}
+
+func TestProductsSQL(t *testing.T) {
+	defer func(table string) { productsTable = table }(productsTable)
+	productsTable = "amazon"
+	for stmt, want := range map[string]string{
+		"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_trgm ON products USING gin (title gin_trgm_ops)": "CREATE INDEX CONCURRENTLY IF NOT EXISTS amazon_idx_title_trgm ON amazon USING gin (title gin_trgm_ops)",
+		"ALTER TABLE products ADD CONSTRAINT products_asin_unique UNIQUE (asin)":                            "ALTER TABLE amazon ADD CONSTRAINT amazon_asin_unique UNIQUE (asin)",
+		"products_search_idx":                    "amazon_search_idx",
+		"products_pkey":                          "amazon_pkey",
+		"INSERT INTO products_soft SELECT 1":     "INSERT INTO products_soft SELECT 1",
+		"SELECT COUNT(*) FROM products p":        "SELECT COUNT(*) FROM amazon p",
+		"CREATE INDEX my_idx_x ON products_soft": "CREATE INDEX my_idx_x ON products_soft",
+	} {
+		if got := productsSQL(stmt); got != want {
+			t.Errorf("productsSQL(%q) = %q, want %q", stmt, got, want)
+		}
+	}
+
+	productsTable = strings.Repeat("t", 41)
+	if err := checkTableFlags(); err != nil {
+		t.Errorf("41-byte table: %v", err)
+	}
+	productsTable = strings.Repeat("t", 42)
+	if err := checkTableFlags(); err == nil {
+		t.Error("42-byte table accepted")
+	}
+}
+
+func TestSplitSQLStatements(t *testing.T) {
+	for _, tc := range []struct {
+		name, script string
+		want         []string
+	}{
+		{"empty", "", nil},
+		{"one without semicolon", "SELECT 1", []string{"SELECT 1"}},
+		{"two", "ALTER SYSTEM SET work_mem = '64MB'; SELECT pg_reload_conf();", []string{"ALTER SYSTEM SET work_mem = '64MB'", "SELECT pg_reload_conf()"}},
+		{"semicolon in a string", "SELECT 'a;b'; SELECT 'it''s;'", []string{"SELECT 'a;b'", "SELECT 'it''s;'"}},
+		{"escape string", `SELECT E'a\';b'; SELECT 2`, []string{`SELECT E'a\';b'`, "SELECT 2"}},
+		{"quoted identifier", `SELECT 1 AS "x;y"; SELECT 2`, []string{`SELECT 1 AS "x;y"`, "SELECT 2"}},
+		{"dollar quotes", "DO $$ BEGIN PERFORM 1; END $$; DO $fn$ BEGIN RAISE NOTICE '$$;'; END $fn$;", []string{"DO $$ BEGIN PERFORM 1; END $$", "DO $fn$ BEGIN RAISE NOTICE '$$;'; END $fn$"}},
+		{"parameter", "SELECT $1; SELECT 2", []string{"SELECT $1", "SELECT 2"}},
+		{"dollar in an identifier", "SELECT a$b$ FROM t; SELECT 2", []string{"SELECT a$b$ FROM t", "SELECT 2"}},
+		{"comments", "-- reset; stats\nSELECT pg_stat_reset(); /* a; /* nested; */ b */ VACUUM products;\n-- done;", []string{"-- reset; stats\nSELECT pg_stat_reset()", "/* a; /* nested; */ b */ VACUUM products"}},
+		{"only comments and semicolons", "-- nothing;\n;;", nil},
+		{"unterminated string", "SELECT 'a; SELECT 2", []string{"SELECT 'a; SELECT 2"}},
+	} {
+		if got := splitSQLStatements(tc.script); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: splitSQLStatements(%q) = %q, want %q", tc.name, tc.script, got, tc.want)
+		}
+	}
+}
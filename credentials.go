@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// defaultPassword is the password of the docker-compose databases, used
+// for a target no other source gives one.
+const defaultPassword = "benchmark123"
+
+// promptPassword is -password-prompt: ask on the terminal for the password
+// of each target no other source gives one.
+var promptPassword = false
+
+// resolvePasswords settles each target's password once the other settings
+// are applied: a password file wins, a password set elsewhere is kept,
+// then ~/.pgpass (or PGPASSFILE) is looked up, then with -password-prompt
+// the user is asked, and otherwise the docker-compose default is used.
+// Only the targets -targets selects are settled, so nobody is asked for the
+// password of a target the run doesn't connect to.
+func resolvePasswords() error {
+	configs := targetConfigs()
+	for _, engine := range selectedTargetNames() {
+		dst := configs[engine]
+		if dst.PasswordFile != "" {
+			password, err := readPasswordFile(dst.PasswordFile)
+			if err != nil {
+				return fmt.Errorf("%s: %v", engine, err)
+			}
+			dst.Password = password
+			continue
+		}
+		if dst.Password != "" {
+			continue
+		}
+		password, err := pgpassLookup(dst)
+		if err != nil {
+			warnf("Warning: %v", err)
+		}
+		if password == "" && promptPassword && !fakeEngines {
+			if password, err = readPasswordPrompt(fmt.Sprintf("Password for %s@%s:%d/%s (%s): ", dst.User, dst.Host, dst.Port, dst.Database, engine)); err != nil {
+				return fmt.Errorf("%s: %v", engine, err)
+			}
+		}
+		if password == "" {
+			password = defaultPassword
+		}
+		dst.Password = password
+	}
+	return nil
+}
+
+// readPasswordFile reads a secrets file holding only the password, as
+// mounted by Docker and Kubernetes secrets; a trailing newline is dropped.
+func readPasswordFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		warnf("Warning: %s is readable by group or others; chmod 600 it", path)
+	}
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return password, nil
+}
+
+// pgpassFile is the password file libpq reads: PGPASSFILE, or .pgpass in
+// the home directory (pgpass.conf under APPDATA on Windows).
+func pgpassFile() string {
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// pgpassLookup returns the password of the first pgpass line matching the
+// target's host, port, database and user, or "" if none does. As libpq,
+// it ignores a file group or others can read.
+func pgpassLookup(config *DBConfig) (string, error) {
+	path := pgpassFile()
+	if path == "" {
+		return "", nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("ignoring %s, which is readable by group or others; chmod 600 it", path)
+	}
+
+	want := []string{config.Host, strconv.Itoa(config.Port), config.Database, config.User}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := pgpassFields(line)
+		if len(fields) != 5 {
+			continue
+		}
+		match := true
+		for i, w := range want {
+			match = match && (fields[i] == "*" || fields[i] == w)
+		}
+		if match {
+			return fields[4], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// pgpassFields splits a pgpass line on the colons not escaped with a
+// backslash.
+func pgpassFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case c == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// readPasswordPrompt asks for a password on the terminal with echo turned
+// off, so it lands in neither the shell history nor the scrollback.
+func readPasswordPrompt(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("-password-prompt needs a terminal on stdin")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
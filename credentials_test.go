@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePasswordsSelectedOnly(t *testing.T) {
+	defer func(names string, vanilla, parade DBConfig, prompt bool) {
+		targetNames, vanillaConfig, paradeConfig, promptPassword = names, vanilla, parade, prompt
+	}(targetNames, vanillaConfig, paradeConfig, promptPassword)
+	t.Setenv("PGPASSFILE", filepath.Join(t.TempDir(), "pgpass"))
+
+	targetNames = "parade"
+	vanillaConfig.Password, vanillaConfig.PasswordFile = "", ""
+	paradeConfig.Password, paradeConfig.PasswordFile = "", ""
+	// A prompt for vanilla would fail without a terminal on stdin
+	promptPassword = true
+	paradeConfig.Password = "secret"
+	if err := resolvePasswords(); err != nil {
+		t.Fatal(err)
+	}
+	if vanillaConfig.Password != "" {
+		t.Errorf("unselected vanilla got password %q", vanillaConfig.Password)
+	}
+	if paradeConfig.Password != "secret" {
+		t.Errorf("parade password %q", paradeConfig.Password)
+	}
+
+	promptPassword = false
+	targetNames = "vanilla,parade"
+	if err := resolvePasswords(); err != nil {
+		t.Fatal(err)
+	}
+	if vanillaConfig.Password != defaultPassword {
+		t.Errorf("vanilla password %q, want the default", vanillaConfig.Password)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dataset is a corpus the setup phases load into productsTable. Datasets map
+// their documents onto the products columns (title, description, brand,
+// categories), so the indexes and scenarios apply to all of them; each
+// brings its own workload.
+type Dataset interface {
+	// Path is where the corpus is kept under dir
+	Path(dir string) string
+	// Download fetches the corpus to path unless it is already there
+	Download(path string) error
+	// Schema creates the corpus table, written against products
+	Schema() string
+	// Stream parses the corpus at path and calls emit with each record in
+	// order, its Provenance naming the record. Records that can't be
+	// loaded are passed with the reason instead; a non-nil return from
+	// emit stops the stream and is returned, errStopStream as nil.
+	Stream(path string, emit func(Product, error) error) error
+	// Workload is the workload file the corpus is searched with, or empty
+	// when -workload must name one
+	Workload() string
+	// Fingerprint identifies the version of the corpus at path
+	Fingerprint(path string) (string, error)
+}
+
+// datasets are the registered datasets by -dataset name.
+var datasets = map[string]Dataset{}
+
+// registerDataset makes a dataset selectable with -dataset; the dataset
+// files call it from init.
+func registerDataset(name string, d Dataset) {
+	if _, ok := datasets[name]; ok {
+		panic("dataset registered twice: " + name)
+	}
+	datasets[name] = d
+}
+
+// datasetNames lists the registered datasets in order.
+func datasetNames() []string {
+	names := make([]string, 0, len(datasets))
+	for name := range datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	// datasetName is -dataset, the corpus the setup phases load
+	datasetName = "amazon"
+	// datasetFile is -dataset-file: the corpus of the custom dataset, or a
+	// copy of another dataset's to use instead of downloading it
+	datasetFile = ""
+	// activeDataset and datasetFingerprint are what selectDataset picked
+	activeDataset      Dataset
+	datasetFingerprint = ""
+	// streamDataset is -stream: read the corpus from its download URL as it
+	// arrives instead of downloading it first
+	streamDataset = false
+)
+
+// remoteDataset is a Dataset downloaded from a URL, which -stream can read
+// directly.
+type remoteDataset interface {
+	URL() string
+}
+
+// streamedDatasetPath is the corpus "path" of -stream: the active
+// dataset's download URL.
+func streamedDatasetPath() (string, error) {
+	if datasetFile != "" {
+		return "", fmt.Errorf("-stream reads the dataset from its download URL; -dataset-file is a local copy already")
+	}
+	d, ok := activeDataset.(remoteDataset)
+	if !ok {
+		return "", fmt.Errorf("-stream needs a dataset with a download URL, which %s has not", datasetName)
+	}
+	return d.URL(), nil
+}
+
+// isRemoteDump reports whether a corpus path is a URL, as with -stream.
+func isRemoteDump(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// remoteStallTimeout gives up on a download that has sent nothing for this
+// long. It does not bound the whole download, which takes as long as the
+// load reading it.
+const remoteStallTimeout = 2 * time.Minute
+
+// openRemoteDump starts downloading url and returns the response body, to
+// be read as it arrives.
+func openRemoteDump(url string) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Cancelling the request is what ends a stalled header wait or read
+	stall := time.AfterFunc(remoteStallTimeout, cancel)
+	resp, err := http.DefaultClient.Do(req)
+	stall.Stop()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("GET %s: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return &stallReader{body: resp.Body, stall: stall, cancel: cancel}, nil
+}
+
+// stallReader is a download body that is cancelled when one read waits
+// longer than remoteStallTimeout. Only the wait inside Read counts, not the
+// time the load spends between reads.
+type stallReader struct {
+	body   io.ReadCloser
+	stall  *time.Timer
+	cancel context.CancelFunc
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.stall.Reset(remoteStallTimeout)
+	n, err := r.body.Read(p)
+	if !r.stall.Stop() && err != nil {
+		err = fmt.Errorf("download stalled for %v: %v", remoteStallTimeout, err)
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.stall.Stop()
+	r.cancel()
+	return r.body.Close()
+}
+
+// remoteFingerprint is fileFingerprint of the file a URL would download,
+// from its size and a ranged request for its head.
+func remoteFingerprint(url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", fingerprintBytes-1))
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes 0-1048575/<size>
+		_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+		size, err = strconv.ParseInt(total, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("GET %s: no size in Content-Range %q", url, resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		size = resp.ContentLength
+	default:
+		return "", fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if size <= 0 {
+		return "", fmt.Errorf("GET %s: size unknown", url)
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, resp.Body, fingerprintBytes); err != nil && err != io.EOF {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s", filepath.Base(url), size, hex.EncodeToString(h.Sum(nil))[:16]), nil
+}
+
+// selectDataset sets activeDataset from -dataset.
+func selectDataset() error {
+	d, ok := datasets[datasetName]
+	if !ok {
+		return fmt.Errorf("unknown -dataset %q (want %s)", datasetName, strings.Join(datasetNames(), ", "))
+	}
+	activeDataset = d
+	return nil
+}
+
+// datasetPath is where the active dataset's corpus is: -dataset-file if
+// given, else its place in dir or the cache.
+func datasetPath(dir string) string {
+	if datasetFile != "" {
+		return datasetFile
+	}
+	return cachedDatasetPath(datasetName, dir)
+}
+
+// errStopStream stops a Stream early without an error.
+var errStopStream = errors.New("stop stream")
+
+// streamLines calls parse on every non-empty line of a file, gzipped if it
+// ends in .gz, and passes the result to emit with the line's provenance.
+func streamLines(path string, parse func(string) (Product, error), emit func(Product, error) error) error {
+	f, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return scanLines(f, filepath.Base(path), parse, emit)
+}
+
+// scanLines is streamLines over an open reader; source names it in the
+// provenance ids.
+func scanLines(r io.Reader, source string, parse func(string) (Product, error), emit func(Product, error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		product, err := parse(line)
+		product.Provenance = provenanceID(source, lineNo)
+		if err == nil && (product.ASIN == "" || product.Title == "") {
+			err = fmt.Errorf("no asin or title")
+		}
+		if err := emit(product, err); err != nil {
+			if err == errStopStream {
+				return nil
+			}
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// fingerprintBytes is how much of the head of a corpus file its
+// fingerprint hashes; with the size it tells versions apart without
+// reading gigabytes.
+const fingerprintBytes = 1 << 20
+
+// fileFingerprint identifies a corpus file by its size and a hash of its
+// head.
+func fileFingerprint(path string) (string, error) {
+	if isRemoteDump(path) {
+		return remoteFingerprint(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, fingerprintBytes); err != nil && err != io.EOF {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%s", filepath.Base(path), info.Size(), hex.EncodeToString(h.Sum(nil))[:16]), nil
+}
+
+// downloadDataset fetches url to path unless it is already there, after
+// checking there is room for it.
+func downloadDataset(url, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := checkDownloadSpace(url, path); err != nil {
+		return fmt.Errorf("preflight failed: %v", err)
+	}
+	return downloadFile(url, path)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+func init() {
+	registerDataset("amazon", amazonDataset{})
+	registerDataset("custom", customDataset{})
+}
+
+// amazonDataset is the Stanford SNAP Amazon product metadata, one Python
+// dict literal per line.
+type amazonDataset struct{}
+
+func (amazonDataset) Path(dir string) string { return filepath.Join(dir, MetadataFile) }
+
+func (amazonDataset) Download(path string) error {
+	infof("🛒 Setting up real Amazon products dataset from Stanford SNAP...")
+	return downloadDataset(MetadataURL, path)
+}
+
+func (amazonDataset) URL() string { return MetadataURL }
+
+func (amazonDataset) Schema() string { return productsTableDDL }
+
+func (amazonDataset) Stream(path string, emit func(Product, error) error) error {
+	return streamLines(path, parseProductLine, emit)
+}
+
+func (amazonDataset) Workload() string { return "workloads/amazon.yaml" }
+
+func (amazonDataset) Fingerprint(path string) (string, error) {
+	return fileFingerprint(path)
+}
+
+// customDataset is a JSON lines file given with -dataset-file, optionally
+// gzipped, whose objects use the keys of the SNAP dump: asin, title,
+// description, price, brand and categories. There is nothing to download
+// and no default workload.
+type customDataset struct{}
+
+func (customDataset) Path(string) string { return datasetFile }
+
+func (customDataset) Download(path string) error {
+	if path == "" {
+		return fmt.Errorf("-dataset custom needs -dataset-file, a JSON lines file of products")
+	}
+	return nil
+}
+
+func (customDataset) Schema() string { return productsTableDDL }
+
+func (customDataset) Stream(path string, emit func(Product, error) error) error {
+	return streamLines(path, func(line string) (Product, error) {
+		var p Product
+		err := json.Unmarshal([]byte(line), &p)
+		return p, err
+	}, emit)
+}
+
+func (customDataset) Workload() string { return "" }
+
+func (customDataset) Fingerprint(path string) (string, error) {
+	return fileFingerprint(path)
+}
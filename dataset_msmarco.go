@@ -0,0 +1,91 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MSMarcoURL is the MS MARCO passage ranking collection, 8.8 million
+	// web passages in a tar of one TSV
+	MSMarcoURL  = "https://msmarco.z22.web.core.windows.net/msmarcoranking/collection.tar.gz"
+	MSMarcoFile = "msmarco-collection.tar.gz"
+	// msmarcoTitleLen is how much of a passage becomes its title; passages
+	// have none of their own
+	msmarcoTitleLen = 80
+)
+
+func init() {
+	registerDataset("msmarco", msmarcoDataset{})
+}
+
+// msmarcoDataset loads the MS MARCO passages, "pid<TAB>passage" lines read
+// from the collection tarball or a collection.tsv given with -dataset-file.
+type msmarcoDataset struct{}
+
+func (msmarcoDataset) Path(dir string) string { return filepath.Join(dir, MSMarcoFile) }
+
+func (msmarcoDataset) Download(path string) error {
+	infof("🔎 Setting up the MS MARCO passage collection...")
+	return downloadDataset(MSMarcoURL, path)
+}
+
+func (msmarcoDataset) URL() string { return MSMarcoURL }
+
+func (msmarcoDataset) Schema() string { return productsTableDDL }
+
+func (msmarcoDataset) Stream(path string, emit func(Product, error) error) error {
+	f, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if !strings.HasSuffix(path, ".tar.gz") && !strings.HasSuffix(path, ".tar") {
+		return scanLines(f, filepath.Base(path), parsePassage, emit)
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("%s has no .tsv file", filepath.Base(path))
+		}
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(hdr.Name, ".tsv") {
+			return scanLines(tr, filepath.Base(hdr.Name), parsePassage, emit)
+		}
+	}
+}
+
+func (msmarcoDataset) Workload() string { return "workloads/msmarco.yaml" }
+
+func (msmarcoDataset) Fingerprint(path string) (string, error) {
+	return fileFingerprint(path)
+}
+
+// parsePassage maps a collection line onto the products columns, the
+// passage's first words standing in for its title.
+func parsePassage(line string) (Product, error) {
+	pid, passage, ok := strings.Cut(line, "\t")
+	if !ok {
+		return Product{}, fmt.Errorf("no tab after the passage id")
+	}
+	passage = strings.TrimSpace(passage)
+	title := passage
+	if len(title) > msmarcoTitleLen {
+		title = title[:msmarcoTitleLen]
+		for !utf8.ValidString(title) {
+			title = title[:len(title)-1]
+		}
+		if i := strings.LastIndexByte(title, ' '); i > 0 {
+			title = title[:i]
+		}
+	}
+	return Product{ASIN: "msmarco-" + pid, Title: title, Description: passage}, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"compress/bzip2"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// WikipediaURL is the Simple English Wikipedia articles dump, about
+	// 300MB compressed and a quarter million articles
+	WikipediaURL  = "https://dumps.wikimedia.org/simplewiki/latest/simplewiki-latest-pages-articles.xml.bz2"
+	WikipediaFile = "simplewiki-latest-pages-articles.xml.bz2"
+)
+
+func init() {
+	registerDataset("wikipedia", wikipediaDataset{})
+}
+
+// wikipediaDataset loads the articles of a MediaWiki pages-articles dump:
+// the page title as title, the article reduced to plain text as
+// description and its [[Category:...]] links as categories. Redirects and
+// pages outside the article namespace are skipped.
+type wikipediaDataset struct{}
+
+func (wikipediaDataset) Path(dir string) string { return filepath.Join(dir, WikipediaFile) }
+
+func (wikipediaDataset) Download(path string) error {
+	infof("📚 Setting up the Simple English Wikipedia articles dump...")
+	return downloadDataset(WikipediaURL, path)
+}
+
+func (wikipediaDataset) URL() string { return WikipediaURL }
+
+func (wikipediaDataset) Schema() string { return productsTableDDL }
+
+// wikiPage is a <page> of the dump.
+type wikiPage struct {
+	Title    string    `xml:"title"`
+	NS       int       `xml:"ns"`
+	ID       int64     `xml:"id"`
+	Redirect *struct{} `xml:"redirect"`
+	Text     string    `xml:"revision>text"`
+}
+
+func (wikipediaDataset) Stream(path string, emit func(Product, error) error) error {
+	f, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".bz2") {
+		r = bzip2.NewReader(f)
+	}
+
+	source := filepath.Base(path)
+	dec := xml.NewDecoder(r)
+	for record := 1; ; {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+		var page wikiPage
+		if err := dec.DecodeElement(&page, &start); err != nil {
+			return err
+		}
+		product, err := wikiProduct(page)
+		product.Provenance = provenanceID(source, record)
+		record++
+		if err := emit(product, err); err != nil {
+			if err == errStopStream {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (wikipediaDataset) Workload() string { return "workloads/wikipedia.yaml" }
+
+func (wikipediaDataset) Fingerprint(path string) (string, error) {
+	return fileFingerprint(path)
+}
+
+// wikiProduct maps an article onto the products columns.
+func wikiProduct(page wikiPage) (Product, error) {
+	switch {
+	case page.NS != 0:
+		return Product{}, fmt.Errorf("page %d is in namespace %d, not an article", page.ID, page.NS)
+	case page.Redirect != nil:
+		return Product{}, fmt.Errorf("page %d is a redirect", page.ID)
+	}
+	var categories []interface{}
+	for _, m := range wikiCategory.FindAllStringSubmatch(page.Text, -1) {
+		categories = append(categories, strings.TrimSpace(m[1]))
+	}
+	product := Product{
+		ASIN:        fmt.Sprintf("wiki-%d", page.ID),
+		Title:       page.Title,
+		Description: wikiPlainText(page.Text),
+		Categories:  categories,
+	}
+	if product.Description == "" {
+		return product, fmt.Errorf("page %d has no text", page.ID)
+	}
+	return product, nil
+}
+
+var (
+	wikiCategory = regexp.MustCompile(`\[\[Category:([^|\]]+)`)
+	// wikiMarkup is what wikiPlainText drops outright: comments, references,
+	// tables, categories, files and tags
+	wikiMarkup = []*regexp.Regexp{
+		regexp.MustCompile(`(?s)<!--.*?-->`),
+		regexp.MustCompile(`(?s)<ref[^>]*/>|<ref[^>]*>.*?</ref>`),
+		regexp.MustCompile(`(?s)\{\|.*?\|\}`),
+		regexp.MustCompile(`\[\[(?:Category|File|Image):(?:[^\[\]]|\[\[[^\]]*\]\])*\]\]`),
+		regexp.MustCompile(`<[^>]+>`),
+	}
+	wikiTemplate = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+	wikiLink     = regexp.MustCompile(`\[\[(?:[^|\]]*\|)?([^\]]*)\]\]`)
+	wikiExtLink  = regexp.MustCompile(`\[https?://[^\s\]]+ ?([^\]]*)\]`)
+	wikiEmphasis = regexp.MustCompile(`'{2,}|={2,}`)
+)
+
+// wikiPlainText reduces wikitext to its prose, well enough for the search
+// columns: templates, references and tables go, links keep their label.
+func wikiPlainText(text string) string {
+	// Templates nest, so strip the innermost until none are left
+	for prev := ""; prev != text; {
+		prev, text = text, wikiTemplate.ReplaceAllString(text, "")
+	}
+	for _, re := range wikiMarkup {
+		text = re.ReplaceAllString(text, "")
+	}
+	text = wikiLink.ReplaceAllString(text, "$1")
+	text = wikiExtLink.ReplaceAllString(text, "$1")
+	text = wikiEmphasis.ReplaceAllString(text, "")
+	return strings.Join(strings.Fields(text), " ")
+}
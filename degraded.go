@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// IngestStatusFile is written to the data directory after every load so the
+// benchmark and reports can tell whether the indexes are the intended ones.
+const IngestStatusFile = "ingest-status.json"
+
+// Degradation is a fallback the loader took instead of failing.
+type Degradation struct {
+	Engine string `json:"engine"`
+	Step   string `json:"step"`
+	Detail string `json:"detail"`
+}
+
+type ingestStatus struct {
+	Status       string        `json:"status"`
+	FinishedAt   time.Time     `json:"finished_at"`
+	BM25Syntax   string        `json:"bm25_syntax"`
+	Execution    string        `json:"execution"`
+	Degradations []Degradation `json:"degradations"`
+}
+
+var (
+	degradationsMu sync.Mutex
+	degradations   []Degradation
+)
+
+// engineKey is the engine name used in workload and config files.
+func engineKey(isParadeDB bool) string {
+	if isParadeDB {
+		return "parade"
+	}
+	return "vanilla"
+}
+
+// degrade logs a warning and records that the run no longer matches the
+// intended setup.
+func degrade(engine, step string, err error) {
+	warnf("%s: Warning: %s: %v", engine, step, err)
+	degradationsMu.Lock()
+	defer degradationsMu.Unlock()
+	degradations = append(degradations, Degradation{Engine: engine, Step: step, Detail: err.Error()})
+}
+
+// saveIngestStatus persists the run label next to the data.
+func saveIngestStatus(dir string) error {
+	degradationsMu.Lock()
+	defer degradationsMu.Unlock()
+
+	status := ingestStatus{
+		Status:       "ok",
+		FinishedAt:   time.Now().UTC(),
+		BM25Syntax:   bm25DDL.Name,
+		Execution:    executionMode(),
+		Degradations: degradations,
+	}
+	if len(degradations) > 0 {
+		status.Status = "degraded"
+	}
+
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, IngestStatusFile), data, 0o644)
+}
+
+// restoreDegradations picks up the fallbacks recorded by an earlier
+// invocation, so a later phase run on its own keeps the label.
+func restoreDegradations(dir string) {
+	status, err := loadIngestStatus(dir)
+	if err != nil {
+		return
+	}
+	degradationsMu.Lock()
+	defer degradationsMu.Unlock()
+	if len(degradations) == 0 {
+		degradations = status.Degradations
+	}
+}
+
+// printDegradations prints the run label if any fallback was taken.
+func printDegradations() {
+	degradationsMu.Lock()
+	defer degradationsMu.Unlock()
+	if len(degradations) == 0 {
+		return
+	}
+	fmt.Printf("\n⚠️  DEGRADED RUN: %d fallback(s) taken, do not publish these numbers\n", len(degradations))
+	for _, d := range degradations {
+		fmt.Printf("   - %s %s: %s\n", d.Engine, d.Step, d.Detail)
+	}
+}
+
+func loadIngestStatus(dir string) (ingestStatus, error) {
+	var status ingestStatus
+	data, err := os.ReadFile(filepath.Join(dir, IngestStatusFile))
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(data, &status)
+	return status, err
+}
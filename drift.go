@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// snapshotTopK runs a statement once and returns its wall time and the
+// ASINs of the rows it returned, in order.
+func snapshotTopK(db *sql.DB, stmt string, args []interface{}) (time.Duration, []string, error) {
+	start := time.Now()
+	ids, err := firstColumnIDs(db, stmt, args)
+	if err != nil {
+		return 0, nil, err
+	}
+	elapsed := time.Since(start)
+
+	asins, err := catalogOf(db).asinsByID(ids)
+	if err != nil {
+		return 0, nil, err
+	}
+	topK := make([]string, len(ids))
+	for i, id := range ids {
+		topK[i] = asins[id]
+	}
+	return elapsed, topK, nil
+}
+
+// runSnapshotScenario stores the ranked results of every workload query,
+// per engine, so drift can compare them with another run, typically one
+// against a different pg_search or Postgres version.
+func runSnapshotScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	var results []manifest.QueryResult
+	failed := 0
+	for _, q := range w.Queries {
+		for _, args := range q.Args {
+			for _, t := range st.Targets {
+				stmt, ok := q.SQL[t.sqlName()]
+				if !ok {
+					continue
+				}
+				res := manifest.QueryResult{Query: q.Name, Category: q.Category, Engine: t.Name, Args: args}
+				d, topK, err := snapshotTopK(t.DB, stmt, q.bind(t.sqlName(), args))
+				if err != nil {
+					res.Error = err.Error()
+					failed++
+					errorf("%s: Snapshot of %s %s failed: %v", t.Label, q.Name, argsLabel(args), err)
+				} else {
+					res.Rows = len(topK)
+					res.TopK = topK
+					res.Stats = summarize([]time.Duration{d})
+				}
+				results = append(results, res)
+			}
+		}
+	}
+	infof("Snapshot of %d result set(s) (%d failed)", len(results), failed)
+	return saveResults(dataDir, "snapshot", manifest.KindQueries, st.Targets, results)
+}
+
+// topKDrift describes how one result list differs from its older version.
+type topKDrift struct {
+	Shared      int // ASINs in both lists
+	Moved       int // shared ASINs at a different position
+	FirstChange int // 1-based position of the first difference, 0 if none
+}
+
+func compareTopK(old, cur []string) topKDrift {
+	var d topKDrift
+	pos := map[string]int{}
+	for i, a := range old {
+		pos[a] = i
+	}
+	for i, a := range cur {
+		if j, ok := pos[a]; ok {
+			d.Shared++
+			if i != j {
+				d.Moved++
+			}
+		}
+	}
+	n := len(old)
+	if len(cur) > n {
+		n = len(cur)
+	}
+	for i := 0; i < n; i++ {
+		if i >= len(old) || i >= len(cur) || old[i] != cur[i] {
+			d.FirstChange = i + 1
+			break
+		}
+	}
+	return d
+}
+
+// loadSnapshot reads the snapshot results of a baseline, or of the data
+// directory when name is empty.
+func loadSnapshot(name string) (*manifest.File, []manifest.QueryResult, error) {
+	dir := dataDir
+	if name != "" {
+		path, err := baselinePath(dataDir, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		dir = path
+	}
+	f, err := loadResults(dir, "snapshot")
+	if err != nil {
+		return nil, nil, err
+	}
+	results, err := f.Queries()
+	return f, results, err
+}
+
+// driftCommand implements "drift OLD [NEW]": it compares the snapshot
+// results of two baselines, or of a baseline and the data directory, and
+// lists every result set whose ranking changed.
+func driftCommand(args []string) {
+	fs := flag.NewFlagSet("drift", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	all := fs.Bool("all", false, "list unchanged result sets too")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . drift OLD [NEW] [flags]")
+		fmt.Fprintln(fs.Output(), "OLD and NEW are baselines; NEW defaults to the results in -data-dir.")
+		fs.PrintDefaults()
+	}
+	var names []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		names, args = append(names, args[0]), args[1:]
+	}
+	fs.Parse(args)
+	if len(names) < 1 || len(names) > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	oldName, newName := names[0], ""
+	if len(names) == 2 {
+		newName = names[1]
+	}
+
+	oldFile, oldResults, err := loadSnapshot(oldName)
+	if err != nil {
+		log.Fatalf("Failed to load snapshot %s: %v (run the snapshot scenario, then save a baseline)", oldName, err)
+	}
+	newFile, newResults, err := loadSnapshot(newName)
+	if err != nil {
+		log.Fatalf("Failed to load snapshot: %v (run the snapshot scenario)", err)
+	}
+	newLabel := newName
+	if newLabel == "" {
+		newLabel = "current"
+	}
+
+	var engines []string
+	for engine := range oldFile.Metadata.Versions {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+	for _, engine := range engines {
+		fmt.Printf("%s: %s (%s) → %s (%s)\n", engine, oldFile.Metadata.Versions[engine], oldName, newFile.Metadata.Versions[engine], newLabel)
+	}
+
+	key := func(r manifest.QueryResult) string {
+		return fmt.Sprintf("%s %s [%s]", r.Query, argsLabel(r.Args), r.Engine)
+	}
+	old := map[string]manifest.QueryResult{}
+	for _, r := range oldResults {
+		old[key(r)] = r
+	}
+	table := newConsoleTable("Query", "Args", "Engine", "Status", "Shared", "Moved", "First change")
+	changed, compared := 0, 0
+	for _, r := range newResults {
+		o, ok := old[key(r)]
+		if !ok || o.Error != "" || r.Error != "" {
+			status := "new"
+			if ok {
+				status = "error"
+			}
+			table.addRow(r.Query, argsLabel(r.Args), r.Engine, status, "-", "-", "-")
+			changed++
+			continue
+		}
+		compared++
+		d := compareTopK(o.TopK, r.TopK)
+		status := "same"
+		switch {
+		case d.FirstChange == 0:
+		case d.Shared == len(o.TopK) && d.Shared == len(r.TopK):
+			status = "reordered"
+		default:
+			status = "changed"
+		}
+		if status == "same" && !*all {
+			continue
+		}
+		if status != "same" {
+			changed++
+		}
+		first := "-"
+		if d.FirstChange > 0 {
+			first = fmt.Sprintf("#%d", d.FirstChange)
+		}
+		table.addRow(r.Query, argsLabel(r.Args), r.Engine, status,
+			fmt.Sprintf("%d/%d", d.Shared, len(o.TopK)), fmt.Sprintf("%d", d.Moved), first)
+	}
+
+	if changed == 0 {
+		fmt.Printf("✅ %d result set(s) ranked identically in %s and %s\n", compared, oldName, newLabel)
+		if !*all {
+			return
+		}
+	}
+	fmt.Printf("\nRANKING DRIFT (%s → %s):\n", oldName, newLabel)
+	table.render(os.Stdout)
+	fmt.Printf("%d of %d result set(s) changed\n", changed, len(newResults))
+}
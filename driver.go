@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// clientDriver is -driver: the Go driver every target connection goes
+// through, pq (lib/pq) or pgx (jackc/pgx behind database/sql). With pgx the
+// load uses its binary COPY and pipelined batches.
+var clientDriver = "pq"
+
+// sqlDrivers maps each -driver to the database/sql driver name it
+// registers.
+var sqlDrivers = map[string]string{"pq": "postgres", "pgx": "pgx"}
+
+// pgxPipelineRows is the batch size below which the pgx driver sends the
+// rows as INSERTs pipelined in one round trip instead of starting a COPY.
+const pgxPipelineRows = 64
+
+func checkClientDriver() error {
+	if _, ok := sqlDrivers[clientDriver]; !ok {
+		return fmt.Errorf("-driver must be pq or pgx, got %q", clientDriver)
+	}
+	return nil
+}
+
+// sqlDriverName is the database/sql driver of -driver.
+func sqlDriverName() string {
+	return sqlDrivers[clientDriver]
+}
+
+// sqlState is the SQLSTATE of a server error from either driver, or "".
+func sqlState(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// insertProductSQL inserts one row of productColumns.
+const insertProductSQL = `
+	INSERT INTO products (asin, title, description, price, brand, categories, sales_rank, image_url, provenance)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+`
+
+// insertBatchPgx loads products in one transaction on a connection of the
+// pgx driver: large batches with binary COPY, small ones as pipelined
+// INSERTs, which cost one round trip however many rows they hold.
+func insertBatchPgx(db *sql.DB, products []Product) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		pc := driverConn.(*stdlib.Conn).Conn()
+		tx, err := pc.Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		if len(products) < pgxPipelineRows {
+			batch := &pgx.Batch{}
+			for _, p := range products {
+				batch.Queue(productsSQL(insertProductSQL), productRow(p)...)
+			}
+			if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+				return err
+			}
+		} else {
+			rows := make([][]interface{}, len(products))
+			for i, p := range products {
+				rows[i] = productRow(p)
+			}
+			if _, err := tx.CopyFrom(ctx, pgx.Identifier{productsTable}, productColumns, pgx.CopyFromRows(rows)); err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+// usesPgx reports whether db was opened with the pgx driver.
+func usesPgx(db *sql.DB) bool {
+	_, ok := db.Driver().(*stdlib.Driver)
+	return ok
+}
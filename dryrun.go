@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// dryRun checks a load without writing anything: see runDryRun
+	dryRun = false
+	// dryRunRecords is how many parsed dump records the dry run prints
+	dryRunRecords = 5
+)
+
+// runDryRun validates what a load would use and exits before it starts:
+// it prints where each target is, connects and runs the compatibility
+// preflight (which only touches a rolled-back scratch table), checks free
+// disk space, prints the load settings (auto-tuned with -auto-tune), the
+// DDL each target would get and the first parsed records of the dump.
+// Nothing is downloaded or written.
+func runDryRun(st *runState) error {
+	fmt.Println("🔍 Dry run: nothing is downloaded or written")
+	table := newConsoleTable("Engine", "Host", "Port", "Database", "User", "sslmode")
+	for _, t := range st.Targets {
+		opts, _ := connOptions(t.Config.connString(t.Config.Host))
+		table.addRow(t.Label, t.Config.Host, fmt.Sprintf("%d", t.Config.Port), t.Config.Database, t.Config.User, opts["sslmode"])
+	}
+	table.render(os.Stdout)
+
+	if err := st.connect(); err != nil {
+		return err
+	}
+	for _, t := range st.Targets {
+		fmt.Printf("✅ %s: connected\n", t.Label)
+	}
+	if autoTune {
+		if err := autoTuneLoad(st.Targets); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Load: batches of %d rows, %d workers per target, sample size %s\n", BatchSize, MaxWorkers, sampleLabel())
+
+	if _, err := os.Stat(st.MetadataPath); err != nil {
+		fmt.Printf("%s is not downloaded yet; a load would fetch the %s dataset first\n", st.MetadataPath, datasetName)
+	} else if err := checkIngestSpace(st.MetadataPath); err != nil {
+		return fmt.Errorf("preflight failed: %v", err)
+	}
+
+	for _, t := range st.Targets {
+		fmt.Printf("\n-- %s\n", t.Label)
+		for _, stmt := range loadDDL(t) {
+			fmt.Println(strings.Join(strings.Fields(stmt), " ") + ";")
+		}
+	}
+
+	if _, err := os.Stat(st.MetadataPath); err != nil {
+		return nil
+	}
+	return printDumpRecords(st.MetadataPath, dryRunRecords)
+}
+
+// sampleLabel describes SampleSize.
+func sampleLabel() string {
+	if SampleSize == 0 {
+		return "all"
+	}
+	return fmt.Sprintf("%d", SampleSize)
+}
+
+// loadDDL lists the statements a load runs on a target, in order: the
+// table and extension before the data, then the indexes after it.
+func loadDDL(t *benchTarget) []string {
+	stmts := []string{"DROP TABLE IF EXISTS products CASCADE", activeDataset.Schema()}
+	if t.IsParadeDB {
+		stmts = append(stmts, "CREATE EXTENSION IF NOT EXISTS pg_search", "ALTER TABLE products SET LOGGED")
+		stmts = append(stmts, t.indexDDL()...)
+		stmts = append(stmts, "ANALYZE products")
+	} else {
+		stmts = append(stmts, trgmExtensionDDL(), "ALTER TABLE products SET LOGGED")
+		stmts = append(stmts, t.indexDDL()...)
+		stmts = append(stmts, "ALTER TABLE products ADD CONSTRAINT products_asin_unique UNIQUE (asin)", "ANALYZE products")
+	}
+	for i, stmt := range stmts {
+		stmts[i] = productsSQL(stmt)
+	}
+	if dbSchema != "" {
+		stmts = append([]string{"CREATE SCHEMA IF NOT EXISTS " + dbSchema}, stmts...)
+	}
+	return stmts
+}
+
+// printDumpRecords parses the first n records of the dump the way the
+// load does and prints them, with the records it would skip.
+func printDumpRecords(filename string, n int) error {
+	table := newConsoleTable("Record", "ASIN", "Title", "Brand", "Price", "Categories")
+	record, shown, skipped := 0, 0, 0
+	var firstSkip string
+	err := activeDataset.Stream(filename, func(product Product, err error) error {
+		if shown >= n {
+			return errStopStream
+		}
+		record++
+		if err != nil {
+			if skipped == 0 {
+				firstSkip = fmt.Sprintf("record %d: %v", record, err)
+			}
+			skipped++
+			return nil
+		}
+		table.addRow(fmt.Sprintf("%d", record), product.ASIN, truncate(product.Title, 40), truncate(product.Brand, 20),
+			product.Price, fmt.Sprintf("%d", len(product.Categories)))
+		shown++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", filepath.Base(filename), err)
+	}
+	fmt.Printf("\nFirst %d records of %s (%d records before them skipped):\n", shown, filepath.Base(filename), skipped)
+	table.render(os.Stdout)
+	if skipped > 0 {
+		fmt.Printf("First skipped %s\n", firstSkip)
+	}
+	return nil
+}
+
+// truncate shortens s to at most n runes for a table cell.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// defaultConnOptions apply unless a target's connection string sets them.
+var defaultConnOptions = map[string]string{"sslmode": "disable", "connect_timeout": "5"}
+
+// connOptions parses a PostgreSQL connection string, either a postgres://
+// URI or libpq key=value pairs, into its options.
+func connOptions(dsn string) (map[string]string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		kv, err := pq.ParseURL(dsn)
+		if err != nil {
+			return nil, err
+		}
+		dsn = kv
+	}
+
+	opts := map[string]string{}
+	s := strings.TrimSpace(dsn)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("expected key=value at %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t\n")
+
+		var value strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+		i, closed := 0, !quoted
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c == '\\' && i+1 < len(s) {
+				i++
+				value.WriteByte(s[i])
+				continue
+			}
+			if quoted && c == '\'' {
+				closed = true
+				i++
+				break
+			}
+			if !quoted && (c == ' ' || c == '\t' || c == '\n') {
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return nil, fmt.Errorf("unterminated quoted value of %s", key)
+		}
+		opts[key] = value.String()
+		s = strings.TrimLeft(s[i:], " \t\n")
+	}
+	return opts, nil
+}
+
+// formatConnOptions writes options as a key=value connection string, with
+// every value quoted.
+func formatConnOptions(opts map[string]string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	pairs := make([]string, 0, len(opts))
+	for k, v := range opts {
+		pairs = append(pairs, k+"='"+escaper.Replace(v)+"'")
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// connString is the connection string for config at host: the defaults
+// and the run's application_name, then the options of config's DSN, then its TLS settings and run-time
+// settings, host, port, database, user and password, which scenarios may
+// have changed since.
+func (config DBConfig) connString(host string) string {
+	opts := map[string]string{}
+	for k, v := range defaultConnOptions {
+		opts[k] = v
+	}
+	opts["application_name"] = applicationName()
+	if config.DSN != "" {
+		// Parsed when the target was configured, so it can't fail here
+		extra, _ := connOptions(config.DSN)
+		for k, v := range extra {
+			opts[k] = v
+		}
+	}
+	for k, v := range config.tlsSettings() {
+		if *v != "" {
+			opts[k] = *v
+		}
+	}
+	for k, v := range config.Settings {
+		opts[k] = v
+	}
+	opts["host"], opts["port"] = host, strconv.Itoa(config.Port)
+	opts["dbname"], opts["user"], opts["password"] = config.Database, config.User, config.Password
+	if dbSchema != "" {
+		// lib/pq sends unknown options as run-time parameters; public keeps
+		// the extensions' functions and operator classes visible
+		opts["search_path"] = dbSchema + ", public"
+	}
+	return formatConnOptions(opts)
+}
+
+// Pool settings of a target whose config leaves them unset.
+const (
+	defaultMaxOpenConns    = 50
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 5 * time.Minute
+)
+
+func (config DBConfig) maxOpenConns() int {
+	if config.MaxOpenConns > 0 {
+		return config.MaxOpenConns
+	}
+	return defaultMaxOpenConns
+}
+
+func (config DBConfig) maxIdleConns() int {
+	if config.MaxIdleConns > 0 {
+		return min(config.MaxIdleConns, config.maxOpenConns())
+	}
+	return min(defaultMaxIdleConns, config.maxOpenConns())
+}
+
+func (config DBConfig) connMaxLifetime() time.Duration {
+	if config.ConnMaxLifetime > 0 {
+		return config.ConnMaxLifetime
+	}
+	return defaultConnMaxLifetime
+}
+
+// configurePool sizes db's connection pool from config.
+func (config DBConfig) configurePool(db *sql.DB) {
+	db.SetMaxOpenConns(config.maxOpenConns())
+	db.SetMaxIdleConns(config.maxIdleConns())
+	db.SetConnMaxLifetime(config.connMaxLifetime())
+}
+
+// unsupportedConnOptions are, per -driver, the libpq client options the
+// driver doesn't implement. It would send them to the server as run-time
+// parameters, which fails the connection with an unrecognized configuration
+// parameter. pgx handles channel binding, passfile, service files, target
+// session attributes and encrypted keys itself.
+var unsupportedConnOptions = map[string][]string{
+	"pq": {
+		"channel_binding", "require_auth", "gssencmode", "gsslib", "gssdelegation",
+		"hostaddr", "passfile", "service", "target_session_attrs", "load_balance_hosts",
+		"keepalives", "keepalives_idle", "keepalives_interval", "keepalives_count", "tcp_user_timeout",
+		"requiressl", "requirepeer", "sslnegotiation", "sslcompression", "sslpassword", "sslcertmode",
+		"sslcrl", "sslcrldir", "ssl_min_protocol_version", "ssl_max_protocol_version",
+	},
+	"pgx": {
+		"require_auth", "gssencmode", "gsslib", "gssdelegation", "hostaddr", "load_balance_hosts",
+		"keepalives", "keepalives_idle", "keepalives_interval", "keepalives_count", "tcp_user_timeout",
+		"requiressl", "requirepeer", "sslcompression", "sslcertmode",
+		"sslcrl", "sslcrldir", "ssl_min_protocol_version", "ssl_max_protocol_version",
+	},
+}
+
+// useDSN points a target at a connection string. The host, port, database,
+// user and password it names are copied into the target's settings, where
+// the rest of the benchmark reads them; host and port flags still win.
+func useDSN(engine, dsn string, setFlags map[string]bool) error {
+	opts, err := connOptions(dsn)
+	if err != nil {
+		return fmt.Errorf("%s connection string: %v", engine, err)
+	}
+	for _, k := range unsupportedConnOptions[clientDriver] {
+		if _, ok := opts[k]; ok {
+			return fmt.Errorf("%s connection string: %s is not supported with -driver %s, remove it", engine, k, clientDriver)
+		}
+	}
+	dst := targetConfigs()[engine]
+	dst.DSN = dsn
+	if v := opts["host"]; v != "" && !setFlags[engine+"-host"] {
+		dst.Host = v
+	}
+	if v := opts["port"]; v != "" && !setFlags[engine+"-port"] {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s connection string: %q is not a port number", engine, v)
+		}
+		dst.Port = port
+	}
+	if v := opts["dbname"]; v != "" {
+		dst.Database = v
+	}
+	if v := opts["user"]; v != "" {
+		dst.User = v
+	}
+	if v := opts["password"]; v != "" {
+		dst.Password = v
+	}
+	return nil
+}
+
+// sslModes are the sslmode values lib/pq supports.
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// tlsSettings maps the libpq TLS option names to config's fields.
+func (config *DBConfig) tlsSettings() map[string]*string {
+	return map[string]*string{
+		"sslmode":     &config.SSLMode,
+		"sslrootcert": &config.SSLRootCert,
+		"sslcert":     &config.SSLCert,
+		"sslkey":      &config.SSLKey,
+	}
+}
+
+// tlsFlags are the -sslmode, -sslrootcert, -sslcert and -sslkey flags,
+// which apply to both targets.
+var tlsFlags DBConfig
+
+// addTLSFlags registers the TLS flags.
+func addTLSFlags(fs *flag.FlagSet) {
+	fs.StringVar(&tlsFlags.SSLMode, "sslmode", "", "TLS mode for both targets: "+strings.Join(sslModes, ", ")+" (default: the DSN's, or disable)")
+	fs.StringVar(&tlsFlags.SSLRootCert, "sslrootcert", "", "CA certificate file that verify-ca and verify-full check the server against")
+	fs.StringVar(&tlsFlags.SSLCert, "sslcert", "", "client certificate file for both targets")
+	fs.StringVar(&tlsFlags.SSLKey, "sslkey", "", "client private key file for -sslcert (must not be group or world readable)")
+}
+
+// checkTLS rejects an sslmode lib/pq doesn't know and TLS files that can't
+// be read, before the first connection attempt hides them behind a
+// handshake error.
+func checkTLS(engine string, config *DBConfig) error {
+	mode := config.SSLMode
+	if mode == "" && config.DSN != "" {
+		opts, _ := connOptions(config.DSN)
+		mode = opts["sslmode"]
+	}
+	if mode != "" {
+		known := false
+		for _, m := range sslModes {
+			known = known || m == mode
+		}
+		if !known {
+			return fmt.Errorf("%s: unsupported sslmode %q (want %s)", engine, mode, strings.Join(sslModes, ", "))
+		}
+	}
+	for _, path := range []string{config.SSLRootCert, config.SSLCert, config.SSLKey} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%s: %v", engine, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnOptions(t *testing.T) {
+	opts, err := connOptions(`host=db port=5433 password='it\'s x' application_name=bench`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts["host"] != "db" || opts["port"] != "5433" || opts["application_name"] != "bench" || opts["password"] != "it's x" {
+		t.Errorf("options %v", opts)
+	}
+	if _, err := connOptions("host='db"); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestUseDSNUnsupported(t *testing.T) {
+	defer func(driver string) { clientDriver = driver }(clientDriver)
+	defer func(c DBConfig) { vanillaConfig = c }(vanillaConfig)
+	for _, tc := range []struct {
+		driver, dsn string
+		refused     bool
+	}{
+		{"pq", "host=db channel_binding=require", true},
+		{"pq", "postgres://u:p@db:5432/bench?target_session_attrs=read-write", true},
+		{"pgx", "host=db channel_binding=require", false},
+		{"pgx", "postgres://u:p@db:5432/bench?target_session_attrs=read-write", false},
+		{"pgx", "host=db service=bench passfile=/run/secrets/pgpass sslpassword=secret", false},
+		{"pgx", "host=db keepalives=1", true},
+	} {
+		clientDriver = tc.driver
+		err := useDSN("vanilla", tc.dsn, nil)
+		if refused := err != nil && strings.Contains(err.Error(), "not supported with -driver "+tc.driver); refused != tc.refused || (err != nil && !refused) {
+			t.Errorf("%s with %s: %v", tc.dsn, tc.driver, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+// EffectiveConfigFile is written to the data directory at the start of a
+// run, next to the results, with the configuration the run uses.
+const EffectiveConfigFile = "run-config.json"
+
+var (
+	// runFlags and runCommandName are the parsed flags and the command of
+	// the invocation, kept for effectiveConfig
+	runFlags       *flag.FlagSet
+	runCommandName string
+)
+
+// dsnFlags are the flags holding connection strings, whose passwords are
+// masked before they are recorded.
+var dsnFlags = map[string]bool{"vanilla-dsn": true, "parade-dsn": true, "shards": true, "citus": true}
+
+// effectiveConfig is the configuration of the run as it stands: every
+// flag's final value, which the -config file and environment have already
+// been merged into, the targets' connections and the load settings. It is
+// nil outside runCommand.
+func effectiveConfig() *manifest.EffectiveConfig {
+	if runFlags == nil {
+		return nil
+	}
+	c := &manifest.EffectiveConfig{
+		Command:    runCommandName,
+		ConfigFile: configPath,
+		Flags:      map[string]string{},
+		Targets:    map[string]manifest.TargetConfig{},
+		Load: manifest.LoadConfig{
+			Dataset:    datasetName,
+			SampleSize: int(SampleSize),
+			BatchSize:  BatchSize,
+			Workers:    MaxWorkers,
+			Table:      productsTable,
+			Schema:     dbSchema,
+		},
+	}
+	runFlags.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if dsnFlags[f.Name] {
+			value = maskDSNs(value)
+		}
+		c.Flags[f.Name] = value
+		if value != f.DefValue {
+			c.Changed = append(c.Changed, f.Name)
+		}
+	})
+	sort.Strings(c.Changed)
+	for _, t := range defaultTargets() {
+		c.Targets[t.Name] = manifest.TargetConfig{
+			Engine:          engineKey(t.IsParadeDB),
+			Host:            t.Config.Host,
+			Port:            t.Config.Port,
+			Database:        t.Config.Database,
+			User:            t.Config.User,
+			SSLMode:         t.Config.SSLMode,
+			MaxOpenConns:    t.Config.maxOpenConns(),
+			MaxIdleConns:    t.Config.maxIdleConns(),
+			ConnMaxLifetime: t.Config.connMaxLifetime().String(),
+			Settings:        t.Config.Settings,
+			Indexes:         t.Indexes,
+			BM25Syntax:      t.BM25Syntax,
+		}
+	}
+	return c
+}
+
+// maskDSNs replaces the passwords in comma-separated connection strings.
+func maskDSNs(value string) string {
+	if value == "" {
+		return value
+	}
+	dsns := strings.Split(value, ",")
+	for i, dsn := range dsns {
+		dsns[i] = maskDSN(strings.TrimSpace(dsn))
+	}
+	return strings.Join(dsns, ",")
+}
+
+// maskDSN replaces the password of a URI or key=value connection string;
+// one it cannot parse is left out altogether rather than risk leaking it.
+func maskDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "(unparsable connection string)"
+		}
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+		q := u.Query()
+		if q.Has("password") {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	opts, err := connOptions(dsn)
+	if err != nil {
+		return "(unparsable connection string)"
+	}
+	if _, ok := opts["password"]; !ok {
+		return dsn
+	}
+	opts["password"] = "xxxxx"
+	return formatConnOptions(opts)
+}
+
+// writeEffectiveConfig saves the run's configuration in dir.
+func writeEffectiveConfig(dir string) error {
+	data, err := json.MarshalIndent(effectiveConfig(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, EffectiveConfigFile), data, 0o644)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// targetEnvPrefixes are the per-target environment variable prefixes, as
+// in PGB_VANILLA_HOST or PGB_PARADE_PASSWORD.
+var targetEnvPrefixes = map[string]string{"vanilla": "PGB_VANILLA_", "parade": "PGB_PARADE_"}
+
+// extraTargetConfigs are the connection settings of the targets the
+// -config file adds besides vanilla and parade, by name.
+var extraTargetConfigs = map[string]*DBConfig{}
+
+// applyFlagEnv sets *dst from the environment variable name unless the
+// flag was given, the order the PGB_* target variables follow too.
+func applyFlagEnv(fs *flag.FlagSet, flagName, name string, dst *string) {
+	given := false
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == flagName })
+	if v := os.Getenv(name); v != "" && !given {
+		*dst = v
+	}
+}
+
+// targetConfigs are the connection settings of each target by name.
+func targetConfigs() map[string]*DBConfig {
+	configs := map[string]*DBConfig{"vanilla": &vanillaConfig, "parade": &paradeConfig}
+	for name, cfg := range extraTargetConfigs {
+		configs[name] = cfg
+	}
+	return configs
+}
+
+// applyTargetEnv overrides one target's connection with the environment
+// variables DSN, HOST, PORT, DATABASE, USER, PASSWORD, PASSWORD_FILE,
+// SSLMODE, SSLROOTCERT, SSLCERT and SSLKEY under a prefix; the parts
+// override the DSN. Host, port and password file set by flag are kept.
+func applyTargetEnv(engine, prefix string, setFlags map[string]bool) error {
+	if v := os.Getenv(prefix + "DSN"); v != "" && !setFlags[engine+"-dsn"] {
+		if err := useDSN(engine, v, setFlags); err != nil {
+			return fmt.Errorf("%sDSN: %v", prefix, err)
+		}
+	}
+	dst := targetConfigs()[engine]
+	if v := os.Getenv(prefix + "HOST"); v != "" && !setFlags[engine+"-host"] {
+		dst.Host = v
+	}
+	if v := os.Getenv(prefix + "PORT"); v != "" && !setFlags[engine+"-port"] {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%sPORT: %q is not a port number", prefix, v)
+		}
+		dst.Port = port
+	}
+	if v := os.Getenv(prefix + "DATABASE"); v != "" {
+		dst.Database = v
+	}
+	if v := os.Getenv(prefix + "USER"); v != "" {
+		dst.User = v
+	}
+	if v := os.Getenv(prefix + "PASSWORD"); v != "" {
+		dst.Password = v
+	}
+	if v := os.Getenv(prefix + "PASSWORD_FILE"); v != "" && !setFlags[engine+"-password-file"] {
+		dst.PasswordFile = v
+	}
+	for k, v := range dst.tlsSettings() {
+		if s := os.Getenv(prefix + strings.ToUpper(k)); s != "" {
+			*v = s
+		}
+	}
+	return nil
+}
+
+// applyLibpqEnv applies the standard PGHOST, PGDATABASE, PGUSER,
+// PGPASSWORD and PGSSLMODE, PGSSLROOTCERT, PGSSLCERT and PGSSLKEY to both
+// targets. PGPORT is left out: the targets listen on different ports.
+func applyLibpqEnv(setFlags map[string]bool) {
+	for engine, dst := range targetConfigs() {
+		if v := os.Getenv("PGHOST"); v != "" && !setFlags[engine+"-host"] {
+			dst.Host = v
+		}
+		if v := os.Getenv("PGDATABASE"); v != "" {
+			dst.Database = v
+		}
+		if v := os.Getenv("PGUSER"); v != "" {
+			dst.User = v
+		}
+		if v := os.Getenv("PGPASSWORD"); v != "" {
+			dst.Password = v
+		}
+		for k, v := range dst.tlsSettings() {
+			if s := os.Getenv("PG" + strings.ToUpper(k)); s != "" {
+				*v = s
+			}
+		}
+	}
+}
+
+// overrideTLS copies the TLS settings src sets onto dst.
+func overrideTLS(dst, src *DBConfig) {
+	from := src.tlsSettings()
+	for k, v := range dst.tlsSettings() {
+		if *from[k] != "" {
+			*v = *from[k]
+		}
+	}
+}
+
+// configureTargets resolves where the targets are, from lowest to highest
+// precedence: the defaults, the standard PG* variables, the -config file,
+// the PGB_<ENGINE>_* variables and the flags. Passwords are settled last,
+// see resolvePasswords.
+func configureTargets(fs *flag.FlagSet, cfg RunConfig) error {
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	applyLibpqEnv(setFlags)
+	if err := applyRunConfig(cfg, setFlags); err != nil {
+		return err
+	}
+	for engine, prefix := range targetEnvPrefixes {
+		if err := applyTargetEnv(engine, prefix, setFlags); err != nil {
+			return err
+		}
+	}
+	if err := checkTargetNames(); err != nil {
+		return err
+	}
+	if err := checkTableFlags(); err != nil {
+		return err
+	}
+	for engine, dst := range targetConfigs() {
+		if setFlags[engine+"-dsn"] {
+			if err := useDSN(engine, dst.DSN, setFlags); err != nil {
+				return err
+			}
+		}
+		overrideTLS(dst, &tlsFlags)
+		if !fakeEngines {
+			if err := checkTLS(engine, dst); err != nil {
+				return err
+			}
+		}
+	}
+	return resolvePasswords()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestApplyFlagEnv(t *testing.T) {
+	t.Setenv("PGB_COMMUNITY_URL", "https://example.com/env.json")
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{nil, "https://example.com/env.json"},
+		{[]string{"-community-url", "local.json"}, "local.json"},
+	} {
+		fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+		source := fs.String("community-url", "", "")
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		applyFlagEnv(fs, "community-url", "PGB_COMMUNITY_URL", source)
+		if *source != tc.want {
+			t.Errorf("%v: %q, want %q", tc.args, *source, tc.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+)
+
+// ephemeralTargets makes smoke start throwaway containers on random ports
+// instead of using the docker-compose targets.
+var ephemeralTargets = false
+
+// Images of the ephemeral targets; keep in step with docker-compose.yml.
+const (
+	vanillaImage = "postgres:17"
+	paradeImage  = "paradedb/paradedb:0.18.0"
+	// ephemeralStartTimeout bounds the wait for a fresh container to accept
+	// connections, which includes initdb.
+	ephemeralStartTimeout = 90 * time.Second
+)
+
+// ephemeralContainer is a target started through the Docker API for one
+// invocation.
+type ephemeralContainer struct {
+	ID       string
+	Config   *DBConfig
+	pool     *dockertest.Pool
+	resource *dockertest.Resource
+}
+
+// startEphemeral runs an image with the target's database and credentials,
+// and any extra host settings, publishes its port on a random local port and
+// points the config at it.
+func startEphemeral(image string, config *DBConfig, hostConfig ...func(*docker.HostConfig)) (*ephemeralContainer, error) {
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		return nil, fmt.Errorf("docker: %v", err)
+	}
+	if err := pool.Client.Ping(); err != nil {
+		return nil, fmt.Errorf("docker: %v", err)
+	}
+	pool.MaxWait = ephemeralStartTimeout
+
+	repository, tag, _ := strings.Cut(image, ":")
+	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: repository,
+		Tag:        tag,
+		Env: []string{
+			"POSTGRES_DB=" + config.Database,
+			"POSTGRES_USER=" + config.User,
+			"POSTGRES_PASSWORD=" + config.Password,
+		},
+		PortBindings: map[docker.Port][]docker.PortBinding{"5432/tcp": {{HostIP: "127.0.0.1"}}},
+	}, func(hc *docker.HostConfig) {
+		hc.AutoRemove = true
+		hc.RestartPolicy = docker.RestartPolicy{Name: "no"}
+		for _, f := range hostConfig {
+			f(hc)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("docker run %s: %v", image, err)
+	}
+	c := &ephemeralContainer{ID: resource.Container.ID, Config: config, pool: pool, resource: resource}
+
+	port, err := strconv.Atoi(resource.GetPort("5432/tcp"))
+	if err != nil {
+		c.stop()
+		return nil, fmt.Errorf("%s: no published port for 5432", image)
+	}
+	config.Host, config.Port = "127.0.0.1", port
+
+	// The entrypoint runs initdb with TCP disabled, so the server only
+	// answers on the published port once it is ready for good
+	err = pool.Retry(func() error {
+		db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable connect_timeout=5",
+			config.Host, config.Port, config.User, config.Password, config.Database))
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return db.Ping()
+	})
+	if err != nil {
+		c.stop()
+		return nil, fmt.Errorf("%s did not accept connections within %v: %v", image, ephemeralStartTimeout, err)
+	}
+	infof("Started %s as %.12s on port %d", image, c.ID, port)
+	return c, nil
+}
+
+// stop removes the container along with its volumes, which discards its
+// data.
+func (c *ephemeralContainer) stop() {
+	if err := c.pool.Purge(c.resource); err != nil {
+		warnf("Warning: Could not remove container %.12s: %v", c.ID, err)
+	}
+}
+
+// startEphemeralTargets starts one container per target and returns a
+// function that removes them again.
+func startEphemeralTargets() (func(), error) {
+	var started []*ephemeralContainer
+	stopAll := func() {
+		for _, c := range started {
+			c.stop()
+		}
+	}
+	for _, spec := range []struct {
+		image  string
+		config *DBConfig
+	}{{vanillaImage, &vanillaConfig}, {paradeImage, &paradeConfig}} {
+		c, err := startEphemeral(spec.image, spec.config)
+		if err != nil {
+			stopAll()
+			return nil, err
+		}
+		started = append(started, c)
+	}
+	return stopAll, nil
+}
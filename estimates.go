@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+// sampleRowEstimates runs every query under EXPLAIN ANALYZE after timing
+// it and keeps the planner's row estimates for its text predicates.
+var sampleRowEstimates = false
+
+// isTextPredicate reports whether a plan node evaluates a search predicate:
+// @@ or @@@ in its index condition or filter, a pg_trgm similarity match,
+// or a ParadeDB custom scan.
+func isTextPredicate(n planNode) bool {
+	if strings.Contains(n.CustomPlanProvider, "ParadeDB") {
+		return true
+	}
+	for _, cond := range []string{n.IndexCond, n.Filter} {
+		if strings.Contains(cond, "@@") || strings.Contains(cond, " % ") {
+			return true
+		}
+	}
+	return false
+}
+
+// textPredicateEstimates returns the estimate of every node of an analyzed
+// plan that evaluates a search predicate.
+func textPredicateEstimates(plan planNode) []manifest.RowEstimate {
+	var estimates []manifest.RowEstimate
+	plan.walk(func(n planNode) {
+		if isTextPredicate(n) {
+			estimates = append(estimates, n.rowEstimate())
+		}
+	})
+	return estimates
+}
+
+// sampleQueryEstimates fills in RowEstimates for one result; a failure only
+// warns, the timings still stand.
+func sampleQueryEstimates(res *manifest.QueryResult, t *benchTarget, stmt string, args []interface{}) {
+	plan, err := explainAnalyzePlan(t.DB, stmt, args)
+	if err != nil {
+		warnf("⚠️  %s: EXPLAIN ANALYZE failed for %s: %v", t.Label, res.Query, err)
+		return
+	}
+	res.RowEstimates = textPredicateEstimates(plan)
+}
+
+// worstEstimate is the estimate of r that is furthest off.
+func worstEstimate(r manifest.QueryResult) (manifest.RowEstimate, bool) {
+	var worst manifest.RowEstimate
+	for i, e := range r.RowEstimates {
+		if i == 0 || e.Error() > worst.Error() {
+			worst = e
+		}
+	}
+	return worst, len(r.RowEstimates) > 0
+}
+
+// printEstimateSummary lists, per query, argument set and engine, the text
+// predicate whose row estimate is furthest off, worst first, if the results
+// were sampled with -row-estimates.
+func printEstimateSummary(results []manifest.QueryResult, targets []*benchTarget) {
+	var sampled []manifest.QueryResult
+	for _, r := range results {
+		if _, ok := worstEstimate(r); ok {
+			sampled = append(sampled, r)
+		}
+	}
+	if len(sampled) == 0 {
+		return
+	}
+	sort.SliceStable(sampled, func(i, j int) bool {
+		a, _ := worstEstimate(sampled[i])
+		b, _ := worstEstimate(sampled[j])
+		return a.Error() > b.Error()
+	})
+
+	fmt.Println("\nTEXT PREDICATE ROW ESTIMATES (worst node per query):")
+	table := newConsoleTable("Query", "Args", "Engine", "Node", "Estimated", "Actual", "Off by")
+	offBy := map[string][]float64{}
+	for _, r := range sampled {
+		e, _ := worstEstimate(r)
+		offBy[r.Engine] = append(offBy[r.Engine], e.Error())
+		table.addRow(r.Query, argsLabel(r.Args), engineLabel(r.Engine, targets), e.Node,
+			fmt.Sprintf("%.0f", e.Estimated), fmt.Sprintf("%.0f", e.Actual), fmt.Sprintf("%.1fx", e.Error()))
+	}
+	table.render(os.Stdout)
+
+	var engines []string
+	for engine := range offBy {
+		engines = append(engines, engine)
+	}
+	sort.Strings(engines)
+	for _, engine := range engines {
+		errs := offBy[engine]
+		sort.Float64s(errs)
+		fmt.Printf("%s: median estimate off by %.1fx, worst %.1fx over %d queries\n",
+			engineLabel(engine, targets), errs[len(errs)/2], errs[len(errs)-1], len(errs))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// eventsPath is where -events writes machine-readable progress, one JSON
+// object per line. "-" is stdout, which moves the human output to stderr.
+var eventsPath = ""
+
+var (
+	eventsMu   sync.Mutex
+	eventsOut  *json.Encoder
+	eventsFile *os.File
+)
+
+// openEvents starts the event stream of -events.
+func openEvents(path string) error {
+	if path == "" {
+		return nil
+	}
+	out := os.Stdout
+	if path == "-" {
+		// Everything printed for people goes to stderr from here on, so
+		// stdout carries nothing but events
+		os.Stdout = os.Stderr
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		eventsFile, out = f, f
+	}
+	eventsOut = json.NewEncoder(out)
+	return nil
+}
+
+// closeEvents ends the event stream.
+func closeEvents() {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventsFile != nil {
+		eventsFile.Close()
+	}
+	eventsOut, eventsFile = nil, nil
+}
+
+// emitEvent writes one event when -events is on: the fields plus "event"
+// and "time". Durations are written as nanoseconds, as in the results.
+func emitEvent(kind string, fields map[string]interface{}) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if eventsOut == nil {
+		return
+	}
+	e := map[string]interface{}{"event": kind, "time": time.Now().UTC()}
+	for k, v := range fields {
+		e[k] = v
+	}
+	if err := eventsOut.Encode(e); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: Could not write event %s: %v\n", kind, err)
+	}
+}
+
+// emitQueryResults writes a query_result event for each result a scenario
+// saved, without the per-execution timings and plans.
+func emitQueryResults(scenario string, results []manifest.QueryResult) {
+	for _, r := range results {
+		fields := map[string]interface{}{
+			"scenario": scenario, "query": r.Query, "category": r.Category, "engine": r.Engine,
+			"args": r.Args, "rows": r.Rows, "stats": r.Stats,
+		}
+		if r.Error != "" {
+			fields["error"] = r.Error
+		}
+		emitEvent("query_result", fields)
+	}
+}
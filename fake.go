@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeEngines runs the scenarios against in-memory engines with canned
+// latencies and results instead of databases, so the runner, statistics and
+// reports can be exercised without Docker.
+var fakeEngines = false
+
+// fakeDriverName is registered with database/sql; the DSN is the engine name.
+const fakeDriverName = "pgsb-fake"
+
+// fakeBaseLatency is the typical latency of each fake engine; queries are
+// spread around it by a factor derived from their text and arguments.
+var fakeBaseLatency = map[string]time.Duration{
+	"vanilla": 3 * time.Millisecond,
+	"parade":  time.Millisecond,
+}
+
+// fakePlans are the canned EXPLAIN output of each fake engine, matching the
+// plan assertions of the amazon workload, with canned row estimates.
+var fakePlans = map[string]string{
+	"vanilla": `[{"Plan": {"Node Type": "Bitmap Heap Scan", "Plan Rows": 120, "Actual Rows": 480, "Actual Loops": 1, "Plans": [{"Node Type": "Bitmap Index Scan", "Index Name": "idx_combined_fulltext", "Index Cond": "(search_vector @@ to_tsquery('english'::regconfig, 'fake'::text))", "Plan Rows": 120, "Actual Rows": 480, "Actual Loops": 1}]}}]`,
+	"parade":  `[{"Plan": {"Node Type": "Custom Scan", "Custom Plan Provider": "ParadeDB Scan", "Index": "products_search_idx", "Plan Rows": 1000, "Actual Rows": 40, "Actual Loops": 1}}]`,
+}
+
+func init() {
+	sql.Register(fakeDriverName, &fakeDriver{engines: map[string]*fakeEngine{}})
+}
+
+// openFakeEngine returns a handle on the fake engine of a target.
+func openFakeEngine(name string) (*sql.DB, error) {
+	return sql.Open(fakeDriverName, name)
+}
+
+type fakeDriver struct {
+	mu      sync.Mutex
+	engines map[string]*fakeEngine
+}
+
+// Open shares one engine, and so one random source, per name.
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.engines[name]
+	if !ok {
+		e = &fakeEngine{name: name, rng: seededRand(name, 0)}
+		d.engines[name] = e
+	}
+	return &fakeConn{engine: e}, nil
+}
+
+// fakeEngine answers queries deterministically from their text and
+// arguments; only the jitter comes from a seeded random source.
+type fakeEngine struct {
+	name string
+	mu   sync.Mutex
+	rng  *rand.Rand
+}
+
+// fakeShape derives the latency factor and row count of a query.
+func fakeShape(query string, args []interface{}) (float64, int) {
+	h := fnv.New64a()
+	h.Write([]byte(query))
+	for _, a := range args {
+		fmt.Fprintf(h, "\x00%v", a)
+	}
+	sum := h.Sum64()
+	return 0.5 + float64(sum%8)/2, int(sum>>8) % 11
+}
+
+// latency is the base latency scaled by factor, with ±20% jitter and a 1%
+// chance of a 5x outlier so tail statistics have something to find.
+func (e *fakeEngine) latency(factor float64) time.Duration {
+	base, ok := fakeBaseLatency[e.name]
+	if !ok {
+		base = 2 * time.Millisecond
+	}
+	e.mu.Lock()
+	jitter := 0.8 + 0.4*e.rng.Float64()
+	if e.rng.Intn(100) == 0 {
+		jitter *= 5
+	}
+	e.mu.Unlock()
+	return time.Duration(float64(base) * factor * jitter)
+}
+
+func (e *fakeEngine) query(query string, args []interface{}) *fakeRows {
+	if strings.HasPrefix(strings.TrimSpace(query), "EXPLAIN") {
+		plan, ok := fakePlans[e.name]
+		if !ok {
+			plan = `[{"Plan": {"Node Type": "Seq Scan"}}]`
+		}
+		return &fakeRows{columns: []string{"QUERY PLAN"}, values: [][]driver.Value{{[]byte(productsSQL(plan))}}}
+	}
+
+	factor, n := fakeShape(query, args)
+	time.Sleep(e.latency(factor))
+	rows := &fakeRows{columns: []string{"id"}}
+	for i := 0; i < n; i++ {
+		rows.values = append(rows.values, []driver.Value{int64(i + 1)})
+	}
+	return rows
+}
+
+// fakeCatalog answers for every fake engine: the corpus holds 100000
+// products, none appended, and every extension is available and none
+// installed.
+type fakeCatalog struct{}
+
+func (fakeCatalog) preloadLibraries() (string, error) { return "pg_search", nil }
+func (fakeCatalog) isSuperuser() (bool, error)        { return false, nil }
+
+func (fakeCatalog) extensionVersions(name string) (available, installed sql.NullString, err error) {
+	return sql.NullString{String: "1.0", Valid: true}, sql.NullString{}, nil
+}
+
+func (fakeCatalog) relationSizes(table string) (heap, indexes int64, err error) {
+	return 64 << 20, 32 << 20, nil
+}
+
+func (fakeCatalog) countProducts(asinPrefix string) (int64, error) {
+	if asinPrefix == "" {
+		return 100000, nil
+	}
+	return 0, nil
+}
+
+func (fakeCatalog) idRange(asinPrefix string) (lo, hi int64, err error) {
+	if asinPrefix == "" {
+		return 1, 100000, nil
+	}
+	return 0, 0, nil
+}
+
+func (fakeCatalog) asinsByID(ids []int64) (map[int64]string, error) {
+	asins := map[int64]string{}
+	for _, id := range ids {
+		asins[id] = fmt.Sprintf("FAKE%06d", id)
+	}
+	return asins, nil
+}
+
+// queryMemory grows with the query's latency factor, so heavier queries
+// hold more.
+func (fakeCatalog) queryMemory(query string, args []interface{}) (int64, error) {
+	factor, _ := fakeShape(query, args)
+	return int64(factor * (1 << 18)), nil
+}
+
+type fakeConn struct {
+	engine *fakeEngine
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	values := make([]interface{}, len(args))
+	for i, a := range args {
+		values[i] = a
+	}
+	return s.conn.engine.query(s.query, values), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
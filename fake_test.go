@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"pg_search_benchmark/manifest"
+)
+
+func fakeTargets(t *testing.T) []*benchTarget {
+	t.Helper()
+	targets := []*benchTarget{
+		{Name: "vanilla", Label: "Vanilla"},
+		{Name: "parade", Label: "ParadeDB", IsParadeDB: true},
+	}
+	for _, target := range targets {
+		db, err := openFakeEngine(target.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		target.DB = db
+	}
+	return targets
+}
+
+// rowCounts keys the rows each engine returned by query and arguments.
+func rowCounts(results []manifest.QueryResult) map[string]int {
+	rows := map[string]int{}
+	for _, r := range results {
+		rows[r.Engine+" "+r.Query+" "+argsLabel(r.Args)] = r.Rows
+	}
+	return rows
+}
+
+// TestFakeWorkload runs the smoke workload through the runner on the fake
+// engines: every cell is timed the requested number of times, and the rows
+// each query returns depend only on its text and arguments.
+func TestFakeWorkload(t *testing.T) {
+	w, err := loadWorkload("workloads/smoke.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := fakeTargets(t)
+	sp := sampling{Iterations: 3, Warmup: 1}
+
+	first := runWorkload(w, targets, sp, nil)
+	cells := 0
+	for _, q := range w.Queries {
+		cells += len(q.Args) * len(targets)
+	}
+	if len(first) != cells {
+		t.Fatalf("%d results, want %d", len(first), cells)
+	}
+	for _, r := range first {
+		if r.Error != "" || r.Stats.Count != sp.Iterations {
+			t.Errorf("%s %s on %s: %d sample(s), error %q", r.Query, argsLabel(r.Args), r.Engine, r.Stats.Count, r.Error)
+		}
+		if r.Stats.Min <= 0 || r.Stats.Min > r.Stats.P50 || r.Stats.P50 > r.Stats.Max {
+			t.Errorf("%s on %s: inconsistent stats %+v", r.Query, r.Engine, r.Stats)
+		}
+	}
+
+	second := runWorkload(w, targets, sp, nil)
+	if a, b := rowCounts(first), rowCounts(second); !reflect.DeepEqual(a, b) {
+		t.Errorf("row counts differ between runs:\n%v\n%v", a, b)
+	}
+}
+
+func TestFakeCatalog(t *testing.T) {
+	targets := fakeTargets(t)
+	c := catalogOf(targets[0].DB)
+	if _, ok := c.(fakeCatalog); !ok {
+		t.Fatalf("fake engine has catalog %T", c)
+	}
+	if super, err := c.isSuperuser(); err != nil || super {
+		t.Errorf("isSuperuser: %v, %v", super, err)
+	}
+	if rows, err := c.countProducts(""); err != nil || rows != 100000 {
+		t.Errorf("countProducts: %d, %v", rows, err)
+	}
+
+	stmt := "SELECT id FROM products WHERE title @@@ $1"
+	_, first, err := snapshotTopK(targets[1].DB, stmt, []interface{}{"speaker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, second, err := snapshotTopK(targets[1].DB, stmt, []interface{}{"speaker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) == 0 {
+		t.Fatal("no rows")
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("top-k differs between runs: %v, %v", first, second)
+	}
+	for i, asin := range first {
+		if want := fmt.Sprintf("FAKE%06d", i+1); asin != want {
+			t.Errorf("position %d: %s, want %s", i+1, asin, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// healthWait is -health-wait: how long a measured scenario waits for an
+	// unhealthy target to recover before it is flagged; zero checks once
+	healthWait = 2 * time.Minute
+	// healthFail is -health-fail: stop the run instead of flagging it
+	healthFail = false
+)
+
+const (
+	// healthMaxReplicationLag is the replay backlog, in bytes, above which
+	// a replica counts as not caught up
+	healthMaxReplicationLag = 16 << 20
+	// healthPollInterval spaces out the checks while waiting
+	healthPollInterval = 5 * time.Second
+)
+
+// targetHealth lists what is wrong with a target before a measurement:
+// its connection is gone, the products table has invalid indexes, a
+// replica lags behind, or autovacuum is working on the table. Each would
+// show up in the next scenario's timings.
+func targetHealth(t *benchTarget) []string {
+	if err := t.DB.Ping(); err != nil {
+		return []string{fmt.Sprintf("connection lost: %v", err)}
+	}
+	var problems []string
+	if invalid, err := invalidIndexes(t.DB); err == nil && len(invalid) > 0 {
+		problems = append(problems, "invalid indexes "+strings.Join(invalid, ", "))
+	}
+
+	// Only a primary has pg_stat_replication rows; a standby has none
+	rows, err := t.DB.Query(`
+		SELECT application_name, COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn), 0)::bigint
+		FROM pg_stat_replication`)
+	if err == nil {
+		for rows.Next() {
+			var replica string
+			var lag int64
+			if rows.Scan(&replica, &lag) == nil && lag > healthMaxReplicationLag {
+				problems = append(problems, fmt.Sprintf("replica %s is %s behind", replica, formatBytes(uint64(lag))))
+			}
+		}
+		rows.Close()
+	}
+
+	var vacuums int
+	var longest float64
+	err = t.DB.QueryRow(`
+		SELECT count(*), COALESCE(max(EXTRACT(EPOCH FROM now() - a.xact_start)), 0)
+		FROM pg_stat_progress_vacuum v JOIN pg_stat_activity a ON a.pid = v.pid
+		WHERE v.relid = to_regclass($1) AND a.backend_type = 'autovacuum worker'`, productsTable).Scan(&vacuums, &longest)
+	if err == nil && vacuums > 0 {
+		problems = append(problems, fmt.Sprintf("autovacuum running on %s for %v", productsTable, (time.Duration(longest)*time.Second).Round(time.Second)))
+	}
+	return problems
+}
+
+// checkHealth runs before each measured scenario. An unhealthy target is
+// given -health-wait to recover, polling; one that does not is recorded as
+// a degradation, so the report says which numbers to distrust, or with
+// -health-fail stops the run.
+func checkHealth(st *runState, scenario string) error {
+	if fakeEngines {
+		return nil
+	}
+	deadline := time.Now().Add(healthWait)
+	for _, t := range st.Targets {
+		problems := targetHealth(t)
+		for len(problems) > 0 && time.Now().Before(deadline) {
+			infof("%s: Unhealthy (%s), waiting before %s...", t.Label, strings.Join(problems, "; "), scenario)
+			time.Sleep(min(healthPollInterval, time.Until(deadline)))
+			problems = targetHealth(t)
+		}
+		if len(problems) == 0 {
+			continue
+		}
+		err := fmt.Errorf("%s", strings.Join(problems, "; "))
+		if healthFail {
+			return fmt.Errorf("%s is unhealthy before %s: %v", t.Label, scenario, err)
+		}
+		degrade(t.Label, "health before "+scenario, err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// vanillaIndexDDL are the indexes of the vanilla target, built one after
+// the other once the data is loaded.
+var vanillaIndexDDL = []string{
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_asin ON products(asin)",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_gin ON products USING gin(to_tsvector('english', title))",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_description_gin ON products USING gin(to_tsvector('english', description))",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_brand_gin ON products USING gin(to_tsvector('english', brand))",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_combined_fulltext ON products USING gin(to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, '')))",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_trgm ON products USING gin (title gin_trgm_ops)",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_description_trgm ON products USING gin (description gin_trgm_ops)",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_brand_trgm ON products USING gin (brand gin_trgm_ops)",
+	"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_price ON products(price)",
+}
+
+// indexDDL is what a target builds once its data is loaded, in order,
+// written against products: the indexes its config lists, or else its
+// engine's.
+func (t *benchTarget) indexDDL() []string {
+	switch {
+	case len(t.Indexes) > 0:
+		return t.Indexes
+	case t.IsParadeDB:
+		return []string{fmt.Sprintf(t.bm25().Index, "products")}
+	}
+	return vanillaIndexDDL
+}
+
+// diskFull is the SQLSTATE of a write that ran out of disk space.
+const diskFull = "53100"
+
+// isDiskFull reports whether err is the server running out of disk space.
+// Extensions writing their own files may only pass on the OS error text.
+func isDiskFull(err error) bool {
+	if sqlState(err) == diskFull {
+		return true
+	}
+	return err != nil && strings.Contains(err.Error(), "No space left on device")
+}
+
+// diagnoseBuildError says what to do about an index build that ran out of
+// disk space; other errors are returned as they are.
+func diagnoseBuildError(err error) error {
+	if !isDiskFull(err) {
+		return err
+	}
+	return fmt.Errorf("%v: the database volume is out of disk space; free space or move the data to a larger volume (check with -db-volume), then run the index scenario again", err)
+}
+
+// invalidIndexes lists the indexes on products that a failed build left
+// behind; the planner ignores them. It is empty if there is no products
+// table yet.
+func invalidIndexes(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT indexrelid::regclass::text FROM pg_index WHERE indrelid = to_regclass($1) AND NOT indisvalid ORDER BY 1", productsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// dropInvalidIndexes drops the invalid indexes on products so they can be
+// built again, and returns their names.
+func dropInvalidIndexes(db *sql.DB) ([]string, error) {
+	names, err := invalidIndexes(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if _, err := db.Exec("DROP INDEX IF EXISTS " + name); err != nil {
+			return nil, fmt.Errorf("could not drop invalid index %s: %v", name, err)
+		}
+	}
+	return names, nil
+}
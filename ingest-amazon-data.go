@@ -1,13 +1,10 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -15,14 +12,19 @@ import (
 	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 const (
-	MetadataURL = "https://snap.stanford.edu/data/amazon/productGraph/metadata.json.gz"
-	SampleSize  = 0 // 0 means process all records
-	BatchSize   = 5000  // Increased from 1000 for better throughput
-	MaxWorkers  = 20    // Increased from 10 for more parallelism
+	MetadataURL  = "https://snap.stanford.edu/data/amazon/productGraph/metadata.json.gz"
+	MetadataFile = "metadata.json.gz"
+)
+
+// Load tuning; a -config file can override these.
+var (
+	SampleSize int32 = 0    // 0 means process all records
+	BatchSize        = 5000 // Increased from 1000 for better throughput
+	MaxWorkers       = 20   // Increased from 10 for more parallelism
 )
 
 type Product struct {
@@ -34,6 +36,8 @@ type Product struct {
 	Categories  []interface{}          `json:"categories"`
 	SalesRank   map[string]interface{} `json:"salesRank"`
 	ImageURL    string                 `json:"imUrl"`
+	// Provenance identifies the source line; empty for generated rows
+	Provenance string `json:"-"`
 }
 
 type DBConfig struct {
@@ -42,15 +46,36 @@ type DBConfig struct {
 	Database string
 	User     string
 	Password string
+	// PasswordFile is a file holding only the password, read in place of
+	// the other sources once the target is configured
+	PasswordFile string
+	// DSN is the connection string the target was given, if any; its other
+	// libpq options (sslmode, application_name, ...) are kept.
+	DSN string
+	// SSLMode, SSLRootCert, SSLCert and SSLKey are the libpq TLS settings;
+	// empty ones keep the DSN's or the default.
+	SSLMode     string
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	// MaxOpenConns, MaxIdleConns and ConnMaxLifetime size the connection
+	// pool; zero keeps the defaults (see configurePool).
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// Settings are run-time parameters sent with every connection.
+	Settings map[string]string
 }
 
 var (
+	// dataDir holds the downloaded dataset; defaults to the current directory
+	dataDir = "."
+
 	vanillaConfig = DBConfig{
 		Host:     "localhost",
 		Port:     5432,
 		Database: "benchmark_vanilla",
 		User:     "benchmark",
-		Password: "benchmark123",
 	}
 
 	paradeConfig = DBConfig{
@@ -58,73 +83,97 @@ var (
 		Port:     5433,
 		Database: "benchmark_parade",
 		User:     "benchmark",
-		Password: "benchmark123",
 	}
 )
 
 func downloadFile(url, filename string) error {
 	if _, err := os.Stat(filename); err == nil {
-		fmt.Printf("File %s already exists, skipping download\n", filename)
+		infof("File %s already exists, skipping download", filename)
 		return nil
 	}
 
-	fmt.Printf("Downloading %s...\n", filename)
+	infof("Downloading %s...", filename)
 	resp, err := http.Get(url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
 
-	out, err := os.Create(filename)
+	// Download next to the file and rename when complete, so an
+	// interrupted download is not taken for the dataset next time
+	tmp := filename + ".part"
+	out, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp)
 	defer out.Close()
 
 	_, err = io.Copy(out, resp.Body)
 	if err != nil {
 		return err
 	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
 
-	fmt.Printf("✅ Downloaded %s\n", filename)
+	infof("✅ Downloaded %s", filename)
 	return nil
 }
 
-func getDB(config DBConfig) (*sql.DB, error) {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		config.Host, config.Port, config.User, config.Password, config.Database)
+// dockerEnvFile exists inside a Docker container.
+var dockerEnvFile = "/.dockerenv"
 
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		return nil, err
+// hostCandidates returns the hosts to try for a target, in order. Docker
+// Desktop on macOS/Windows publishes ports on IPv4 only, so "localhost" may
+// resolve to ::1 and miss the container; from inside a container the host
+// is only reachable as host.docker.internal.
+func hostCandidates(host string) []string {
+	if host != "localhost" {
+		return []string{host}
 	}
+	candidates := []string{"localhost", "127.0.0.1", "host.docker.internal"}
+	if _, err := os.Stat(dockerEnvFile); err == nil {
+		candidates = []string{"host.docker.internal", "localhost", "127.0.0.1"}
+	}
+	return candidates
+}
 
-	db.SetMaxOpenConns(50)  // Increased for better parallelism
-	db.SetMaxIdleConns(10)
-	db.SetConnMaxLifetime(5 * time.Minute)
+func getDB(config DBConfig) (*sql.DB, error) {
+	var lastErr error
+	for _, host := range hostCandidates(config.Host) {
+		db, err := sql.Open(sqlDriverName(), config.connString(host))
+		if err != nil {
+			return nil, err
+		}
 
-	if err := db.Ping(); err != nil {
-		return nil, err
-	}
+		config.configurePool(db)
 
-	return db, nil
-}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			lastErr = err
+			warnf("Could not reach %s:%d: %v", host, config.Port, err)
+			continue
+		}
 
-func setupTables(db *sql.DB, isParadeDB bool) error {
-	dbType := "Vanilla PostgreSQL"
-	if isParadeDB {
-		dbType = "ParadeDB"
+		if host != config.Host {
+			infof("Connected to %s via %s:%d", config.Database, host, config.Port)
+		}
+		return db, nil
 	}
-	fmt.Printf("Setting up %s...\n", dbType)
 
-	// Drop and create table
-	_, err := db.Exec("DROP TABLE IF EXISTS products CASCADE")
-	if err != nil {
-		return err
-	}
+	return nil, lastErr
+}
 
-	// Create unlogged table for faster initial load (will be converted to logged after)
-	_, err = db.Exec(`
+// productsTableDDL creates the products table, unlogged until the indexes
+// are built.
+const productsTableDDL = `
 		CREATE UNLOGGED TABLE products (
 			id SERIAL PRIMARY KEY,
 			asin VARCHAR(20),
@@ -135,36 +184,74 @@ func setupTables(db *sql.DB, isParadeDB bool) error {
 			categories TEXT[],
 			sales_rank JSONB,
 			image_url TEXT,
+			provenance VARCHAR(16),
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)
-	`)
+	`
+
+// setupTables creates an empty products table on a target, and the
+// extension its indexes need.
+func setupTables(t *benchTarget) error {
+	db := t.DB
+	infof("Setting up %s...", t.Label)
+
+	// Drop and create table
+	_, err := db.Exec(productsSQL("DROP TABLE IF EXISTS products CASCADE"))
 	if err != nil {
 		return err
 	}
 
-	if isParadeDB {
+	// Create unlogged table for faster initial load (will be converted to logged after)
+	_, err = db.Exec(productsSQL(activeDataset.Schema()))
+	if err != nil {
+		return err
+	}
+
+	if t.IsParadeDB {
 		_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS pg_search")
 		if err != nil {
-			log.Printf("Warning: Could not create pg_search extension: %v", err)
+			return fmt.Errorf("could not create pg_search extension: %v", err)
 		}
 		// Note: BM25 index will be created AFTER data load for better performance
-		log.Println("ParadeDB: Deferring BM25 index creation until after data load...")
+		infof("%s: Deferring BM25 index creation until after data load...", t.Label)
 	} else {
 		// Create only pg_trgm extension now, indexes will be created after data load
-		_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm")
+		_, err = db.Exec(trgmExtensionDDL())
 		if err != nil {
-			log.Printf("Warning: Could not create pg_trgm extension: %v", err)
+			degrade(t.Label, "create pg_trgm extension", err)
 		}
-		log.Println("PostgreSQL: Deferring index creation until after data load...")
+		infof("%s: Deferring index creation until after data load...", t.Label)
 	}
 
 	return nil
 }
 
+// parseProductLine converts one line of the SNAP dump (a Python dict
+// literal) into a Product.
+func parseProductLine(line string) (Product, error) {
+	var product Product
+	jsonLine, err := pythonLiteralToJSON(line)
+	if err != nil {
+		return product, err
+	}
+	err = json.Unmarshal(jsonLine, &product)
+	return product, err
+}
+
+// productColumns are the products columns a batch fills, in the order of
+// productRow.
+var productColumns = []string{"asin", "title", "description", "price", "brand", "categories", "sales_rank", "image_url", "provenance"}
+
+// insertBatch loads products with COPY FROM STDIN in one transaction. A
+// row Postgres rejects fails the whole batch, as it did with INSERTs, since
+// an error aborts the transaction.
 func insertBatch(db *sql.DB, products []Product) error {
 	if len(products) == 0 {
 		return nil
 	}
+	if usesPgx(db) {
+		return insertBatchPgx(db, products)
+	}
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -172,242 +259,265 @@ func insertBatch(db *sql.DB, products []Product) error {
 	}
 	defer tx.Rollback()
 
-	// Use COPY-style batch insert for better performance
-	stmt, err := tx.Prepare(`
-		INSERT INTO products (asin, title, description, price, brand, categories, sales_rank, image_url)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`)
+	stmt, err := tx.Prepare(pq.CopyIn(productsTable, productColumns...))
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-
 	for _, p := range products {
-		// Handle categories - convert to PostgreSQL array format
-		var categoriesArray string
-		if p.Categories != nil && len(p.Categories) > 0 {
-			categoryStrings := make([]string, 0)
-			for _, cat := range p.Categories {
-				if catList, ok := cat.([]interface{}); ok {
-					for _, subCat := range catList {
-						if str, ok := subCat.(string); ok {
-							// Escape quotes and backslashes for PostgreSQL
-							str = strings.ReplaceAll(str, "\\", "\\\\")
-							str = strings.ReplaceAll(str, "\"", "\\\"")
-							categoryStrings = append(categoryStrings, str)
-						}
-					}
-				} else if str, ok := cat.(string); ok {
-					// Escape quotes and backslashes for PostgreSQL
-					str = strings.ReplaceAll(str, "\\", "\\\\")
-					str = strings.ReplaceAll(str, "\"", "\\\"")
-					categoryStrings = append(categoryStrings, str)
-				}
-			}
-			if len(categoryStrings) > 0 {
-				categoriesArray = "{\"" + strings.Join(categoryStrings, "\",\"") + "\"}"
-			} else {
-				categoriesArray = "{}"
-			}
-		} else {
-			categoriesArray = "{}"
-		}
-		
-		salesRankJSON, _ := json.Marshal(p.SalesRank)
-		
-		if p.Brand == "" {
-			p.Brand = "Unknown"
-		}
-		if p.Price == "" || p.Price == "null" {
-			p.Price = "0"
+		if _, err := stmt.Exec(productRow(p)...); err != nil {
+			return err
 		}
+	}
+	// The final Exec without arguments flushes the rows and reports any the
+	// server rejected.
+	if _, err := stmt.Exec(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-		_, err = stmt.Exec(
-			p.ASIN,
-			p.Title,
-			p.Description,
-			p.Price,
-			p.Brand,
-			categoriesArray,
-			string(salesRankJSON),
-			p.ImageURL,
-		)
-		if err != nil {
-			// Log error but continue with other products
-			log.Printf("Error inserting product %s: %v", p.ASIN, err)
+// productRow is a product as the values of productColumns.
+func productRow(p Product) []interface{} {
+	categories := []string{}
+	for _, cat := range p.Categories {
+		if catList, ok := cat.([]interface{}); ok {
+			for _, subCat := range catList {
+				if str, ok := subCat.(string); ok {
+					categories = append(categories, str)
+				}
+			}
+		} else if str, ok := cat.(string); ok {
+			categories = append(categories, str)
 		}
 	}
 
-	return tx.Commit()
+	salesRankJSON, _ := json.Marshal(p.SalesRank)
+
+	if p.Brand == "" {
+		p.Brand = "Unknown"
+	}
+	if p.Price == "" || p.Price == "null" {
+		p.Price = "0"
+	}
+	var provenance interface{}
+	if p.Provenance != "" {
+		provenance = p.Provenance
+	}
+	return []interface{}{p.ASIN, p.Title, p.Description, p.Price, p.Brand, pq.StringArray(categories), string(salesRankJSON), p.ImageURL, provenance}
 }
 
-func createIndexesAfterLoad(db *sql.DB, isParadeDB bool) error {
-	if isParadeDB {
-		log.Println("Creating ParadeDB BM25 index...")
-		_, err := db.Exec(`
-			CREATE INDEX IF NOT EXISTS products_search_idx ON products 
-			USING bm25 (id, title, description, brand) 
-			WITH (
-				key_field='id',
-				text_fields='{
-					"title": {
-						"tokenizer": {"type": "en_stem"},
-						"record": "position",
-						"normalizer": "lowercase"
-					},
-					"description": {
-						"tokenizer": {"type": "en_stem"},
-						"record": "position",
-						"normalizer": "lowercase"
-					},
-					"brand": {
-						"tokenizer": {"type": "raw"},
-						"record": "basic",
-						"normalizer": "lowercase"
-					}
-				}'
-			)
-		`)
+// BM25 index definitions for pg_search 0.13-0.19; %[1]s is the table name.
+// Other releases use the templates in bm25ddl.go.
+const (
+	bm25IndexDDL = `
+		CREATE INDEX IF NOT EXISTS %[1]s_search_idx ON %[1]s
+		USING bm25 (id, title, description, brand)
+		WITH (
+			key_field='id',
+			text_fields='{
+				"title": {
+					"tokenizer": {"type": "en_stem"},
+					"record": "position",
+					"normalizer": "lowercase"
+				},
+				"description": {
+					"tokenizer": {"type": "en_stem"},
+					"record": "position",
+					"normalizer": "lowercase"
+				},
+				"brand": {
+					"tokenizer": {"type": "raw"},
+					"record": "basic",
+					"normalizer": "lowercase"
+				}
+			}'
+		)
+	`
+
+	bm25FallbackDDL = `
+		CREATE INDEX IF NOT EXISTS %[1]s_search_idx ON %[1]s
+		USING bm25 (id, title, description, brand)
+		WITH (key_field='id')
+	`
+)
+
+// createIndexesAfterLoad builds a target's indexes: the BM25 index, with a
+// fallback to default tokenizers, on ParadeDB, the full-text and trigram
+// indexes on vanilla, or the indexes the target's config lists instead.
+func createIndexesAfterLoad(t *benchTarget) error {
+	db := t.DB
+	if len(t.Indexes) > 0 {
+		infof("%s: Creating the configured indexes...", t.Label)
+		for i, idx := range t.Indexes {
+			infof("%s: Creating index %d/%d...", t.Label, i+1, len(t.Indexes))
+			if _, err := db.Exec(productsSQL(idx)); err != nil {
+				degrade(t.Label, fmt.Sprintf("create index %d/%d", i+1, len(t.Indexes)), diagnoseBuildError(err))
+			}
+		}
+		if !t.IsParadeDB {
+			if _, err := db.Exec(productsSQL("ALTER TABLE products ADD CONSTRAINT products_asin_unique UNIQUE (asin)")); err != nil {
+				degrade(t.Label, "add unique constraint", err)
+			}
+		}
+		infof("✅ %s indexes created", t.Label)
+		return nil
+	}
+	if t.IsParadeDB {
+		infof("Creating ParadeDB BM25 index...")
+		_, err := db.Exec(fmt.Sprintf(t.bm25().Index, productsTable))
 		if err != nil {
-			log.Printf("Warning: Could not create optimized BM25 index: %v", err)
+			degrade(t.Label, "create optimized BM25 index, used default tokenizers", err)
 			// Fallback to simpler configuration
-			_, err = db.Exec(`
-				CREATE INDEX IF NOT EXISTS products_search_idx ON products 
-				USING bm25 (id, title, description, brand) 
-				WITH (key_field='id')
-			`)
+			_, err = db.Exec(fmt.Sprintf(t.bm25().Fallback, productsTable))
 			if err != nil {
-				return fmt.Errorf("could not create BM25 index: %v", err)
+				return fmt.Errorf("could not create BM25 index: %v", diagnoseBuildError(err))
 			}
 		}
-		log.Println("✅ ParadeDB BM25 index created")
+		infof("✅ ParadeDB BM25 index created")
 	} else {
-		log.Println("Creating PostgreSQL indexes...")
+		infof("Creating PostgreSQL indexes...")
 		
-		// Create all indexes in parallel for faster setup
-		indexes := []string{
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_asin ON products(asin)",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_gin ON products USING gin(to_tsvector('english', title))",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_description_gin ON products USING gin(to_tsvector('english', description))",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_brand_gin ON products USING gin(to_tsvector('english', brand))",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_combined_fulltext ON products USING gin(to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, '')))",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_title_trgm ON products USING gin (title gin_trgm_ops)",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_description_trgm ON products USING gin (description gin_trgm_ops)",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_brand_trgm ON products USING gin (brand gin_trgm_ops)",
-			"CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_price ON products(price)",
+		// A failed CONCURRENTLY build leaves an invalid index that IF NOT
+		// EXISTS would keep, so drop those first
+		if dropped, err := dropInvalidIndexes(db); err != nil {
+			degrade(t.Label, "drop invalid indexes", err)
+		} else if len(dropped) > 0 {
+			warnf("Dropped invalid indexes left by a failed build: %s", strings.Join(dropped, ", "))
 		}
-		
+
+		indexes := vanillaIndexDDL
 		for i, idx := range indexes {
-			log.Printf("Creating index %d/%d...", i+1, len(indexes))
-			_, err := db.Exec(idx)
+			infof("Creating index %d/%d...", i+1, len(indexes))
+			_, err := db.Exec(productsSQL(idx))
 			if err != nil {
-				log.Printf("Warning: Could not create index: %v", err)
+				degrade(t.Label, fmt.Sprintf("create index %d/%d", i+1, len(indexes)), diagnoseBuildError(err))
 			}
 		}
 		
 		// Add unique constraint on asin
-		_, err := db.Exec("ALTER TABLE products ADD CONSTRAINT products_asin_unique UNIQUE (asin)")
+		_, err := db.Exec(productsSQL("ALTER TABLE products ADD CONSTRAINT products_asin_unique UNIQUE (asin)"))
 		if err != nil {
-			log.Printf("Warning: Could not add unique constraint: %v", err)
+			degrade(t.Label, "add unique constraint", err)
 		}
 		
-		log.Println("✅ PostgreSQL indexes created")
+		infof("✅ PostgreSQL indexes created")
 	}
 	
 	return nil
 }
 
-func processAmazonData(db *sql.DB, isParadeDB bool, wg *sync.WaitGroup) {
+// loadBatch is a batch of records and the span of the corpus they were
+// read from, in loadProgress's numbering.
+type loadBatch struct {
+	products    []Product
+	first, next int
+}
+
+// loadDataset streams the active dataset's corpus into the table, SampleSize
+// records at most, skipping the records it can't load. Records whose
+// provenance is in loaded, or that progress holds as committed, are
+// already in the table from an interrupted load and count towards
+// SampleSize. Committed batches are recorded in progress unless it is nil.
+func loadDataset(t *benchTarget, filename string, loaded map[string]int, progress *loadProgress, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	dbType := "Vanilla"
-	if isParadeDB {
-		dbType = "ParadeDB"
-	}
+	db, dbType := t.DB, t.Label
 	
 	start := time.Now()
 
-	file, err := os.Open("metadata.json.gz")
-	if err != nil {
-		log.Printf("%s: Error opening file: %v", dbType, err)
-		return
-	}
-	defer file.Close()
-
-	gz, err := gzip.NewReader(file)
-	if err != nil {
-		log.Printf("%s: Error creating gzip reader: %v", dbType, err)
-		return
-	}
-	defer gz.Close()
-
-	scanner := bufio.NewScanner(gz)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // Increase buffer size
-
-	var processedCount int32
+	resumed := int32(len(loaded))
+	var skip *loadProgress
+	if progress != nil && progress.Rows > 0 {
+		// Batches committed from here on extend the same watermarks
+		skipCopy := *progress
+		skipCopy.Committed = append([][2]int(nil), progress.Committed...)
+		skip = &skipCopy
+		resumed = int32(progress.Rows)
+		infof("%s: Resuming after record %d of the corpus, %d rows already loaded", dbType, progress.Watermark, progress.Rows)
+	}
+	processedCount := resumed
+	// Records loaded this run with quotes or apostrophes in their text,
+	// which the old quote swap dropped, and records no parser could read
+	_, pythonDump := activeDataset.(amazonDataset)
+	recovered, malformed := 0, 0
 	batch := make([]Product, 0, BatchSize)
+	record, batchFirst := 0, 0
 	
 	// Create a channel for batches and worker pool
-	batchChan := make(chan []Product, 100)  // Increased buffer for better throughput
+	batchChan := make(chan loadBatch, 100)  // Increased buffer for better throughput
 	workerWg := &sync.WaitGroup{}
 	
 	// Start worker goroutines
+	var committed int64
 	for i := 0; i < MaxWorkers; i++ {
 		workerWg.Add(1)
 		go func() {
 			defer workerWg.Done()
-			for products := range batchChan {
+			for b := range batchChan {
+				products := b.products
 				if err := insertBatch(db, products); err != nil {
-					log.Printf("%s: Error inserting batch: %v", dbType, err)
+					degrade(dbType, fmt.Sprintf("insert batch of %d rows", len(products)), err)
+					continue
+				}
+				if progress != nil {
+					commitWatermark(progress, b.first, b.next, len(products))
 				}
+				emitEvent("batch_committed", map[string]interface{}{
+					"engine": t.Name, "rows": len(products), "committed": atomic.AddInt64(&committed, int64(len(products))),
+				})
 			}
 		}()
 	}
 
-	for scanner.Scan() && (SampleSize == 0 || atomic.LoadInt32(&processedCount) < SampleSize) {
-		line := scanner.Text()
-		if line == "" {
-			continue
+	err := streamCorpus(filename, func(product Product, err error) error {
+		if SampleSize > 0 && atomic.LoadInt32(&processedCount) >= SampleSize {
+			return errStopStream
 		}
-
-		// Convert Python dict format to JSON
-		jsonLine := strings.ReplaceAll(line, "'", "\"")
-		jsonLine = strings.ReplaceAll(jsonLine, "True", "true")
-		jsonLine = strings.ReplaceAll(jsonLine, "False", "false")
-		jsonLine = strings.ReplaceAll(jsonLine, "None", "null")
-
-		var product Product
-		if err := json.Unmarshal([]byte(jsonLine), &product); err != nil {
-			continue // Skip malformed lines
+		if err != nil {
+			malformed++
+			return nil // Skip malformed records
 		}
-
-		if product.ASIN != "" && product.Title != "" {
-			batch = append(batch, product)
-			atomic.AddInt32(&processedCount, 1)
-
-			if len(batch) >= BatchSize {
-				// Send batch to workers
-				batchChan <- batch
-				batch = make([]Product, 0, BatchSize)
-				
-				count := atomic.LoadInt32(&processedCount)
-				if count%50000 == 0 {
-					elapsed := time.Since(start)
-					rate := float64(count) / elapsed.Seconds()
-					eta := time.Duration(float64(1600000-count) / rate * float64(time.Second))
-					fmt.Printf("%s: %d products processed (%.0f/sec, ETA: %v)...\n", dbType, count, rate, eta.Round(time.Second))
+		i := record
+		record++
+		if loaded[product.Provenance] > 0 || (skip != nil && skip.done(i)) {
+			return nil // Skip already loaded records
+		}
+		if pythonDump && product.quotedText() {
+			recovered++
+		}
+		if len(batch) == 0 {
+			batchFirst = i
+		}
+		batch = append(batch, product)
+		count := atomic.AddInt32(&processedCount, 1)
+
+		if len(batch) >= BatchSize {
+			// Send batch to workers
+			batchChan <- loadBatch{batch, batchFirst, record}
+			batch = make([]Product, 0, BatchSize)
+			
+			if count%50000 == 0 || currentLevel == levelDebug {
+				elapsed := time.Since(start)
+				rate := float64(count-resumed) / elapsed.Seconds()
+				if SampleSize > 0 {
+					eta := time.Duration(float64(SampleSize-count) / rate * float64(time.Second))
+					infof("%s: %d products processed (%.0f/sec, ETA: %v)...", dbType, count, rate, eta.Round(time.Second))
+				} else {
+					infof("%s: %d products processed (%.0f/sec)...", dbType, count, rate)
 				}
 			}
 		}
 
-		if SampleSize > 0 && atomic.LoadInt32(&processedCount) >= SampleSize {
-			break
-		}
+		return nil
+	})
+	if err != nil {
+		errorf("%s: Error reading %s: %v", dbType, filename, err)
 	}
 
 	// Process remaining batch
 	if len(batch) > 0 {
-		batchChan <- batch
+		batchChan <- loadBatch{batch, batchFirst, record}
 	}
 
 	// Close channel and wait for workers to finish
@@ -416,84 +526,64 @@ func processAmazonData(db *sql.DB, isParadeDB bool, wg *sync.WaitGroup) {
 
 	finalCount := atomic.LoadInt32(&processedCount)
 	elapsed := time.Since(start)
-	fmt.Printf("%s: Data loading complete! %d products loaded in %v\n", dbType, finalCount, elapsed.Round(time.Second))
-	
+	if resumed > 0 {
+		infof("%s: Data loading complete! %d products loaded in %v, %d of them before resuming", dbType, finalCount, elapsed.Round(time.Second), resumed)
+	} else {
+		infof("%s: Data loading complete! %d products loaded in %v", dbType, finalCount, elapsed.Round(time.Second))
+	}
+	if pythonDump {
+		infof("%s: %d record(s) with quotes or apostrophes in their text loaded, %d skipped as malformed or without an asin or title", dbType, recovered, malformed)
+	} else if malformed > 0 {
+		infof("%s: %d record(s) skipped as malformed or without an asin or title", dbType, malformed)
+	}
+}
+
+// finalizeTable makes the loaded table durable, builds the indexes and
+// refreshes planner statistics.
+func finalizeTable(t *benchTarget, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	db, dbType := t.DB, t.Label
+	start := time.Now()
+
 	// Convert UNLOGGED table back to LOGGED for durability
-	log.Printf("%s: Converting to logged table for durability...\n", dbType)
-	_, err = db.Exec("ALTER TABLE products SET LOGGED")
+	infof("%s: Converting to logged table for durability...", dbType)
+	_, err := db.Exec("ALTER TABLE " + productsTable + " SET LOGGED")
 	if err != nil {
-		log.Printf("%s: Warning: Could not convert to logged table: %v", dbType, err)
+		degrade(dbType, "convert to logged table", err)
 	}
 	
 	// Create indexes AFTER data load
-	log.Printf("%s: Creating indexes...\n", dbType)
+	infof("%s: Creating indexes...", dbType)
 	indexStart := time.Now()
-	if err := createIndexesAfterLoad(db, isParadeDB); err != nil {
-		log.Printf("%s: Error creating indexes: %v", dbType, err)
+	if err := createIndexesAfterLoad(t); err != nil {
+		degrade(dbType, "create indexes", err)
+	}
+	infof("%s: Indexes created in %v", dbType, time.Since(indexStart).Round(time.Second))
+	invalid, err := invalidIndexes(db)
+	if err == nil && len(invalid) > 0 {
+		degrade(dbType, "create indexes", fmt.Errorf("%s left invalid by a failed build; queries will not use them", strings.Join(invalid, ", ")))
 	}
-	fmt.Printf("%s: Indexes created in %v\n", dbType, time.Since(indexStart).Round(time.Second))
+	emitEvent("index_built", map[string]interface{}{"engine": t.Name, "duration_ns": time.Since(indexStart), "invalid": invalid})
 	
 	// Analyze table for better query performance
-	log.Printf("%s: Analyzing table...\n", dbType)
-	_, err = db.Exec("ANALYZE products")
+	infof("%s: Analyzing table...", dbType)
+	_, err = db.Exec("ANALYZE " + productsTable)
 	if err != nil {
-		log.Printf("%s: Error analyzing table: %v", dbType, err)
+		degrade(dbType, "analyze table", err)
 	}
 	
 	totalTime := time.Since(start)
-	fmt.Printf("%s: Total setup time: %v\n", dbType, totalTime.Round(time.Second))
+	infof("%s: Post-load setup time: %v", dbType, totalTime.Round(time.Second))
 
 	// Verify insertion
 	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM products").Scan(&count)
+	err = db.QueryRow("SELECT COUNT(*) FROM " + productsTable).Scan(&count)
 	if err == nil {
-		fmt.Printf("%s: Verified %d products in database\n", dbType, count)
-	}
-}
-
-func main() {
-	fmt.Println("🛒 Setting up real Amazon products dataset from Stanford SNAP...")
-
-	// Download file if needed
-	if err := downloadFile(MetadataURL, "metadata.json.gz"); err != nil {
-		log.Fatalf("Failed to download file: %v", err)
-	}
-
-	fmt.Println("Make sure Docker containers are running: docker-compose up -d")
-	time.Sleep(3 * time.Second)
-
-	// Connect to databases
-	vanillaDB, err := getDB(vanillaConfig)
-	if err != nil {
-		log.Fatalf("Failed to connect to Vanilla PostgreSQL: %v", err)
-	}
-	defer vanillaDB.Close()
-
-	paradeDB, err := getDB(paradeConfig)
-	if err != nil {
-		log.Fatalf("Failed to connect to ParadeDB: %v", err)
-	}
-	defer paradeDB.Close()
-
-	// Setup tables
-	if err := setupTables(vanillaDB, false); err != nil {
-		log.Fatalf("Failed to setup Vanilla PostgreSQL: %v", err)
+		infof("%s: Verified %d products in database", dbType, count)
 	}
 
-	if err := setupTables(paradeDB, true); err != nil {
-		log.Fatalf("Failed to setup ParadeDB: %v", err)
+	if err := runHooks(db, dbType, "after_setup", runConfig.Hooks[t.Name].AfterSetup); err != nil {
+		degrade(dbType, "after_setup hook", err)
 	}
-
-	// Process data in parallel
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go processAmazonData(vanillaDB, false, &wg)
-	go processAmazonData(paradeDB, true, &wg)
-
-	wg.Wait()
-
-	fmt.Println("\n✅ Real Amazon dataset setup complete!")
-	fmt.Println("🚀 Run: npm run dev")
-	fmt.Println("🔍 Try searching real products like: \"apple\", \"samsung phone\", \"book\", \"camera\"")
-}
\ No newline at end of file
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHostCandidates(t *testing.T) {
+	defer func(path string) { dockerEnvFile = path }(dockerEnvFile)
+	inContainer := filepath.Join(t.TempDir(), ".dockerenv")
+	if err := os.WriteFile(inContainer, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	onHost := filepath.Join(t.TempDir(), ".dockerenv")
+
+	for _, tc := range []struct {
+		name      string
+		host      string
+		container bool
+		want      []string
+	}{
+		{"localhost on the host", "localhost", false, []string{"localhost", "127.0.0.1", "host.docker.internal"}},
+		{"localhost in a container", "localhost", true, []string{"host.docker.internal", "localhost", "127.0.0.1"}},
+		{"compose service name", "paradedb", true, []string{"paradedb"}},
+		{"ip address", "127.0.0.1", false, []string{"127.0.0.1"}},
+		{"remote host", "db.example.com", false, []string{"db.example.com"}},
+	} {
+		dockerEnvFile = onHost
+		if tc.container {
+			dockerEnvFile = inContainer
+		}
+		if got := hostCandidates(tc.host); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: hostCandidates(%q) = %v, want %v", tc.name, tc.host, got, tc.want)
+		}
+	}
+}
+
+// TestHostCandidatesEnv checks that a host set in the environment is tried
+// alone, even from inside a container.
+func TestHostCandidatesEnv(t *testing.T) {
+	defer func(path string, vanilla DBConfig) { dockerEnvFile, vanillaConfig = path, vanilla }(dockerEnvFile, vanillaConfig)
+	dockerEnvFile = filepath.Join(t.TempDir(), ".dockerenv")
+	if err := os.WriteFile(dockerEnvFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGB_VANILLA_HOST", "vanilla_postgres")
+	if err := applyTargetEnv("vanilla", targetEnvPrefixes["vanilla"], map[string]bool{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostCandidates(vanillaConfig.Host); !reflect.DeepEqual(got, []string{"vanilla_postgres"}) {
+		t.Errorf("hostCandidates = %v, want [vanilla_postgres]", got)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+)
+
+// parallelTargets runs setup phases on all targets at once. It is faster, but
+// when the targets share a machine they compete for CPU and disk and the
+// per-engine timings are skewed, so benchmarking defaults to serial.
+var parallelTargets = false
+
+// executionMode is how the setup phases ran, as recorded in
+// ingest-status.json.
+func executionMode() string {
+	if parallelTargets {
+		return "parallel"
+	}
+	return "serial"
+}
+
+// measuredExecution is how a scenario's measurements ran: Mode is serial
+// when the targets were timed one at a time and parallel when together,
+// and Concurrent names the load that ran on them meanwhile, if any.
+type measuredExecution struct {
+	Mode       string
+	Concurrent string
+}
+
+// scenarioExecutions are the scenarios whose measurements don't run one
+// target at a time on otherwise idle targets. -parallel only applies to the
+// setup phases; every other scenario times its targets serially.
+var scenarioExecutions = map[string]measuredExecution{
+	"mixed":          {"serial", "background writes"},
+	"pagination":     {"serial", "background writes"},
+	"lag":            {"parallel", "background writes"},
+	"stream":         {"parallel", ""},
+	"noisy-neighbor": {"serial", "neighbor load"},
+	"oltp":           {"serial", "OLTP load"},
+}
+
+// scenarioExecution returns how scenario's measurements ran.
+func scenarioExecution(scenario string) measuredExecution {
+	if e, ok := scenarioExecutions[scenario]; ok {
+		return e
+	}
+	return measuredExecution{Mode: "serial"}
+}
+
+// forEachTarget calls fn for every target, concurrently only in parallel
+// mode. fn must call wg.Done.
+func (st *runState) forEachTarget(fn func(t *benchTarget, wg *sync.WaitGroup)) {
+	var wg sync.WaitGroup
+	for _, t := range st.Targets {
+		wg.Add(1)
+		if parallelTargets {
+			go fn(t, &wg)
+		} else {
+			fn(t, &wg)
+		}
+	}
+	wg.Wait()
+}
+
+// machineKey maps the names Docker setups use for the local machine to one
+// key, so targets published on localhost and 127.0.0.1 compare equal.
+func machineKey(host string) string {
+	switch host {
+	case "localhost", "127.0.0.1", "::1", "host.docker.internal", "":
+		return "local"
+	}
+	return host
+}
+
+// sharedHosts returns the labels of targets that run on the same machine as
+// at least one other target.
+func sharedHosts(targets []*benchTarget) []string {
+	byHost := map[string][]string{}
+	var order []string
+	for _, t := range targets {
+		key := machineKey(t.Config.Host)
+		if _, ok := byHost[key]; !ok {
+			order = append(order, key)
+		}
+		byHost[key] = append(byHost[key], t.Label)
+	}
+
+	var shared []string
+	for _, key := range order {
+		if len(byHost[key]) > 1 {
+			shared = append(shared, byHost[key]...)
+		}
+	}
+	return shared
+}
+
+// warnParallelContention prints a warning when parallel mode will make
+// targets on one machine compete with each other.
+func warnParallelContention(targets []*benchTarget) {
+	if !parallelTargets {
+		return
+	}
+	if shared := sharedHosts(targets); len(shared) > 0 {
+		warnf("⚠️  Parallel mode: %v share one machine's CPUs and disk; setup timings will be skewed. Drop -parallel for publishable numbers.", shared)
+	}
+}
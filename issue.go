@@ -0,0 +1,527 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"pg_search_benchmark/manifest"
+)
+
+// divergentShare is the share of the shorter result list two engines must
+// have in common for their results not to count as divergent.
+const divergentShare = 0.5
+
+// issueCandidate is a ParadeDB result worth reporting upstream: a query
+// and argument set that errored, or whose results diverged from another
+// engine's.
+type issueCandidate struct {
+	Query  string
+	Args   []interface{}
+	Engine string
+	Reason string
+}
+
+// findIssueCandidates lists the ParadeDB errors of the read-only results
+// and the snapshot result sets that share too little with the first
+// non-ParadeDB engine's.
+func findIssueCandidates(dir string, targets []*benchTarget) []issueCandidate {
+	parade, other := map[string]bool{}, ""
+	for _, t := range targets {
+		if t.IsParadeDB {
+			parade[t.Name] = true
+		} else if other == "" {
+			other = t.Name
+		}
+	}
+
+	var found []issueCandidate
+	if f, err := loadResults(dir, "read-only"); err == nil {
+		if results, err := f.Queries(); err == nil {
+			for _, r := range results {
+				if parade[r.Engine] && r.Error != "" {
+					found = append(found, issueCandidate{r.Query, r.Args, r.Engine, "errors: " + r.Error})
+				}
+			}
+		}
+	}
+	if _, results, err := loadSnapshot(""); err == nil && other != "" {
+		key := func(r manifest.QueryResult) string { return r.Query + " " + argsLabel(r.Args) }
+		others := map[string]manifest.QueryResult{}
+		for _, r := range results {
+			if r.Engine == other && r.Error == "" {
+				others[key(r)] = r
+			}
+		}
+		for _, r := range results {
+			o, ok := others[key(r)]
+			if !parade[r.Engine] || r.Error != "" || !ok {
+				continue
+			}
+			shared := compareTopK(o.TopK, r.TopK).Shared
+			if shared >= int(divergentShare*float64(min(len(o.TopK), len(r.TopK)))) && (len(o.TopK) == 0) == (len(r.TopK) == 0) {
+				continue
+			}
+			found = append(found, issueCandidate{r.Query, r.Args, r.Engine,
+				fmt.Sprintf("returns %d rows where %s returns %d, %d of them shared", len(r.TopK), other, len(o.TopK), shared)})
+		}
+	}
+	return found
+}
+
+// captureIssueCommand implements "capture-issue": it packages each
+// ParadeDB error or divergence into a directory holding an issue.md to
+// file upstream and a repro.sql that rebuilds the case on an empty
+// database.
+func captureIssueCommand(args []string) {
+	st := &runState{}
+	fs := flag.NewFlagSet("capture-issue", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	fs.StringVar(&configPath, "config", configPath, "YAML or TOML (.toml) run configuration file")
+	fs.StringVar(&datasetName, "dataset", datasetName, "dataset whose workload the results came from: "+strings.Join(datasetNames(), ", "))
+	fs.StringVar(&st.WorkloadPath, "workload", "", "workload file the results came from (default: the dataset's workload)")
+	query := fs.String("query", "", "capture this workload query instead of the errors and divergences of the stored results")
+	argSet := fs.Int("args", 0, "with -query, the index of the argument set to capture")
+	sampleRows := fs.Int("rows", 20, "most rows of data to include in the repro")
+	maxIssues := fs.Int("max", 5, "most issues to capture from the stored results")
+	out := fs.String("out", "", "directory to write the bundles to (default: issues in -data-dir)")
+	reduce := fs.Bool("reduce", false, "shrink each issue to the fewest rows and search terms that still reproduce it before writing the bundle")
+	fs.IntVar(&maxReduceTests, "reduce-tests", maxReduceTests, "most cases -reduce tries per issue")
+	fs.DurationVar(&lockWait, "lock-wait", lockWait, "with -reduce, how long to wait for a benchmark run holding a target to finish before giving up")
+	fs.Parse(args)
+
+	var err error
+	if runConfig, err = loadRunConfig(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := configureTargets(fs, runConfig); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := selectDataset(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if st.WorkloadPath == "" {
+		st.WorkloadPath = activeDataset.Workload()
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *out == "" {
+		*out = filepath.Join(dataDir, "issues")
+	}
+
+	targets := defaultTargets()
+	var parade *benchTarget
+	for _, t := range targets {
+		db, err := getDB(t.Config)
+		if err != nil {
+			log.Fatalf("%s: %v", t.Label, err)
+		}
+		t.DB = db
+		defer db.Close()
+		if t.IsParadeDB && parade == nil {
+			parade = t
+		}
+	}
+	if parade == nil {
+		log.Fatalf("No ParadeDB target to capture issues from")
+	}
+
+	var candidates []issueCandidate
+	if *query != "" {
+		q, ok := w.query(*query)
+		if !ok {
+			log.Fatalf("%s has no query %s", st.WorkloadPath, *query)
+		}
+		if *argSet < 0 || *argSet >= max(len(q.Args), 1) {
+			log.Fatalf("%s has %d argument set(s), -args %d is out of range", q.Name, len(q.Args), *argSet)
+		}
+		var args []interface{}
+		if len(q.Args) > 0 {
+			args = q.Args[*argSet]
+		}
+		candidates = append(candidates, issueCandidate{q.Name, args, parade.Name, "captured on request"})
+	} else {
+		candidates = findIssueCandidates(dataDir, targets)
+		if len(candidates) == 0 {
+			fmt.Println("✅ No ParadeDB errors in the read-only results and no divergent snapshot results; name a query with -query to capture it anyway")
+			return
+		}
+		if len(candidates) > *maxIssues {
+			fmt.Printf("%d issue(s) found, capturing the first %d (-max)\n", len(candidates), *maxIssues)
+			candidates = candidates[:*maxIssues]
+		}
+	}
+
+	byName := map[string]*benchTarget{}
+	for _, t := range targets {
+		byName[t.Name] = t
+	}
+	for _, c := range candidates {
+		t := byName[c.Engine]
+		if t == nil {
+			t = parade
+		}
+		dir, err := captureIssue(w, c, t, targets, *sampleRows, *reduce, *out)
+		if err != nil {
+			errorf("Failed to capture %s %s: %v", c.Query, argsLabel(c.Args), err)
+			continue
+		}
+		fmt.Printf("📦 %s %s (%s): %s\n", c.Query, argsLabel(c.Args), c.Reason, dir)
+	}
+}
+
+// query finds a workload query by name.
+func (w *Workload) query(name string) (WorkloadQuery, bool) {
+	for _, q := range w.Queries {
+		if q.Name == name {
+			return q, true
+		}
+	}
+	return WorkloadQuery{}, false
+}
+
+// issueRun is what one engine made of the captured query.
+type issueRun struct {
+	Target  *benchTarget
+	SQL     string
+	Args    []interface{}
+	Explain string
+	TopK    []string
+	Err     error
+}
+
+// captureIssue runs the candidate's query on t and on the other targets
+// that have it, and writes the bundle to a directory under out. With
+// reduce, the repro holds the reduced case instead of a sample.
+func captureIssue(w *Workload, c issueCandidate, t *benchTarget, targets []*benchTarget, sampleRows int, reduce bool, out string) (string, error) {
+	q, ok := w.query(c.Query)
+	if !ok {
+		return "", fmt.Errorf("the workload has no query %s", c.Query)
+	}
+	// Results files keep arguments as JSON decoded them; the workload's
+	// own argument set binds with the original types
+	args := c.Args
+	for _, a := range q.Args {
+		if argsLabel(a) == argsLabel(c.Args) {
+			args = a
+			break
+		}
+	}
+
+	// The reported engine first, then the others to compare with
+	engines := []*benchTarget{t}
+	for _, other := range targets {
+		if !other.IsParadeDB {
+			engines = append(engines, other)
+		}
+	}
+	var runs []issueRun
+	for _, other := range engines {
+		stmt, ok := q.SQL[other.sqlName()]
+		if !ok {
+			continue
+		}
+		run := issueRun{Target: other, SQL: stmt, Args: q.bind(other.sqlName(), args)}
+		if run.Explain, run.Err = explainText(other.DB, stmt, run.Args); run.Err == nil {
+			_, run.TopK, run.Err = snapshotTopK(other.DB, stmt, run.Args)
+		}
+		runs = append(runs, run)
+	}
+	if len(runs) == 0 || runs[0].Target != t {
+		return "", fmt.Errorf("%s has no SQL for %s", c.Query, t.Label)
+	}
+
+	columns, err := tableColumns(t.DB)
+	if err != nil {
+		return "", fmt.Errorf("read the schema of %s: %v", productsTable, err)
+	}
+	indexes, err := tableIndexDDL(t.DB)
+	if err != nil {
+		return "", fmt.Errorf("read the indexes of %s: %v", productsTable, err)
+	}
+	var red *issueReduction
+	if reduce {
+		if red, err = reduceIssue(q, args, runs); err != nil {
+			warnf("Warning: %s %s not reduced: %v", c.Query, argsLabel(c.Args), err)
+		}
+	}
+	var rows []string
+	repro := runs[0]
+	if red != nil {
+		rows, err = rowsByASIN(t.DB, red.ASINs)
+		repro.Args = q.bind(t.sqlName(), red.Args)
+		fmt.Printf("✂️  %s %s reduced to %d of %d row(s) and arguments %s in %d test(s)\n",
+			c.Query, argsLabel(c.Args), len(red.ASINs), red.Original, argsLabel(red.Args), red.Tests)
+	} else {
+		rows, err = sampleIssueRows(t.DB, runs, sampleRows)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sample rows: %v", err)
+	}
+
+	dir := filepath.Join(out, fmt.Sprintf("%s-%s-%s", c.Query, c.Engine, runID))
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		dir = filepath.Join(out, fmt.Sprintf("%s-%s-%s-%d", c.Query, c.Engine, runID, i))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	script := issueRepro(columns, indexes, rows, repro)
+	if err := os.WriteFile(filepath.Join(dir, "repro.sql"), []byte(script), 0o644); err != nil {
+		return "", err
+	}
+	report := issueMarkdown(c, runs, red, engineVersions(targets), len(rows))
+	if err := os.WriteFile(filepath.Join(dir, "issue.md"), []byte(report), 0o644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// explainText is the plan of a statement as EXPLAIN (VERBOSE) prints it.
+// Queries are not run under ANALYZE, so one that errors still has a plan.
+func explainText(db *sql.DB, stmt string, args []interface{}) (string, error) {
+	rows, err := db.Query("EXPLAIN (VERBOSE) "+stmt, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
+
+// tableColumns are the column definitions of the products table, without
+// defaults, which may name sequences the repro doesn't have.
+func tableColumns(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT quote_ident(attname) || ' ' || format_type(atttypid, atttypmod) || CASE WHEN attnotnull THEN ' NOT NULL' ELSE '' END
+		FROM pg_attribute
+		WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped
+		ORDER BY attnum`, productsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table not found")
+	}
+	return columns, nil
+}
+
+// tableIndexDDL is the CREATE INDEX statement of every index on the
+// products table.
+func tableIndexDDL(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT pg_get_indexdef(indexrelid) FROM pg_index WHERE indrelid = to_regclass($1) ORDER BY indexrelid", productsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ddl []string
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			return nil, err
+		}
+		ddl = append(ddl, stmt)
+	}
+	return ddl, rows.Err()
+}
+
+// sampleIssueRows picks the rows of the repro as JSON: first the ones only
+// some engines returned, then the ones they all did, then, if the query
+// returned nothing anywhere, any rows of the table.
+func sampleIssueRows(db *sql.DB, runs []issueRun, limit int) ([]string, error) {
+	seen := map[string]int{}
+	for _, r := range runs {
+		for _, asin := range r.TopK {
+			seen[asin]++
+		}
+	}
+	var asins []string
+	for asin := range seen {
+		asins = append(asins, asin)
+	}
+	sort.Slice(asins, func(i, j int) bool {
+		if seen[asins[i]] != seen[asins[j]] {
+			return seen[asins[i]] < seen[asins[j]]
+		}
+		return asins[i] < asins[j]
+	})
+	if len(asins) > limit {
+		asins = asins[:limit]
+	}
+
+	if len(asins) == 0 {
+		return productRowsJSON(db, productsSQL("SELECT to_jsonb(p) FROM products p LIMIT $1"), limit)
+	}
+	return rowsByASIN(db, asins)
+}
+
+// rowsByASIN are the products rows of the given ASINs as JSON.
+func rowsByASIN(db *sql.DB, asins []string) ([]string, error) {
+	return productRowsJSON(db, productsSQL("SELECT to_jsonb(p) FROM products p WHERE asin = ANY($1) ORDER BY asin"), pq.Array(asins))
+}
+
+func productRowsJSON(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sample []string
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return nil, err
+		}
+		sample = append(sample, row)
+	}
+	return sample, rows.Err()
+}
+
+// sqlLiteral quotes a workload argument for a SQL script.
+func sqlLiteral(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case int, int64, float64, bool:
+		return fmt.Sprint(v)
+	}
+	return pq.QuoteLiteral(fmt.Sprint(v))
+}
+
+// issueRepro is a script that rebuilds the case on an empty database: the
+// table, the sampled rows, the indexes, then the query.
+func issueRepro(columns, indexes, rows []string, run issueRun) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- Reproduces %s on %s; run with psql -f on an empty database.\n", run.Target.Label, productsTable)
+	if run.Target.IsParadeDB {
+		b.WriteString("CREATE EXTENSION IF NOT EXISTS pg_search;\n")
+	}
+	if dbSchema != "" {
+		fmt.Fprintf(&b, "CREATE SCHEMA IF NOT EXISTS %s;\nSET search_path = %s, public;\n", dbSchema, dbSchema)
+	}
+	fmt.Fprintf(&b, "\nCREATE TABLE %s (\n    %s\n);\n\n", productsTable, strings.Join(columns, ",\n    "))
+	for _, row := range rows {
+		fmt.Fprintf(&b, "INSERT INTO %s SELECT * FROM jsonb_populate_record(NULL::%s, %s);\n", productsTable, productsTable, pq.QuoteLiteral(row))
+	}
+	b.WriteString("\n")
+	for _, ddl := range indexes {
+		b.WriteString(ddl + ";\n")
+	}
+	b.WriteString("\nPREPARE issue AS\n" + strings.TrimSpace(run.SQL) + ";\n")
+	literals := make([]string, len(run.Args))
+	for i, a := range run.Args {
+		literals[i] = sqlLiteral(a)
+	}
+	if len(literals) > 0 {
+		fmt.Fprintf(&b, "EXPLAIN (VERBOSE) EXECUTE issue(%s);\nEXECUTE issue(%s);\n", strings.Join(literals, ", "), strings.Join(literals, ", "))
+	} else {
+		b.WriteString("EXPLAIN (VERBOSE) EXECUTE issue;\nEXECUTE issue;\n")
+	}
+	return b.String()
+}
+
+// issueMarkdown is the issue text: what went wrong, the versions, and each
+// engine's query, plan and results.
+func issueMarkdown(c issueCandidate, runs []issueRun, red *issueReduction, versions map[string]string, sampled int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s: %s %s\n\n", runs[0].Target.Label, c.Query, c.Reason)
+	fmt.Fprintf(&b, "Captured by pg_search_benchmark (run %s) from workload query `%s` with arguments %s.\n\n", runID, c.Query, argsLabel(c.Args))
+	b.WriteString("## Versions\n\n")
+	for _, r := range runs {
+		version, ok := versions[r.Target.Name]
+		if !ok {
+			version = "unknown"
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", r.Target.Label, version)
+	}
+	if red == nil {
+		fmt.Fprintf(&b, "\n## Reproduce\n\n`psql -f repro.sql` creates `%s` with %d sampled row(s) and the indexes of the benchmark's %s target, then runs the query. Rows returned by only some engines were sampled first.\n", productsTable, sampled, runs[0].Target.Label)
+	} else {
+		fmt.Fprintf(&b, "\n## Reproduce\n\n`psql -f repro.sql` creates `%s` with the %d row(s) of the reduced case and the indexes of the benchmark's %s target, then runs the query with the reduced arguments.\n", productsTable, sampled, runs[0].Target.Label)
+		fmt.Fprintf(&b, "\n## Reduced case\n\nThe case still reproduces with %d of the %d row(s) the engines returned on the whole corpus (%s) and arguments %s, found in %d test(s).\n\n",
+			len(red.ASINs), red.Original, strings.Join(red.ASINs, ", "), argsLabel(red.Args), red.Tests)
+		for _, r := range red.Results {
+			if r.Err != nil {
+				fmt.Fprintf(&b, "- %s: error `%v`\n", r.Target.Label, r.Err)
+			} else {
+				fmt.Fprintf(&b, "- %s: %d row(s): %s\n", r.Target.Label, len(r.TopK), strings.Join(r.TopK, ", "))
+			}
+		}
+	}
+
+	for _, r := range runs {
+		fmt.Fprintf(&b, "\n## %s\n\n```sql\n%s\n```\n\n", r.Target.Label, strings.TrimSpace(r.SQL))
+		if len(r.Args) > 0 {
+			fmt.Fprintf(&b, "Arguments: %s\n\n", argsLabel(r.Args))
+		}
+		if r.Explain != "" {
+			fmt.Fprintf(&b, "```\n%s\n```\n\n", r.Explain)
+		}
+		if r.Err != nil {
+			fmt.Fprintf(&b, "Error: `%v`\n", r.Err)
+			continue
+		}
+		fmt.Fprintf(&b, "Returned %d row(s): %s\n", len(r.TopK), strings.Join(r.TopK, ", "))
+	}
+
+	if len(runs) > 1 && runs[0].Err == nil {
+		for _, other := range runs[1:] {
+			if other.Err != nil {
+				continue
+			}
+			only, missing := asinDifference(runs[0].TopK, other.TopK), asinDifference(other.TopK, runs[0].TopK)
+			if len(only) == 0 && len(missing) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "\n## Difference from %s\n\n", other.Target.Label)
+			fmt.Fprintf(&b, "- Only %s: %s\n- Only %s: %s\n", runs[0].Target.Label, strings.Join(only, ", "), other.Target.Label, strings.Join(missing, ", "))
+		}
+	}
+	return b.String()
+}
+
+// asinDifference lists the ASINs of a that b lacks, in a's order.
+func asinDifference(a, b []string) []string {
+	in := map[string]bool{}
+	for _, asin := range b {
+		in[asin] = true
+	}
+	var diff []string
+	for _, asin := range a {
+		if !in[asin] {
+			diff = append(diff, asin)
+		}
+	}
+	return diff
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// journal is the cell journal of the scenario running, nil outside
+// runScenarios: runWorkload skips the cells it holds and appends the ones
+// it finishes.
+var journal *cellJournal
+
+// journalFile is where a scenario's finished cells are appended while it
+// runs, next to the results it ends up in.
+func journalFile(dir, scenario string) string {
+	return filepath.Join(dir, fmt.Sprintf("journal-%s.jsonl", scenario))
+}
+
+// journalEntry is one finished cell: the results of every query of a
+// category on an engine, in the pass-th runWorkload call of the scenario.
+type journalEntry struct {
+	Pass     int                    `json:"pass"`
+	Engine   string                 `json:"engine"`
+	Category string                 `json:"category"`
+	At       time.Time              `json:"at"`
+	Results  []manifest.QueryResult `json:"results"`
+}
+
+// cellJournal records the (engine, category) cells a scenario has finished,
+// so a run that crashes part way through is resumed from the last one
+// instead of from the start of the scenario. Passes number the scenario's
+// runWorkload calls, which a scenario makes in the same order every run.
+type cellJournal struct {
+	path string
+	pass int
+	done map[string]journalEntry
+}
+
+func journalKey(pass int, engine, category string) string {
+	return fmt.Sprintf("%d/%s/%s", pass, engine, category)
+}
+
+// openCellJournal starts the journal of a scenario. With resume the cells
+// an interrupted run finished after since are kept; otherwise, or if
+// nothing was, the journal starts empty.
+func openCellJournal(dir, scenario string, resume bool, since time.Time) *cellJournal {
+	j := &cellJournal{path: journalFile(dir, scenario), done: map[string]journalEntry{}}
+	if !resume {
+		os.Remove(j.path)
+		return j
+	}
+	f, err := os.Open(j.path)
+	if err != nil {
+		return j
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var e journalEntry
+		// A line cut short by the crash is the cell that was being written
+		if json.Unmarshal(scanner.Bytes(), &e) != nil || e.At.Before(since) {
+			continue
+		}
+		j.done[journalKey(e.Pass, e.Engine, e.Category)] = e
+	}
+	if len(j.done) > 0 {
+		infof("⏭️  Resuming %s: %d engine/category cell(s) finished before the interruption", scenario, len(j.done))
+	}
+	return j
+}
+
+// nextPass starts the journal's next runWorkload call.
+func (j *cellJournal) nextPass() int {
+	if j == nil {
+		return 0
+	}
+	j.pass++
+	return j.pass
+}
+
+// finished returns the journaled results of a cell.
+func (j *cellJournal) finished(pass int, engine, category string) ([]manifest.QueryResult, bool) {
+	if j == nil {
+		return nil, false
+	}
+	e, ok := j.done[journalKey(pass, engine, category)]
+	return e.Results, ok
+}
+
+// record appends a finished cell, synced so it survives a crash right after.
+func (j *cellJournal) record(pass int, engine, category string, results []manifest.QueryResult) {
+	if j == nil {
+		return
+	}
+	data, err := json.Marshal(journalEntry{pass, engine, category, time.Now().UTC(), results})
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err == nil {
+			if _, err = f.Write(append(data, '\n')); err == nil {
+				err = f.Sync()
+			}
+			f.Close()
+		}
+	}
+	if err != nil {
+		warnf("Warning: Could not journal %s/%s: %v", engine, category, err)
+	}
+}
+
+// finish removes the journal of a scenario that completed; its results file
+// now holds everything.
+func (j *cellJournal) finish() {
+	if j != nil {
+		os.Remove(j.path)
+	}
+}
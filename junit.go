@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// junitPath is where -junit writes the assertion results
+	junitPath = ""
+	// maxRegression fails results whose mean is this many percent slower
+	// than the -compare-to baseline; 0 disables the check.
+	maxRegression = 0.0
+	// rowParity fails queries whose engines return different row counts
+	rowParity = false
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func (s *junitSuite) add(class, name, failure, detail string) {
+	c := junitCase{Name: name, Classname: class}
+	if failure != "" {
+		c.Failure = &junitFailure{Message: failure, Body: detail}
+		s.Failures++
+	}
+	s.Cases = append(s.Cases, c)
+	s.Tests++
+}
+
+// assertResults checks the stored read-only results against the configured
+// assertions: plan expectations from the workload, the -max-regression limit
+// against the -compare-to baseline, -row-parity between engines and, for
+// smoke runs, the fixture corpus checks.
+func assertResults(st *runState) (junitSuites, error) {
+	var out junitSuites
+	f, err := loadResults(dataDir, "read-only")
+	if err != nil {
+		return out, err
+	}
+	results, err := f.Queries()
+	if err != nil {
+		return out, err
+	}
+	caseName := func(r manifest.QueryResult) string {
+		return fmt.Sprintf("%s %s [%s]", r.Query, argsLabel(r.Args), r.Engine)
+	}
+
+	if w, err := st.loadWorkload(); err == nil {
+		expected := map[string]bool{}
+		for _, q := range w.Queries {
+			for engine := range q.ExpectPlan {
+				expected[q.Name+"/"+engine] = true
+			}
+		}
+		suite := junitSuite{Name: "plan"}
+		for _, r := range results {
+			if !expected[r.Query+"/"+r.Engine] {
+				continue
+			}
+			failure := ""
+			if len(r.PlanProblems) > 0 {
+				failure = "plan does not match expect_plan"
+			}
+			suite.add("plan."+r.Engine, caseName(r), failure, strings.Join(r.PlanProblems, "\n"))
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+
+	if maxRegression > 0 {
+		if compareTo == "" {
+			return out, fmt.Errorf("-max-regression needs -compare-to")
+		}
+		path, err := baselinePath(dataDir, compareTo)
+		if err != nil {
+			return out, err
+		}
+		base, err := loadResults(path, "read-only")
+		if err != nil {
+			return out, fmt.Errorf("baseline %s: %v", compareTo, err)
+		}
+		baseResults, err := base.Queries()
+		if err != nil {
+			return out, err
+		}
+		suite := junitSuite{Name: "regression"}
+		for _, d := range diffQueries(baseResults, results) {
+			name := fmt.Sprintf("%s %s [%s]", d.Query, d.Args, d.Engine)
+			change, ok := d.Change()
+			switch {
+			case !ok:
+				suite.add("regression."+d.Engine, name, "no comparable result", "missing or errored in the baseline or this run")
+			case change*100 > maxRegression:
+				suite.add("regression."+d.Engine, name, fmt.Sprintf("%+.1f%% slower than baseline %s (limit %.1f%%)", change*100, compareTo, maxRegression),
+					fmt.Sprintf("baseline mean %s, current mean %s", formatDuration(d.Base), formatDuration(d.Current)))
+			default:
+				suite.add("regression."+d.Engine, name, "", "")
+			}
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+
+	if rowParity {
+		suite := junitSuite{Name: "row-parity"}
+		rows := map[string][]manifest.QueryResult{}
+		var order []string
+		for _, r := range results {
+			key := r.Query + " " + argsLabel(r.Args)
+			if _, seen := rows[key]; !seen {
+				order = append(order, key)
+			}
+			rows[key] = append(rows[key], r)
+		}
+		for _, key := range order {
+			var counts []string
+			same := true
+			for _, r := range rows[key] {
+				if r.Error != "" {
+					counts = append(counts, r.Engine+"=error")
+					same = false
+					continue
+				}
+				counts = append(counts, fmt.Sprintf("%s=%d", r.Engine, r.Rows))
+				same = same && r.Rows == rows[key][0].Rows
+			}
+			failure := ""
+			if !same {
+				failure = "engines returned different row counts"
+			}
+			suite.add("row-parity", key, failure, strings.Join(counts, ", "))
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+
+	if st.Smoke {
+		suite, err := verifySmoke(st, results)
+		if err != nil {
+			return out, err
+		}
+		out.Suites = append(out.Suites, suite)
+	}
+
+	for _, s := range out.Suites {
+		out.Tests += s.Tests
+		out.Failures += s.Failures
+	}
+	return out, nil
+}
+
+func writeJUnit(path string, suites junitSuites) error {
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0o644)
+}
+
+// checkAssertions writes the JUnit report if asked for and fails the run
+// when any configured assertion failed.
+func checkAssertions(st *runState) error {
+	if junitPath == "" && maxRegression == 0 && !rowParity && !st.Smoke {
+		return nil
+	}
+	suites, err := assertResults(st)
+	if err != nil {
+		return err
+	}
+	if junitPath != "" {
+		if err := writeJUnit(junitPath, suites); err != nil {
+			return err
+		}
+		infof("📌 Wrote %d assertion(s) to %s", suites.Tests, junitPath)
+	}
+	for _, s := range suites.Suites {
+		for _, c := range s.Cases {
+			if c.Failure != nil {
+				errorf("❌ %s: %s: %s", s.Name, c.Name, c.Failure.Message)
+			}
+		}
+	}
+	if suites.Failures > 0 {
+		return fmt.Errorf("%d of %d assertion(s) failed", suites.Failures, suites.Tests)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// logLevel orders how much a run prints.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+var (
+	// logLevelName is -log-level: debug adds per-batch progress, info is
+	// the usual output, warn keeps only problems and the final numbers,
+	// error only failures and the final numbers
+	logLevelName = "info"
+	// quiet is -quiet, -log-level error for CI
+	quiet        = false
+	currentLevel = levelInfo
+	// progressOut takes the tables scenarios print as they finish: stdout
+	// up to info, discarded above it, where the report has the numbers
+	progressOut io.Writer = os.Stdout
+)
+
+// applyLogLevel sets the level from -log-level and -quiet. Progress goes
+// through the log functions below; it is set before anything runs, so the
+// level is never changed while goroutines print.
+func applyLogLevel() error {
+	level, ok := logLevels[logLevelName]
+	if !ok {
+		return fmt.Errorf("unknown -log-level %q (want debug, info, warn or error)", logLevelName)
+	}
+	if quiet {
+		level = levelError
+	}
+	currentLevel = level
+	progressOut = os.Stdout
+	if level > levelInfo {
+		progressOut = io.Discard
+	}
+	return nil
+}
+
+func logAt(level logLevel, format string, args ...interface{}) {
+	if level >= currentLevel {
+		log.Printf(format, args...)
+	}
+}
+
+// debugf logs detail only -log-level debug shows.
+func debugf(format string, args ...interface{}) { logAt(levelDebug, format, args...) }
+
+// infof logs progress.
+func infof(format string, args ...interface{}) { logAt(levelInfo, format, args...) }
+
+// warnf logs a problem the run carries on past.
+func warnf(format string, args ...interface{}) { logAt(levelWarn, format, args...) }
+
+// errorf logs a failed operation.
+func errorf(format string, args ...interface{}) { logAt(levelError, format, args...) }
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+const usage = `Usage: go run . [command] [flags]
+
+Commands:
+  (none)   download the dataset, load it and build indexes
+           (schema + ingest + index)
+  setup-schema
+           create the empty products table on every target
+  ingest   download the dataset and load it, setting the table up again
+           unless setup-schema just created it
+  create-indexes
+           make the table logged, build the indexes and analyze
+  run      run the scenario graph: %s
+           (optional, with -scenario: %s)
+  bench    run the read-only scenario against the loaded data
+  smoke    load a small generated corpus and run a short workload end to end,
+           to check an environment or the tool itself
+  append   add -append-rows products to the loaded corpus and run a short
+           workload, extending the latency-by-corpus-size series
+  baseline save|list|delete [NAME]
+           keep the current results as a named baseline to compare against
+  publish  replay dump lines onto a NATS subject for the stream scenario
+  report   summarize the stored results
+  clean    drop the benchmark's tables and delete the results and state
+  slowest  list the slowest individual executions of the stored results
+  chart    render SVG charts of the stored results
+  compare -against community
+           position the stored results among the published community
+           results for the same dataset and scale
+  drift OLD [NEW]
+           compare the ranked results of two snapshot runs kept as baselines
+  capture-issue
+           package each ParadeDB error or result divergence of the stored
+           results, or a named query, into an issue text and a repro script;
+           -reduce shrinks each to the fewest rows and search terms first
+  verify   check that every engine loaded the same source lines, and print
+           the dump lines of any that differ
+  analyze  build the corpus's term dictionary (document and collection
+           frequencies), cache and export it, and label workload
+           arguments by selectivity
+  validate-config
+           check every target before a long run: connection, server
+           version, pg_search or pg_trgm, CREATE TABLE permission and
+           disk space, printed as a pass/fail table
+  workload validate [FILE]
+           check a workload against every target: each statement parses,
+           binds its arguments and runs once with LIMIT 1, and the indexes
+           its plan expectations name exist
+  upgrade-results FILE...
+           rewrite results files in the current schema version
+
+Run "go run . <command> -h" for the flags of a command.
+`
+
+// scenarioNames lists the scenarios "run" executes, or with optional set,
+// the ones it leaves out.
+func scenarioNames(optional bool) []string {
+	var names []string
+	for _, s := range scenarios {
+		if s.Optional == optional {
+			names = append(names, s.Name)
+		}
+	}
+	return names
+}
+
+func main() {
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "":
+		runCommand("setup", args, []string{"schema", "ingest", "index"})
+	case "setup-schema":
+		runCommand("setup-schema", args, []string{"schema"})
+	case "ingest":
+		runCommand("ingest", args, []string{"ingest"})
+	case "create-indexes":
+		runCommand("create-indexes", args, []string{"index"})
+	case "report":
+		runCommand("report", args, []string{"report"})
+	case "run":
+		runCommand("run", args, scenarioNames(false))
+	case "bench":
+		runCommand("bench", args, []string{"read-only"})
+	case "smoke":
+		runCommand("smoke", append(append([]string{}, smokeDefaults...), args...), smokeScenarios)
+	case "append":
+		runCommand("append", append(append([]string{}, appendDefaults...), args...), []string{"append"})
+	case "clean":
+		cleanCommand(args)
+	case "baseline":
+		baselineCommand(args)
+	case "publish":
+		publishCommand(args)
+	case "slowest":
+		slowestCommand(args)
+	case "chart":
+		chartCommand(args)
+	case "compare":
+		compareCommand(args)
+	case "drift":
+		driftCommand(args)
+	case "capture-issue":
+		captureIssueCommand(args)
+	case "verify":
+		verifyCommand(args)
+	case "analyze":
+		analyzeCommand(args)
+	case "validate-config":
+		validateConfigCommand(args)
+	case "workload":
+		workloadCommand(args)
+	case "upgrade-results":
+		upgradeResults(args)
+	default:
+		fmt.Fprintf(os.Stderr, usage, strings.Join(scenarioNames(false), " → "), strings.Join(scenarioNames(true), ", "))
+		os.Exit(2)
+	}
+}
+
+// addTargetFlags registers the flags that point the targets elsewhere.
+func addTargetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&vanillaConfig.Host, "vanilla-host", vanillaConfig.Host, "vanilla PostgreSQL host")
+	fs.IntVar(&vanillaConfig.Port, "vanilla-port", vanillaConfig.Port, "vanilla PostgreSQL port")
+	fs.StringVar(&paradeConfig.Host, "parade-host", paradeConfig.Host, "ParadeDB host")
+	fs.IntVar(&paradeConfig.Port, "parade-port", paradeConfig.Port, "ParadeDB port")
+	fs.StringVar(&vanillaConfig.DSN, "vanilla-dsn", vanillaConfig.DSN, "vanilla PostgreSQL connection string (postgres:// URI or key=value), with any libpq options")
+	fs.StringVar(&paradeConfig.DSN, "parade-dsn", paradeConfig.DSN, "ParadeDB connection string (postgres:// URI or key=value), with any libpq options")
+	fs.StringVar(&vanillaConfig.PasswordFile, "vanilla-password-file", vanillaConfig.PasswordFile, "file holding only the vanilla PostgreSQL password, such as a mounted secret")
+	fs.StringVar(&paradeConfig.PasswordFile, "parade-password-file", paradeConfig.PasswordFile, "file holding only the ParadeDB password, such as a mounted secret")
+	fs.BoolVar(&promptPassword, "password-prompt", promptPassword, "ask on the terminal for the password of each target no flag, variable, config or ~/.pgpass gives one")
+	fs.StringVar(&productsTable, "table", productsTable, "table the corpus is loaded into and searched (overrides table in -config)")
+	fs.StringVar(&dbSchema, "schema", dbSchema, "schema the tables live in, created if missing (default: the search_path, usually public)")
+	fs.StringVar(&targetNames, "targets", targetNames, "targets to run against, comma-separated: vanilla, parade and targets named in -config")
+	addTLSFlags(fs)
+}
+
+// runCommand parses the shared flags and runs the given scenarios, or the
+// one picked with -scenario.
+func runCommand(name string, args []string, names []string) {
+	st := &runState{}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	fs.StringVar(&configPath, "config", configPath, "YAML or TOML (.toml) run configuration file")
+	fs.DurationVar(&lockWait, "lock-wait", lockWait, "how long to wait for another benchmark run holding a target to finish before giving up (default: give up at once)")
+	fs.StringVar(&dbVolume, "db-volume", dbVolume, "local path on the volume backing the databases, checked for free space before loading")
+	fs.BoolVar(&skipDiskChecks, "skip-disk-check", skipDiskChecks, "skip the free disk space preflight checks")
+	fs.IntVar(&sampleSizeFlag, "sample-size", sampleSizeFlag, "products to load from the dump, 0 for all (overrides sample_size in -config)")
+	fs.StringVar(&samplePreset, "preset", samplePreset, "sample size preset: small (10,000 products), medium (200,000) or full (all)")
+	fs.IntVar(&batchSizeFlag, "batch-size", batchSizeFlag, "rows per insert transaction (default: batch_size in -config, or 5000)")
+	fs.IntVar(&workersFlag, "workers", workersFlag, "concurrent insert workers per target (default: workers in -config, or 20)")
+	fs.StringVar(&clientDriver, "driver", clientDriver, "Go driver to connect with: pq (lib/pq, text COPY) or pgx (jackc/pgx, binary COPY and pipelined small batches)")
+	fs.BoolVar(&dryRun, "dry-run", dryRun, "check the targets, disk space, DDL and the first parsed records of the dump, then exit without writing anything")
+	fs.IntVar(&dryRunRecords, "dry-run-records", dryRunRecords, "parsed records -dry-run prints")
+	fs.BoolVar(&autoTune, "auto-tune", autoTune, "pick -batch-size and -workers, where not given, from the targets' free connections and round-trip time")
+	fs.StringVar(&bm25Syntax, "bm25-syntax", bm25Syntax, "force a BM25 index DDL template (typed, options, procedure) instead of detecting it from the pg_search version")
+	fs.BoolVar(&skipCompatCheck, "skip-compat-check", skipCompatCheck, "skip the pg_search version and configuration preflight")
+	fs.DurationVar(&healthWait, "health-wait", healthWait, "how long a measured scenario waits for an unhealthy target (lost connection, invalid indexes, lagging replica, autovacuum on the table) before flagging the run")
+	fs.BoolVar(&healthFail, "health-fail", healthFail, "stop the run when a target is still unhealthy after -health-wait, instead of flagging it")
+	fs.StringVar(&clientCPUSet, "client-cpuset", clientCPUSet, "pin the benchmark client to these CPUs (Linux, e.g. 0-1)")
+	fs.StringVar(&vanillaCPUSet, "vanilla-cpuset", vanillaCPUSet, "pin the vanilla container to these CPUs via docker update (e.g. 2-5)")
+	fs.StringVar(&paradeCPUSet, "parade-cpuset", paradeCPUSet, "pin the ParadeDB container to these CPUs via docker update (e.g. 6-9)")
+	fs.BoolVar(&parallelTargets, "parallel", parallelTargets, "load and index all targets at once instead of one after another")
+	fs.StringVar(&datasetName, "dataset", datasetName, "corpus the setup phases load: "+strings.Join(datasetNames(), ", "))
+	fs.BoolVar(&streamDataset, "stream", streamDataset, "load the dataset straight from its download URL, decompressing and parsing it as it arrives, instead of storing the download first")
+	fs.StringVar(&datasetFile, "dataset-file", datasetFile, "corpus file to load instead of downloading the dataset's; required by -dataset custom (JSON lines with the SNAP dump's keys)")
+	fs.StringVar(&cacheDir, "cache-dir", cacheDir, "where downloaded datasets and their parsed records are kept across runs; empty to keep the download in -data-dir and parse every load")
+	fs.StringVar(&st.WorkloadPath, "workload", "", "workload file to run (default: the dataset's workload)")
+	fs.IntVar(&st.Iterations, "iterations", 10, "timed runs per query and engine")
+	fs.IntVar(&st.Warmup, "warmup", 2, "untimed runs per query and engine before timing")
+	fs.DurationVar(&st.Duration, "duration", 0, "time each query and engine for this long instead of -iterations runs (e.g. 30s)")
+	fs.Float64Var(&st.CIWidth, "ci-width", 0, "keep timing each query until the p95's 95% confidence interval is narrower than this fraction of it (e.g. 0.05); -iterations is then the minimum")
+	fs.DurationVar(&st.MaxTime, "max-time", 2*time.Minute, "with -ci-width, stop timing a query after this long even if it has not converged")
+	fs.IntVar(&st.WriteRate, "write-rate", 200, "rows/sec inserted into each engine during the mixed and pagination scenarios")
+	fs.IntVar(&st.ChurnRows, "churn-rows", 10000, "rows inserted, updated and deleted by the churn scenario")
+	fs.Float64Var(&st.DeletedFraction, "deleted-fraction", 0.1, "fraction of rows the soft-delete scenario marks deleted")
+	fs.StringVar(&softDeleteWorkload, "soft-delete-workload", softDeleteWorkload, "workload the soft-delete scenario times against "+SoftDeleteTable)
+	fs.StringVar(&matviewWorkload, "matview-workload", matviewWorkload, "workload the matview scenario times, with vanilla-mv statements against "+MatviewName)
+	fs.StringVar(&natsURL, "nats-url", natsURL, "NATS server the stream scenario consumes from")
+	fs.StringVar(&natsSubject, "nats-subject", natsSubject, "subject the stream scenario consumes product documents from")
+	fs.DurationVar(&streamDuration, "stream-duration", streamDuration, "how long the stream scenario consumes")
+	fs.StringVar(&lagWriteRates, "lag-write-rates", lagWriteRates, "background insert rates (rows/sec) the lag scenario probes under, comma-separated")
+	fs.IntVar(&lagProbes, "lag-probes", lagProbes, "sentinel documents the lag scenario inserts per write rate")
+	fs.DurationVar(&lagInterval, "lag-interval", lagInterval, "pause between lag scenario sentinels")
+	fs.DurationVar(&lagTimeout, "lag-timeout", lagTimeout, "give up on a sentinel not findable after this long")
+	fs.StringVar(&trafficMix, "mix", trafficMix, "operation weights for the mix scenario: search, insert, update and delete (deletes only touch rows the benchmark wrote)")
+	fs.IntVar(&mixWorkers, "mix-workers", mixWorkers, "concurrent clients per engine in the mix scenario")
+	fs.DurationVar(&mixDuration, "mix-duration", mixDuration, "how long the mix scenario drives each engine")
+	fs.StringVar(&shardDSNs, "shards", shardDSNs, "comma-separated connection strings of the vanilla shards for the sharded scenario")
+	fs.StringVar(&citusDSN, "citus", citusDSN, "connection string of the Citus coordinator for the citus scenario")
+	fs.IntVar(&partitionYears, "partition-years", partitionYears, "years of synthetic created_at values the partition scenario spreads rows over")
+	fs.StringVar(&partitionWorkload, "partition-workload", partitionWorkload, "workload the partition scenario times against "+PartitionedTable)
+	fs.StringVar(&sweepLimits, "limits", sweepLimits, "LIMITs the limit-sweep scenario times every ranked query at")
+	fs.IntVar(&selectivityTerms, "selectivity-terms", selectivityTerms, "selectivity scenario: terms searched per bucket (rare, medium, common)")
+	fs.StringVar(&selectivityCategory, "selectivity-category", selectivityCategory, "selectivity scenario: workload category whose single-argument queries search the terms")
+	fs.IntVar(&paginationRounds, "pagination-rounds", paginationRounds, "times the pagination scenario pages each ranked query per engine")
+	fs.StringVar(&statisticsTargets, "statistics-targets", statisticsTargets, "default_statistics_target values the statistics scenario analyzes vanilla with")
+	fs.BoolVar(&extendedStats, "extended-stats", extendedStats, "statistics scenario: also measure each target with extended statistics on brand and categories")
+	fs.StringVar(&statisticsWorkload, "statistics-workload", statisticsWorkload, "search+filter workload the statistics scenario times")
+	fs.StringVar(&coexistExtensions, "extensions", coexistExtensions, "extensions scenario: comma-separated extensions to install next to pg_search on the ParadeDB target")
+	fs.StringVar(&restrictedRole, "restricted-role", restrictedRole, "roles scenario: login role to create with SELECT on products only and search as (dropped afterwards)")
+	fs.DurationVar(&cancelTimeout, "cancel-timeout", cancelTimeout, "cancel scenario: statement_timeout the expensive queries run under")
+	fs.IntVar(&cancelRounds, "cancel-rounds", cancelRounds, "cancel scenario: cancellations per expensive query and engine")
+	fs.StringVar(&cancelWorkload, "cancel-workload", cancelWorkload, "deliberately expensive workload the cancel scenario cancels")
+	fs.StringVar(&memoryLimits, "memory-limits", memoryLimits, "memory-pressure scenario: container memory limits to step through, largest first (shared_buffers is a quarter of each)")
+	fs.IntVar(&diskFullRows, "disk-full-rows", diskFullRows, "disk-full scenario: synthetic products to load before building the indexes")
+	fs.Float64Var(&diskFullHeadroom, "disk-full-headroom", diskFullHeadroom, "disk-full scenario: free space left for the index build, as a fraction of the table size")
+	fs.IntVar(&restartRounds, "restart-rounds", restartRounds, "restart scenario: restarts per target")
+	fs.Float64Var(&retentionFraction, "retention-fraction", retentionFraction, "fraction of rows, the oldest by created_at, the retention scenario deletes")
+	fs.IntVar(&retentionDays, "retention-days", retentionDays, "days of synthetic created_at values the retention scenario spreads rows over")
+	fs.IntVar(&neighborClients, "neighbor-clients", neighborClients, "noisy-neighbor scenario: busy connections in the neighbor database of every target")
+	fs.IntVar(&neighborRows, "neighbor-rows", neighborRows, "noisy-neighbor scenario: rows of the table the neighbor scans and updates")
+	fs.IntVar(&oltpScale, "oltp-scale", oltpScale, "oltp scenario: TPC-B scale factor, as pgbench -s (100,000 accounts each)")
+	fs.IntVar(&oltpClients, "oltp-clients", oltpClients, "oltp scenario: connections running TPC-B transactions on each target")
+	fs.DurationVar(&oltpDuration, "oltp-duration", oltpDuration, "oltp scenario: how long TPC-B runs alone on each target")
+	fs.IntVar(&appendRows, "append-rows", appendRows, "products the append scenario adds to the loaded corpus per run")
+	fs.StringVar(&appendSource, "append-source", appendSource, "where appended products come from: dump (lines not loaded yet) or synthetic")
+	fs.StringVar(&marcLanguageList, "marc-languages", marcLanguageList, "languages of the Multilingual Amazon Reviews Corpus the multilingual scenario loads")
+	fs.IntVar(&marcRows, "marc-rows", marcRows, "reviews the multilingual scenario loads per language")
+	fs.StringVar(&marcURL, "marc-url", marcURL, "download URL of a language's MARC training split, %s being the language code")
+	fs.StringVar(&marcWorkload, "marc-workload", marcWorkload, "workload the multilingual scenario times against the reviews_<language> tables")
+	fs.StringVar(&codeDump, "code-dump", codeDump, "StackExchange Posts.xml or Hacker News items .jsonl (optionally .gz) the code scenario loads")
+	fs.IntVar(&codeRows, "code-rows", codeRows, "posts the code scenario loads from -code-dump")
+	fs.StringVar(&codeWorkload, "code-workload", codeWorkload, "workload the code scenario times against "+CodeTable)
+	fs.IntVar(&logRows, "log-rows", logRows, "log lines the logs scenario generates, or reads from -log-file at most")
+	fs.StringVar(&logFile, "log-file", logFile, "access log in Combined Log Format (optionally .gz) for the logs scenario to load instead of synthetic lines")
+	fs.StringVar(&logWorkload, "log-workload", logWorkload, "workload the logs scenario times against "+LogTable)
+	fs.StringVar(&emailDump, "email-dump", emailDump, "Enron maildir, its .tar.gz or emails.csv the email scenario loads")
+	fs.IntVar(&emailRows, "email-rows", emailRows, "messages the email scenario loads from -email-dump")
+	fs.StringVar(&emailWorkload, "email-workload", emailWorkload, "workload the email scenario times against "+EmailTable)
+	fs.BoolVar(&sampleMemory, "sample-memory", sampleMemory, "after timing each query, measure how much backend memory it holds (pg_backend_memory_contexts, PostgreSQL 14+)")
+	fs.BoolVar(&sampleRowEstimates, "row-estimates", sampleRowEstimates, "after timing each query, run it under EXPLAIN ANALYZE and record the planner's row estimates for its text predicates")
+	fs.BoolVar(&autoExplain, "auto-explain", autoExplain, "enable auto_explain on the targets while timing (ALTER SYSTEM, superuser) and attach the slowest 1% of plans to the results")
+	fs.DurationVar(&autoExplainMin, "auto-explain-min", autoExplainMin, "auto_explain.log_min_duration for -auto-explain")
+	fs.BoolVar(&captureServerLogs, "server-logs", captureServerLogs, "read the targets' server logs for the timed run and attach their errors and warnings to the queries that caused them")
+	fs.StringVar(&queryOrder, "order", queryOrder, "order of timed executions: blocked, serial (one seeded shuffle replayed per engine) or interleaved (each step on every engine)")
+	fs.Int64Var(&runSeed, "seed", runSeed, "seed of every random choice: -order shuffles, generated and churned rows, mix and lag picks; runs with the same seed send the same workload")
+	fs.StringVar(&baselineEngine, "baseline", baselineEngine, "engine the report compares the others against (default: first engine)")
+	fs.StringVar(&categoryWeights, "weights", categoryWeights, "category weights for the composite score, e.g. fulltext=40,fuzzy=40,exact=20 (default: workload weights)")
+	fs.StringVar(&compareTo, "compare-to", compareTo, "named baseline to diff the results against after the run")
+	fs.StringVar(&markdownPath, "markdown", markdownPath, "also write a GitHub-flavored Markdown summary of the results to this file (- for stdout)")
+	fs.StringVar(&markdownTemplate, "markdown-template", markdownTemplate, "Go text/template to render the -markdown summary with instead of the built-in one")
+	fs.BoolVar(&writeCharts, "charts", writeCharts, "render SVG charts of the results into the charts directory of -data-dir after the run")
+	fs.StringVar(&eventsPath, "events", eventsPath, "write progress as JSON lines (scenario_started, batch_committed, index_built, query_result, ...) to this file; - for stdout, which moves the human output to stderr")
+	fs.StringVar(&shareResultsURL, "share-results", shareResultsURL, "opt in to POSTing an anonymized summary of the read-only results (dataset, rounded row count, hardware class, category means) to this URL after the run; - prints it instead")
+	fs.StringVar(&junitPath, "junit", junitPath, "write plan, regression and row-parity assertions as JUnit XML to this file")
+	fs.Float64Var(&maxRegression, "max-regression", maxRegression, "fail when a query's mean is more than this many percent slower than the -compare-to baseline (0 = off)")
+	fs.BoolVar(&rowParity, "row-parity", rowParity, "fail when engines return different row counts for the same query and arguments")
+	fs.BoolVar(&fakeEngines, "fake", fakeEngines, "time in-memory fake engines with canned latencies instead of the databases, to try the runner and reports without Docker")
+	fs.StringVar(&logLevelName, "log-level", logLevelName, "debug (adds per-batch load progress), info, warn or error; above info only problems and the final summary are printed")
+	fs.BoolVar(&quiet, "quiet", quiet, "print only failures and the final numbers, for CI (-log-level error)")
+	fs.BoolVar(&noColor, "no-color", noColor, "disable colored output")
+	fs.BoolVar(&plainOutput, "plain", plainOutput, "ASCII tables without color, for CI logs")
+	if name == "smoke" {
+		fs.BoolVar(&ephemeralTargets, "ephemeral", ephemeralTargets, "start throwaway vanilla and ParadeDB containers on random ports instead of using the running targets")
+	}
+	only := fs.String("scenario", "", "run only this scenario against the existing state")
+	resume := fs.Bool("resume", false, "skip scenarios already completed against the current data, and continue an interrupted load from the rows it committed")
+	force := fs.Bool("force", false, "run scenarios even if their dependencies have not completed")
+	fs.Parse(args)
+	runFlags, runCommandName = fs, name
+
+	if st.CIWidth > 0 && st.Duration > 0 {
+		log.Fatalf("-ci-width and -duration are different sampling modes, pick one")
+	}
+	if st.CIWidth > 0 && st.MaxTime <= 0 {
+		log.Fatalf("-ci-width needs a positive -max-time")
+	}
+	if err := checkQueryOrder(queryOrder, st.sampling()); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	var err error
+	if runConfig, err = loadRunConfig(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := configureTargets(fs, runConfig); err != nil {
+		log.Fatalf("%v", err)
+	}
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if err := applySampleFlags(setFlags); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := applyLoadFlags(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := checkClientDriver(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	// The smoke corpus is written in the layout of the SNAP dump, and is
+	// too small to be worth caching
+	if name == "smoke" {
+		datasetName, datasetFile, cacheDir = "amazon", "", ""
+	}
+	if err := selectDataset(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if st.WorkloadPath == "" {
+		st.WorkloadPath = activeDataset.Workload()
+	}
+	if *only != "" {
+		names = []string{*only}
+	}
+	if eventsPath == "-" && markdownPath == "-" {
+		log.Fatalf("-events - and -markdown - both want stdout, send one of them to a file")
+	}
+	if shareResultsURL == "-" && (eventsPath == "-" || markdownPath == "-") {
+		log.Fatalf("-share-results - wants stdout too, send -events or -markdown to a file")
+	}
+	if err := openEvents(eventsPath); err != nil {
+		log.Fatalf("Failed to open -events: %v", err)
+	}
+	defer closeEvents()
+	if err := applyLogLevel(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	// Without progress, the report is what a run that times reads prints
+	if currentLevel > levelInfo && slices.Contains(names, "read-only") && !slices.Contains(names, "report") {
+		names = append(names, "report")
+	}
+	emitEvent("run_started", map[string]interface{}{"command": name, "scenarios": names, "config": effectiveConfig()})
+	if fakeEngines {
+		for _, n := range names {
+			if (n == "schema" || n == "ingest") && !dryRun {
+				log.Fatalf("-fake engines have nothing to load, use it with bench or -scenario")
+			}
+		}
+		if autoExplain {
+			log.Fatalf("-auto-explain needs real targets, not -fake")
+		}
+		if captureServerLogs {
+			log.Fatalf("-server-logs needs real targets, not -fake")
+		}
+	}
+	// Keep smoke state and results away from the real ones
+	if name == "smoke" {
+		dataDir = filepath.Join(dataDir, "smoke")
+		st.Smoke = true
+	}
+
+	if dryRun {
+		st.MetadataPath = datasetPath(dataDir)
+		st.Targets = defaultTargets()
+		err := runDryRun(st)
+		st.close()
+		if err != nil {
+			log.Fatalf("Dry run failed: %v", err)
+		}
+		return
+	}
+
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	st.MetadataPath = datasetPath(dataDir)
+	if streamDataset {
+		if st.MetadataPath, err = streamedDatasetPath(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	ingesting := false
+	for _, n := range names {
+		ingesting = ingesting || n == "ingest"
+	}
+	if ingesting && name == "smoke" {
+		st.MetadataPath = filepath.Join(dataDir, SmokeCorpusFile)
+		infof("🧪 Writing %d generated products to %s...", smokeCorpusRows, st.MetadataPath)
+		if err := writeSmokeCorpus(st.MetadataPath); err != nil {
+			log.Fatalf("Failed to write smoke corpus: %v", err)
+		}
+	} else if ingesting {
+		// Download file if needed; -dataset-file is already there, and
+		// -stream reads the download as it loads
+		if datasetFile == "" && !streamDataset {
+			if err := activeDataset.Download(st.MetadataPath); err != nil {
+				log.Fatalf("Failed to download the %s dataset: %v", datasetName, err)
+			}
+		}
+
+		infof("Make sure Docker containers are running: docker-compose up -d")
+		time.Sleep(3 * time.Second)
+	}
+	if fp, err := activeDataset.Fingerprint(st.MetadataPath); err == nil {
+		datasetFingerprint = fp
+		if _, err := os.Stat(termDictionaryPath(fp)); err == nil && cacheDir != "" {
+			termDictionaryFile = termDictionaryPath(fp)
+		}
+	}
+
+	if ephemeralTargets {
+		if st.stopTargets, err = startEphemeralTargets(); err != nil {
+			log.Fatalf("Failed to start ephemeral targets: %v", err)
+		}
+	}
+	st.Targets = defaultTargets()
+	if streamDataset && ingesting && len(st.Targets) > 1 {
+		// Each target would download on its own, and nothing guarantees
+		// two downloads of a URL send the same bytes
+		st.close()
+		log.Fatalf("-stream loads one target, pick it with -targets, or load %d targets from a download", len(st.Targets))
+	}
+	if err := writeEffectiveConfig(dataDir); err != nil {
+		warnf("Warning: Could not write %s: %v", EffectiveConfigFile, err)
+	}
+
+	warnParallelContention(st.Targets)
+	warnSharedTables(st.Targets)
+	if err := applyCPUPinning(st.Targets); err != nil {
+		st.close()
+		log.Fatalf("CPU pinning failed: %v", err)
+	}
+
+	defer st.close()
+	start := time.Now()
+	st.Resume = *resume
+	err = runScenarios(st, names, *resume, *force)
+	printDegradations()
+	if err != nil {
+		st.close()
+		log.Fatalf("%v", err)
+	}
+	if compareTo != "" {
+		if err := compareToBaseline(dataDir, compareTo, st.Targets); err != nil {
+			st.close()
+			log.Fatalf("Failed to compare to baseline: %v", err)
+		}
+	}
+	if markdownPath != "" {
+		if err := writeMarkdownFile(markdownPath, dataDir, st.Targets, st.workloadClaims()); err != nil {
+			st.close()
+			log.Fatalf("Failed to write Markdown summary: %v", err)
+		}
+	}
+	if shareResultsURL != "" {
+		shareResults(dataDir, st.Targets)
+	}
+	if writeCharts {
+		written, err := renderCharts(dataDir, st.Targets)
+		if err != nil {
+			st.close()
+			log.Fatalf("Failed to render charts: %v", err)
+		}
+		reportCharts(written)
+	}
+	if err := checkAssertions(st); err != nil {
+		st.close()
+		log.Fatalf("Assertions: %v", err)
+	}
+	emitEvent("run_finished", map[string]interface{}{"command": name, "duration_ns": time.Since(start)})
+
+	if name == "smoke" {
+		checkSmokeBudget(time.Since(start))
+	}
+
+	if ingesting && name == "setup" {
+		infof("✅ Real Amazon dataset setup complete!")
+		infof("🚀 Run: npm run dev")
+		infof("🔍 Try searching real products like: \"apple\", \"samsung phone\", \"book\", \"camera\"")
+	}
+}
+
+// upgradeResults converts results files written by older versions in place.
+func upgradeResults(paths []string) {
+	for _, path := range paths {
+		f, err := manifest.Read(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if err := manifest.Write(path, f); err != nil {
+			log.Fatalf("Failed to write %s: %v", path, err)
+		}
+		fmt.Printf("✅ %s: schema version %d\n", path, f.SchemaVersion)
+	}
+}
@@ -0,0 +1,307 @@
+// Package manifest defines the on-disk format of benchmark result files so
+// downstream tooling can parse them without depending on the benchmark
+// binary. Files carry a schema_version; Read upgrades older versions
+// transparently and the JSON Schema for the current version is embedded as
+// Schema.
+package manifest
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// SchemaVersion is the version written by this package.
+//
+//	1: {metadata, results}, no version field
+//	2: adds schema_version and kind
+const SchemaVersion = 2
+
+// Result kinds, telling readers how to decode File.Results.
+const (
+	KindQueries = "queries"
+	KindChurn   = "churn"
+)
+
+//go:embed schema/v2.json
+var Schema []byte
+
+// File is one results file, written per measured scenario.
+type File struct {
+	SchemaVersion int             `json:"schema_version"`
+	Kind          string          `json:"kind"`
+	Metadata      Metadata        `json:"metadata"`
+	Results       json.RawMessage `json:"results"`
+}
+
+// Metadata describes how the results were produced.
+type Metadata struct {
+	Scenario  string    `json:"scenario"`
+	SavedAt   time.Time `json:"saved_at"`
+	Execution string    `json:"execution"`
+	// Concurrent names the load that ran on the targets while they were
+	// timed, such as background writes
+	Concurrent string `json:"concurrent,omitempty"`
+	// SharedHost lists targets that ran on the same machine
+	SharedHost []string `json:"shared_host,omitempty"`
+	// CPUSets maps client/vanilla/parade to the CPUs they were pinned to
+	CPUSets map[string]string `json:"cpusets,omitempty"`
+	// QueryOrder is blocked, serial or interleaved; Seed is the -seed every
+	// random choice of the run derived from, the shuffle of the latter two
+	// included.
+	QueryOrder string `json:"query_order,omitempty"`
+	Seed       int64  `json:"seed,omitempty"`
+	// RunID is part of the application_name of the run's connections
+	RunID string `json:"run_id,omitempty"`
+	// Fake marks results timed against in-memory fake engines
+	Fake bool `json:"fake,omitempty"`
+	// Versions maps each engine to its server version and, for ParadeDB,
+	// the pg_search version
+	Versions map[string]string `json:"versions,omitempty"`
+	// Dataset is the corpus the targets were loaded with and
+	// DatasetFingerprint the version of it the run found on disk
+	Dataset            string `json:"dataset,omitempty"`
+	DatasetFingerprint string `json:"dataset_fingerprint,omitempty"`
+	// TermDictionary is the analyze command's term dictionary of that
+	// version, when one was built
+	TermDictionary string `json:"term_dictionary,omitempty"`
+	// Config is the configuration the run used, so the file describes how
+	// it was produced on its own
+	Config *EffectiveConfig `json:"config,omitempty"`
+}
+
+// EffectiveConfig is a run's configuration after the flags, the -config
+// file and the environment were merged. Passwords are left out.
+type EffectiveConfig struct {
+	Command    string `json:"command"`
+	ConfigFile string `json:"config_file,omitempty"`
+	// Flags holds the final value of every flag of the command; Changed
+	// names the ones that differ from their defaults
+	Flags   map[string]string       `json:"flags"`
+	Changed []string                `json:"changed,omitempty"`
+	Targets map[string]TargetConfig `json:"targets"`
+	Load    LoadConfig              `json:"load"`
+}
+
+// TargetConfig is where one target ran and how it was connected to.
+type TargetConfig struct {
+	Engine          string `json:"engine"`
+	Host            string `json:"host"`
+	Port            int    `json:"port"`
+	Database        string `json:"database"`
+	User            string `json:"user"`
+	SSLMode         string `json:"sslmode,omitempty"`
+	MaxOpenConns    int    `json:"max_open_conns"`
+	MaxIdleConns    int    `json:"max_idle_conns"`
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	// Settings, Indexes and BM25Syntax are the target's own configuration
+	// and index strategy, when the config file gives one
+	Settings   map[string]string `json:"settings,omitempty"`
+	Indexes    []string          `json:"indexes,omitempty"`
+	BM25Syntax string            `json:"bm25_syntax,omitempty"`
+}
+
+// LoadConfig is what was loaded and how.
+type LoadConfig struct {
+	Dataset    string `json:"dataset"`
+	SampleSize int    `json:"sample_size"`
+	BatchSize  int    `json:"batch_size"`
+	Workers    int    `json:"workers"`
+	Table      string `json:"table"`
+	Schema     string `json:"schema,omitempty"`
+}
+
+// QueryResult is one (query, argument set, engine) cell of a run.
+type QueryResult struct {
+	Query        string        `json:"query"`
+	Category     string        `json:"category"`
+	Engine       string        `json:"engine"`
+	Args         []interface{} `json:"args"`
+	Rows         int           `json:"rows"`
+	Stats        LatencyStats  `json:"stats"`
+	PlanProblems []string      `json:"plan_problems,omitempty"`
+	// MaxBackendMemory is the most memory the backend held for the query
+	// when memory sampling was on.
+	MaxBackendMemory int64 `json:"max_backend_memory_bytes,omitempty"`
+	// Slowest are the slowest timed executions, slowest first
+	Slowest []Execution `json:"slowest,omitempty"`
+	// SlowPlans are auto_explain captures of the slowest executions
+	SlowPlans []SlowPlan `json:"slow_plans,omitempty"`
+	// ServerMessages are the errors and warnings the engine logged while
+	// the query ran
+	ServerMessages []ServerMessage `json:"server_messages,omitempty"`
+	// TopK are the ASINs of the returned rows in order, kept by the
+	// snapshot scenario to detect ranking drift
+	TopK []string `json:"top_k,omitempty"`
+	// RowEstimates compare planner estimates with actual rows for plan
+	// nodes of one EXPLAIN ANALYZE
+	RowEstimates []RowEstimate `json:"row_estimates,omitempty"`
+	// Workers and Throughput are the concurrent clients and completed
+	// operations per second of results timed under load
+	Workers    int     `json:"workers,omitempty"`
+	Throughput float64 `json:"ops_per_second,omitempty"`
+	// CorpusRows is the size of the products table when the result was
+	// timed, for series over a growing corpus
+	CorpusRows int64 `json:"corpus_rows,omitempty"`
+	// Recall compares the rows the engine's search matches with the ones
+	// known to be relevant, for scenarios that know them
+	Recall *Recall `json:"recall,omitempty"`
+	// Dropped counts queued messages the client discarded as a slow
+	// consumer, for scenarios fed from a queue; they are missing from Stats
+	Dropped int    `json:"dropped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Recall counts the relevant rows of a search, the rows the engine
+// matched, and the relevant ones among them.
+type Recall struct {
+	Relevant int `json:"relevant"`
+	Matched  int `json:"matched"`
+	Found    int `json:"found"`
+}
+
+// RowEstimate is the planner's row estimate for one plan node next to the
+// rows it actually produced (over all loops).
+type RowEstimate struct {
+	Node      string  `json:"node"`
+	Relation  string  `json:"relation,omitempty"`
+	Estimated float64 `json:"estimated_rows"`
+	Actual    float64 `json:"actual_rows"`
+}
+
+// Error is how many times the estimate is off in either direction; both
+// sides count as at least one row so empty results stay finite.
+func (e RowEstimate) Error() float64 {
+	est, act := e.Estimated, e.Actual
+	if est < 1 {
+		est = 1
+	}
+	if act < 1 {
+		act = 1
+	}
+	if est > act {
+		return est / act
+	}
+	return act / est
+}
+
+// Execution is one timed run of a query.
+type Execution struct {
+	Duration  time.Duration `json:"duration_ns"`
+	StartedAt time.Time     `json:"started_at"`
+}
+
+// SlowPlan is one execution logged by auto_explain.
+type SlowPlan struct {
+	Duration   time.Duration   `json:"duration_ns"`
+	LoggedAt   string          `json:"logged_at,omitempty"`
+	Parameters string          `json:"parameters,omitempty"`
+	Plan       json.RawMessage `json:"plan"`
+}
+
+// ServerMessage is an error or warning from an engine's server log.
+type ServerMessage struct {
+	Level    string `json:"level"`
+	Message  string `json:"message"`
+	LoggedAt string `json:"logged_at,omitempty"`
+}
+
+// LatencyStats summarizes the timed iterations of one query on one engine.
+// Durations are serialized as nanoseconds.
+type LatencyStats struct {
+	Count int           `json:"count"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	Min   time.Duration `json:"min_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// ChurnResult is one write operation of the churn scenario on one engine.
+type ChurnResult struct {
+	Engine    string        `json:"engine"`
+	Operation string        `json:"operation"`
+	Rows      int64         `json:"rows"`
+	Duration  time.Duration `json:"duration_ns"`
+	// TableBytes and IndexBytes are the on-disk size of the table and its
+	// indexes after the operation, when it was measured
+	TableBytes int64 `json:"table_bytes,omitempty"`
+	IndexBytes int64 `json:"index_bytes,omitempty"`
+}
+
+// New builds a current-version file around results.
+func New(kind string, meta Metadata, results interface{}) (*File, error) {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	return &File{SchemaVersion: SchemaVersion, Kind: kind, Metadata: meta, Results: data}, nil
+}
+
+// Queries decodes the results of a KindQueries file.
+func (f *File) Queries() ([]QueryResult, error) {
+	if f.Kind != KindQueries {
+		return nil, fmt.Errorf("results are %s, not %s", f.Kind, KindQueries)
+	}
+	var out []QueryResult
+	err := json.Unmarshal(f.Results, &out)
+	return out, err
+}
+
+// Churn decodes the results of a KindChurn file.
+func (f *File) Churn() ([]ChurnResult, error) {
+	if f.Kind != KindChurn {
+		return nil, fmt.Errorf("results are %s, not %s", f.Kind, KindChurn)
+	}
+	var out []ChurnResult
+	err := json.Unmarshal(f.Results, &out)
+	return out, err
+}
+
+// Upgrade parses a results file of any known version and converts it to the
+// current one.
+func Upgrade(data []byte) (*File, error) {
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case f.SchemaVersion == 0:
+		// Version 1 had no version field; the kind follows from the scenario
+		f.Kind = KindQueries
+		if f.Metadata.Scenario == "churn" {
+			f.Kind = KindChurn
+		}
+		f.SchemaVersion = SchemaVersion
+	case f.SchemaVersion > SchemaVersion:
+		return nil, fmt.Errorf("schema version %d is newer than supported version %d", f.SchemaVersion, SchemaVersion)
+	}
+	return &f, nil
+}
+
+// Read loads and upgrades a results file.
+func Read(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Upgrade(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return f, nil
+}
+
+// Write stores a file in the current version.
+func Write(path string, f *File) error {
+	f.SchemaVersion = SchemaVersion
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// markdownPath is where -markdown writes the GFM summary; "-" is stdout.
+	markdownPath = ""
+	// markdownTemplate replaces the built-in summary template, e.g. for a
+	// translated or branded report.
+	markdownTemplate = ""
+)
+
+// defaultMarkdownTemplate renders the summary unless -markdown-template
+// names another one.
+//
+//go:embed templates/summary.md.tmpl
+var defaultMarkdownTemplate string
+
+// summaryData is what a summary template renders.
+type summaryData struct {
+	Degraded     bool
+	Degradations int
+	QualityLevel string
+	QualityScore int
+	Findings     []qualityFinding
+	Execution    string
+	Concurrent   string
+	SavedAt      time.Time
+	// Engines are the labels of every engine and Others those of the
+	// engines compared with Baseline, in column order.
+	Engines    []string
+	Baseline   string
+	Others     []string
+	Categories []summaryCategory
+	// Claims are the workload's claims checked against the results, and
+	// ClaimsHeld how many of them held.
+	Claims     []claimOutcome
+	ClaimsHeld int
+	// Comparison is set when -compare-to names a baseline with results.
+	Comparison *summaryComparison
+	Results    []summaryResult
+	// Config is the configuration the results were produced with, when
+	// they record it.
+	Config *manifest.EffectiveConfig
+}
+
+// summaryCategory is one row of the category table. Means are zero where
+// Cells say "error" or "skipped"; Speedups line up with Others.
+type summaryCategory struct {
+	Name     string
+	Cells    []string
+	Means    []time.Duration
+	Speedups []string
+}
+
+// summaryComparison lists the results that moved by the regression
+// threshold or more against a baseline.
+type summaryComparison struct {
+	Name         string
+	Regressions  int
+	Improvements int
+	// Threshold is in percent.
+	Threshold float64
+	Moved     []summaryDelta
+}
+
+// summaryDelta is one moved result; Change is relative, positive is slower.
+type summaryDelta struct {
+	Query, Args, Engine string
+	Base, Current       time.Duration
+	Change              float64
+}
+
+// summaryResult is a stored result with its engine's label.
+type summaryResult struct {
+	manifest.QueryResult
+	EngineLabel string
+}
+
+// summaryFuncs are the helpers summary templates can use.
+var summaryFuncs = template.FuncMap{
+	"md":       mdCell,
+	"duration": formatDuration,
+	"args":     argsLabel,
+	"time":     func(t time.Time) string { return t.Format(time.RFC3339) },
+	"percent":  func(f float64) string { return fmt.Sprintf("%+.1f%%", f*100) },
+}
+
+// writeMarkdownFile renders the stored results as a compact GitHub-flavored
+// Markdown summary, meant to be posted as a PR comment by CI.
+func writeMarkdownFile(path, dir string, targets []*benchTarget, claims []speedClaim) error {
+	if path == "-" {
+		return writeMarkdownSummary(os.Stdout, dir, targets, claims)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeMarkdownSummary(f, dir, targets, claims); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadSummaryTemplate parses -markdown-template, or the built-in template.
+func loadSummaryTemplate() (*template.Template, error) {
+	name, text := "summary.md.tmpl", defaultMarkdownTemplate
+	if markdownTemplate != "" {
+		data, err := os.ReadFile(markdownTemplate)
+		if err != nil {
+			return nil, err
+		}
+		name, text = markdownTemplate, string(data)
+	}
+	return template.New(name).Funcs(summaryFuncs).Parse(text)
+}
+
+func writeMarkdownSummary(w io.Writer, dir string, targets []*benchTarget, claims []speedClaim) error {
+	tmpl, err := loadSummaryTemplate()
+	if err != nil {
+		return err
+	}
+	data, err := loadSummaryData(dir, targets, claims)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// loadSummaryData builds the summary model from the stored read-only
+// results, the claims checked against them and, with -compare-to, the
+// baseline's.
+func loadSummaryData(dir string, targets []*benchTarget, claims []speedClaim) (*summaryData, error) {
+	f, err := loadResults(dir, "read-only")
+	if err != nil {
+		return nil, err
+	}
+	results, err := f.Queries()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &summaryData{Execution: f.Metadata.Execution, Concurrent: f.Metadata.Concurrent, SavedAt: f.Metadata.SavedAt, Config: f.Metadata.Config}
+	var status *ingestStatus
+	if s, err := loadIngestStatus(dir); err == nil {
+		status = &s
+		data.Degraded, data.Degradations = s.Status == "degraded", len(s.Degradations)
+	}
+	score, findings := assessQuality(results, f.Metadata, status)
+	data.QualityLevel, data.QualityScore, data.Findings = qualityLevel(score), score, findings
+
+	summarizeCategoriesInto(data, results, targets)
+	data.Claims = checkClaims(claims, results)
+	for _, o := range data.Claims {
+		if o.Held {
+			data.ClaimsHeld++
+		}
+	}
+
+	if compareTo != "" {
+		path, err := baselinePath(dir, compareTo)
+		if err != nil {
+			return nil, err
+		}
+		if base, err := loadResults(path, "read-only"); err == nil {
+			baseResults, err := base.Queries()
+			if err != nil {
+				return nil, err
+			}
+			data.Comparison = summarizeDeltas(compareTo, diffQueries(baseResults, results), targets)
+		}
+	}
+
+	for _, r := range results {
+		data.Results = append(data.Results, summaryResult{QueryResult: r, EngineLabel: engineLabel(r.Engine, targets)})
+	}
+	return data, nil
+}
+
+// summarizeCategoriesInto fills in the category table: mean latency per
+// category and engine, and each engine's speedup over the baseline engine.
+func summarizeCategoriesInto(data *summaryData, results []manifest.QueryResult, targets []*benchTarget) {
+	sum := summarizeCategories(results)
+	if len(sum.Engines) == 0 {
+		return
+	}
+	baseline := sum.baseline()
+	data.Baseline = engineLabel(baseline, targets)
+	for _, e := range sum.Engines {
+		data.Engines = append(data.Engines, engineLabel(e, targets))
+		if e != baseline {
+			data.Others = append(data.Others, engineLabel(e, targets))
+		}
+	}
+	for _, cat := range sum.Categories {
+		row := summaryCategory{Name: cat}
+		for _, e := range sum.Engines {
+			row.Cells = append(row.Cells, sum.cell(cat, e))
+			row.Means = append(row.Means, sum.Means[cat][e])
+		}
+		for _, e := range sum.Engines {
+			if e != baseline {
+				row.Speedups = append(row.Speedups, mdSpeedup(sum.Means[cat][baseline], sum.Means[cat][e]))
+			}
+		}
+		data.Categories = append(data.Categories, row)
+	}
+}
+
+// summarizeDeltas keeps only the results that moved by the regression
+// threshold or more, to keep the comment short.
+func summarizeDeltas(name string, deltas []resultDelta, targets []*benchTarget) *summaryComparison {
+	c := &summaryComparison{Name: name, Threshold: regressionThreshold * 100}
+	for _, d := range deltas {
+		change, ok := d.Change()
+		if !ok || (change < regressionThreshold && change > -regressionThreshold) {
+			continue
+		}
+		c.Moved = append(c.Moved, summaryDelta{
+			Query: d.Query, Args: d.Args, Engine: engineLabel(d.Engine, targets),
+			Base: d.Base, Current: d.Current, Change: change,
+		})
+		if change > 0 {
+			c.Regressions++
+		} else {
+			c.Improvements++
+		}
+	}
+	return c
+}
+
+// mdSpeedup is formatSpeedup with emoji instead of terminal colors.
+func mdSpeedup(base, other time.Duration) string {
+	if base <= 0 || other <= 0 {
+		return "-"
+	}
+	ratio := float64(base) / float64(other)
+	s := fmt.Sprintf("%.2fx", ratio)
+	switch {
+	case ratio >= 1.1:
+		return s + " 🟢"
+	case ratio <= 0.9:
+		return s + " 🔴"
+	}
+	return s
+}
+
+// mdCell keeps free text from breaking out of a table cell.
+func mdCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"pg_search_benchmark/manifest"
+)
+
+// sampleMemory measures backend memory for every query after timing it.
+var sampleMemory = false
+
+// memorySamples is how many times each query is run to find its peak.
+const memorySamples = 3
+
+// printMemorySummary shows the largest backend memory per engine and
+// category, if the results were sampled.
+func printMemorySummary(results []manifest.QueryResult, targets []*benchTarget) {
+	sum := summarizeCategories(results)
+	peaks := map[string]map[string]int64{}
+	sampled := false
+	for _, r := range results {
+		if r.MaxBackendMemory == 0 {
+			continue
+		}
+		sampled = true
+		if peaks[r.Category] == nil {
+			peaks[r.Category] = map[string]int64{}
+		}
+		if r.MaxBackendMemory > peaks[r.Category][r.Engine] {
+			peaks[r.Category][r.Engine] = r.MaxBackendMemory
+		}
+	}
+	if !sampled {
+		return
+	}
+
+	headers := []string{"Category"}
+	for _, e := range sum.Engines {
+		headers = append(headers, engineLabel(e, targets))
+	}
+	table := newConsoleTable(headers...)
+	overall := map[string]int64{}
+	for _, cat := range sum.Categories {
+		row := []string{cat}
+		for _, e := range sum.Engines {
+			peak, ok := peaks[cat][e]
+			if !ok {
+				row = append(row, "-")
+				continue
+			}
+			row = append(row, formatBytes(uint64(peak)))
+			if peak > overall[e] {
+				overall[e] = peak
+			}
+		}
+		table.addRow(row...)
+	}
+	row := []string{"max"}
+	for _, e := range sum.Engines {
+		row = append(row, formatBytes(uint64(overall[e])))
+	}
+	table.addRow(row...)
+
+	fmt.Println("\nMAX BACKEND MEMORY (held at end of execution, above the idle backend):")
+	table.render(os.Stdout)
+}
+
+// sampleQueryMemory fills in MaxBackendMemory for one result; a failure only
+// warns, the timings still stand.
+func sampleQueryMemory(res *manifest.QueryResult, t *benchTarget, stmt string, args []interface{}) {
+	peak, err := catalogOf(t.DB).queryMemory(stmt, args)
+	if err != nil {
+		warnf("⚠️  %s: memory sampling failed for %s: %v", t.Label, res.Query, err)
+		return
+	}
+	res.MaxBackendMemory = peak
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// queryOrder is how timed executions are ordered across queries and
+	// engines: "blocked" runs each query's iterations back to back on one
+	// engine, then the next engine; "serial" replays one seeded shuffle of
+	// all executions against each engine in turn; "interleaved" runs each
+	// step of that shuffle on every engine, rotating which goes first.
+	queryOrder = "blocked"
+)
+
+var queryOrders = []string{"blocked", "serial", "interleaved"}
+
+func checkQueryOrder(order string, sp sampling) error {
+	for _, o := range queryOrders {
+		if o != order {
+			continue
+		}
+		if order != "blocked" && (sp.Duration > 0 || sp.CIWidth > 0) {
+			return fmt.Errorf("-order %s replays a fixed sequence and needs -iterations, not -duration or -ci-width", order)
+		}
+		return nil
+	}
+	return fmt.Errorf("-order must be one of %s, got %q", strings.Join(queryOrders, ", "), order)
+}
+
+// runShuffledWorkload times the workload in the serial or interleaved order.
+// Both modes replay the same sequence for the same seed, so ordering effects
+// such as cache warmth from the previous query hit every engine alike.
+func runShuffledWorkload(w *Workload, targets []*benchTarget, sp sampling, planProblems map[string][]string) []manifest.QueryResult {
+	type group struct {
+		q     WorkloadQuery
+		args  []interface{}
+		cells []*queryCell
+	}
+	pass := journal.nextPass()
+	var groups []group
+	for _, q := range w.Queries {
+		for _, args := range q.Args {
+			g := group{q: q, args: args}
+			for _, t := range targets {
+				if _, done := journal.finished(pass, t.Name, q.Category); done {
+					continue
+				}
+				if c, ok := newQueryCell(q, args, t, planProblems); ok {
+					g.cells = append(g.cells, c)
+				}
+			}
+			groups = append(groups, g)
+		}
+	}
+
+	for _, g := range groups {
+		for _, c := range g.cells {
+			c.warm(sp.Warmup)
+		}
+	}
+
+	var steps []int
+	for i := range groups {
+		for n := 0; n < sp.Iterations; n++ {
+			steps = append(steps, i)
+		}
+	}
+	seededRand("order", 0).Shuffle(len(steps), func(i, j int) { steps[i], steps[j] = steps[j], steps[i] })
+
+	switch queryOrder {
+	case "serial":
+		for _, t := range targets {
+			infof("%s: replaying %d executions (seed %d)", t.Label, len(steps), runSeed)
+			for _, i := range steps {
+				for _, c := range groups[i].cells {
+					if c.t == t {
+						c.run()
+					}
+				}
+			}
+		}
+	case "interleaved":
+		infof("Replaying %d executions on every engine in turn (seed %d)", len(steps), runSeed)
+		for n, i := range steps {
+			cells := groups[i].cells
+			for k := range cells {
+				cells[(n+k)%len(cells)].run()
+			}
+		}
+	}
+
+	var results []manifest.QueryResult
+	cells := map[string][]manifest.QueryResult{}
+	category := ""
+	for _, g := range groups {
+		if g.q.Category != category {
+			category = g.q.Category
+			infof("--- %s ---", strings.ToUpper(category))
+		}
+		line := make([]string, 0, len(g.cells))
+		for _, c := range g.cells {
+			res := c.finish()
+			results = append(results, res)
+			cells[c.t.Name+"/"+res.Category] = append(cells[c.t.Name+"/"+res.Category], res)
+			line = append(line, c.describe(sp))
+		}
+		if len(line) > 0 {
+			infof("%s %s: %s", g.q.Name, argsLabel(g.args), strings.Join(line, " | "))
+		}
+	}
+
+	// Executions interleave across categories, so cells are journaled only
+	// once the whole pass is done
+	journaled := map[string]bool{}
+	for _, q := range w.Queries {
+		for _, t := range targets {
+			key := t.Name + "/" + q.Category
+			if journaled[key] {
+				continue
+			}
+			journaled[key] = true
+			if prior, done := journal.finished(pass, t.Name, q.Category); done {
+				infof("⏭️  %s %s: kept from the interrupted run", t.Label, q.Category)
+				results = append(results, prior...)
+				continue
+			}
+			journal.record(pass, t.Name, q.Category, cells[key])
+		}
+	}
+	return results
+}
+
+// runFairnessScenario times the workload serially and interleaved with the
+// same seed and shows how much the order moved each engine's numbers.
+func runFairnessScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+
+	defer func(order string) { queryOrder = order }(queryOrder)
+	sums := map[string]categorySummary{}
+	for _, order := range []string{"serial", "interleaved"} {
+		queryOrder = order
+		infof("=== %s order ===", order)
+		results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+		if err := saveResults(dataDir, "fairness-"+order, manifest.KindQueries, st.Targets, results); err != nil {
+			return err
+		}
+		sums[order] = summarizeCategories(results)
+	}
+
+	serial, interleaved := sums["serial"], sums["interleaved"]
+	table := newConsoleTable("Category", "Engine", "Serial", "Interleaved", "Difference")
+	for _, cat := range serial.Categories {
+		for _, e := range serial.Engines {
+			a, okA := serial.Means[cat][e]
+			b, okB := interleaved.Means[cat][e]
+			diff := "-"
+			if okA && okB && a > 0 {
+				diff = fmt.Sprintf("%+.1f%%", float64(b-a)/float64(a)*100)
+			}
+			table.addRow(cat, engineLabel(e, st.Targets), serial.cell(cat, e), interleaved.cell(cat, e), diff)
+		}
+	}
+	fmt.Fprintf(progressOut, "\nORDERING EFFECT (seed %d):\n", runSeed)
+	table.render(progressOut)
+	fmt.Fprintln(progressOut, "Difference: interleaved mean relative to serial; large values mean results depend on execution order")
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CPU sets in Docker/taskset list syntax ("0-3,8"). Pinning the client and
+// each database container to disjoint sets keeps the load generator from
+// stealing cycles from the server being measured on a single machine.
+var (
+	clientCPUSet  = ""
+	vanillaCPUSet = ""
+	paradeCPUSet  = ""
+)
+
+// parseCPUSet expands a cpuset list into sorted CPU numbers.
+func parseCPUSet(spec string) ([]int, error) {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset %q", spec)
+		}
+		to, err := strconv.Atoi(hi)
+		if err != nil || to < from {
+			return nil, fmt.Errorf("invalid cpuset %q", spec)
+		}
+		for c := from; c <= to; c++ {
+			seen[c] = true
+		}
+	}
+	cpus := make([]int, 0, len(seen))
+	for c := range seen {
+		cpus = append(cpus, c)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// cpuSets returns the configured sets keyed by what they pin.
+func cpuSets() map[string]string {
+	sets := map[string]string{}
+	for name, spec := range map[string]string{"client": clientCPUSet, "vanilla": vanillaCPUSet, "parade": paradeCPUSet} {
+		if spec != "" {
+			sets[name] = spec
+		}
+	}
+	return sets
+}
+
+// checkDisjointCPUSets rejects configurations where two pinned processes
+// would share a CPU.
+func checkDisjointCPUSets(sets map[string]string) error {
+	owner := map[int]string{}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cpus, err := parseCPUSet(sets[name])
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, c := range cpus {
+			if other, ok := owner[c]; ok {
+				return fmt.Errorf("CPU %d is in both the %s and %s cpusets", c, other, name)
+			}
+			owner[c] = name
+		}
+	}
+	return nil
+}
+
+// serviceContainer returns the ID of the running container of a compose
+// service.
+func serviceContainer(service string) (string, error) {
+	out, err := exec.Command("docker", "compose", "ps", "-q", service).Output()
+	if err != nil {
+		return "", fmt.Errorf("could not find container for %s: %v", service, err)
+	}
+	id := strings.TrimSpace(string(out))
+	if id == "" {
+		return "", fmt.Errorf("service %s is not running", service)
+	}
+	return id, nil
+}
+
+// pinContainer applies a cpuset to the running container of a compose
+// service.
+func pinContainer(service, cpuset string) error {
+	id, err := serviceContainer(service)
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command("docker", "update", "--cpuset-cpus", cpuset, id).CombinedOutput(); err != nil {
+		return fmt.Errorf("docker update %s: %v: %s", service, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// applyCPUPinning pins the containers and this process as configured.
+func applyCPUPinning(targets []*benchTarget) error {
+	sets := cpuSets()
+	if len(sets) == 0 {
+		return nil
+	}
+	if err := checkDisjointCPUSets(sets); err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		spec, ok := sets[t.Name]
+		if !ok {
+			continue
+		}
+		if err := pinContainer(t.Service, spec); err != nil {
+			return err
+		}
+		infof("📌 %s pinned to CPUs %s", t.Label, spec)
+	}
+
+	if spec, ok := sets["client"]; ok {
+		cpus, err := parseCPUSet(spec)
+		if err != nil {
+			return err
+		}
+		if err := pinProcess(cpus); err != nil {
+			return fmt.Errorf("could not pin client: %v", err)
+		}
+		infof("📌 Benchmark client pinned to CPUs %s", spec)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// pinProcess sets the affinity of every existing thread of this process;
+// threads the runtime starts later inherit it from their creator.
+func pinProcess(cpus []int) error {
+	var mask [1024 / 64]uint64
+	for _, c := range cpus {
+		if c >= len(mask)*64 {
+			return syscall.EINVAL
+		}
+		mask[c/64] |= 1 << (uint(c) % 64)
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask[0])))
+		if errno != 0 {
+			return errno
+		}
+	}
+	runtime.GOMAXPROCS(len(cpus))
+	return nil
+}
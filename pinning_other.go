@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// pinProcess is only supported on Linux; elsewhere run the client under the
+// OS's own affinity tool.
+func pinProcess(cpus []int) error {
+	return fmt.Errorf("client CPU pinning is not supported on %s", runtime.GOOS)
+}
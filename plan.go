@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"pg_search_benchmark/manifest"
+)
+
+// planNode is the subset of EXPLAIN (FORMAT JSON) output the assertions use.
+type planNode struct {
+	NodeType           string     `json:"Node Type"`
+	RelationName       string     `json:"Relation Name"`
+	IndexName          string     `json:"Index Name"`
+	Index              string     `json:"Index"` // ParadeDB custom scans
+	CustomPlanProvider string     `json:"Custom Plan Provider"`
+	IndexCond          string     `json:"Index Cond"`
+	Filter             string     `json:"Filter"`
+	Plans              []planNode `json:"Plans"`
+	// Set by EXPLAIN ANALYZE; actual rows are per loop
+	PlanRows    float64 `json:"Plan Rows"`
+	ActualRows  float64 `json:"Actual Rows"`
+	ActualLoops float64 `json:"Actual Loops"`
+}
+
+func explainPlan(db *sql.DB, query string, args []interface{}) (planNode, error) {
+	return explainWith(db, "EXPLAIN (FORMAT JSON) ", query, args)
+}
+
+// explainAnalyzePlan runs the query under EXPLAIN ANALYZE, so the plan
+// carries actual row counts.
+func explainAnalyzePlan(db *sql.DB, query string, args []interface{}) (planNode, error) {
+	return explainWith(db, "EXPLAIN (ANALYZE, FORMAT JSON) ", query, args)
+}
+
+func explainWith(db *sql.DB, explain, query string, args []interface{}) (planNode, error) {
+	var raw []byte
+	if err := db.QueryRow(explain+query, args...).Scan(&raw); err != nil {
+		return planNode{}, err
+	}
+
+	var plans []struct {
+		Plan planNode `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plans); err != nil {
+		return planNode{}, err
+	}
+	if len(plans) == 0 {
+		return planNode{}, fmt.Errorf("empty plan")
+	}
+	return plans[0].Plan, nil
+}
+
+// rowEstimate is the node's estimate next to its actual rows over all loops.
+func (n planNode) rowEstimate() manifest.RowEstimate {
+	loops := n.ActualLoops
+	if loops < 1 {
+		loops = 1
+	}
+	return manifest.RowEstimate{Node: n.NodeType, Relation: n.RelationName, Estimated: n.PlanRows, Actual: n.ActualRows * loops}
+}
+
+// scanEstimate is the estimate of the first node that scans a table: the
+// node applying the search and filter predicates, whose estimate decides
+// the plan above it.
+func (n planNode) scanEstimate() (manifest.RowEstimate, bool) {
+	var est manifest.RowEstimate
+	found := false
+	n.walk(func(c planNode) {
+		if !found && c.RelationName != "" {
+			est, found = c.rowEstimate(), true
+		}
+	})
+	return est, found
+}
+
+func (n planNode) walk(fn func(planNode)) {
+	fn(n)
+	for _, child := range n.Plans {
+		child.walk(fn)
+	}
+}
+
+// checkPlan returns one message per expectation the plan does not meet.
+func checkPlan(plan planNode, expect PlanExpectation) []string {
+	indexes := map[string]bool{}
+	nodeTypes := map[string]bool{}
+	relations := map[string]bool{}
+	seqScan := false
+	plan.walk(func(n planNode) {
+		nodeTypes[n.NodeType] = true
+		if n.RelationName != "" {
+			relations[n.RelationName] = true
+		}
+		if n.IndexName != "" {
+			indexes[n.IndexName] = true
+		}
+		if n.Index != "" {
+			indexes[n.Index] = true
+		}
+		if n.NodeType == "Seq Scan" {
+			seqScan = true
+		}
+	})
+
+	var problems []string
+	for _, idx := range expect.UsesIndex {
+		if !indexes[idx] {
+			problems = append(problems, fmt.Sprintf("does not use index %s", idx))
+		}
+	}
+	for _, nt := range expect.NodeTypes {
+		if !nodeTypes[nt] {
+			problems = append(problems, fmt.Sprintf("has no %s node", nt))
+		}
+	}
+	if expect.NoSeqScan && seqScan {
+		problems = append(problems, "uses a sequential scan")
+	}
+	if expect.MaxRelations > 0 && len(relations) > expect.MaxRelations {
+		problems = append(problems, fmt.Sprintf("scans %d relations, expected at most %d", len(relations), expect.MaxRelations))
+	}
+	return problems
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Number of lines read from the dataset to extrapolate its full size.
+	estimateSampleLines = 10000
+
+	// Rough on-disk multipliers over the raw text bytes of a row, measured on
+	// the full SNAP load: heap + TOAST, the nine GIN/trgm/B-tree indexes on the
+	// vanilla side, and the single BM25 index on the ParadeDB side.
+	heapOverheadFactor    = 1.3
+	vanillaIndexFactor    = 2.5
+	paradeIndexFactor     = 1.0
+	diskSafetyMarginRatio = 1.1
+)
+
+var (
+	// dbVolume is a local path on the volume backing the database containers
+	// (e.g. /var/lib/docker); empty means the ingest check is informational.
+	dbVolume       = ""
+	skipDiskChecks = false
+)
+
+// datasetEstimate is extrapolated from the first lines of the dataset.
+type datasetEstimate struct {
+	CompressedBytes   int64
+	DecompressedBytes int64
+	Records           int64
+	RowBytes          int64
+}
+
+// countingReader counts the bytes pulled through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// checkDownloadSpace aborts before a download that cannot fit next to the
+// data directory.
+func checkDownloadSpace(url, filename string) error {
+	if skipDiskChecks {
+		return nil
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+
+	resp, err := http.Head(url)
+	if err != nil {
+		warnf("Warning: Could not determine download size: %v", err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.ContentLength <= 0 {
+		warnf("Warning: Server did not report download size, skipping disk check")
+		return nil
+	}
+
+	free, err := freeDiskBytes(filepath.Dir(filename))
+	if err != nil {
+		warnf("Warning: Could not read free disk space: %v", err)
+		return nil
+	}
+
+	need := uint64(float64(resp.ContentLength) * diskSafetyMarginRatio)
+	if free < need {
+		return fmt.Errorf("not enough disk space in %s: download needs %s, only %s free (use -data-dir to pick another volume)",
+			filepath.Dir(filename), formatBytes(need), formatBytes(free))
+	}
+	infof("Disk check: download needs %s, %s free", formatBytes(need), formatBytes(free))
+	return nil
+}
+
+// estimateDataset samples the head of the gzip file and extrapolates the
+// decompressed size, record count and average stored row size.
+func estimateDataset(filename string) (datasetEstimate, error) {
+	var est datasetEstimate
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return est, err
+	}
+	est.CompressedBytes = info.Size()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return est, err
+	}
+	defer file.Close()
+
+	counter := &countingReader{r: file}
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		return est, err
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var lines, lineBytes, rows, rowBytes int64
+	for lines < estimateSampleLines && scanner.Scan() {
+		line := scanner.Text()
+		lines++
+		lineBytes += int64(len(line)) + 1
+
+		product, err := parseProductLine(line)
+		if err != nil || product.ASIN == "" || product.Title == "" {
+			continue
+		}
+		rows++
+		rowBytes += int64(len(product.ASIN) + len(product.Title) + len(product.Description) +
+			len(product.Brand) + len(product.Price) + len(product.ImageURL))
+	}
+	if err := scanner.Err(); err != nil {
+		return est, err
+	}
+	if lines == 0 || counter.n == 0 {
+		return est, fmt.Errorf("%s is empty", filename)
+	}
+
+	ratio := float64(lineBytes) / float64(counter.n)
+	est.DecompressedBytes = int64(float64(est.CompressedBytes) * ratio)
+	est.Records = int64(float64(est.DecompressedBytes) / float64(lineBytes) * float64(rows))
+	if rows > 0 {
+		est.RowBytes = rowBytes / rows
+	}
+	if SampleSize > 0 && est.Records > int64(SampleSize) {
+		est.Records = int64(SampleSize)
+	}
+	return est, nil
+}
+
+// requiredDBBytes estimates the footprint of the loaded table plus indexes.
+func requiredDBBytes(est datasetEstimate, isParadeDB bool) uint64 {
+	indexFactor := vanillaIndexFactor
+	if isParadeDB {
+		indexFactor = paradeIndexFactor
+	}
+	raw := float64(est.Records * est.RowBytes)
+	return uint64(raw * (heapOverheadFactor + indexFactor))
+}
+
+// checkIngestSpace aborts before loading when the database volume cannot
+// hold both engines' tables and indexes.
+func checkIngestSpace(filename string) error {
+	if skipDiskChecks {
+		return nil
+	}
+	if isRemoteDump(filename) {
+		infof("Disk check: the dataset is streamed, no size estimate")
+		return nil
+	}
+	switch activeDataset.(type) {
+	case amazonDataset, customDataset:
+	default:
+		// estimateDataset samples gzipped lines; the other corpora are not
+		infof("Disk check: no size estimate for the %s dataset, skipped", datasetName)
+		return nil
+	}
+
+	est, err := estimateDataset(filename)
+	if err != nil {
+		warnf("Warning: Could not estimate dataset size: %v", err)
+		return nil
+	}
+
+	vanillaNeed := requiredDBBytes(est, false)
+	paradeNeed := requiredDBBytes(est, true)
+	need := uint64(float64(vanillaNeed+paradeNeed) * diskSafetyMarginRatio)
+	infof("Disk check: ~%d records (%s decompressed), expect ~%s Vanilla + ~%s ParadeDB",
+		est.Records, formatBytes(uint64(est.DecompressedBytes)), formatBytes(vanillaNeed), formatBytes(paradeNeed))
+
+	if dbVolume == "" {
+		return nil
+	}
+	free, err := freeDiskBytes(dbVolume)
+	if err != nil {
+		warnf("Warning: Could not read free disk space on %s: %v", dbVolume, err)
+		return nil
+	}
+	if free < need {
+		return fmt.Errorf("not enough disk space on %s: load needs ~%s, only %s free (lower the sample size or grow the volume)",
+			dbVolume, formatBytes(need), formatBytes(free))
+	}
+	infof("Disk check: database volume has %s free", formatBytes(free))
+	return nil
+}
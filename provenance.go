@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// provenanceID names the source line a product was loaded from. It hashes
+// the file's base name and the 1-based line number, so the same line gets
+// the same id in every engine and on every load of the same dump.
+func provenanceID(source string, line int) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s:%d", source, line)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// loadProvenance returns the provenance ids in a target's products table and
+// how many rows have none (generated rows, or data loaded before ids were
+// recorded). Duplicates mean a line was loaded twice.
+func loadProvenance(db *sql.DB) (ids map[string]int, unsourced int, err error) {
+	rows, err := db.Query("SELECT provenance FROM " + productsTable)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	ids = map[string]int{}
+	for rows.Next() {
+		var id sql.NullString
+		if err := rows.Scan(&id); err != nil {
+			return nil, 0, err
+		}
+		if !id.Valid {
+			unsourced++
+			continue
+		}
+		ids[id.String]++
+	}
+	return ids, unsourced, rows.Err()
+}
+
+// sourceRecords finds the corpus records with the given provenance ids,
+// keyed by id, by streaming the active dataset at path the way a load does.
+// Records are numbered in the order the dataset streams them.
+func sourceRecords(path string, want map[string]bool) (map[string]sourceRecord, error) {
+	found := map[string]sourceRecord{}
+	n := 0
+	err := activeDataset.Stream(path, func(product Product, err error) error {
+		n++
+		if !want[product.Provenance] {
+			return nil
+		}
+		text := fmt.Sprintf("%s  %s", product.ASIN, product.Title)
+		if err != nil {
+			text = fmt.Sprintf("(not loadable: %v)", err)
+		}
+		found[product.Provenance] = sourceRecord{Number: n, Text: text}
+		if len(found) == len(want) {
+			return errStopStream
+		}
+		return nil
+	})
+	return found, err
+}
+
+type sourceRecord struct {
+	Number int
+	Text   string
+}
+
+// provenanceProblem is a source line that is not loaded exactly once in
+// every engine.
+type provenanceProblem struct {
+	ID     string
+	Counts map[string]int
+}
+
+// verifyCommand implements "verify": it compares which source records each
+// engine holds and traces every difference back to its record in the corpus.
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	fs.StringVar(&datasetName, "dataset", datasetName, "dataset the targets were loaded with: "+strings.Join(datasetNames(), ", "))
+	fs.StringVar(&cacheDir, "cache-dir", cacheDir, "where downloaded datasets are kept")
+	source := fs.String("source", "", "corpus file or URL the targets were loaded from (default: the -dataset's corpus in -data-dir or the cache)")
+	n := fs.Int("n", 20, "number of mismatched records to print")
+	fs.Parse(args)
+	if err := configureTargets(fs, RunConfig{}); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := selectDataset(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *source == "" {
+		*source = datasetPath(dataDir)
+	}
+
+	targets := defaultTargets()
+	counts := map[string]map[string]int{}
+	for _, t := range targets {
+		db, err := getDB(t.Config)
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", t.Label, err)
+		}
+		ids, unsourced, err := loadProvenance(db)
+		db.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", t.Label, err)
+		}
+		counts[t.Name] = ids
+		fmt.Printf("%s: %d source records loaded", t.Label, len(ids))
+		if unsourced > 0 {
+			fmt.Printf(", %d rows without provenance (generated, or loaded by an older version)", unsourced)
+		}
+		fmt.Println()
+	}
+
+	var problems []provenanceProblem
+	seen := map[string]bool{}
+	for _, t := range targets {
+		for id := range counts[t.Name] {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			p := provenanceProblem{ID: id, Counts: map[string]int{}}
+			bad := false
+			for _, other := range targets {
+				c := counts[other.Name][id]
+				p.Counts[other.Name] = c
+				bad = bad || c != 1
+			}
+			if bad {
+				problems = append(problems, p)
+			}
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Println("✅ Every source record is loaded exactly once in every engine")
+		return
+	}
+
+	want := map[string]bool{}
+	for _, p := range problems {
+		want[p.ID] = true
+	}
+	records, err := sourceRecords(*source, want)
+	if err != nil {
+		warnf("Warning: Could not read %s to find the source records: %v", *source, err)
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return records[problems[i].ID].Number < records[problems[j].ID].Number
+	})
+
+	fmt.Printf("\n❌ %d source record(s) differ between engines:\n", len(problems))
+	for i, p := range problems {
+		if i == *n {
+			fmt.Printf("... and %d more (raise -n to list them)\n", len(problems)-*n)
+			break
+		}
+		status := ""
+		for _, t := range targets {
+			status += fmt.Sprintf(" %s=%d", t.Name, p.Counts[t.Name])
+		}
+		r, ok := records[p.ID]
+		if !ok {
+			fmt.Printf("%s:%s (not found in %s)\n", p.ID, status, filepath.Base(*source))
+			continue
+		}
+		text := r.Text
+		if len(text) > 160 {
+			text = text[:160] + "..."
+		}
+		fmt.Printf("%s record %d:%s\n    %s\n", filepath.Base(*source), r.Number, status, text)
+	}
+	os.Exit(1)
+}
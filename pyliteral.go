@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// pythonLiteralToJSON converts a Python literal as printed by repr() in the
+// SNAP dump (dicts, lists, tuples, str and unicode strings, numbers, True,
+// False, None) to JSON. JSON's own true, false and null are accepted too, so
+// JSON lines pass through unchanged in meaning.
+//
+// Python 2 str literals hold bytes: \xhh escapes are bytes of (usually)
+// UTF-8 text, and a string whose bytes are not valid UTF-8 is read as
+// Latin-1. In unicode literals (u'...') \xhh is a code point.
+func pythonLiteralToJSON(s string) ([]byte, error) {
+	p := &pyParser{src: s}
+	p.skipSpace()
+	if err := p.value(); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q after the value", p.src[p.pos])
+	}
+	return p.out, nil
+}
+
+type pyParser struct {
+	src string
+	pos int
+	out []byte
+	// depth guards against stack exhaustion on hostile nesting
+	depth int
+}
+
+// maxPyDepth is far deeper than any SNAP record nests.
+const maxPyDepth = 64
+
+func (p *pyParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *pyParser) skipSpace() {
+	for p.pos < len(p.src) && strings.IndexByte(" \t\r\n", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *pyParser) value() error {
+	if p.pos >= len(p.src) {
+		return p.errorf("unexpected end of input")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.container('{', '}', true)
+	case c == '[':
+		return p.container('[', ']', false)
+	case c == '(':
+		return p.container('(', ')', false)
+	case c == '\'' || c == '"':
+		return p.str(false)
+	case (c == 'u' || c == 'U' || c == 'b' || c == 'B') && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '\'' || p.src[p.pos+1] == '"'):
+		p.pos++
+		return p.str(c == 'u' || c == 'U')
+	case c == '-' || c == '+' || c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	}
+	for _, kw := range pyKeywords {
+		if strings.HasPrefix(p.src[p.pos:], kw.word) && !isIdentByte(p.src, p.pos+len(kw.word)) {
+			p.pos += len(kw.word)
+			p.out = append(p.out, kw.json...)
+			return nil
+		}
+	}
+	return p.errorf("unexpected %q", p.src[p.pos])
+}
+
+// pyKeywords are the constants a literal may contain, Python's and JSON's.
+var pyKeywords = []struct{ word, json string }{
+	{"True", "true"}, {"False", "false"}, {"None", "null"},
+	{"true", "true"}, {"false", "false"}, {"null", "null"},
+}
+
+func isIdentByte(s string, i int) bool {
+	if i >= len(s) {
+		return false
+	}
+	c := s[i]
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// container parses a dict, list or tuple; tuples become JSON arrays, and a
+// trailing comma is allowed as in Python.
+func (p *pyParser) container(open, close byte, dict bool) error {
+	if p.depth++; p.depth > maxPyDepth {
+		return p.errorf("nested deeper than %d", maxPyDepth)
+	}
+	defer func() { p.depth-- }()
+
+	if dict {
+		p.out = append(p.out, '{')
+	} else {
+		p.out = append(p.out, '[')
+	}
+	p.pos++
+	for first := true; ; first = false {
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == close {
+			p.pos++
+			break
+		}
+		if !first {
+			p.out = append(p.out, ',')
+		}
+		if dict {
+			// JSON keys must be strings; SNAP keys always are
+			if p.pos >= len(p.src) || !strings.ContainsRune("'\"uUbB", rune(p.src[p.pos])) {
+				return p.errorf("dict key is not a string")
+			}
+		}
+		if err := p.value(); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if dict {
+			if p.pos >= len(p.src) || p.src[p.pos] != ':' {
+				return p.errorf("expected ':' in dict")
+			}
+			p.pos++
+			p.out = append(p.out, ':')
+			p.skipSpace()
+			if err := p.value(); err != nil {
+				return err
+			}
+			p.skipSpace()
+		}
+		if p.pos >= len(p.src) {
+			return p.errorf("unterminated %c", open)
+		}
+		switch p.src[p.pos] {
+		case ',':
+			p.pos++
+		case close:
+		default:
+			return p.errorf("expected ',' or %q, got %q", close, p.src[p.pos])
+		}
+	}
+	if dict {
+		p.out = append(p.out, '}')
+	} else {
+		p.out = append(p.out, ']')
+	}
+	return nil
+}
+
+// str decodes a quoted string with Python escapes and appends it as a JSON
+// string.
+func (p *pyParser) str(unicode bool) error {
+	quote := p.src[p.pos]
+	p.pos++
+	var buf []byte
+	for {
+		if p.pos >= len(p.src) {
+			return p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == quote {
+			p.pos++
+			break
+		}
+		if c != '\\' {
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			return p.errorf("unterminated string")
+		}
+		e := p.src[p.pos+1]
+		p.pos += 2
+		switch e {
+		case '\\', '\'', '"':
+			buf = append(buf, e)
+		case 'n':
+			buf = append(buf, '\n')
+		case 't':
+			buf = append(buf, '\t')
+		case 'r':
+			buf = append(buf, '\r')
+		case 'a':
+			buf = append(buf, '\a')
+		case 'b':
+			buf = append(buf, '\b')
+		case 'f':
+			buf = append(buf, '\f')
+		case 'v':
+			buf = append(buf, '\v')
+		case '\n':
+			// line continuation
+		case 'x':
+			n, err := p.hex(2)
+			if err != nil {
+				return err
+			}
+			if unicode {
+				buf = utf8.AppendRune(buf, rune(n))
+			} else {
+				buf = append(buf, byte(n))
+			}
+		case 'u', 'U':
+			if !unicode {
+				buf = append(buf, '\\', e)
+				continue
+			}
+			digits := 4
+			if e == 'U' {
+				digits = 8
+			}
+			n, err := p.hex(digits)
+			if err != nil {
+				return err
+			}
+			if n > utf8.MaxRune {
+				return p.errorf("invalid code point \\%c%x", e, n)
+			}
+			// Narrow Python 2 builds print astral characters as surrogate pairs
+			if r := rune(n); utf16.IsSurrogate(r) && strings.HasPrefix(p.src[p.pos:], "\\u") {
+				save := p.pos
+				p.pos += 2
+				if lo, err := p.hex(4); err == nil && utf16.DecodeRune(r, rune(lo)) != utf8.RuneError {
+					n = uint64(utf16.DecodeRune(r, rune(lo)))
+				} else {
+					p.pos = save
+				}
+			}
+			buf = utf8.AppendRune(buf, rune(n))
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			n := uint64(e - '0')
+			for i := 0; i < 2 && p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '7'; i++ {
+				n = n*8 + uint64(p.src[p.pos]-'0')
+				p.pos++
+			}
+			if unicode {
+				buf = utf8.AppendRune(buf, rune(n))
+			} else if n > 0o377 {
+				// Python 2 would keep the low byte; refuse rather than guess
+				return p.errorf("octal escape \\%o does not fit a byte", n)
+			} else {
+				buf = append(buf, byte(n))
+			}
+		default:
+			// Python keeps unknown escapes as they are
+			buf = append(buf, '\\', e)
+		}
+	}
+
+	text := string(buf)
+	if !utf8.ValidString(text) {
+		runes := make([]rune, len(buf))
+		for i, b := range buf {
+			runes[i] = rune(b)
+		}
+		text = string(runes)
+	}
+	quoted, err := json.Marshal(text)
+	if err != nil {
+		return err
+	}
+	p.out = append(p.out, quoted...)
+	return nil
+}
+
+func (p *pyParser) hex(digits int) (uint64, error) {
+	if p.pos+digits > len(p.src) {
+		return 0, p.errorf("truncated escape")
+	}
+	n, err := strconv.ParseUint(p.src[p.pos:p.pos+digits], 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid escape %q", p.src[p.pos:p.pos+digits])
+	}
+	p.pos += digits
+	return n, nil
+}
+
+// number copies an int or float, rewriting forms JSON lacks (1., .5, +1,
+// 10L) through strconv.
+func (p *pyParser) number() error {
+	start := p.pos
+	for p.pos < len(p.src) && strings.IndexByte("+-.0123456789eE", p.src[p.pos]) >= 0 {
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	if p.pos < len(p.src) && (p.src[p.pos] == 'L' || p.src[p.pos] == 'l') {
+		p.pos++
+	}
+	if json.Valid([]byte(text)) {
+		p.out = append(p.out, text...)
+		return nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return p.errorf("invalid number %q", text)
+	}
+	p.out = strconv.AppendFloat(p.out, f, 'g', -1, 64)
+	return nil
+}
+
+// quotedText reports whether any text of p holds a quote or an apostrophe.
+// repr() writes such strings in a way that swapping ' for " breaks, so these
+// are the records the old quote swap dropped.
+func (p Product) quotedText() bool {
+	for _, s := range []string{p.Title, p.Description, p.Brand} {
+		if strings.ContainsAny(s, `'"`) {
+			return true
+		}
+	}
+	return quotedCategory(p.Categories)
+}
+
+func quotedCategory(v interface{}) bool {
+	switch v := v.(type) {
+	case string:
+		return strings.ContainsAny(v, `'"`)
+	case []interface{}:
+		for _, c := range v {
+			if quotedCategory(c) {
+				return true
+			}
+		}
+	}
+	return false
+}
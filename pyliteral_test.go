@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// goldenLiteral is a line of testdata/pyliteral/golden.jsonl: a literal of
+// lines.txt and the document Python reads from it, as golden.py writes them.
+type goldenLiteral struct {
+	Line string          `json:"line"`
+	Want json.RawMessage `json:"want"`
+}
+
+func readGoldenLiterals(t testing.TB) []goldenLiteral {
+	t.Helper()
+	f, err := os.Open("testdata/pyliteral/golden.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var golden []goldenLiteral
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var g goldenLiteral
+		if err := json.Unmarshal(scanner.Bytes(), &g); err != nil {
+			t.Fatalf("golden.jsonl: %v", err)
+		}
+		golden = append(golden, g)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return golden
+}
+
+func sameDocument(t testing.TB, got, want []byte) bool {
+	t.Helper()
+	var g, w interface{}
+	if err := json.Unmarshal(got, &g); err != nil {
+		t.Fatalf("output is not JSON: %v", err)
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(g, w)
+}
+
+// TestPythonLiteralGolden checks the conversion against Python's reading of
+// the validation corpus: every line converts to the document Python reads.
+func TestPythonLiteralGolden(t *testing.T) {
+	for _, g := range readGoldenLiterals(t) {
+		got, err := pythonLiteralToJSON(g.Line)
+		if err != nil {
+			t.Errorf("%s: %v", g.Line, err)
+			continue
+		}
+		if !sameDocument(t, got, g.Want) {
+			t.Errorf("%s\n got %s\nwant %s", g.Line, got, g.Want)
+		}
+	}
+}
+
+func TestPythonLiteralJSONKeywords(t *testing.T) {
+	got, err := pythonLiteralToJSON(`{"asin": "B00JSON001", "ok": true, "gone": false, "brand": null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"asin":"B00JSON001","ok":true,"gone":false,"brand":null}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestPythonLiteralErrors(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"{'asin': 'B00000001'",
+		"{'title': 'unterminated}",
+		"{1: 'key is not a string'}",
+		"{'asin': 'B00000001'} trailing",
+		"{'title': 'Caf\\777'}",
+		"{'title': 'Caf\\400'}",
+		"{'title': u'\\U00110000'}",
+		"{'title': 'x' 'y'}",
+		strings.Repeat("[", maxPyDepth+1) + strings.Repeat("]", maxPyDepth+1),
+	} {
+		if out, err := pythonLiteralToJSON(line); err == nil {
+			t.Errorf("%q converted to %s, want an error", line, out)
+		}
+	}
+}
+
+// TestPythonLiteralOctal checks that octal escapes up to \377 are bytes in a
+// str and code points in a unicode literal.
+func TestPythonLiteralOctal(t *testing.T) {
+	for line, want := range map[string]string{
+		`'\377'`:     `"ÿ"`,
+		`'\303\251'`: `"é"`,
+		`u'\777'`:    `"ǿ"`,
+		`'\0'`:       `"\u0000"`,
+	} {
+		got, err := pythonLiteralToJSON(line)
+		if err != nil {
+			t.Errorf("%s: %v", line, err)
+			continue
+		}
+		if !sameDocument(t, got, []byte(want)) {
+			t.Errorf("%s: got %s, want %s", line, got, want)
+		}
+	}
+}
+
+func FuzzPythonLiteralToJSON(f *testing.F) {
+	for _, g := range readGoldenLiterals(f) {
+		f.Add(g.Line)
+	}
+	for _, seed := range []string{
+		"",
+		"{",
+		"{'a': 'unterminated}",
+		`{'title': 'It\'s "quoted"'}`,
+		`{'title': u'😀'}`,
+		`{'title': '\777'}`,
+		"[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		out, err := pythonLiteralToJSON(line)
+		if err == nil && !json.Valid(out) {
+			t.Fatalf("%q converted to invalid JSON %q", line, out)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"pg_search_benchmark/manifest"
+)
+
+// Thresholds for the run quality score. Each finding subtracts its penalty
+// from 100; the score only discourages over-reading a run, it doesn't
+// change any numbers.
+const (
+	minSolidIterations = 30
+	minIterations      = 10
+	// A cell is noisy when its p95 is more than this multiple above its p50
+	noisySpread        = 1.0
+	maxNoisyCellsRatio = 0.25
+	maxCountedPenalty  = 20
+)
+
+type qualityFinding struct {
+	Penalty int
+	Reason  string
+}
+
+// assessQuality scores how far the results of one scenario can be trusted.
+func assessQuality(results []manifest.QueryResult, meta manifest.Metadata, status *ingestStatus) (int, []qualityFinding) {
+	var findings []qualityFinding
+	add := func(penalty int, format string, args ...interface{}) {
+		findings = append(findings, qualityFinding{Penalty: penalty, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if meta.Fake {
+		add(100, "results come from -fake engines, not databases")
+	}
+	if status != nil && status.Status == "degraded" {
+		add(40, "data was loaded with %d fallback(s)", len(status.Degradations))
+	}
+	if meta.Execution == "parallel" && len(meta.SharedHost) > 0 {
+		add(20, "targets shared a machine while running in parallel")
+	}
+	if len(meta.SharedHost) > 0 && len(meta.CPUSets) == 0 {
+		add(10, "targets share a machine without CPU pinning")
+	}
+
+	var counts []int
+	planMismatches, errors, noisy, timed := 0, 0, 0, 0
+	for _, r := range results {
+		if len(r.PlanProblems) > 0 {
+			planMismatches++
+		}
+		if r.Error != "" {
+			errors++
+			continue
+		}
+		if r.Stats.Count == 0 {
+			continue
+		}
+		timed++
+		counts = append(counts, r.Stats.Count)
+		if r.Stats.P50 > 0 && float64(r.Stats.P95-r.Stats.P50)/float64(r.Stats.P50) > noisySpread {
+			noisy++
+		}
+	}
+
+	if planMismatches > 0 {
+		add(min(5*planMismatches, maxCountedPenalty), "%d result(s) ran with a plan that failed its assertions", planMismatches)
+	}
+	if errors > 0 {
+		add(min(5*errors, maxCountedPenalty), "%d query/engine cell(s) errored", errors)
+	}
+	if len(counts) > 0 {
+		sort.Ints(counts)
+		median := counts[len(counts)/2]
+		switch {
+		case median < minIterations:
+			add(15, "median of %d timed iterations per query (want %d+)", median, minSolidIterations)
+		case median < minSolidIterations:
+			add(5, "median of %d timed iterations per query (want %d+)", median, minSolidIterations)
+		}
+	}
+	if timed > 0 && float64(noisy)/float64(timed) > maxNoisyCellsRatio {
+		add(15, "%d of %d cells are noisy (p95 more than %.0fx above p50)", noisy, timed, 1+noisySpread)
+	}
+
+	score := 100
+	for _, f := range findings {
+		score -= f.Penalty
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score, findings
+}
+
+func qualityLevel(score int) string {
+	switch {
+	case score >= 80:
+		return "HIGH"
+	case score >= 50:
+		return "MEDIUM"
+	default:
+		return "LOW"
+	}
+}
+
+func qualityBadge(score int) string {
+	switch level := qualityLevel(score); level {
+	case "HIGH":
+		return colorize(level, ansiGreen)
+	case "LOW":
+		return colorize(level, ansiRed)
+	default:
+		return level
+	}
+}
+
+func printQuality(score int, findings []qualityFinding) {
+	fmt.Printf("\nRUN QUALITY: %s (%d/100)\n", qualityBadge(score), score)
+	for _, f := range findings {
+		fmt.Printf("   -%d %s\n", f.Penalty, f.Reason)
+	}
+	if score < 50 {
+		fmt.Println("   Don't draw conclusions from this run.")
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// reduceTable is the scratch table a reduction loads each subset of rows
+// into, on every engine it compares.
+const reduceTable = "pgsb_reduce"
+
+// maxReduceTests is -reduce-tests: the most subsets and argument variants a
+// reduction tries. Each one reloads the scratch table and rebuilds its
+// indexes, so the bound keeps a reduction of a large result set short; when
+// it is reached the smallest case found so far is kept.
+var maxReduceTests = 200
+
+var indexDefHead = regexp.MustCompile(`^CREATE (UNIQUE )?INDEX \S+ ON (ONLY )?\S+ `)
+
+// issueReduction is the smallest case a reduction found.
+type issueReduction struct {
+	ASINs    []string
+	Args     []interface{}
+	Original int
+	Tests    int
+	// Results holds what each engine returned on the reduced case
+	Results []issueRun
+}
+
+// issueReducer shrinks a ParadeDB error or divergence. The engine reported
+// is compared with one other engine, unless it errored, which reproduces
+// alone.
+type issueReducer struct {
+	q       WorkloadQuery
+	engines []*benchTarget
+	// stmts are the engines' statements against the scratch table
+	stmts   map[string]string
+	errored bool
+	ddl     map[string][]string
+	tests   int
+	last    []issueRun
+	// try runs one case, as run does against the engines
+	try func(asins []string, args []interface{}) (bool, error)
+}
+
+// reduceIssue looks for the fewest rows, among those the engines returned
+// on the whole corpus, and the fewest search terms with which the first of
+// runs still errors or still returns other rows than the second.
+func reduceIssue(q WorkloadQuery, args []interface{}, runs []issueRun) (*issueReduction, error) {
+	r := &issueReducer{q: q, engines: []*benchTarget{runs[0].Target}, errored: runs[0].Err != nil, stmts: map[string]string{}, ddl: map[string][]string{}}
+	if !r.errored {
+		if len(runs) < 2 || runs[1].Err != nil {
+			return nil, fmt.Errorf("no other engine returned results to compare with")
+		}
+		r.engines = append(r.engines, runs[1].Target)
+	}
+	r.try = r.run
+	table := regexp.MustCompile(`\b` + regexp.QuoteMeta(productsTable) + `\b`)
+	for _, t := range r.engines {
+		r.stmts[t.Name] = table.ReplaceAllString(q.SQL[t.sqlName()], reduceTable)
+		ddl, err := tableIndexDDL(t.DB)
+		if err != nil {
+			return nil, fmt.Errorf("%s: read the indexes: %v", t.Label, err)
+		}
+		for i, stmt := range ddl {
+			r.ddl[t.Name] = append(r.ddl[t.Name], indexDefHead.ReplaceAllString(stmt, fmt.Sprintf("CREATE ${1}INDEX %s_%d ON %s ", reduceTable, i+1, reduceTable)))
+		}
+	}
+	// The scratch table is dropped and reloaded like the run's own tables,
+	// so no run may be loading or timing these targets meanwhile
+	if err := lockTargets(r.engines); err != nil {
+		return nil, err
+	}
+	defer unlockTargets()
+	defer r.cleanup()
+
+	seen := map[string]bool{}
+	var asins []string
+	for _, run := range runs {
+		for _, asin := range run.TopK {
+			if !seen[asin] {
+				seen[asin] = true
+				asins = append(asins, asin)
+			}
+		}
+	}
+	red := &issueReduction{Original: len(asins), Args: append([]interface{}(nil), args...)}
+
+	ok, err := r.reproduces(asins, red.Args)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("does not reproduce on the %d row(s) the engines returned, so it depends on the rest of the corpus", len(asins))
+	}
+	red.Args, err = r.simplifyArgs(asins, red.Args)
+	if err != nil {
+		return nil, err
+	}
+	if red.ASINs, err = ddmin(asins, func(subset []string) (bool, error) { return r.reproduces(subset, red.Args) }); err != nil {
+		return nil, err
+	}
+	// The results of the case kept, which the last test may not have run
+	if _, err := r.run(red.ASINs, red.Args); err != nil {
+		return nil, err
+	}
+	red.Tests, red.Results = r.tests, r.last
+	return red, nil
+}
+
+// reproduces is run within maxReduceTests; once they are spent, nothing
+// reproduces any more.
+func (r *issueReducer) reproduces(asins []string, args []interface{}) (bool, error) {
+	if r.tests >= maxReduceTests {
+		return false, nil
+	}
+	r.tests++
+	return r.try(asins, args)
+}
+
+// run loads asins into the scratch table of every engine and reports
+// whether the query still errors, or still returns different rows.
+func (r *issueReducer) run(asins []string, args []interface{}) (bool, error) {
+	r.last = nil
+	for _, t := range r.engines {
+		if err := r.load(t, asins); err != nil {
+			return false, fmt.Errorf("%s: load %s: %v", t.Label, reduceTable, err)
+		}
+		run := issueRun{Target: t, SQL: r.stmts[t.Name], Args: r.q.bind(t.sqlName(), args)}
+		_, run.TopK, run.Err = snapshotTopK(t.DB, run.SQL, run.Args)
+		r.last = append(r.last, run)
+	}
+	if r.errored {
+		return r.last[0].Err != nil, nil
+	}
+	if r.last[0].Err != nil || r.last[1].Err != nil {
+		return false, nil
+	}
+	return len(asinDifference(r.last[0].TopK, r.last[1].TopK))+len(asinDifference(r.last[1].TopK, r.last[0].TopK)) > 0, nil
+}
+
+// load replaces the scratch table of t with the rows of asins and the
+// indexes of the products table.
+func (r *issueReducer) load(t *benchTarget, asins []string) error {
+	stmts := []string{
+		"DROP TABLE IF EXISTS " + reduceTable,
+		fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING DEFAULTS)", reduceTable, productsTable),
+	}
+	for _, stmt := range stmts {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := t.DB.Exec(fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE asin = ANY($1)", reduceTable, productsTable), pq.Array(asins)); err != nil {
+		return err
+	}
+	for _, stmt := range append(r.ddl[t.Name], "ANALYZE "+reduceTable) {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *issueReducer) cleanup() {
+	for _, t := range r.engines {
+		if _, err := t.DB.Exec("DROP TABLE IF EXISTS " + reduceTable); err != nil {
+			warnf("Warning: %s: could not drop %s: %v", t.Label, reduceTable, err)
+		}
+	}
+}
+
+// simplifyArgs drops the words of each string argument, one at a time,
+// while the case still reproduces on asins; one word is always kept.
+func (r *issueReducer) simplifyArgs(asins []string, args []interface{}) ([]interface{}, error) {
+	args = append([]interface{}(nil), args...)
+	for i, a := range args {
+		s, ok := a.(string)
+		if !ok {
+			continue
+		}
+		words := strings.Fields(s)
+		for j := 0; j < len(words) && len(words) > 1; {
+			fewer := append(append([]string(nil), words[:j]...), words[j+1:]...)
+			try := append([]interface{}(nil), args...)
+			try[i] = strings.Join(fewer, " ")
+			ok, err := r.reproduces(asins, try)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				words, args = fewer, try
+				continue
+			}
+			j++
+		}
+	}
+	return args, nil
+}
+
+// ddmin is Zeller's delta debugging: it returns a subset of items that
+// still passes test and from which no single chunk of the last partition
+// can be removed. items must pass test.
+func ddmin(items []string, test func([]string) (bool, error)) ([]string, error) {
+	if len(items) == 0 {
+		return items, nil
+	}
+	if ok, err := test(nil); err != nil || ok {
+		return nil, err
+	}
+	n := 2
+	for len(items) >= 2 {
+		chunks := splitChunks(items, n)
+		reduced := false
+		for _, c := range chunks {
+			ok, err := test(c)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				items, n, reduced = c, 2, true
+				break
+			}
+		}
+		if !reduced && n > 2 {
+			for i := range chunks {
+				complement := make([]string, 0, len(items))
+				for j, c := range chunks {
+					if j != i {
+						complement = append(complement, c...)
+					}
+				}
+				ok, err := test(complement)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					items, n, reduced = complement, max(n-1, 2), true
+					break
+				}
+			}
+		}
+		if !reduced {
+			if n >= len(items) {
+				break
+			}
+			n = min(2*n, len(items))
+		}
+	}
+	return items, nil
+}
+
+// splitChunks divides items into n chunks of nearly equal size.
+func splitChunks(items []string, n int) [][]string {
+	var chunks [][]string
+	for i := 0; i < n; i++ {
+		from, to := i*len(items)/n, (i+1)*len(items)/n
+		if from < to {
+			chunks = append(chunks, items[from:to])
+		}
+	}
+	return chunks
+}
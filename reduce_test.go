@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// containsAll is a reduction predicate: a subset reproduces while it holds
+// every one of want.
+func containsAll(want ...string) func([]string) (bool, error) {
+	return func(subset []string) (bool, error) {
+		for _, w := range want {
+			if !slices.Contains(subset, w) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+func TestDDMin(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, tc := range []struct {
+		name  string
+		items []string
+		test  func([]string) (bool, error)
+		want  []string
+	}{
+		{"one item", items, containsAll("e"), []string{"e"}},
+		{"items apart", items, containsAll("b", "e", "g"), []string{"b", "e", "g"}},
+		{"every item", items[:4], containsAll("a", "b", "c", "d"), []string{"a", "b", "c", "d"}},
+		{"empty passes", items, containsAll(), nil},
+		{"no items", nil, containsAll(), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ddmin(tc.items, tc.test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) || (len(got) > 0 && !reflect.DeepEqual(got, tc.want)) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+			// 1-minimal: leaving out any one item no longer reproduces
+			for i := range got {
+				fewer := append(append([]string(nil), got[:i]...), got[i+1:]...)
+				if ok, _ := tc.test(fewer); ok {
+					t.Errorf("%q still reproduces without %q", fewer, got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDDMinTestLimit(t *testing.T) {
+	defer func(n int) { maxReduceTests = n }(maxReduceTests)
+	maxReduceTests = 4
+
+	// The empty set, both halves and then {e f} use up the tests, so the
+	// reduction stops at {e f}, the smallest case found
+	test := containsAll("e")
+	r := &issueReducer{try: func(asins []string, _ []interface{}) (bool, error) { return test(asins) }}
+	got, err := ddmin([]string{"a", "b", "c", "d", "e", "f", "g", "h"}, func(subset []string) (bool, error) {
+		return r.reproduces(subset, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e", "f"}; !reflect.DeepEqual(got, want) || r.tests != maxReduceTests {
+		t.Errorf("got %q after %d test(s), want %q after %d", got, r.tests, want, maxReduceTests)
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	for _, tc := range []struct {
+		items []string
+		n     int
+		want  [][]string
+	}{
+		{[]string{"a", "b", "c", "d", "e"}, 2, [][]string{{"a", "b"}, {"c", "d", "e"}}},
+		{[]string{"a", "b", "c", "d", "e"}, 3, [][]string{{"a"}, {"b", "c"}, {"d", "e"}}},
+		// More chunks than items leaves out the empty ones
+		{[]string{"a", "b"}, 4, [][]string{{"a"}, {"b"}}},
+	} {
+		if got := splitChunks(tc.items, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q in %d: got %q, want %q", tc.items, tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestSimplifyArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []interface{}
+		// reproduces while the first argument has this word, or always
+		word string
+		want []interface{}
+	}{
+		{"drops other words", []interface{}{"usb c cable", 10}, "cable", []interface{}{"cable", 10}},
+		{"keeps one word", []interface{}{"usb c cable"}, "", []interface{}{"cable"}},
+		{"needed word only", []interface{}{"cable"}, "cable", []interface{}{"cable"}},
+		{"no strings", []interface{}{10, true}, "", []interface{}{10, true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &issueReducer{try: func(_ []string, args []interface{}) (bool, error) {
+				s, _ := args[0].(string)
+				return tc.word == "" || slices.Contains(strings.Fields(s), tc.word), nil
+			}}
+			orig := append([]interface{}(nil), tc.args...)
+			got, err := r.simplifyArgs(nil, tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+			if !reflect.DeepEqual(tc.args, orig) {
+				t.Errorf("the caller's arguments became %v", tc.args)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// baselineEngine is the engine the other engines are compared against;
+// empty means the first engine in the results.
+var baselineEngine = ""
+
+// categorySummary holds the mean latency by category and engine, and which
+// engines only produced errors for a category.
+type categorySummary struct {
+	Categories []string
+	Engines    []string
+	Means      map[string]map[string]time.Duration
+	Errored    map[string]map[string]bool
+}
+
+// summarizeCategories averages the per-query mean latency by category and
+// engine, keeping engines in the order they appear in the results.
+func summarizeCategories(results []manifest.QueryResult) categorySummary {
+	sum := categorySummary{
+		Means:   map[string]map[string]time.Duration{},
+		Errored: map[string]map[string]bool{},
+	}
+	totals := map[string]map[string]time.Duration{}
+	counts := map[string]map[string]int{}
+	seenEngine := map[string]bool{}
+	for _, r := range results {
+		if totals[r.Category] == nil {
+			totals[r.Category] = map[string]time.Duration{}
+			counts[r.Category] = map[string]int{}
+			sum.Errored[r.Category] = map[string]bool{}
+			sum.Categories = append(sum.Categories, r.Category)
+		}
+		if !seenEngine[r.Engine] {
+			seenEngine[r.Engine] = true
+			sum.Engines = append(sum.Engines, r.Engine)
+		}
+		if r.Error != "" || r.Stats.Count == 0 {
+			sum.Errored[r.Category][r.Engine] = true
+			continue
+		}
+		totals[r.Category][r.Engine] += r.Stats.Mean
+		counts[r.Category][r.Engine]++
+	}
+
+	for cat, engines := range totals {
+		sum.Means[cat] = map[string]time.Duration{}
+		for engine, total := range engines {
+			sum.Means[cat][engine] = total / time.Duration(counts[cat][engine])
+			delete(sum.Errored[cat], engine)
+		}
+	}
+	return sum
+}
+
+// cell renders one engine's mean for a category, or why it has none.
+func (s categorySummary) cell(cat, engine string) string {
+	if d, ok := s.Means[cat][engine]; ok {
+		return formatDuration(d)
+	}
+	if s.Errored[cat][engine] {
+		return "error"
+	}
+	return "skipped"
+}
+
+// relativeSpeed is the geometric mean over shared categories of how many
+// times faster a is than b.
+func (s categorySummary) relativeSpeed(a, b string) (float64, int) {
+	logSum, n := 0.0, 0
+	for _, cat := range s.Categories {
+		da, okA := s.Means[cat][a]
+		db, okB := s.Means[cat][b]
+		if !okA || !okB || da <= 0 || db <= 0 {
+			continue
+		}
+		logSum += math.Log(float64(db) / float64(da))
+		n++
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return math.Exp(logSum / float64(n)), n
+}
+
+// baseline is the -baseline engine if it has results, else the first engine.
+func (s categorySummary) baseline() string {
+	for _, e := range s.Engines {
+		if e == baselineEngine {
+			return e
+		}
+	}
+	return s.Engines[0]
+}
+
+func engineLabel(engine string, targets []*benchTarget) string {
+	for _, t := range targets {
+		if t.Name == engine {
+			return t.Label
+		}
+	}
+	return engine
+}
+
+func printReadSummary(title string, results []manifest.QueryResult, targets []*benchTarget, weights map[string]float64) {
+	sum := summarizeCategories(results)
+	if len(sum.Engines) == 0 {
+		return
+	}
+	baseline := sum.baseline()
+	if baselineEngine != "" && baseline != baselineEngine {
+		fmt.Printf("⚠️  Baseline %s has no results here, comparing against %s\n", baselineEngine, baseline)
+	}
+	fmt.Printf("\n%s:\n", title)
+
+	headers := []string{"Category"}
+	for _, e := range sum.Engines {
+		headers = append(headers, engineLabel(e, targets))
+	}
+	for _, e := range sum.Engines {
+		if e != baseline {
+			headers = append(headers, engineLabel(e, targets)+" vs "+engineLabel(baseline, targets))
+		}
+	}
+	table := newConsoleTable(headers...)
+
+	for _, cat := range sum.Categories {
+		row := []string{cat}
+		for _, e := range sum.Engines {
+			row = append(row, sum.cell(cat, e))
+		}
+		for _, e := range sum.Engines {
+			if e != baseline {
+				row = append(row, formatSpeedup(sum.Means[cat][baseline], sum.Means[cat][e]))
+			}
+		}
+		table.addRow(row...)
+	}
+	table.render(os.Stdout)
+	fmt.Printf("Speedup: %s mean / engine mean, >1 = engine faster (-baseline to change)\n", engineLabel(baseline, targets))
+
+	if len(sum.Engines) > 2 {
+		printSpeedupMatrix(sum, targets)
+	}
+	printCompositeScores(sum, weights, baseline, targets)
+}
+
+// printSpeedupMatrix shows every pair of engines: the cell is how many times
+// faster the row engine is than the column engine across shared categories.
+func printSpeedupMatrix(sum categorySummary, targets []*benchTarget) {
+	headers := []string{"Row vs column"}
+	for _, e := range sum.Engines {
+		headers = append(headers, engineLabel(e, targets))
+	}
+	table := newConsoleTable(headers...)
+	for _, a := range sum.Engines {
+		row := []string{engineLabel(a, targets)}
+		for _, b := range sum.Engines {
+			ratio, n := sum.relativeSpeed(a, b)
+			switch {
+			case a == b:
+				row = append(row, "-")
+			case n == 0:
+				row = append(row, "n/a")
+			default:
+				row = append(row, formatSpeedup(time.Duration(ratio*1e6), time.Duration(1e6)))
+			}
+		}
+		table.addRow(row...)
+	}
+	fmt.Println("\nPAIRWISE SPEEDUP (geometric mean over categories both engines ran):")
+	table.render(os.Stdout)
+}
+
+func printExecution(meta manifest.Metadata) {
+	order := ""
+	if meta.QueryOrder != "" && meta.QueryOrder != "blocked" {
+		order = fmt.Sprintf(", %s order", meta.QueryOrder)
+	}
+	if meta.Seed != 0 {
+		order += fmt.Sprintf(", seed %d", meta.Seed)
+	}
+	concurrent := ""
+	if meta.Concurrent != "" {
+		concurrent = " with " + meta.Concurrent
+	}
+	fmt.Printf("\n[%s: %s execution%s%s, saved %s]", meta.Scenario, meta.Execution, concurrent, order, meta.SavedAt.Format(time.RFC3339))
+	if meta.Execution == "parallel" && len(meta.SharedHost) > 0 {
+		fmt.Printf("\n⚠️  %v shared one machine while running in parallel; timings are skewed", meta.SharedHost)
+	}
+	fmt.Println()
+}
+
+// reportWeights returns the -weights override or the workload's weights.
+func reportWeights(st *runState) (map[string]float64, error) {
+	if categoryWeights != "" {
+		return parseWeights(categoryWeights)
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		// Reports on stored results don't need the workload file
+		return nil, nil
+	}
+	return w.Weights, nil
+}
+
+func runReportScenario(st *runState) error {
+	// The summary is the result of the run, printed at every -log-level
+	return printReport(st)
+}
+
+func printReport(st *runState) error {
+	fmt.Println("========== BENCHMARK SUMMARY ==========")
+	var status *ingestStatus
+	if s, err := loadIngestStatus(dataDir); err == nil {
+		status = &s
+		if s.Status == "degraded" {
+			fmt.Printf("⚠️  DEGRADED RUN: data was loaded with %d fallback(s), do not publish these numbers\n", len(s.Degradations))
+		}
+	}
+
+	f, err := loadResults(dataDir, "read-only")
+	if err != nil {
+		return err
+	}
+	readOnly, err := f.Queries()
+	if err != nil {
+		return err
+	}
+	printQuality(assessQuality(readOnly, f.Metadata, status))
+	printExecution(f.Metadata)
+	weights, err := reportWeights(st)
+	if err != nil {
+		return err
+	}
+	printReadSummary("READ PERFORMANCE", readOnly, st.Targets, weights)
+	printClaims(st.workloadClaims(), readOnly)
+	printMemorySummary(readOnly, st.Targets)
+	printEstimateSummary(readOnly, st.Targets)
+	printServerMessages(readOnly, st.Targets)
+
+	if f, err := loadResults(dataDir, "mixed"); err == nil {
+		mixed, err := f.Queries()
+		if err != nil {
+			return err
+		}
+		printExecution(f.Metadata)
+		printReadSummary("READ PERFORMANCE UNDER WRITES", mixed, st.Targets, weights)
+	}
+
+	if f, err := loadResults(dataDir, "churn"); err == nil {
+		churn, err := f.Churn()
+		if err != nil {
+			return err
+		}
+		printExecution(f.Metadata)
+		fmt.Println("\nCHURN:")
+		table := newConsoleTable("Engine", "Operation", "Rows", "Time", "Rows/sec")
+		for _, r := range churn {
+			rate := "-"
+			if r.Rows > 0 && r.Duration > 0 {
+				rate = fmt.Sprintf("%.0f", float64(r.Rows)/r.Duration.Seconds())
+			}
+			table.addRow(r.Engine, r.Operation, fmt.Sprintf("%d", r.Rows), formatDuration(r.Duration), rate)
+		}
+		table.render(os.Stdout)
+	}
+	return nil
+}
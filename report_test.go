@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+func meanResult(query, category, engine string, mean time.Duration) manifest.QueryResult {
+	return manifest.QueryResult{Query: query, Category: category, Engine: engine, Stats: manifest.LatencyStats{Count: 10, Mean: mean}}
+}
+
+func TestSummarizeCategories(t *testing.T) {
+	results := []manifest.QueryResult{
+		meanResult("a", "fulltext", "vanilla", 4*time.Millisecond),
+		meanResult("a", "fulltext", "parade", time.Millisecond),
+		meanResult("b", "fulltext", "vanilla", 8*time.Millisecond),
+		meanResult("b", "fulltext", "parade", 3*time.Millisecond),
+		meanResult("c", "fuzzy", "vanilla", 10*time.Millisecond),
+		{Query: "c", Category: "fuzzy", Engine: "parade", Error: "syntax error"},
+		meanResult("d", "exact", "vanilla", time.Millisecond),
+		{Query: "d", Category: "exact", Engine: "parade", Error: "timeout"},
+		meanResult("e", "exact", "parade", 2*time.Millisecond),
+	}
+	sum := summarizeCategories(results)
+	if want := []string{"fulltext", "fuzzy", "exact"}; !reflect.DeepEqual(sum.Categories, want) {
+		t.Errorf("categories %v, want %v", sum.Categories, want)
+	}
+	if want := []string{"vanilla", "parade"}; !reflect.DeepEqual(sum.Engines, want) {
+		t.Errorf("engines %v, want %v", sum.Engines, want)
+	}
+	for _, tc := range []struct{ cat, engine, want string }{
+		{"fulltext", "vanilla", "6.00ms"},
+		{"fulltext", "parade", "2.00ms"},
+		{"fuzzy", "vanilla", "10.0ms"},
+		// only errors
+		{"fuzzy", "parade", "error"},
+		// an error next to a result keeps the result
+		{"exact", "parade", "2.00ms"},
+		{"missing", "parade", "skipped"},
+	} {
+		if got := sum.cell(tc.cat, tc.engine); got != tc.want {
+			t.Errorf("%s/%s: got %s, want %s", tc.cat, tc.engine, got, tc.want)
+		}
+	}
+
+	// fulltext 3x, exact 0.5x: the geometric mean is √1.5
+	speed, n := sum.relativeSpeed("parade", "vanilla")
+	if n != 2 || math.Abs(speed-math.Sqrt(1.5)) > 1e-9 {
+		t.Errorf("relative speed %v over %d categories, want %v over 2", speed, n, math.Sqrt(1.5))
+	}
+}
+
+func TestSummaryBaseline(t *testing.T) {
+	defer func(e string) { baselineEngine = e }(baselineEngine)
+	sum := categorySummary{Engines: []string{"vanilla", "parade"}}
+	for engine, want := range map[string]string{"": "vanilla", "parade": "parade", "unknown": "vanilla"} {
+		baselineEngine = engine
+		if got := sum.baseline(); got != want {
+			t.Errorf("-baseline %q: got %s, want %s", engine, got, want)
+		}
+	}
+}
+
+func TestCompositeScore(t *testing.T) {
+	sum := summarizeCategories([]manifest.QueryResult{
+		meanResult("a", "fulltext", "vanilla", 10*time.Millisecond),
+		meanResult("b", "exact", "vanilla", 2*time.Millisecond),
+		meanResult("a", "fulltext", "parade", 4*time.Millisecond),
+	})
+	weights, err := parseWeights("fulltext=75%, exact=25,fuzzy=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := compositeScore(sum, weights, "vanilla"); !ok || got != 8*time.Millisecond {
+		t.Errorf("vanilla: got %v (%v), want 8ms", got, ok)
+	}
+	if _, ok := compositeScore(sum, weights, "parade"); ok {
+		t.Error("parade has no exact result but got a composite score")
+	}
+	for _, spec := range []string{"fulltext", "fulltext=-1", "fulltext=x"} {
+		if _, err := parseWeights(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+}
+
+func TestDiffQueries(t *testing.T) {
+	base := []manifest.QueryResult{
+		meanResult("a", "fulltext", "vanilla", 10*time.Millisecond),
+		meanResult("b", "fulltext", "vanilla", 10*time.Millisecond),
+	}
+	current := []manifest.QueryResult{
+		meanResult("b", "fulltext", "vanilla", 12*time.Millisecond),
+		meanResult("a", "fulltext", "vanilla", 5*time.Millisecond),
+		meanResult("c", "fulltext", "vanilla", 5*time.Millisecond),
+		{Query: "a", Category: "fulltext", Engine: "parade", Error: "boom"},
+	}
+	deltas := diffQueries(base, current)
+	if len(deltas) != len(current) {
+		t.Fatalf("%d deltas, want %d", len(deltas), len(current))
+	}
+	for i, want := range []struct {
+		query  string
+		change float64
+		ok     bool
+	}{{"b", 0.2, true}, {"a", -0.5, true}, {"c", 0, false}, {"a", 0, false}} {
+		d := deltas[i]
+		change, ok := d.Change()
+		if d.Query != want.query || ok != want.ok || math.Abs(change-want.change) > 1e-9 {
+			t.Errorf("delta %d: %s %+v (%v), want %s %+v (%v)", i, d.Query, change, ok, want.query, want.change, want.ok)
+		}
+	}
+}
+
+func TestCompareTopK(t *testing.T) {
+	for _, tc := range []struct {
+		old, cur []string
+		want     topKDrift
+	}{
+		{[]string{"A", "B", "C"}, []string{"A", "B", "C"}, topKDrift{Shared: 3}},
+		{[]string{"A", "B", "C"}, []string{"A", "C", "B"}, topKDrift{Shared: 3, Moved: 2, FirstChange: 2}},
+		{[]string{"A", "B", "C"}, []string{"A", "B"}, topKDrift{Shared: 2, FirstChange: 3}},
+		{[]string{"A", "B"}, []string{"X", "A"}, topKDrift{Shared: 1, Moved: 1, FirstChange: 1}},
+		{nil, nil, topKDrift{}},
+	} {
+		if got := compareTopK(tc.old, tc.cur); got != tc.want {
+			t.Errorf("%v -> %v: got %+v, want %+v", tc.old, tc.cur, got, tc.want)
+		}
+	}
+}
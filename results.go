@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// resultsFile is where a measured scenario stores its results in the data
+// directory, so the report scenario can run on its own later.
+func resultsFile(dir, scenario string) string {
+	return filepath.Join(dir, fmt.Sprintf("results-%s.json", scenario))
+}
+
+func saveResults(dir, scenario, kind string, targets []*benchTarget, v interface{}) error {
+	if results, ok := v.([]manifest.QueryResult); ok {
+		emitQueryResults(scenario, results)
+	}
+	return writeResults(dir, scenario, kind, targets, v)
+}
+
+// writeResults saves results without emitting query_result events, for
+// files that carry results of earlier runs too.
+func writeResults(dir, scenario, kind string, targets []*benchTarget, v interface{}) error {
+	execution := scenarioExecution(scenario)
+	meta := manifest.Metadata{
+		Scenario:   scenario,
+		SavedAt:    time.Now().UTC(),
+		Execution:  execution.Mode,
+		Concurrent: execution.Concurrent,
+		SharedHost: sharedHosts(targets),
+		CPUSets:    cpuSets(),
+		Fake:       fakeEngines,
+		Versions:   engineVersions(targets),
+		Seed:       runSeed,
+		RunID:      runID,
+		Config:     effectiveConfig(),
+	}
+	if activeDataset != nil && !fakeEngines {
+		meta.Dataset, meta.DatasetFingerprint = datasetName, datasetFingerprint
+		meta.TermDictionary = termDictionaryFile
+	}
+	if kind == manifest.KindQueries {
+		meta.QueryOrder = queryOrder
+	}
+	f, err := manifest.New(kind, meta, v)
+	if err != nil {
+		return err
+	}
+	return manifest.Write(resultsFile(dir, scenario), f)
+}
+
+// engineVersions asks every connected target for its server version and,
+// on ParadeDB, the installed pg_search version. Targets that can't answer
+// are left out.
+func engineVersions(targets []*benchTarget) map[string]string {
+	if fakeEngines {
+		return nil
+	}
+	versions := map[string]string{}
+	for _, t := range targets {
+		if t.DB == nil {
+			continue
+		}
+		var server string
+		if err := t.DB.QueryRow("SHOW server_version").Scan(&server); err != nil {
+			continue
+		}
+		version := "PostgreSQL " + server
+		var ext string
+		if t.IsParadeDB && t.DB.QueryRow("SELECT extversion FROM pg_extension WHERE extname = 'pg_search'").Scan(&ext) == nil {
+			version += ", pg_search " + ext
+		}
+		versions[t.Name] = version
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions
+}
+
+func loadResults(dir, scenario string) (*manifest.File, error) {
+	return manifest.Read(resultsFile(dir, scenario))
+}
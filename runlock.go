@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+// runLockKey is the session advisory lock a run holds on every target
+// database while it loads or times anything, so a second invocation cannot
+// drop and reload the same tables or measure alongside it.
+var runLockKey = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("pg_search_benchmark run lock"))
+	// Positive, so pg_locks' classid and objid halves read back simply
+	return int64(h.Sum64() >> 2)
+}()
+
+// lockWait is -lock-wait: how long to wait for another run to release a
+// target before giving up; zero gives up at once.
+var lockWait time.Duration
+
+// runLock is a connection of its own holding the run lock, outside the
+// target's pool so it neither takes one of the pool's connections nor goes
+// back to it with the lock still held.
+type runLock struct {
+	db   *sql.DB
+	conn *sql.Conn
+}
+
+func (l runLock) release() {
+	l.conn.Close()
+	l.db.Close()
+}
+
+// runLocks are the run locks held, by database, so targets sharing a
+// database take it once.
+var runLocks = map[string]runLock{}
+
+// lockIdentity names the database a target's lock is taken in.
+func lockIdentity(config DBConfig) string {
+	return fmt.Sprintf("%s:%d/%s", config.Host, config.Port, config.Database)
+}
+
+// lockTarget takes the run lock on a target's database unless this run
+// holds it already. A lock lost with its session, as when a scenario
+// restarts the server, is taken again.
+func lockTarget(label string, config DBConfig) error {
+	if fakeEngines {
+		return nil
+	}
+	id := lockIdentity(config)
+	ctx := context.Background()
+	if l, ok := runLocks[id]; ok {
+		if l.conn.PingContext(ctx) == nil {
+			return nil
+		}
+		l.release()
+		delete(runLocks, id)
+	}
+
+	db, err := getDB(config)
+	if err != nil {
+		return fmt.Errorf("%s: run lock: %v", label, err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("%s: run lock: %v", label, err)
+	}
+	l := runLock{db, conn}
+	deadline := time.Now().Add(lockWait)
+	for {
+		var locked bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", runLockKey).Scan(&locked); err != nil {
+			l.release()
+			return fmt.Errorf("%s: run lock: %v", label, err)
+		}
+		if locked {
+			runLocks[id] = l
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			holder := lockHolder(ctx, conn)
+			l.release()
+			return fmt.Errorf("%s: another benchmark run holds %s (%s); wait for it, or give -lock-wait to queue behind it", label, id, holder)
+		}
+		infof("%s: Waiting for another benchmark run to release %s...", label, id)
+		time.Sleep(min(5*time.Second, time.Until(deadline)+time.Millisecond))
+	}
+}
+
+// lockHolder describes the session holding the run lock, as far as this
+// user may see it.
+func lockHolder(ctx context.Context, conn *sql.Conn) string {
+	var pid int
+	var client string
+	var since time.Time
+	err := conn.QueryRowContext(ctx, `
+		SELECT a.pid, COALESCE(host(a.client_addr), 'local socket'), a.backend_start
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		  AND l.classid::bigint = $1::bigint >> 32 AND l.objid::bigint = $1::bigint & 4294967295`, runLockKey).Scan(&pid, &client, &since)
+	if err != nil {
+		return "holder unknown"
+	}
+	return fmt.Sprintf("backend %d from %s, connected %s", pid, client, since.Format(time.RFC3339))
+}
+
+// lockTargets takes the run lock on every target.
+func lockTargets(targets []*benchTarget) error {
+	for _, t := range targets {
+		if err := lockTarget(t.Label, t.Config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlockTargets releases the run locks by ending their sessions.
+func unlockTargets() {
+	for id, l := range runLocks {
+		l.release()
+		delete(runLocks, id)
+	}
+}
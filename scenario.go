@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// ScenarioStateFile records which scenarios have completed against the data
+// currently loaded, so runs can resume and single scenarios can check their
+// dependencies.
+const ScenarioStateFile = "scenario-state.json"
+
+// scenario is one node of the benchmark graph.
+type scenario struct {
+	Name      string
+	DependsOn []string
+	// Measured scenarios are wrapped in the before/after_scenario hooks
+	Measured bool
+	// Optional scenarios are left out of "run" unless picked with -scenario
+	Optional bool
+	Run      func(*runState) error
+}
+
+// scenarios is in execution order; DependsOn lists the state a scenario
+// needs, not everything that runs before it.
+var scenarios = []scenario{
+	{Name: "schema", Run: runSchemaScenario},
+	{Name: "ingest", DependsOn: []string{"schema"}, Run: runIngestScenario},
+	{Name: "index", DependsOn: []string{"ingest"}, Run: runIndexScenario},
+	{Name: "warmup", DependsOn: []string{"index"}, Measured: true, Run: runWarmupScenario},
+	{Name: "read-only", DependsOn: []string{"index"}, Measured: true, Run: runReadOnlyScenario},
+	{Name: "mixed", DependsOn: []string{"index"}, Measured: true, Run: runMixedScenario},
+	{Name: "churn", DependsOn: []string{"index"}, Measured: true, Run: runChurnScenario},
+	{Name: "report", DependsOn: []string{"read-only"}, Run: runReportScenario},
+	{Name: "fairness", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runFairnessScenario},
+	{Name: "soft-delete", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runSoftDeleteScenario},
+	{Name: "matview", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runMatviewScenario},
+	{Name: "stream", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runStreamScenario},
+	{Name: "lag", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runLagScenario},
+	{Name: "mix", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runMixScenario},
+	{Name: "sharded", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runShardedScenario},
+	{Name: "citus", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runCitusScenario},
+	{Name: "partition", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runPartitionScenario},
+	{Name: "robustness", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runRobustnessScenario},
+	{Name: "limit-sweep", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runLimitSweepScenario},
+	{Name: "selectivity", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runSelectivityScenario},
+	{Name: "pagination", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runPaginationScenario},
+	{Name: "snapshot", DependsOn: []string{"index"}, Optional: true, Run: runSnapshotScenario},
+	{Name: "statistics", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runStatisticsScenario},
+	{Name: "extensions", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runExtensionsScenario},
+	{Name: "roles", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runRolesScenario},
+	{Name: "cancel", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runCancelScenario},
+	{Name: "memory-pressure", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runMemoryPressureScenario},
+	{Name: "disk-full", Optional: true, Run: runDiskFullScenario},
+	{Name: "restart", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runRestartScenario},
+	{Name: "retention", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runRetentionScenario},
+	{Name: "noisy-neighbor", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runNoisyNeighborScenario},
+	{Name: "oltp", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runOLTPScenario},
+	{Name: "append", DependsOn: []string{"index"}, Measured: true, Optional: true, Run: runAppendScenario},
+	{Name: "multilingual", Measured: true, Optional: true, Run: runMultilingualScenario},
+	{Name: "code", Measured: true, Optional: true, Run: runCodeScenario},
+	{Name: "logs", Measured: true, Optional: true, Run: runLogsScenario},
+	{Name: "email", Measured: true, Optional: true, Run: runEmailScenario},
+}
+
+func findScenario(name string) (scenario, bool) {
+	for _, s := range scenarios {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return scenario{}, false
+}
+
+type scenarioState struct {
+	Completed map[string]time.Time `json:"completed"`
+}
+
+func loadScenarioState(dir string) scenarioState {
+	state := scenarioState{Completed: map[string]time.Time{}}
+	data, err := os.ReadFile(filepath.Join(dir, ScenarioStateFile))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		warnf("Warning: Ignoring unreadable %s: %v", ScenarioStateFile, err)
+		return scenarioState{Completed: map[string]time.Time{}}
+	}
+	if state.Completed == nil {
+		state.Completed = map[string]time.Time{}
+	}
+	return state
+}
+
+// complete marks a scenario done and forgets everything that depended on the
+// state it just replaced.
+func (s scenarioState) complete(name string) {
+	s.Completed[name] = time.Now().UTC()
+	stale := map[string]bool{name: true}
+	for _, sc := range scenarios {
+		for _, dep := range sc.DependsOn {
+			if stale[dep] && sc.Name != name {
+				stale[sc.Name] = true
+				delete(s.Completed, sc.Name)
+			}
+		}
+	}
+}
+
+func (s scenarioState) save(dir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ScenarioStateFile), data, 0o644)
+}
+
+// runState is shared by the scenarios of one invocation.
+type runState struct {
+	Targets      []*benchTarget
+	MetadataPath string
+	WorkloadPath string
+	Iterations   int
+	Warmup       int
+	Duration     time.Duration
+	CIWidth      float64
+	MaxTime      time.Duration
+	WriteRate    int
+	ChurnRows    int
+	// DeletedFraction of rows is soft-deleted by the soft-delete scenario
+	DeletedFraction float64
+	// Smoke adds the fixture corpus checks to the assertions
+	Smoke bool
+	// Resume continues an interrupted load instead of starting it over
+	Resume bool
+
+	connected bool
+	workload  *Workload
+	// stopTargets removes ephemeral targets on close
+	stopTargets func()
+}
+
+// connect opens every target once and runs the compatibility preflight, or
+// with -fake opens the fake engines instead.
+func (st *runState) connect() error {
+	if st.connected {
+		return nil
+	}
+	if fakeEngines {
+		for _, t := range st.Targets {
+			db, err := openFakeEngine(t.Name)
+			if err != nil {
+				return err
+			}
+			t.DB = db
+		}
+		st.connected = true
+		return nil
+	}
+	for _, t := range st.Targets {
+		db, err := getDB(t.Config)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %v", t.Label, err)
+		}
+		t.DB = db
+	}
+
+	// Refuse to run against targets that would produce meaningless comparisons
+	for _, t := range st.Targets {
+		var err error
+		switch {
+		case !skipCompatCheck && t.IsParadeDB:
+			err = checkParadeDBCompat(t, t.DB)
+		case !skipCompatCheck:
+			err = checkVanillaCompat(t.DB)
+		case t.IsParadeDB && (bm25Syntax != "" || t.BM25Syntax != ""):
+			var tmpl bm25Template
+			tmpl, err = selectBM25Template("", t.BM25Syntax)
+			t.BM25 = &tmpl
+		}
+		if err != nil {
+			return fmt.Errorf("%s preflight failed: %v", t.Label, err)
+		}
+	}
+	// Tables the scenarios create on the side get the first ParadeDB
+	// target's syntax
+	for _, t := range st.Targets {
+		if t.IsParadeDB {
+			bm25DDL = t.bm25()
+			break
+		}
+	}
+
+	if dbSchema != "" && !dryRun {
+		for _, t := range st.Targets {
+			if err := ensureSchema(t.DB); err != nil {
+				return fmt.Errorf("%s: schema %s: %v", t.Label, dbSchema, err)
+			}
+		}
+	}
+
+	// A failed index build leaves invalid indexes the planner ignores;
+	// timing against them would quietly measure something else
+	for _, t := range st.Targets {
+		if invalid, err := invalidIndexes(t.DB); err == nil && len(invalid) > 0 {
+			warnf("%s: Warning: %s left invalid by a failed index build; run the index scenario again to rebuild them", t.Label, strings.Join(invalid, ", "))
+		}
+	}
+
+	st.connected = true
+	return nil
+}
+
+func (st *runState) close() {
+	unlockTargets()
+	for _, t := range st.Targets {
+		if t.DB != nil {
+			t.DB.Close()
+		}
+	}
+	if st.stopTargets != nil {
+		st.stopTargets()
+		st.stopTargets = nil
+	}
+}
+
+func (st *runState) sampling() sampling {
+	return sampling{Iterations: st.Iterations, Warmup: st.Warmup, Duration: st.Duration, CIWidth: st.CIWidth, MaxTime: st.MaxTime}
+}
+
+func (st *runState) loadWorkload() (*Workload, error) {
+	if st.workload == nil {
+		if st.WorkloadPath == "" {
+			return nil, fmt.Errorf("the %s dataset has no workload of its own, name one with -workload", datasetName)
+		}
+		w, err := loadWorkload(st.WorkloadPath)
+		if err != nil {
+			return nil, err
+		}
+		st.workload = w
+	}
+	return st.workload, nil
+}
+
+// runScenarios executes the named scenarios in graph order. Scenarios whose
+// dependencies have not completed are refused unless they are part of the
+// same invocation or force is set. With resume, completed scenarios are
+// skipped and an interrupted one keeps the cells its journal holds.
+func runScenarios(st *runState, names []string, resume, force bool) error {
+	state := loadScenarioState(dataDir)
+	selected := map[string]bool{}
+	for _, name := range names {
+		if _, ok := findScenario(name); !ok {
+			return fmt.Errorf("unknown scenario %q", name)
+		}
+		selected[name] = true
+	}
+
+	for _, sc := range scenarios {
+		if !selected[sc.Name] {
+			continue
+		}
+		if _, done := state.Completed[sc.Name]; done && resume {
+			infof("⏭️  Skipping %s (completed %s)", sc.Name, state.Completed[sc.Name].Format(time.RFC3339))
+			continue
+		}
+		for _, dep := range sc.DependsOn {
+			if _, done := state.Completed[dep]; !done && !selected[dep] && !force {
+				return fmt.Errorf("scenario %s needs %s, which has not completed against the current data (use -force to run anyway)", sc.Name, dep)
+			}
+		}
+
+		infof("========== SCENARIO: %s ==========", sc.Name)
+		if sc.Name != "report" {
+			if err := st.connect(); err != nil {
+				return err
+			}
+			if err := lockTargets(st.Targets); err != nil {
+				return err
+			}
+		}
+		if sc.Measured {
+			if err := checkHealth(st, sc.Name); err != nil {
+				return err
+			}
+			for _, t := range st.Targets {
+				if err := runHooks(t.DB, t.Label, "before_scenario", runConfig.Hooks[t.Name].BeforeScenario); err != nil {
+					return fmt.Errorf("%s: %v", t.Label, err)
+				}
+			}
+		}
+
+		// Cells journaled before the data the scenario depends on was
+		// last set up measured other data
+		var since time.Time
+		for _, dep := range sc.DependsOn {
+			if at := state.Completed[dep]; at.After(since) {
+				since = at
+			}
+		}
+		journal = openCellJournal(dataDir, sc.Name, resume, since)
+
+		start := time.Now()
+		emitEvent("scenario_started", map[string]interface{}{"scenario": sc.Name})
+		err := sc.Run(st)
+		if err != nil {
+			journal = nil
+			emitEvent("scenario_failed", map[string]interface{}{"scenario": sc.Name, "error": err.Error()})
+			return fmt.Errorf("scenario %s: %v", sc.Name, err)
+		}
+		journal.finish()
+		journal = nil
+
+		if sc.Measured {
+			for _, t := range st.Targets {
+				if err := runHooks(t.DB, t.Label, "after_scenario", runConfig.Hooks[t.Name].AfterScenario); err != nil {
+					warnf("%s: Warning: %v", t.Label, err)
+				}
+			}
+		}
+
+		state.complete(sc.Name)
+		if err := state.save(dataDir); err != nil {
+			warnf("Warning: Could not write %s: %v", ScenarioStateFile, err)
+		}
+		emitEvent("scenario_finished", map[string]interface{}{"scenario": sc.Name, "duration_ns": time.Since(start)})
+		infof("✅ Scenario %s finished in %v", sc.Name, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// setupSchema runs the before_setup hooks and creates an empty products
+// table on a target.
+func setupSchema(t *benchTarget) error {
+	if err := runHooks(t.DB, t.Label, "before_setup", runConfig.Hooks[t.Name].BeforeSetup); err != nil {
+		return fmt.Errorf("%s: %v", t.Label, err)
+	}
+	if err := setupTables(t); err != nil {
+		return fmt.Errorf("failed to setup %s: %v", t.Label, err)
+	}
+	return nil
+}
+
+func runSchemaScenario(st *runState) error {
+	for _, t := range st.Targets {
+		if err := setupSchema(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runIngestScenario loads the dump into every target. An empty products
+// table, as the schema scenario leaves it, is loaded as is, so changes made
+// to it in between are kept; a missing or loaded one is set up again first,
+// so ingest can be re-run on its own. With -resume, a table an interrupted
+// load left behind is kept and the load continues with the records it does
+// not hold yet.
+func runIngestScenario(st *runState) error {
+	if err := checkIngestSpace(st.MetadataPath); err != nil {
+		return fmt.Errorf("preflight failed: %v", err)
+	}
+	if err := prepareCorpus(st.MetadataPath); err != nil {
+		return err
+	}
+	if autoTune {
+		if err := autoTuneLoad(st.Targets); err != nil {
+			return err
+		}
+	}
+
+	openWatermarks(dataDir)
+	resumed := map[*benchTarget]map[string]int{}
+	progress := map[*benchTarget]*loadProgress{}
+	for _, t := range st.Targets {
+		var loaded bool
+		if err := t.DB.QueryRow(productsSQL("SELECT EXISTS (SELECT 1 FROM products)")).Scan(&loaded); err == nil && !loaded {
+			progress[t] = startWatermark(t)
+			continue
+		} else if err == nil && st.Resume {
+			if p := resumeWatermark(t); p != nil {
+				progress[t] = p
+				continue
+			}
+			progress[t] = startWatermark(t)
+			ids, err := resumeLoad(t)
+			if err != nil {
+				return err
+			}
+			resumed[t] = ids
+			continue
+		}
+		if err := setupSchema(t); err != nil {
+			return err
+		}
+		progress[t] = startWatermark(t)
+	}
+
+	st.forEachTarget(func(t *benchTarget, wg *sync.WaitGroup) {
+		loadDataset(t, st.MetadataPath, resumed[t], progress[t], wg)
+	})
+
+	return saveIngestStatus(dataDir)
+}
+
+// resumeLoad returns the provenance ids of the records an interrupted load
+// committed to a target. Batches commit whole, so every record of the dump
+// is either among them or still to load.
+func resumeLoad(t *benchTarget) (map[string]int, error) {
+	ids, unsourced, err := loadProvenance(t.DB)
+	if err != nil {
+		return nil, fmt.Errorf("%s: read loaded rows to resume: %v", t.Label, err)
+	}
+	if unsourced > 0 {
+		return nil, fmt.Errorf("%s: %d rows have no provenance to resume from, load again without -resume", t.Label, unsourced)
+	}
+	infof("%s: Resuming the load after %d products already committed", t.Label, len(ids))
+	return ids, nil
+}
+
+func runIndexScenario(st *runState) error {
+	restoreDegradations(dataDir)
+
+	st.forEachTarget(func(t *benchTarget, wg *sync.WaitGroup) {
+		finalizeTable(t, wg)
+	})
+
+	return saveIngestStatus(dataDir)
+}
+
+func runWarmupScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	runs, failures := 0, 0
+	for _, q := range w.Queries {
+		for _, args := range q.Args {
+			for _, t := range st.Targets {
+				stmt, ok := q.SQL[t.sqlName()]
+				if !ok {
+					continue
+				}
+				runs++
+				if _, _, err := timeQuery(t.DB, stmt, q.bind(t.sqlName(), args)); err != nil {
+					failures++
+					errorf("%s: Warmup %s failed: %v", t.Label, q.Name, err)
+				}
+			}
+		}
+	}
+	infof("Warmed up with %d queries (%d failed)", runs, failures)
+	return nil
+}
+
+func runReadOnlyScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "read-only", manifest.KindQueries, st.Targets, results)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// Growth step of the append scenario.
+var (
+	appendRows = 100000
+	// appendSource is dump (lines of the dataset not loaded yet) or
+	// synthetic (generated products)
+	appendSource = "dump"
+)
+
+// appendDefaults are prepended to the append command's arguments: the
+// workload after each step is a short one, so flags the user passes win.
+var appendDefaults = []string{"-iterations", "3", "-warmup", "1"}
+
+// appendASINPrefix marks generated rows the append scenario added. They are
+// part of the corpus from then on, so unlike syntheticASINPrefix rows no
+// scenario cleans them up.
+const appendASINPrefix = "APP"
+
+// remainingProducts reads the dump the way loadDataset does and
+// returns the first n products whose lines no target holds yet.
+func remainingProducts(filename string, loaded map[string]bool, n int) ([]Product, error) {
+	var products []Product
+	err := activeDataset.Stream(filename, func(product Product, err error) error {
+		if len(products) >= n {
+			return errStopStream
+		}
+		if err == nil && !loaded[product.Provenance] {
+			products = append(products, product)
+		}
+		return nil
+	})
+	return products, err
+}
+
+// appendProducts picks the products of the next growth step: dump lines
+// not loaded into any target, or generated products numbered on from the
+// ones earlier steps added.
+func appendProducts(st *runState) ([]Product, error) {
+	switch appendSource {
+	case "dump":
+		loaded := map[string]bool{}
+		for _, t := range st.Targets {
+			ids, _, err := loadProvenance(t.DB)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", t.Label, err)
+			}
+			for id := range ids {
+				loaded[id] = true
+			}
+		}
+		products, err := remainingProducts(st.MetadataPath, loaded, appendRows)
+		if err != nil {
+			return nil, fmt.Errorf("%v (use -append-source synthetic to generate rows instead)", err)
+		}
+		if len(products) < appendRows {
+			warnf("Warning: Only %d lines of %s are not loaded yet", len(products), filepath.Base(st.MetadataPath))
+		}
+		return products, nil
+	case "synthetic":
+		added, err := catalogOf(st.Targets[0].DB).countProducts(appendASINPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", st.Targets[0].Label, err)
+		}
+		products := syntheticProducts(seededFaker("append", added), appendRows)
+		for i := range products {
+			products[i].ASIN = fmt.Sprintf("%s%07d", appendASINPrefix, added+int64(i))
+		}
+		return products, nil
+	}
+	return nil, fmt.Errorf("unknown -append-source %q (want dump or synthetic)", appendSource)
+}
+
+// runAppendScenario grows the loaded corpus by -append-rows products on
+// every target, lets the indexes absorb them, and times the workload again
+// at the new size. Results accumulate in one file across runs, each keyed
+// by the corpus size it was timed at, so repeated appends give latency
+// against corpus size without reloading.
+func runAppendScenario(st *runState) error {
+	if appendRows < 1 {
+		return fmt.Errorf("-append-rows must be at least 1")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	products, err := appendProducts(st)
+	if err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return fmt.Errorf("nothing left to append from %s", st.MetadataPath)
+	}
+
+	corpus := map[string]int64{}
+	for _, t := range st.Targets {
+		start := time.Now()
+		for i := 0; i < len(products); i += BatchSize {
+			end := i + BatchSize
+			if end > len(products) {
+				end = len(products)
+			}
+			if err := insertBatch(t.DB, products[i:end]); err != nil {
+				return fmt.Errorf("%s: %v", t.Label, err)
+			}
+			emitEvent("batch_committed", map[string]interface{}{"engine": t.Name, "rows": end - i, "committed": end})
+		}
+		if _, err := t.DB.Exec("ANALYZE " + productsTable); err != nil {
+			warnf("%s: Warning: ANALYZE failed: %v", t.Label, err)
+		}
+		rows, err := catalogOf(t.DB).countProducts("")
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		corpus[t.Name] = rows
+		infof("%s: Appended %d %s products in %v, %d in total",
+			t.Label, len(products), appendSource, time.Since(start).Round(time.Millisecond), rows)
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	for i := range results {
+		results[i].CorpusRows = corpus[results[i].Engine]
+	}
+
+	// Earlier steps stay in the series
+	emitQueryResults("append", results)
+	if f, err := loadResults(dataDir, "append"); err == nil {
+		earlier, err := f.Queries()
+		if err != nil {
+			return err
+		}
+		results = append(earlier, results...)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	printCorpusSeries(results, st.Targets)
+	return writeResults(dataDir, "append", manifest.KindQueries, st.Targets, results)
+}
+
+// printCorpusSeries prints, per corpus size and engine, the geometric mean
+// over the workload's queries of their mean and p95 latency.
+func printCorpusSeries(results []manifest.QueryResult, targets []*benchTarget) {
+	type key struct {
+		rows   int64
+		engine string
+	}
+	means, p95s := map[key][]time.Duration{}, map[key][]time.Duration{}
+	var keys []key
+	for _, r := range results {
+		if r.Error != "" || r.CorpusRows == 0 {
+			continue
+		}
+		k := key{r.CorpusRows, r.Engine}
+		if _, ok := means[k]; !ok {
+			keys = append(keys, k)
+		}
+		means[k] = append(means[k], r.Stats.Mean)
+		p95s[k] = append(p95s[k], r.Stats.P95)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].rows < keys[j].rows })
+
+	table := newConsoleTable("Rows", "Engine", "Results", "Mean (geo)", "p95 (geo)")
+	for _, k := range keys {
+		table.addRow(fmt.Sprintf("%d", k.rows), engineLabel(k.engine, targets), fmt.Sprintf("%d", len(means[k])),
+			formatDuration(time.Duration(geoMean(means[k]))), formatDuration(time.Duration(geoMean(p95s[k]))))
+	}
+	fmt.Fprintln(progressOut, "\nLATENCY BY CORPUS SIZE:")
+	table.render(progressOut)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// cancelTimeout is the statement_timeout the cancel scenario sets
+	cancelTimeout = 100 * time.Millisecond
+	// cancelRounds is how many times each expensive query is cancelled
+	cancelRounds = 5
+	// cancelWorkload has the expensive queries the cancel scenario cancels
+	cancelWorkload = "workloads/expensive.yaml"
+)
+
+// probeRuns is how many times the probe query is timed before and after
+// each cancellation.
+const probeRuns = 3
+
+// queryCanceled is the SQLSTATE of a statement cancelled by
+// statement_timeout or a cancel request.
+const queryCanceled = "57014"
+
+// cancelCell collects the rounds of one expensive query on one engine.
+type cancelCell struct {
+	cancelled []time.Duration
+	completed int
+	before    []time.Duration
+	after     []time.Duration
+	problems  []string
+}
+
+// connQuery runs a query on a dedicated connection and drains its rows.
+func connQuery(conn *sql.Conn, query string, args []interface{}) (time.Duration, error) {
+	start := time.Now()
+	rows, err := conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return time.Since(start), err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return time.Since(start), rows.Err()
+}
+
+// probe times the probe query a few times on conn.
+func probe(conn *sql.Conn, query string, args []interface{}) ([]time.Duration, error) {
+	var samples []time.Duration
+	for i := 0; i < probeRuns; i++ {
+		d, err := connQuery(conn, query, args)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, d)
+	}
+	return samples, nil
+}
+
+// cancelRound runs one expensive query under statement_timeout on its own
+// connection, between probes, and records how long the cancellation took
+// and whether the backend stayed usable.
+func cancelRound(db *sql.DB, c *cancelCell, stmt string, args []interface{}, probeStmt string, probeArgs []interface{}) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	before, err := probe(conn, probeStmt, probeArgs)
+	if err != nil {
+		return fmt.Errorf("probe before cancelling: %v", err)
+	}
+	c.before = append(c.before, before...)
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET statement_timeout = %d", cancelTimeout.Milliseconds())); err != nil {
+		return err
+	}
+	elapsed, err := connQuery(conn, stmt, args)
+	switch {
+	case err == nil:
+		c.completed++
+	case sqlState(err) == queryCanceled:
+		c.cancelled = append(c.cancelled, elapsed)
+	default:
+		return fmt.Errorf("expected a cancellation, got: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "RESET statement_timeout"); err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("backend unusable after cancel: %v", err))
+		return nil
+	}
+
+	after, err := probe(conn, probeStmt, probeArgs)
+	if err != nil {
+		c.problems = append(c.problems, fmt.Sprintf("probe after cancel failed: %v", err))
+		return nil
+	}
+	c.after = append(c.after, after...)
+	return nil
+}
+
+// runCancelScenario sets an aggressive -cancel-timeout and runs the
+// deliberately expensive queries of -cancel-workload on every engine,
+// -cancel-rounds times each. Each round uses a fresh connection: the first
+// query of the main workload is timed as a probe, the expensive query is
+// cancelled by statement_timeout, and the probe is timed again on the same
+// backend. It reports how long past the timeout each engine took to cancel
+// and whether the probe slowed down afterwards, and fails if a backend was
+// unusable after a cancellation.
+func runCancelScenario(st *runState) error {
+	if cancelTimeout <= 0 || cancelRounds < 1 {
+		return fmt.Errorf("-cancel-timeout and -cancel-rounds must be positive")
+	}
+	w, err := loadWorkload(cancelWorkload)
+	if err != nil {
+		return err
+	}
+	base, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	if len(base.Queries) == 0 || len(base.Queries[0].Args) == 0 {
+		return fmt.Errorf("%s has no query to probe the backends with", base.Name)
+	}
+	probeQuery := base.Queries[0]
+	probeArgs := probeQuery.Args[0]
+
+	var results []manifest.QueryResult
+	var failures []string
+	table := newConsoleTable("Query", "Args", "Engine", "Cancelled", "Time to cancel", "Overshoot", "Probe before", "Probe after", "Change")
+	for _, q := range w.Queries {
+		for _, args := range q.Args {
+			for _, t := range st.Targets {
+				stmt, ok := q.SQL[t.sqlName()]
+				probeStmt, probeOK := probeQuery.SQL[t.sqlName()]
+				if !ok || !probeOK {
+					continue
+				}
+				c := &cancelCell{}
+				for round := 0; round < cancelRounds; round++ {
+					err := cancelRound(t.DB, c, stmt, q.bind(t.sqlName(), args),
+						probeStmt, probeQuery.bind(t.sqlName(), probeArgs))
+					if err != nil {
+						c.problems = append(c.problems, err.Error())
+						break
+					}
+				}
+				cancelled := summarize(c.cancelled)
+				before, after := summarize(c.before), summarize(c.after)
+				if c.completed > 0 {
+					warnf("%s: Warning: %s %s finished within %v in %d of %d rounds; it is not expensive enough at this data size",
+						t.Label, q.Name, argsLabel(args), cancelTimeout, c.completed, cancelRounds)
+				}
+				for _, p := range c.problems {
+					failures = append(failures, fmt.Sprintf("%s %s [%s]: %s", q.Name, argsLabel(args), t.Name, p))
+				}
+
+				timeToCancel, overshoot, change := "-", "-", "-"
+				if cancelled.Count > 0 {
+					timeToCancel = formatDuration(cancelled.P50)
+					overshoot = formatDuration(cancelled.Max - cancelTimeout)
+				}
+				if before.Mean > 0 && after.Count > 0 {
+					change = fmt.Sprintf("%+.1f%%", (float64(after.Mean)/float64(before.Mean)-1)*100)
+				}
+				table.addRow(q.Name, argsLabel(args), t.Label, fmt.Sprintf("%d/%d", cancelled.Count, cancelRounds),
+					timeToCancel, overshoot, formatDuration(before.Mean), formatDuration(after.Mean), change)
+
+				res := manifest.QueryResult{Query: q.Name + "/cancel", Category: "cancel", Engine: t.Name, Args: args, Rows: c.completed, Stats: cancelled}
+				if len(c.problems) > 0 {
+					res.Error = c.problems[0]
+				}
+				results = append(results, res,
+					manifest.QueryResult{Query: q.Name + "/probe-before", Category: "cancel", Engine: t.Name, Args: args, Stats: before},
+					manifest.QueryResult{Query: q.Name + "/probe-after", Category: "cancel", Engine: t.Name, Args: args, Stats: after})
+			}
+		}
+	}
+	fmt.Fprintf(progressOut, "\nCANCELLATION UNDER statement_timeout = %v (%s probed before and after):\n", cancelTimeout, probeQuery.Name)
+	table.render(progressOut)
+
+	if err := saveResults(dataDir, "cancel", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		for _, f := range failures {
+			errorf("❌ %s", f)
+		}
+		return fmt.Errorf("%d cancellation problem(s)", len(failures))
+	}
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"pg_search_benchmark/manifest"
+)
+
+// CodeTable holds the posts the code scenario searches.
+const CodeTable = "posts"
+
+var (
+	// codeDump is a StackExchange Posts.xml or a Hacker News JSON lines
+	// export, optionally gzipped
+	codeDump = ""
+	// codeRows is how many posts are loaded from it
+	codeRows = 100000
+	// codeWorkload is timed by the code scenario against CodeTable
+	codeWorkload = "workloads/code.yaml"
+)
+
+// codePost is a question, answer, story or comment, reduced to what both
+// dumps have.
+type codePost struct {
+	ID    int64
+	Title string
+	Body  string
+	Tags  []string
+}
+
+// htmlTag matches the markup the dumps keep in post bodies; the code inside
+// <code> and <pre> is kept as text.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// postText turns a post body into the plain text an application would index.
+func postText(body string) string {
+	return strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(body, " ")))
+}
+
+// postTags splits StackExchange tags, written <c++><stl> in older dumps and
+// |c++|stl| in newer ones.
+func postTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool { return r == '<' || r == '>' || r == '|' })
+}
+
+// openDump opens a dump, decompressing it when it ends in .gz. A URL is
+// read as it downloads (see -stream).
+func openDump(path string) (io.ReadCloser, error) {
+	var file io.ReadCloser
+	var err error
+	if isRemoteDump(path) {
+		file, err = openRemoteDump(path)
+	} else {
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, file}, nil
+}
+
+// readStackExchangePosts reads the first n questions and answers of a
+// Posts.xml, whose rows carry everything as attributes.
+func readStackExchangePosts(r io.Reader, n int) ([]codePost, error) {
+	type row struct {
+		ID         int64  `xml:"Id,attr"`
+		PostTypeID int    `xml:"PostTypeId,attr"`
+		Title      string `xml:"Title,attr"`
+		Body       string `xml:"Body,attr"`
+		Tags       string `xml:"Tags,attr"`
+	}
+	dec := xml.NewDecoder(r)
+	var posts []codePost
+	for len(posts) < n {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+		var r row
+		if err := dec.DecodeElement(&r, &start); err != nil {
+			return nil, err
+		}
+		// 1 is a question, 2 an answer; the rest are wiki and moderation posts
+		if r.PostTypeID != 1 && r.PostTypeID != 2 {
+			continue
+		}
+		posts = append(posts, codePost{ID: r.ID, Title: r.Title, Body: postText(r.Body), Tags: postTags(r.Tags)})
+	}
+	return posts, nil
+}
+
+// readHackerNewsItems reads the first n stories and comments with text of
+// a JSON lines export of Hacker News items.
+func readHackerNewsItems(r io.Reader, n int) ([]codePost, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	var posts []codePost
+	for lineNo := 1; scanner.Scan() && len(posts) < n; lineNo++ {
+		var item struct {
+			ID    int64  `json:"id"`
+			Title string `json:"title"`
+			Text  string `json:"text"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if item.Title == "" && item.Text == "" {
+			continue
+		}
+		posts = append(posts, codePost{ID: item.ID, Title: item.Title, Body: postText(item.Text)})
+	}
+	return posts, scanner.Err()
+}
+
+// readCodePosts reads -code-dump, telling the formats apart by extension.
+func readCodePosts(path string, n int) ([]codePost, error) {
+	f, err := openDump(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var posts []codePost
+	if strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".xml") {
+		posts, err = readStackExchangePosts(f, n)
+	} else {
+		posts, err = readHackerNewsItems(f, n)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return posts, nil
+}
+
+// codeBM25DDL renders the BM25 index of CodeTable: title and body with the
+// default tokenizer, which splits code tokens on punctuation, and tags kept
+// whole, or with fallback every field with the default tokenizer.
+func codeBM25DDL(fallback bool) string {
+	switch {
+	case fallback && bm25DDL.Name == "procedure":
+		return fmt.Sprintf("CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id', text_fields => paradedb.field('title') || paradedb.field('body') || paradedb.field('tags'))", CodeTable)
+	case fallback:
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, title, body, tags) WITH (key_field='id')", CodeTable)
+	case bm25DDL.Name == "typed":
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, title, body, (tags::pdb.literal)) WITH (key_field='id')", CodeTable)
+	case bm25DDL.Name == "procedure":
+		return fmt.Sprintf("CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id', text_fields => paradedb.field('title') || paradedb.field('body') || paradedb.field('tags', tokenizer => paradedb.tokenizer('raw')))", CodeTable)
+	}
+	return fmt.Sprintf(`CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, title, body, tags)
+		WITH (key_field='id', text_fields='{"tags": {"tokenizer": {"type": "raw"}}}')`, CodeTable)
+}
+
+// loadCodeTable creates CodeTable, loads the posts and builds the engine's
+// indexes: GIN over the english configuration and over the tag array on
+// vanilla, BM25 on ParadeDB.
+func loadCodeTable(t *benchTarget, posts []codePost) error {
+	steps := []string{
+		"DROP TABLE IF EXISTS " + CodeTable,
+		fmt.Sprintf(`CREATE TABLE %s (
+			id BIGINT PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL DEFAULT '',
+			tags TEXT[] NOT NULL DEFAULT '{}'
+		)`, CodeTable),
+	}
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(posts); i += BatchSize {
+		if err := insertCodeBatch(t.DB, posts[i:min(i+BatchSize, len(posts))]); err != nil {
+			return err
+		}
+	}
+
+	if t.IsParadeDB {
+		if _, err := t.DB.Exec(codeBM25DDL(false)); err != nil {
+			warnf("%s: Warning: BM25 index with raw tags on %s failed, using the default tokenizer: %v", t.Label, CodeTable, err)
+			if _, err := t.DB.Exec(codeBM25DDL(true)); err != nil {
+				return fmt.Errorf("could not create BM25 index on %s: %v", CodeTable, diagnoseBuildError(err))
+			}
+		}
+	} else {
+		for _, stmt := range []string{
+			fmt.Sprintf("CREATE INDEX %[1]s_fulltext ON %[1]s USING gin (to_tsvector('english', title || ' ' || body))", CodeTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_tags ON %[1]s USING gin (tags)", CodeTable),
+		} {
+			if _, err := t.DB.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := t.DB.Exec("ANALYZE " + CodeTable)
+	return err
+}
+
+func insertCodeBatch(db *sql.DB, posts []codePost) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (id, title, body, tags) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO NOTHING", CodeTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range posts {
+		// A nil slice would be bound as NULL
+		tags := append([]string{}, p.Tags...)
+		if _, err := stmt.Exec(p.ID, p.Title, p.Body, pq.Array(tags)); err != nil {
+			return fmt.Errorf("post %d: %v", p.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// runCodeScenario loads the first -code-rows posts of a StackExchange or
+// Hacker News dump into CodeTable and times workloads/code.yaml, whose
+// code tokens (std::vector, __init__, NullPointerException) stress the
+// tokenizers very differently from product text.
+func runCodeScenario(st *runState) error {
+	if codeRows < 1 {
+		return fmt.Errorf("-code-rows must be at least 1")
+	}
+	w, err := loadWorkload(codeWorkload)
+	if err != nil {
+		return err
+	}
+
+	var posts []codePost
+	if !fakeEngines {
+		if codeDump == "" {
+			return fmt.Errorf("the code scenario needs -code-dump: a StackExchange Posts.xml or a Hacker News items .jsonl, optionally gzipped")
+		}
+		if posts, err = readCodePosts(codeDump, codeRows); err != nil {
+			return err
+		}
+		infof("Read %d posts from %s", len(posts), filepath.Base(codeDump))
+	}
+	for _, t := range st.Targets {
+		start := time.Now()
+		if err := loadCodeTable(t, posts); err != nil {
+			return fmt.Errorf("%s: %s: %v", t.Label, CodeTable, err)
+		}
+		infof("%s: Loaded and indexed %s in %v", t.Label, CodeTable, time.Since(start).Round(time.Millisecond))
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "code", manifest.KindQueries, st.Targets, results)
+}
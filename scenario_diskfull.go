@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ory/dockertest/v3/docker"
+)
+
+var (
+	// diskFullRows is how many synthetic products the disk-full scenario
+	// loads before building the indexes
+	diskFullRows = 20000
+	// diskFullHeadroom is the free space left for the index build, as a
+	// fraction of the table's size; every engine's indexes need more
+	diskFullHeadroom = 0.5
+)
+
+// Throwaway data directories of the disk-full scenario live on a tmpfs of
+// this size, so filling them never touches a real volume.
+const (
+	diskFullVolume = "512m"
+	pgData         = "/var/lib/postgresql/data"
+	fillerFile     = pgData + "/pgsb_filler"
+)
+
+// diskFullOutcome is what running out of disk space during the index build
+// did to one engine.
+type diskFullOutcome struct {
+	Failed    string
+	DiskFull  bool
+	Diagnosis string
+	Invalid   []string
+	Healthy   bool
+	Rebuilt   bool
+	Problems  []string
+}
+
+// containerFreeBytes reads the free space of the data directory inside a
+// container.
+func containerFreeBytes(id string) (int64, error) {
+	out, err := exec.Command("docker", "exec", id, "df", "-B1", "--output=avail", pgData).Output()
+	if err != nil {
+		return 0, fmt.Errorf("df: %v", commandError(err))
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected df output %q", out)
+	}
+	return strconv.ParseInt(fields[len(fields)-1], 10, 64)
+}
+
+// waitHealthy polls until the server answers a query again, which after a
+// PANIC on a failed WAL write includes crash recovery.
+func waitHealthy(db *sql.DB) bool {
+	deadline := time.Now().Add(ephemeralStartTimeout)
+	for {
+		var one int
+		if err := db.QueryRow("SELECT 1").Scan(&one); err == nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// buildIndexes runs the index DDL of a target and returns the statement
+// that failed with its error, if any.
+func buildIndexes(t *benchTarget) (string, error) {
+	for _, stmt := range t.indexDDL() {
+		if _, err := t.DB.Exec(productsSQL(stmt)); err != nil {
+			return stmt, err
+		}
+	}
+	return "", nil
+}
+
+// fillDisk loads synthetic products into a fresh container, then fills its
+// data directory until only the headroom is left, and builds the indexes
+// into the rest. It checks that the build fails with a disk-full error,
+// that the server stays up, what the failure left behind, and that the
+// indexes build once the space is freed again.
+func fillDisk(t *benchTarget, c *ephemeralContainer, db *sql.DB) (diskFullOutcome, error) {
+	var out diskFullOutcome
+	// The throwaway container stands in for the target
+	scratch := *t
+	scratch.DB = db
+	if err := setupTables(&scratch); err != nil {
+		return out, err
+	}
+	faker := seededFaker("disk-full", 0)
+	for loaded := 0; loaded < diskFullRows; loaded += BatchSize {
+		n := BatchSize
+		if diskFullRows-loaded < n {
+			n = diskFullRows - loaded
+		}
+		if err := insertBatch(db, syntheticProducts(faker, n)); err != nil {
+			return out, err
+		}
+	}
+	if _, err := db.Exec("ALTER TABLE " + productsTable + " SET LOGGED"); err != nil {
+		return out, err
+	}
+	if _, err := db.Exec("CHECKPOINT"); err != nil {
+		return out, err
+	}
+
+	var tableBytes int64
+	if err := db.QueryRow("SELECT pg_total_relation_size($1)", productsTable).Scan(&tableBytes); err != nil {
+		return out, err
+	}
+	free, err := containerFreeBytes(c.ID)
+	if err != nil {
+		return out, err
+	}
+	headroom := int64(float64(tableBytes) * diskFullHeadroom)
+	if free <= headroom {
+		return out, fmt.Errorf("only %s free for a %s table, raise the tmpfs size", formatBytes(uint64(free)), formatBytes(uint64(tableBytes)))
+	}
+	fill := strconv.FormatInt(free-headroom, 10)
+	if err := exec.Command("docker", "exec", c.ID, "fallocate", "-l", fill, fillerFile).Run(); err != nil {
+		return out, fmt.Errorf("could not fill the data directory: %v", commandError(err))
+	}
+	infof("%s: %s table, %s left free for the index build", t.Label, formatBytes(uint64(tableBytes)), formatBytes(uint64(headroom)))
+
+	stmt, buildErr := buildIndexes(&scratch)
+	out.Healthy = waitHealthy(db)
+	if out.Healthy {
+		if invalid, err := invalidIndexes(db); err == nil {
+			out.Invalid = invalid
+		}
+	}
+	switch {
+	case buildErr == nil:
+		out.Problems = append(out.Problems, fmt.Sprintf("the indexes fit in %s; lower -disk-full-headroom", formatBytes(uint64(headroom))))
+	case isDiskFull(buildErr):
+		out.Failed, out.DiskFull = stmt, true
+		out.Diagnosis = diagnoseBuildError(buildErr).Error()
+	default:
+		out.Failed = stmt
+		out.Diagnosis = buildErr.Error()
+		out.Problems = append(out.Problems, "the build failed without saying the disk is full: "+buildErr.Error())
+	}
+	if !out.Healthy {
+		out.Problems = append(out.Problems, "the server did not answer again after the build failed")
+		return out, nil
+	}
+
+	if err := exec.Command("docker", "exec", c.ID, "rm", "-f", fillerFile).Run(); err != nil {
+		return out, fmt.Errorf("could not free the data directory: %v", commandError(err))
+	}
+	if _, err := dropInvalidIndexes(db); err != nil {
+		out.Problems = append(out.Problems, err.Error())
+		return out, nil
+	}
+	if stmt, err := buildIndexes(&scratch); err != nil {
+		out.Problems = append(out.Problems, fmt.Sprintf("rebuild after freeing space failed at %q: %v", stmt, err))
+		return out, nil
+	}
+	out.Rebuilt = true
+	return out, nil
+}
+
+// runDiskFullScenario starts a throwaway container per engine with its
+// data directory on a small tmpfs, and lets the index build run out of
+// disk space there. The real targets are not touched. It fails if an
+// engine's build fails without a disk-full error, the server does not
+// recover, or the indexes cannot be rebuilt once space is freed; invalid
+// indexes left behind are reported, and the index scenario drops them
+// before building.
+func runDiskFullScenario(st *runState) error {
+	if fakeEngines {
+		return fmt.Errorf("disk-full starts its own containers, so it can't run with -fake")
+	}
+	if diskFullRows < 1 || diskFullHeadroom <= 0 {
+		return fmt.Errorf("-disk-full-rows and -disk-full-headroom must be positive")
+	}
+
+	table := newConsoleTable("Engine", "Failed at", "Disk full", "Invalid indexes left", "Healthy", "Rebuilt after freeing")
+	var diagnoses, failures []string
+	for _, t := range st.Targets {
+		image := vanillaImage
+		if t.IsParadeDB {
+			image = paradeImage
+		}
+		config := t.Config
+		c, err := startEphemeral(image, &config, func(hc *docker.HostConfig) {
+			hc.Tmpfs = map[string]string{pgData: "rw,size=" + diskFullVolume}
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		db, err := getDB(config)
+		if err != nil {
+			c.stop()
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		out, err := fillDisk(t, c, db)
+		db.Close()
+		c.stop()
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+
+		failed, invalid, full := "-", "none", "-"
+		if out.Failed != "" {
+			failed = out.Failed
+			if len(failed) > 60 {
+				failed = failed[:57] + "..."
+			}
+			full = fmt.Sprint(out.DiskFull)
+			diagnoses = append(diagnoses, fmt.Sprintf("%s: %s", t.Label, out.Diagnosis))
+		}
+		if len(out.Invalid) > 0 {
+			invalid = strings.Join(out.Invalid, ", ")
+		}
+		table.addRow(t.Label, failed, full, invalid, fmt.Sprint(out.Healthy), fmt.Sprint(out.Rebuilt))
+		for _, p := range out.Problems {
+			failures = append(failures, fmt.Sprintf("%s: %s", t.Label, p))
+		}
+	}
+	fmt.Fprintln(progressOut, "\nINDEX BUILD ON A FULL DISK:")
+	table.render(progressOut)
+	for _, d := range diagnoses {
+		fmt.Fprintln(progressOut, d)
+	}
+
+	if len(failures) > 0 {
+		for _, f := range failures {
+			errorf("❌ %s", f)
+		}
+		return fmt.Errorf("%d engine(s) did not fail cleanly on a full disk", len(failures))
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"archive/tar"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"pg_search_benchmark/manifest"
+)
+
+// EmailTable holds the messages the email scenario searches.
+const EmailTable = "emails"
+
+var (
+	// emailDump is the Enron corpus: the CMU maildir tarball, the maildir
+	// extracted from it or the emails.csv published on Kaggle
+	emailDump = ""
+	// emailRows is how many messages are loaded from it
+	emailRows = 100000
+	// emailWorkload is timed by the email scenario against EmailTable
+	emailWorkload = "workloads/enron.yaml"
+)
+
+// email is one message, reduced to the fields e-discovery filters on.
+type email struct {
+	MessageID  string
+	Folder     string
+	SentAt     time.Time
+	Sender     string
+	Recipients []string
+	Subject    string
+	Body       string
+}
+
+// emailText drops what PostgreSQL rejects in TEXT: NUL bytes and invalid
+// UTF-8, which the corpus has in a few forwarded attachments.
+func emailText(s string) string {
+	return strings.ToValidUTF8(strings.ReplaceAll(s, "\x00", ""), "")
+}
+
+// emailAddresses splits a To, Cc or Bcc header. The corpus has many
+// addresses net/mail rejects, so they are only split on commas.
+func emailAddresses(headers ...string) []string {
+	var addrs []string
+	for _, h := range headers {
+		for _, a := range strings.Split(h, ",") {
+			if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return addrs
+}
+
+// parseEmail parses one RFC 822 message of the folder (the mailbox path,
+// such as kaminski-v/inbox).
+func parseEmail(folder string, r io.Reader) (email, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return email{}, err
+	}
+	body, err := io.ReadAll(msg.Body)
+	if err != nil {
+		return email{}, err
+	}
+	sentAt, err := mail.ParseDate(msg.Header.Get("Date"))
+	if err != nil {
+		return email{}, fmt.Errorf("date: %v", err)
+	}
+	return email{
+		MessageID:  msg.Header.Get("Message-ID"),
+		Folder:     emailText(folder),
+		SentAt:     sentAt,
+		Sender:     emailText(strings.ToLower(strings.TrimSpace(msg.Header.Get("From")))),
+		Recipients: emailAddresses(emailText(msg.Header.Get("To")), emailText(msg.Header.Get("Cc")), emailText(msg.Header.Get("Bcc"))),
+		Subject:    emailText(msg.Header.Get("Subject")),
+		Body:       emailText(string(body)),
+	}, nil
+}
+
+// emailReader collects up to n parsed messages, counting the ones it skips.
+type emailReader struct {
+	n       int
+	emails  []email
+	skipped int
+}
+
+func (er *emailReader) full() bool { return len(er.emails) >= er.n }
+
+// add parses the message at path, a file of the maildir: its folder is the
+// path without the file name.
+func (er *emailReader) add(path string, r io.Reader) {
+	e, err := parseEmail(filepath.ToSlash(filepath.Dir(path)), r)
+	if err != nil {
+		er.skipped++
+		return
+	}
+	er.emails = append(er.emails, e)
+}
+
+// readTar reads the messages of the maildir tarball, optionally gzipped.
+func (er *emailReader) readTar(path string) error {
+	f, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for !er.full() {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			er.add(strings.TrimPrefix(hdr.Name, "maildir/"), tr)
+		}
+	}
+	return nil
+}
+
+// readDir reads the messages of an extracted maildir.
+func (er *emailReader) readDir(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if er.full() {
+			return filepath.SkipAll
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rel, _ := filepath.Rel(root, path)
+		er.add(rel, f)
+		return nil
+	})
+}
+
+// readCSV reads the emails.csv of Kaggle, whose rows are the maildir path
+// and the raw message.
+func (er *emailReader) readCSV(path string) error {
+	f, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.ReuseRecord = true
+	if _, err := r.Read(); err != nil {
+		return err
+	}
+	for !er.full() {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		er.add(rec[0], strings.NewReader(rec[1]))
+	}
+	return nil
+}
+
+// readEmails reads up to n messages of -email-dump, telling the formats
+// apart by extension.
+func readEmails(path string, n int) ([]email, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	er := &emailReader{n: n}
+	name := strings.TrimSuffix(path, ".gz")
+	switch {
+	case info.IsDir():
+		err = er.readDir(path)
+	case strings.HasSuffix(name, ".csv"):
+		err = er.readCSV(path)
+	case strings.HasSuffix(name, ".tar") || strings.HasSuffix(path, ".tgz"):
+		err = er.readTar(path)
+	default:
+		return nil, fmt.Errorf("%s: expected the maildir, its .tar.gz or emails.csv", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	if er.skipped > 0 {
+		warnf("Warning: Skipped %d messages of %s that could not be parsed", er.skipped, path)
+	}
+	return er.emails, nil
+}
+
+// emailBM25DDL renders the BM25 index of EmailTable: subject and body with
+// the default tokenizer, the addresses kept whole and the date, or with
+// fallback every column with the defaults.
+func emailBM25DDL(fallback bool) string {
+	switch {
+	case fallback && bm25DDL.Name == "procedure":
+		return fmt.Sprintf("CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id', text_fields => paradedb.field('subject') || paradedb.field('body') || paradedb.field('sender') || paradedb.field('recipients'), datetime_fields => paradedb.field('sent_at'))", EmailTable)
+	case fallback:
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, subject, body, sender, recipients, sent_at) WITH (key_field='id')", EmailTable)
+	case bm25DDL.Name == "typed":
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, subject, body, (sender::pdb.literal), (recipients::pdb.literal), sent_at) WITH (key_field='id')", EmailTable)
+	case bm25DDL.Name == "procedure":
+		return fmt.Sprintf(`CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id',
+			text_fields => paradedb.field('subject') || paradedb.field('body') || paradedb.field('sender', tokenizer => paradedb.tokenizer('raw')) || paradedb.field('recipients', tokenizer => paradedb.tokenizer('raw')),
+			datetime_fields => paradedb.field('sent_at'))`, EmailTable)
+	}
+	return fmt.Sprintf(`CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, subject, body, sender, recipients, sent_at)
+		WITH (key_field='id', text_fields='{"sender": {"tokenizer": {"type": "raw"}}, "recipients": {"tokenizer": {"type": "raw"}}}')`, EmailTable)
+}
+
+// loadEmailTable creates EmailTable, loads the messages and builds the
+// engine's indexes: GIN over the english configuration and over the
+// recipients and B-trees on the sender and date on vanilla, BM25 on ParadeDB.
+func loadEmailTable(t *benchTarget, emails []email) error {
+	steps := []string{
+		"DROP TABLE IF EXISTS " + EmailTable,
+		fmt.Sprintf(`CREATE TABLE %s (
+			id BIGSERIAL PRIMARY KEY,
+			message_id TEXT,
+			folder TEXT NOT NULL,
+			sent_at TIMESTAMPTZ NOT NULL,
+			sender TEXT NOT NULL,
+			recipients TEXT[] NOT NULL DEFAULT '{}',
+			subject TEXT NOT NULL DEFAULT '',
+			body TEXT NOT NULL DEFAULT ''
+		)`, EmailTable),
+	}
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(emails); i += BatchSize {
+		if err := insertEmailBatch(t.DB, emails[i:min(i+BatchSize, len(emails))]); err != nil {
+			return err
+		}
+	}
+
+	if t.IsParadeDB {
+		if _, err := t.DB.Exec(emailBM25DDL(false)); err != nil {
+			warnf("%s: Warning: BM25 index with raw addresses on %s failed, using the default tokenizer: %v", t.Label, EmailTable, err)
+			if _, err := t.DB.Exec(emailBM25DDL(true)); err != nil {
+				return fmt.Errorf("could not create BM25 index on %s: %v", EmailTable, diagnoseBuildError(err))
+			}
+		}
+	} else {
+		for _, stmt := range []string{
+			fmt.Sprintf("CREATE INDEX %[1]s_fulltext ON %[1]s USING gin (to_tsvector('english', subject || ' ' || body))", EmailTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_sender ON %[1]s (sender)", EmailTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_recipients ON %[1]s USING gin (recipients)", EmailTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_sent_at ON %[1]s (sent_at)", EmailTable),
+		} {
+			if _, err := t.DB.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := t.DB.Exec("ANALYZE " + EmailTable)
+	return err
+}
+
+func insertEmailBatch(db *sql.DB, emails []email) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+		INSERT INTO %s (message_id, folder, sent_at, sender, recipients, subject, body)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7)
+	`, EmailTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range emails {
+		// A nil slice would be bound as NULL
+		recipients := append([]string{}, e.Recipients...)
+		if _, err := stmt.Exec(e.MessageID, e.Folder, e.SentAt, e.Sender, pq.Array(recipients), e.Subject, e.Body); err != nil {
+			return fmt.Errorf("message %s: %v", e.MessageID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// runEmailScenario loads the first -email-rows messages of the Enron corpus
+// into EmailTable and times workloads/enron.yaml, which combines sender,
+// recipient and date filters with body search the way e-discovery and
+// document search do.
+func runEmailScenario(st *runState) error {
+	if emailRows < 1 {
+		return fmt.Errorf("-email-rows must be at least 1")
+	}
+	w, err := loadWorkload(emailWorkload)
+	if err != nil {
+		return err
+	}
+
+	var emails []email
+	if !fakeEngines {
+		if emailDump == "" {
+			return fmt.Errorf("the email scenario needs -email-dump: the Enron maildir, its .tar.gz or emails.csv")
+		}
+		if emails, err = readEmails(emailDump, emailRows); err != nil {
+			return err
+		}
+		infof("Read %d messages from %s", len(emails), filepath.Base(emailDump))
+	}
+	for _, t := range st.Targets {
+		start := time.Now()
+		if err := loadEmailTable(t, emails); err != nil {
+			return fmt.Errorf("%s: %s: %v", t.Label, EmailTable, err)
+		}
+		infof("%s: Loaded and indexed %s in %v", t.Label, EmailTable, time.Since(start).Round(time.Millisecond))
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "email", manifest.KindQueries, st.Targets, results)
+}
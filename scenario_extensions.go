@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"pg_search_benchmark/manifest"
+)
+
+// coexistExtensions are the extensions the extensions scenario installs
+// next to pg_search on the ParadeDB target.
+var coexistExtensions = "pg_trgm,vector,postgis,pg_stat_statements,pg_cron"
+
+// extensionStatus is what installing one extension next to pg_search did.
+type extensionStatus struct {
+	Name      string
+	Version   string
+	Preloaded bool
+	// Created is set when the scenario created the extension, so it drops
+	// it again afterwards
+	Created bool
+	Problem string
+}
+
+// isPreloaded reports whether a shared_preload_libraries value lists lib.
+func isPreloaded(preload, lib string) bool {
+	for _, l := range strings.Split(preload, ",") {
+		if strings.Trim(strings.TrimSpace(l), `"`) == lib {
+			return true
+		}
+	}
+	return false
+}
+
+// installExtension creates an extension unless it is already installed.
+// An extension the server doesn't ship, or that refuses to be created (such
+// as pg_cron outside shared_preload_libraries), is a problem to report, not
+// a failure of the scenario.
+func installExtension(db *sql.DB, name, preload string) extensionStatus {
+	s := extensionStatus{Name: name, Preloaded: isPreloaded(preload, name)}
+	available, installed, err := catalogOf(db).extensionVersions(name)
+	if err == sql.ErrNoRows {
+		s.Problem = "not available on this server"
+		return s
+	}
+	if err != nil {
+		s.Problem = err.Error()
+		return s
+	}
+	if installed.Valid {
+		s.Version = installed.String
+		return s
+	}
+	if _, err := db.Exec("CREATE EXTENSION IF NOT EXISTS " + pq.QuoteIdentifier(name)); err != nil {
+		s.Problem = err.Error()
+		return s
+	}
+	s.Version, s.Created = available.String, true
+	return s
+}
+
+// runExtensionsScenario times the workload on the ParadeDB target, installs
+// the -extensions next to pg_search, then checks the plans and times the
+// workload again. Extensions that hook the planner or executor only do so
+// when preloaded, which the table shows. It fails if a query errors or a
+// plan assertion breaks only once the extensions are installed; latency
+// changes are reported, not judged. Extensions the scenario created are
+// dropped again.
+func runExtensionsScenario(st *runState) error {
+	var parade *benchTarget
+	for _, t := range st.Targets {
+		if t.IsParadeDB {
+			parade = t
+		}
+	}
+	if parade == nil {
+		return fmt.Errorf("no ParadeDB target to install extensions on")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	targets := []*benchTarget{parade}
+
+	infof("%s: workload with pg_search alone", parade.Label)
+	baseProblems, err := checkPlansOrFail(w, targets)
+	if err != nil {
+		return err
+	}
+	baseline := runProfiledWorkload(w, targets, st.sampling(), baseProblems)
+
+	preload, err := catalogOf(parade.DB).preloadLibraries()
+	if err != nil {
+		return fmt.Errorf("%s: could not read shared_preload_libraries: %v", parade.Label, err)
+	}
+	var installed []extensionStatus
+	for _, name := range strings.Split(coexistExtensions, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			installed = append(installed, installExtension(parade.DB, name, preload))
+		}
+	}
+	defer func() {
+		for i := len(installed) - 1; i >= 0; i-- {
+			if !installed[i].Created {
+				continue
+			}
+			if _, err := parade.DB.Exec("DROP EXTENSION IF EXISTS " + pq.QuoteIdentifier(installed[i].Name)); err != nil {
+				warnf("%s: Warning: Could not drop extension %s: %v", parade.Label, installed[i].Name, err)
+			}
+		}
+	}()
+
+	infof("%s: workload with the extensions installed", parade.Label)
+	extProblems := checkWorkloadPlans(w, targets)
+	with := runProfiledWorkload(w, targets, st.sampling(), extProblems)
+
+	extTable := newConsoleTable("Extension", "Version", "Preloaded", "Status")
+	for _, s := range installed {
+		status := "installed"
+		switch {
+		case s.Problem != "":
+			status = "skipped: " + s.Problem
+		case !s.Created:
+			status = "already installed"
+		}
+		preloaded := "no"
+		if s.Preloaded {
+			preloaded = "yes"
+		}
+		version := s.Version
+		if version == "" {
+			version = "-"
+		}
+		extTable.addRow(s.Name, version, preloaded, status)
+	}
+	fmt.Fprintln(progressOut, "\nEXTENSIONS NEXT TO PG_SEARCH:")
+	extTable.render(progressOut)
+
+	var failures []string
+	for key, problems := range extProblems {
+		if len(baseProblems[key]) == 0 {
+			for _, p := range problems {
+				failures = append(failures, fmt.Sprintf("%s: %s", key, p))
+			}
+		}
+	}
+	baseByCell := map[string]manifest.QueryResult{}
+	for _, r := range baseline {
+		baseByCell[fmt.Sprintf("%s/%v", r.Query, r.Args)] = r
+	}
+	table := newConsoleTable("Query", "Args", "pg_search alone", "With extensions", "Change")
+	var results []manifest.QueryResult
+	var baseTotal, withTotal float64
+	for _, r := range with {
+		b, ok := baseByCell[fmt.Sprintf("%s/%v", r.Query, r.Args)]
+		if !ok {
+			continue
+		}
+		if r.Error != "" && b.Error == "" {
+			failures = append(failures, fmt.Sprintf("%s %s: %s", r.Query, argsLabel(r.Args), r.Error))
+		}
+		change := "-"
+		if r.Error == "" && b.Error == "" && b.Stats.Mean > 0 {
+			change = fmt.Sprintf("%+.1f%%", (float64(r.Stats.Mean)/float64(b.Stats.Mean)-1)*100)
+			baseTotal += float64(b.Stats.Mean)
+			withTotal += float64(r.Stats.Mean)
+		}
+		table.addRow(r.Query, argsLabel(r.Args), formatDuration(b.Stats.Mean), formatDuration(r.Stats.Mean), change)
+
+		b.Query, r.Query = b.Query+"/pg_search-only", r.Query+"/extensions"
+		results = append(results, b, r)
+	}
+	fmt.Fprintln(progressOut, "\nWORKLOAD WITH AND WITHOUT THE EXTENSIONS:")
+	table.render(progressOut)
+	if baseTotal > 0 {
+		fmt.Fprintf(progressOut, "Overall: %+.1f%% summed mean latency with the extensions installed\n", (withTotal/baseTotal-1)*100)
+	}
+
+	if err := saveResults(dataDir, "extensions", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		for _, f := range failures {
+			errorf("❌ %s", f)
+		}
+		return fmt.Errorf("%d problem(s) appeared only with the extensions installed", len(failures))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// Searchability probe settings for the lag scenario.
+var (
+	// lagWriteRates are the background insert rates (rows/sec) the probe
+	// runs under, one round each.
+	lagWriteRates = "0,200,1000"
+	lagProbes     = 50
+	lagInterval   = 200 * time.Millisecond
+	// lagTimeout gives up on a sentinel that never becomes findable
+	lagTimeout = 30 * time.Second
+)
+
+// lagPollEvery is how often a sentinel is searched for until it is found.
+const lagPollEvery = 2 * time.Millisecond
+
+// lagSearchSQL finds a sentinel through each engine's search index, the path
+// real searches take, rather than by key.
+var lagSearchSQL = map[string]string{
+	"vanilla": `SELECT id FROM products
+		WHERE to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, ''))
+		      @@ plainto_tsquery('english', $1)`,
+	"parade": `SELECT id FROM products WHERE id @@@ paradedb.match(field => 'title', value => $1)`,
+}
+
+// parseWriteRates reads a comma-separated list of rows/sec.
+func parseWriteRates(spec string) ([]int, error) {
+	var rates []int
+	for _, part := range strings.Split(spec, ",") {
+		rate, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid write rate %q", part)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// sentinelToken is a word no real product contains and that no stemmer
+// changes, so a hit can only be the sentinel.
+func sentinelToken(rng *rand.Rand) string {
+	const letters = "bcdfghjklmnpqrstvwxz"
+	b := []byte("pgsbprobe")
+	for i := 0; i < 10; i++ {
+		b = append(b, letters[rng.Intn(len(letters))])
+	}
+	return string(b)
+}
+
+// probeSearchability inserts one sentinel and searches for it until it is
+// found, returning the time from issuing the insert to the first hit.
+func probeSearchability(t *benchTarget, stmt, token string, n int) (time.Duration, error) {
+	p := Product{
+		ASIN:  fmt.Sprintf("%sP%06d", syntheticASINPrefix, n),
+		Title: "Searchability probe " + token,
+		Brand: "probe",
+		Price: "0",
+	}
+	start := time.Now()
+	if err := insertBatch(t.DB, []Product{p}); err != nil {
+		return 0, err
+	}
+	for time.Since(start) < lagTimeout {
+		_, rows, err := timeQuery(t.DB, stmt, []interface{}{token})
+		if err != nil {
+			return 0, err
+		}
+		if rows > 0 {
+			return time.Since(start), nil
+		}
+		time.Sleep(lagPollEvery)
+	}
+	return 0, fmt.Errorf("not findable after %v", lagTimeout)
+}
+
+// runLagScenario measures how long inserted documents take to become
+// findable through search on each engine, at each background write rate.
+// This exposes deferred index maintenance such as GIN pending lists or
+// BM25 segment commits.
+func runLagScenario(st *runState) error {
+	rates, err := parseWriteRates(lagWriteRates)
+	if err != nil {
+		return err
+	}
+	for _, t := range st.Targets {
+		if _, ok := lagSearchSQL[t.sqlName()]; !ok {
+			return fmt.Errorf("no sentinel search for engine %s", t.sqlName())
+		}
+	}
+
+	var results []manifest.QueryResult
+	table := newConsoleTable("Write rate", "Engine", "Found", "Mean", "p50", "p95", "Max")
+	probe := 0
+	for r, rate := range rates {
+		infof("Probing %d sentinel(s) every %v at %d rows/sec of background writes", lagProbes, lagInterval, rate)
+		stop := make(chan struct{})
+		var writers sync.WaitGroup
+		for _, t := range st.Targets {
+			writers.Add(1)
+			go func(t *benchTarget) {
+				defer writers.Done()
+				backgroundWriter(t.DB, rate, int64(r), stop)
+			}(t)
+		}
+
+		lags := make([][]time.Duration, len(st.Targets))
+		failures := make([]int, len(st.Targets))
+		rng := seededRand("lag", int64(rate))
+		for i := 0; i < lagProbes; i++ {
+			token := sentinelToken(rng)
+			probe++
+			var wg sync.WaitGroup
+			for j, t := range st.Targets {
+				wg.Add(1)
+				go func(j int, t *benchTarget) {
+					defer wg.Done()
+					d, err := probeSearchability(t, productsSQL(lagSearchSQL[t.sqlName()]), token, probe)
+					if err != nil {
+						errorf("%s: Probe %s: %v", t.Label, token, err)
+						failures[j]++
+						return
+					}
+					lags[j] = append(lags[j], d)
+				}(j, t)
+			}
+			wg.Wait()
+			time.Sleep(lagInterval)
+		}
+		close(stop)
+		writers.Wait()
+
+		// Each rate starts from the corpus, not from the rows and probes
+		// the last rate left behind
+		for _, t := range st.Targets {
+			if _, err := t.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%"); err != nil {
+				warnf("%s: Warning: Could not delete the rows written at %d rows/sec: %v", t.Label, rate, err)
+			}
+		}
+
+		for j, t := range st.Targets {
+			stats := summarize(lags[j])
+			res := manifest.QueryResult{
+				Query: "insert-to-searchable", Category: "lag", Engine: t.Name,
+				Args: []interface{}{rate}, Rows: len(lags[j]), Stats: stats,
+			}
+			if failures[j] > 0 {
+				res.Error = fmt.Sprintf("%d of %d sentinel(s) failed or were never found", failures[j], lagProbes)
+			}
+			results = append(results, res)
+			table.addRow(fmt.Sprintf("%d/s", rate), t.Label, fmt.Sprintf("%d/%d", len(lags[j]), lagProbes),
+				formatDuration(stats.Mean), formatDuration(stats.P50), formatDuration(stats.P95), formatDuration(stats.Max))
+		}
+	}
+
+	fmt.Fprintln(progressOut, "\nINSERT → SEARCHABLE LAG:")
+	table.render(progressOut)
+	return saveResults(dataDir, "lag", manifest.KindQueries, st.Targets, results)
+}
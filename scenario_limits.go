@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// sweepLimits are the top-K sizes the limit-sweep scenario times every
+// ranked query at.
+var sweepLimits = "10,100,1000"
+
+// orderByClause marks a ranked statement: one that sorts before limiting.
+var orderByClause = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+
+// parseLimits reads a comma-separated list of positive LIMITs.
+func parseLimits(spec string) ([]int, error) {
+	var limits []int
+	for _, part := range strings.Split(spec, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %q", part)
+		}
+		limits = append(limits, n)
+	}
+	return limits, nil
+}
+
+// limitQueryName keys a swept result by its limit.
+func limitQueryName(query string, limit int) string {
+	return fmt.Sprintf("%s/limit=%d", query, limit)
+}
+
+// limitSweepWorkload copies every ranked query of w once per limit, with
+// the trailing LIMIT of each engine's statement replaced. A query is only
+// swept when all its statements are ranked and end in a literal LIMIT, so
+// the engines stay comparable.
+func limitSweepWorkload(w *Workload, limits []int) (*Workload, []string) {
+	out := &Workload{Name: w.Name + "-limits", PlanMismatch: w.PlanMismatch, Weights: w.Weights}
+	var swept []string
+	for _, q := range w.Queries {
+		ranked := true
+		for _, stmt := range q.SQL {
+			stmt = strings.TrimSpace(stmt)
+			ranked = ranked && orderByClause.MatchString(stmt) && limitClause.MatchString(stmt)
+		}
+		if !ranked {
+			continue
+		}
+		swept = append(swept, q.Name)
+		for _, limit := range limits {
+			lq := q
+			lq.Name = limitQueryName(q.Name, limit)
+			lq.SQL = map[string]string{}
+			for engine, stmt := range q.SQL {
+				lq.SQL[engine] = limitClause.ReplaceAllString(strings.TrimSpace(stmt), fmt.Sprintf("LIMIT %d", limit))
+			}
+			out.Queries = append(out.Queries, lq)
+		}
+	}
+	return out, swept
+}
+
+// runLimitSweepScenario times each ranked query of the workload at every
+// -limits size. Top-K size shifts the balance between scoring and sorting
+// every match and reading matches in index order, so a comparison at
+// LIMIT 10 alone can mislead.
+func runLimitSweepScenario(st *runState) error {
+	limits, err := parseLimits(sweepLimits)
+	if err != nil {
+		return err
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	lw, swept := limitSweepWorkload(w, limits)
+	if len(swept) == 0 {
+		return fmt.Errorf("%s has no ranked queries (ORDER BY ... LIMIT n on every engine) to sweep", w.Name)
+	}
+	infof("Sweeping LIMIT %s over %s", sweepLimits, strings.Join(swept, ", "))
+
+	planProblems, err := checkPlansOrFail(lw, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(lw, st.Targets, st.sampling(), planProblems)
+	if err := saveResults(dataDir, "limit-sweep", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+
+	// Mean over all argument sets, per query, engine and limit
+	type key struct {
+		query, engine string
+		limit         int
+	}
+	sums := map[key]float64{}
+	counts := map[key]int{}
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		for _, q := range swept {
+			for _, limit := range limits {
+				if r.Query == limitQueryName(q, limit) {
+					k := key{q, r.Engine, limit}
+					sums[k] += float64(r.Stats.Mean)
+					counts[k]++
+				}
+			}
+		}
+	}
+	headers := []string{"Query", "Engine"}
+	for _, limit := range limits {
+		headers = append(headers, fmt.Sprintf("LIMIT %d", limit))
+	}
+	table := newConsoleTable(headers...)
+	for _, q := range swept {
+		for _, t := range st.Targets {
+			row := []string{q, t.Label}
+			for _, limit := range limits {
+				k := key{q, t.Name, limit}
+				if counts[k] == 0 {
+					row = append(row, "-")
+					continue
+				}
+				row = append(row, formatDuration(time.Duration(sums[k]/float64(counts[k]))))
+			}
+			table.addRow(row...)
+		}
+	}
+	fmt.Fprintln(progressOut, "\nMEAN LATENCY BY LIMIT:")
+	table.render(progressOut)
+	return nil
+}
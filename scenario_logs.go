@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// LogTable holds the log lines the logs scenario searches.
+const LogTable = "logs"
+
+var (
+	// logRows is how many synthetic lines are generated
+	logRows = 500000
+	// logFile is a real access log in Combined Log Format to load instead
+	logFile = ""
+	// logWorkload is timed by the logs scenario against LogTable
+	logWorkload = "workloads/logs.yaml"
+)
+
+// Synthetic lines are spread evenly over one day from logStart, with a
+// constant seed, so the identifiers and time windows in workloads/logs.yaml
+// exist on every run.
+var logStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const (
+	logSeed = 1
+	logSpan = 24 * time.Hour
+	// logNeedleEvery is the average number of lines between needles, the
+	// rare errors a needle-in-a-haystack search looks for
+	logNeedleEvery = 50000
+)
+
+// logLine is one row of LogTable.
+type logLine struct {
+	TS        time.Time
+	Service   string
+	Level     string
+	Host      string
+	Status    int
+	RequestID string
+	UserID    string
+	Message   string
+}
+
+var (
+	logServices = []string{"api", "auth", "billing", "search", "worker"}
+	logMethods  = []string{"GET", "GET", "GET", "POST", "PUT", "DELETE"}
+	logPaths    = []string{"products", "orders", "users", "carts", "sessions"}
+	logNeedles  = []string{
+		"java.lang.OutOfMemoryError: Java heap space",
+		"FATAL: deadlock detected on relation orders",
+		"panic: runtime error: invalid memory address or nil pointer dereference",
+		"segfault at 10 ip 00007f3a2c1d4e8b sp 00007ffd3b2a1c40 error 4 in libc.so.6",
+	}
+)
+
+// syntheticLogs generates n lines: mostly request logs, 5% warnings and 1%
+// errors with a few recurring messages, a rare needle about every
+// logNeedleEvery lines, a unique request id per line (req-<line number>)
+// and user ids from a long tail.
+func syntheticLogs(n int) []logLine {
+	rng := rand.New(rand.NewSource(logSeed))
+	users := rand.NewZipf(rng, 1.1, 1, 99999)
+	lines := make([]logLine, n)
+	for i := range lines {
+		l := logLine{
+			TS:        logStart.Add(time.Duration(float64(logSpan) * float64(i) / float64(n))),
+			Service:   logServices[rng.Intn(len(logServices))],
+			Host:      fmt.Sprintf("web-%02d", rng.Intn(20)),
+			RequestID: fmt.Sprintf("req-%012d", i),
+			UserID:    fmt.Sprintf("user-%d", users.Uint64()+1),
+		}
+		target := fmt.Sprintf("/api/%s/%d", logPaths[rng.Intn(len(logPaths))], rng.Intn(100000))
+		switch r := rng.Intn(100); {
+		case rng.Intn(logNeedleEvery) == 0:
+			l.Level, l.Status = "error", 500
+			l.Message = logNeedles[rng.Intn(len(logNeedles))]
+		case r == 0:
+			l.Level, l.Status = "error", []int{500, 502, 503, 504}[rng.Intn(4)]
+			l.Message = []string{
+				"upstream connect error or disconnect/reset before headers. reset reason: connection failure",
+				fmt.Sprintf("connection reset by peer while reading response from %s", target),
+				fmt.Sprintf("context deadline exceeded calling %s service", logServices[rng.Intn(len(logServices))]),
+			}[rng.Intn(3)]
+		case r < 6:
+			l.Level, l.Status = "warn", []int{400, 401, 404, 429}[rng.Intn(4)]
+			l.Message = []string{
+				fmt.Sprintf("slow query took %dms on %s", 1000+rng.Intn(9000), target),
+				fmt.Sprintf("retrying request to %s after timeout (attempt %d)", target, 1+rng.Intn(3)),
+				fmt.Sprintf("rate limit exceeded for %s", l.UserID),
+			}[rng.Intn(3)]
+		default:
+			l.Level, l.Status = "info", []int{200, 200, 200, 201, 204, 304}[rng.Intn(6)]
+			l.Message = fmt.Sprintf("%s %s %d %dms", logMethods[rng.Intn(len(logMethods))], target, l.Status, 1+rng.Intn(250))
+		}
+		lines[i] = l
+	}
+	return lines
+}
+
+// combinedLogLine matches the Combined (or Common) Log Format of Apache and
+// nginx access logs.
+var combinedLogLine = regexp.MustCompile(`^(\S+) \S+ (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) \S+(?: "[^"]*" "[^"]*")?`)
+
+// readAccessLog reads up to n lines of a Combined Log Format file. The
+// whole line is the message; the level follows the status code.
+func readAccessLog(path string, n int) ([]logLine, error) {
+	f, err := openDump(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var lines []logLine
+	skipped := 0
+	for scanner.Scan() && len(lines) < n {
+		m := combinedLogLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			skipped++
+			continue
+		}
+		ts, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[3])
+		if err != nil {
+			skipped++
+			continue
+		}
+		status, _ := strconv.Atoi(m[5])
+		level := "info"
+		if status >= 500 {
+			level = "error"
+		} else if status >= 400 {
+			level = "warn"
+		}
+		user := m[2]
+		if user == "-" {
+			user = ""
+		}
+		lines = append(lines, logLine{TS: ts, Service: "web", Level: level, Host: m[1], Status: status, UserID: user, Message: scanner.Text()})
+	}
+	if skipped > 0 {
+		warnf("Warning: Skipped %d lines of %s that are not in Combined Log Format", skipped, path)
+	}
+	return lines, scanner.Err()
+}
+
+// logBM25DDL renders the BM25 index of LogTable: the message with the
+// default tokenizer, the identifiers kept whole and the timestamp, or with
+// fallback every column with the defaults.
+func logBM25DDL(fallback bool) string {
+	switch {
+	case fallback && bm25DDL.Name == "procedure":
+		return fmt.Sprintf("CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id', text_fields => paradedb.field('message') || paradedb.field('request_id') || paradedb.field('user_id'), datetime_fields => paradedb.field('ts'))", LogTable)
+	case fallback:
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, message, request_id, user_id, ts) WITH (key_field='id')", LogTable)
+	case bm25DDL.Name == "typed":
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, message, (request_id::pdb.literal), (user_id::pdb.literal), ts) WITH (key_field='id')", LogTable)
+	case bm25DDL.Name == "procedure":
+		return fmt.Sprintf(`CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id',
+			text_fields => paradedb.field('message') || paradedb.field('request_id', tokenizer => paradedb.tokenizer('raw')) || paradedb.field('user_id', tokenizer => paradedb.tokenizer('raw')),
+			datetime_fields => paradedb.field('ts'))`, LogTable)
+	}
+	return fmt.Sprintf(`CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, message, request_id, user_id, ts)
+		WITH (key_field='id', text_fields='{"request_id": {"tokenizer": {"type": "raw"}}, "user_id": {"tokenizer": {"type": "raw"}}}')`, LogTable)
+}
+
+// loadLogTable creates LogTable, loads the lines and builds the engine's
+// indexes: GIN over the simple configuration (log text has no language to
+// stem) and B-trees on the identifiers and time on vanilla, BM25 on ParadeDB.
+func loadLogTable(t *benchTarget, lines []logLine) error {
+	steps := []string{
+		"DROP TABLE IF EXISTS " + LogTable,
+		fmt.Sprintf(`CREATE TABLE %s (
+			id BIGSERIAL PRIMARY KEY,
+			ts TIMESTAMPTZ NOT NULL,
+			service TEXT NOT NULL,
+			level TEXT NOT NULL,
+			host TEXT,
+			status INTEGER,
+			request_id TEXT,
+			user_id TEXT,
+			message TEXT NOT NULL
+		)`, LogTable),
+	}
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < len(lines); i += BatchSize {
+		if err := insertLogBatch(t.DB, lines[i:min(i+BatchSize, len(lines))]); err != nil {
+			return err
+		}
+	}
+
+	if t.IsParadeDB {
+		if _, err := t.DB.Exec(logBM25DDL(false)); err != nil {
+			warnf("%s: Warning: BM25 index with raw identifiers on %s failed, using the default tokenizer: %v", t.Label, LogTable, err)
+			if _, err := t.DB.Exec(logBM25DDL(true)); err != nil {
+				return fmt.Errorf("could not create BM25 index on %s: %v", LogTable, diagnoseBuildError(err))
+			}
+		}
+	} else {
+		for _, stmt := range []string{
+			fmt.Sprintf("CREATE INDEX %[1]s_message ON %[1]s USING gin (to_tsvector('simple', message))", LogTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_request_id ON %[1]s (request_id)", LogTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_user_id ON %[1]s (user_id)", LogTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_ts ON %[1]s (ts)", LogTable),
+		} {
+			if _, err := t.DB.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := t.DB.Exec("ANALYZE " + LogTable)
+	return err
+}
+
+func insertLogBatch(db *sql.DB, lines []logLine) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+		INSERT INTO %s (ts, service, level, host, status, request_id, user_id, message)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)
+	`, LogTable))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, l := range lines {
+		if _, err := stmt.Exec(l.TS, l.Service, l.Level, l.Host, l.Status, l.RequestID, l.UserID, l.Message); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// runLogsScenario loads log lines into LogTable, -log-rows synthetic ones
+// or the lines of -log-file, and times workloads/logs.yaml: rare errors in
+// a haystack of routine lines, lookups of high-cardinality identifiers and
+// searches bounded to a time window, the log search use case rather than
+// product search.
+func runLogsScenario(st *runState) error {
+	if logRows < 1 {
+		return fmt.Errorf("-log-rows must be at least 1")
+	}
+	w, err := loadWorkload(logWorkload)
+	if err != nil {
+		return err
+	}
+
+	var lines []logLine
+	switch {
+	case fakeEngines:
+	case logFile != "":
+		if lines, err = readAccessLog(logFile, logRows); err != nil {
+			return err
+		}
+		infof("Read %d lines from %s", len(lines), logFile)
+	default:
+		lines = syntheticLogs(logRows)
+		infof("Generated %d log lines over %s from %s", len(lines), logSpan, logStart.Format(time.RFC3339))
+	}
+	for _, t := range st.Targets {
+		start := time.Now()
+		if err := loadLogTable(t, lines); err != nil {
+			return fmt.Errorf("%s: %s: %v", t.Label, LogTable, err)
+		}
+		infof("%s: Loaded and indexed %s in %v", t.Label, LogTable, time.Since(start).Round(time.Millisecond))
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "logs", manifest.KindQueries, st.Targets, results)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// MatviewName is the denormalized search view built on the vanilla target.
+const MatviewName = "products_search_mv"
+
+// matviewEngine is the engine name of the vanilla target searching the
+// materialized view instead of the table.
+const matviewEngine = "vanilla-mv"
+
+// matviewWorkload is timed by the matview scenario; it has statements for
+// vanilla, vanilla-mv and parade.
+var matviewWorkload = "workloads/matview.yaml"
+
+// matviewDDL builds the view with one precomputed tsvector over title,
+// description and brand; the unique index lets it refresh concurrently.
+var matviewDDL = []string{
+	"DROP MATERIALIZED VIEW IF EXISTS " + MatviewName,
+	`CREATE MATERIALIZED VIEW ` + MatviewName + ` AS
+		SELECT id, title, brand, price,
+		       to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, '')) AS document
+		FROM products`,
+	"CREATE UNIQUE INDEX " + MatviewName + "_id ON " + MatviewName + " (id)",
+	"CREATE INDEX " + MatviewName + "_document ON " + MatviewName + " USING gin (document)",
+	"ANALYZE " + MatviewName,
+}
+
+// runMatviewScenario compares searching a materialized view on vanilla,
+// a common workaround, with the table on both engines, then measures what
+// keeping the view fresh costs after -churn-rows inserts.
+func runMatviewScenario(st *runState) error {
+	var vanilla *benchTarget
+	for _, t := range st.Targets {
+		if !t.IsParadeDB {
+			vanilla = t
+			break
+		}
+	}
+	if vanilla == nil {
+		return fmt.Errorf("no vanilla target to build %s on", MatviewName)
+	}
+	w, err := loadWorkload(matviewWorkload)
+	if err != nil {
+		return err
+	}
+
+	var refresh []manifest.ChurnResult
+	timed := func(op string, rows int64, fn func() error) error {
+		start := time.Now()
+		if err := fn(); err != nil {
+			return fmt.Errorf("%s %s: %v", vanilla.Label, op, err)
+		}
+		r := manifest.ChurnResult{Engine: matviewEngine, Operation: op, Rows: rows, Duration: time.Since(start)}
+		infof("  %s: %s", op, formatDuration(r.Duration))
+		refresh = append(refresh, r)
+		return nil
+	}
+	exec := func(stmts ...string) func() error {
+		return func() error {
+			for _, stmt := range stmts {
+				if _, err := vanilla.DB.Exec(productsSQL(stmt)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	infof("%s: Building %s", vanilla.Label, MatviewName)
+	if err := timed("build", 0, exec(matviewDDL...)); err != nil {
+		return err
+	}
+
+	mv := &benchTarget{Name: matviewEngine, Label: vanilla.Label + " MV", Service: vanilla.Service, Config: vanilla.Config, DB: vanilla.DB}
+	targets := append(append([]*benchTarget{}, st.Targets...), mv)
+	planProblems, err := checkPlansOrFail(w, targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, targets, st.sampling(), planProblems)
+	if err := saveResults(dataDir, "matview", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+
+	infof("%s: Inserting %d rows, then refreshing %s", vanilla.Label, st.ChurnRows, MatviewName)
+	faker := seededFaker("matview", 0)
+	for done := 0; done < st.ChurnRows; done += BatchSize {
+		n := BatchSize
+		if st.ChurnRows-done < n {
+			n = st.ChurnRows - done
+		}
+		if err := insertBatch(vanilla.DB, syntheticProducts(faker, n)); err != nil {
+			return fmt.Errorf("%s: %v", vanilla.Label, err)
+		}
+	}
+	churned := int64(st.ChurnRows)
+	if err := timed("refresh concurrently", churned, exec("REFRESH MATERIALIZED VIEW CONCURRENTLY "+MatviewName)); err != nil {
+		return err
+	}
+	if err := timed("refresh", churned, exec("REFRESH MATERIALIZED VIEW "+MatviewName)); err != nil {
+		return err
+	}
+
+	// Leave the table and the view as the other scenarios expect them
+	if _, err := vanilla.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%"); err != nil {
+		return fmt.Errorf("%s: %v", vanilla.Label, err)
+	}
+	if err := exec("REFRESH MATERIALIZED VIEW " + MatviewName)(); err != nil {
+		return fmt.Errorf("%s: %v", vanilla.Label, err)
+	}
+	return saveResults(dataDir, "matview-refresh", manifest.KindChurn, st.Targets, refresh)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"pg_search_benchmark/manifest"
+)
+
+// Traffic mix of the mix scenario.
+var (
+	// trafficMix weights the operations, e.g. search=90,insert=8,update=2
+	trafficMix  = "search=90,insert=8,update=2"
+	mixWorkers  = 4
+	mixDuration = 30 * time.Second
+)
+
+// mixUpdateRows are the synthetic rows written before each engine's run for
+// updates to change, so the loaded products are never modified.
+const mixUpdateRows = 1000
+
+// mixOperations are the operations a traffic mix can weight.
+var mixOperations = []string{"search", "insert", "update", "delete"}
+
+// parseTrafficMix reads -mix into cumulative weights in mixOperations order.
+func parseTrafficMix(spec string) ([]float64, error) {
+	weights, err := parseWeights(spec)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{}
+	for _, op := range mixOperations {
+		known[op] = true
+	}
+	for op := range weights {
+		if !known[op] {
+			return nil, fmt.Errorf("unknown operation %q in -mix (want %s)", op, strings.Join(mixOperations, ", "))
+		}
+	}
+	cumulative := make([]float64, len(mixOperations))
+	total := 0.0
+	for i, op := range mixOperations {
+		total += weights[op]
+		cumulative[i] = total
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("-mix gives every operation weight 0")
+	}
+	return cumulative, nil
+}
+
+// pickOperation draws an operation from cumulative weights.
+func pickOperation(rng *rand.Rand, cumulative []float64) string {
+	x := rng.Float64() * cumulative[len(cumulative)-1]
+	for i, c := range cumulative {
+		if x < c {
+			return mixOperations[i]
+		}
+	}
+	return mixOperations[len(mixOperations)-1]
+}
+
+// mixWorker issues operations against one engine until the deadline and
+// keeps their latencies by operation.
+type mixWorker struct {
+	t        *benchTarget
+	rng      *rand.Rand
+	faker    *gofakeit.Faker
+	searches []queryCell
+	// minID and maxID bound the ids of the rows written for updates
+	minID, maxID int64
+	latencies    map[string][]time.Duration
+	errors       map[string]int
+	lastErrors   map[string]string
+}
+
+func (w *mixWorker) do(op string) error {
+	switch op {
+	case "search":
+		c := w.searches[w.rng.Intn(len(w.searches))]
+		_, _, err := w.t.timeQuery(c.stmt, c.args)
+		return err
+	case "insert":
+		return insertBatch(w.t.DB, syntheticProducts(w.faker, 1))
+	case "update":
+		// The prefix check skips any loaded row that took an id in the range
+		_, err := w.t.DB.Exec(productsSQL("UPDATE products SET price = $2 WHERE id = $1 AND asin LIKE $3"),
+			w.minID+w.rng.Int63n(w.maxID-w.minID+1), fmt.Sprintf("%.2f", w.faker.Price(1, 500)), syntheticASINPrefix+"%")
+		return err
+	case "delete":
+		// Only rows this benchmark wrote, so the loaded data stays intact
+		_, err := w.t.DB.Exec(productsSQL(`DELETE FROM products WHERE id = (
+			SELECT id FROM products WHERE asin LIKE $1 LIMIT 1 FOR UPDATE SKIP LOCKED)`), syntheticASINPrefix+"%")
+		return err
+	}
+	return fmt.Errorf("unknown operation %s", op)
+}
+
+func (w *mixWorker) run(cumulative []float64, deadline time.Time) {
+	for time.Now().Before(deadline) {
+		op := pickOperation(w.rng, cumulative)
+		start := time.Now()
+		if err := w.do(op); err != nil {
+			w.errors[op]++
+			w.lastErrors[op] = err.Error()
+			continue
+		}
+		w.latencies[op] = append(w.latencies[op], time.Since(start))
+	}
+}
+
+// runMixScenario drives each engine with -mix-workers clients issuing a
+// weighted mix of searches and writes for -mix-duration, so reads are timed
+// under the writes a real application would send alongside them. Engines
+// run one after another so they don't compete.
+func runMixScenario(st *runState) error {
+	cumulative, err := parseTrafficMix(trafficMix)
+	if err != nil {
+		return err
+	}
+	if mixWorkers < 1 {
+		return fmt.Errorf("-mix-workers must be at least 1")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	var results []manifest.QueryResult
+	table := newConsoleTable("Engine", "Operation", "Count", "ops/s", "Mean", "p50", "p95", "p99", "Errors")
+	for _, t := range st.Targets {
+		var searches []queryCell
+		for _, q := range w.Queries {
+			for _, args := range q.Args {
+				if c, ok := newQueryCell(q, args, t, nil); ok {
+					searches = append(searches, *c)
+				}
+			}
+		}
+		if len(searches) == 0 && cumulative[0] > 0 {
+			return fmt.Errorf("%s: the workload has no queries to search with", t.Label)
+		}
+		if err := insertBatch(t.DB, syntheticProducts(seededFaker("mix-rows", 0), mixUpdateRows)); err != nil {
+			return fmt.Errorf("%s: could not write the rows to update: %v", t.Label, err)
+		}
+		minID, maxID, err := catalogOf(t.DB).idRange(syntheticASINPrefix)
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+
+		infof("%s: %d worker(s) for %v, mix %s", t.Label, mixWorkers, mixDuration, trafficMix)
+		workers := make([]*mixWorker, mixWorkers)
+		deadline := time.Now().Add(mixDuration)
+		var wg sync.WaitGroup
+		for i := range workers {
+			workers[i] = &mixWorker{
+				t: t, searches: searches, minID: minID, maxID: maxID,
+				rng:        seededRand("mix", int64(i)),
+				faker:      seededFaker("mix", int64(i)),
+				latencies:  map[string][]time.Duration{},
+				errors:     map[string]int{},
+				lastErrors: map[string]string{},
+			}
+			wg.Add(1)
+			go func(mw *mixWorker) {
+				defer wg.Done()
+				mw.run(cumulative, deadline)
+			}(workers[i])
+		}
+		wg.Wait()
+
+		for _, op := range mixOperations {
+			var samples []time.Duration
+			errors, lastError := 0, ""
+			for _, mw := range workers {
+				samples = append(samples, mw.latencies[op]...)
+				errors += mw.errors[op]
+				if e := mw.lastErrors[op]; e != "" {
+					lastError = e
+				}
+			}
+			if len(samples) == 0 && errors == 0 {
+				continue
+			}
+			stats := summarize(samples)
+			res := manifest.QueryResult{
+				Query: op, Category: "mix", Engine: t.Name, Args: []interface{}{trafficMix},
+				Stats: stats, Workers: mixWorkers, Throughput: float64(stats.Count) / mixDuration.Seconds(),
+			}
+			if errors > 0 {
+				errorf("%s: %d %s operation(s) failed, last: %s", t.Label, errors, op, lastError)
+				if len(samples) == 0 {
+					res.Error = lastError
+				}
+			}
+			results = append(results, res)
+			table.addRow(t.Label, op, fmt.Sprintf("%d", stats.Count), fmt.Sprintf("%.0f", res.Throughput),
+				formatDuration(stats.Mean), formatDuration(stats.P50), formatDuration(stats.P95), formatDuration(stats.P99), fmt.Sprintf("%d", errors))
+		}
+
+		if _, err := t.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%"); err != nil {
+			warnf("%s: Warning: Could not delete inserted rows: %v", t.Label, err)
+		}
+	}
+
+	fmt.Fprintf(progressOut, "\nTRAFFIC MIX (%s, %d worker(s) per engine):\n", trafficMix, mixWorkers)
+	table.render(progressOut)
+	return saveResults(dataDir, "mix", manifest.KindQueries, st.Targets, results)
+}
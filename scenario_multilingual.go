@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"pg_search_benchmark/manifest"
+)
+
+// marcLanguage is one language of the Multilingual Amazon Reviews Corpus
+// and how each engine analyzes it.
+type marcLanguage struct {
+	Code string
+	// Config is the PostgreSQL text search configuration
+	Config string
+	// Stemmer is the ParadeDB stemmer for space-separated languages;
+	// Segmenter the Lindera dictionary for the others, which PostgreSQL
+	// has no parser for
+	Stemmer   string
+	Segmenter string
+}
+
+// marcLanguages are the corpus's languages, in its own order.
+var marcLanguages = []marcLanguage{
+	{Code: "de", Config: "german", Stemmer: "German"},
+	{Code: "en", Config: "english", Stemmer: "English"},
+	{Code: "es", Config: "spanish", Stemmer: "Spanish"},
+	{Code: "fr", Config: "french", Stemmer: "French"},
+	{Code: "ja", Config: "simple", Segmenter: "japanese"},
+	{Code: "zh", Config: "simple", Segmenter: "chinese"},
+}
+
+var (
+	// marcLanguageList picks the languages the multilingual scenario loads
+	marcLanguageList = "de,en,es,fr,ja,zh"
+	// marcRows is how many reviews of each language are loaded
+	marcRows = 50000
+	// marcURL is the download location of a language's training split, with
+	// %s for the language code. The original bucket may be withdrawn; any
+	// mirror of the JSON lines files works.
+	marcURL = "https://amazon-reviews-ml.s3-us-west-2.amazonaws.com/json/train/dataset_%s_train.json"
+	// marcWorkload is timed by the multilingual scenario; each query's
+	// category is the language whose table it searches.
+	marcWorkload = "workloads/marc.yaml"
+)
+
+// marcReview is one line of the corpus. Stars are quoted in the files.
+type marcReview struct {
+	ReviewID        string      `json:"review_id"`
+	ProductID       string      `json:"product_id"`
+	Stars           json.Number `json:"stars"`
+	ReviewBody      string      `json:"review_body"`
+	ReviewTitle     string      `json:"review_title"`
+	ProductCategory string      `json:"product_category"`
+}
+
+// marcTable is the table a language's reviews are loaded into.
+func marcTable(l marcLanguage) string {
+	return "reviews_" + l.Code
+}
+
+// marcFile is where a language's training split is kept in the data
+// directory.
+func marcFile(l marcLanguage) string {
+	return filepath.Join(dataDir, fmt.Sprintf("marc_%s_train.json", l.Code))
+}
+
+// selectedMarcLanguages parses -marc-languages.
+func selectedMarcLanguages() ([]marcLanguage, error) {
+	var langs []marcLanguage
+	for _, code := range strings.Split(marcLanguageList, ",") {
+		code = strings.TrimSpace(code)
+		found := false
+		for _, l := range marcLanguages {
+			if l.Code == code {
+				langs, found = append(langs, l), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown MARC language %q (want some of de, en, es, fr, ja, zh)", code)
+		}
+	}
+	return langs, nil
+}
+
+// marcTokenizer is the BM25 tokenizer of a language in the syntax of the
+// selected template.
+func marcTokenizer(l marcLanguage) string {
+	switch bm25DDL.Name {
+	case "typed":
+		if l.Segmenter != "" {
+			return fmt.Sprintf("pdb.lindera('%s')", l.Segmenter)
+		}
+		return fmt.Sprintf("pdb.simple('stemmer=%s')", strings.ToLower(l.Stemmer))
+	case "procedure":
+		if l.Segmenter != "" {
+			return fmt.Sprintf("paradedb.tokenizer('%s_lindera')", l.Segmenter)
+		}
+		return fmt.Sprintf("paradedb.tokenizer('default', stemmer => '%s')", l.Stemmer)
+	}
+	if l.Segmenter != "" {
+		return fmt.Sprintf(`{"type": "%s_lindera"}`, l.Segmenter)
+	}
+	return fmt.Sprintf(`{"type": "default", "stemmer": "%s"}`, l.Stemmer)
+}
+
+// marcBM25DDL renders the BM25 index of a language's table, with its
+// tokenizer on title and body or, with fallback, the default tokenizer.
+func marcBM25DDL(l marcLanguage, fallback bool) string {
+	table, tok := marcTable(l), marcTokenizer(l)
+	switch bm25DDL.Name {
+	case "typed":
+		if fallback {
+			return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, review_title, review_body) WITH (key_field='id')", table)
+		}
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, (review_title::%[2]s), (review_body::%[2]s)) WITH (key_field='id')", table, tok)
+	case "procedure":
+		fields := "paradedb.field('review_title') || paradedb.field('review_body')"
+		if !fallback {
+			fields = fmt.Sprintf("paradedb.field('review_title', tokenizer => %[1]s) || paradedb.field('review_body', tokenizer => %[1]s)", tok)
+		}
+		return fmt.Sprintf("CALL paradedb.create_bm25(index_name => '%[1]s_search_idx', table_name => '%[1]s', key_field => 'id', text_fields => %[2]s)", table, fields)
+	}
+	if fallback {
+		return fmt.Sprintf("CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, review_title, review_body) WITH (key_field='id')", table)
+	}
+	return fmt.Sprintf(`CREATE INDEX %[1]s_search_idx ON %[1]s USING bm25 (id, review_title, review_body)
+		WITH (key_field='id', text_fields='{"review_title": {"tokenizer": %[2]s}, "review_body": {"tokenizer": %[2]s}}')`, table, tok)
+}
+
+// marcDocument is the text both engines search, as vanilla indexes it.
+const marcDocument = "review_title || ' ' || review_body"
+
+// readMarcReviews reads the first n reviews of a training split.
+func readMarcReviews(path string, n int) ([]marcReview, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var reviews []marcReview
+	for lineNo := 1; scanner.Scan() && len(reviews) < n; lineNo++ {
+		var r marcReview
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", filepath.Base(path), lineNo, err)
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, scanner.Err()
+}
+
+// loadMarcTable creates a language's table, loads the reviews into it and
+// builds the engine's index: a GIN index over the language's text search
+// configuration on vanilla, a BM25 index with the language's tokenizer on
+// ParadeDB. It returns the tokenizer the index ended up with.
+func loadMarcTable(t *benchTarget, l marcLanguage, reviews []marcReview) (string, error) {
+	table := marcTable(l)
+	steps := []string{
+		"DROP TABLE IF EXISTS " + table,
+		fmt.Sprintf(`CREATE TABLE %s (
+			id BIGSERIAL PRIMARY KEY,
+			review_id TEXT NOT NULL,
+			product_id TEXT,
+			stars INTEGER,
+			review_title TEXT NOT NULL DEFAULT '',
+			review_body TEXT NOT NULL DEFAULT '',
+			product_category TEXT
+		)`, table),
+	}
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return "", err
+		}
+	}
+
+	for i := 0; i < len(reviews); i += BatchSize {
+		end := min(i+BatchSize, len(reviews))
+		if err := insertMarcBatch(t.DB, table, reviews[i:end]); err != nil {
+			return "", err
+		}
+	}
+
+	analyzer := l.Config
+	if t.IsParadeDB {
+		analyzer = marcTokenizer(l)
+		if _, err := t.DB.Exec(marcBM25DDL(l, false)); err != nil {
+			warnf("%s: Warning: BM25 index with %s on %s failed, using the default tokenizer: %v", t.Label, analyzer, table, err)
+			analyzer = "default (fallback)"
+			if _, err := t.DB.Exec(marcBM25DDL(l, true)); err != nil {
+				return "", fmt.Errorf("could not create BM25 index on %s: %v", table, diagnoseBuildError(err))
+			}
+		}
+	} else {
+		stmt := fmt.Sprintf("CREATE INDEX %[1]s_fulltext ON %[1]s USING gin (to_tsvector('%[2]s', %[3]s))", table, l.Config, marcDocument)
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return "", err
+		}
+	}
+	if _, err := t.DB.Exec("ANALYZE " + table); err != nil {
+		return "", err
+	}
+	return analyzer, nil
+}
+
+func insertMarcBatch(db *sql.DB, table string, reviews []marcReview) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf(`
+		INSERT INTO %s (review_id, product_id, stars, review_title, review_body, product_category)
+		VALUES ($1, $2, NULLIF($3, '')::integer, $4, $5, $6)
+	`, table))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range reviews {
+		if _, err := stmt.Exec(r.ReviewID, r.ProductID, r.Stars.String(), r.ReviewTitle, r.ReviewBody, r.ProductCategory); err != nil {
+			return fmt.Errorf("review %s: %v", r.ReviewID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// marcMatchSQL returns every row an engine's search matches, with the
+// predicates of workloads/marc.yaml but without ranking or a limit.
+func marcMatchSQL(t *benchTarget, l marcLanguage) string {
+	if t.IsParadeDB {
+		return fmt.Sprintf(`SELECT id FROM %s WHERE id @@@ paradedb.boolean(should => ARRAY[
+			paradedb.match(field => 'review_title', value => $1),
+			paradedb.match(field => 'review_body', value => $1)
+		])`, marcTable(l))
+	}
+	return fmt.Sprintf("SELECT id FROM %[1]s WHERE to_tsvector('%[2]s', %[3]s) @@ plainto_tsquery('%[2]s', $1)", marcTable(l), l.Config, marcDocument)
+}
+
+// marcRelevantSQL returns the rows containing every pattern of $1, matched
+// case-insensitively with a sequential scan, so no analyzer is involved.
+func marcRelevantSQL(l marcLanguage) string {
+	return fmt.Sprintf("SELECT id FROM %s WHERE (SELECT bool_and(%s ~* p) FROM unnest($1::text[]) AS p)", marcTable(l), marcDocument)
+}
+
+// marcPatterns turns a search into the patterns its relevant reviews must
+// contain: each word on its own, at word boundaries, for space-separated
+// languages, and the whole search as a substring for the others.
+func marcPatterns(l marcLanguage, search string) []string {
+	if l.Segmenter != "" {
+		return []string{regexp.QuoteMeta(strings.TrimSpace(search))}
+	}
+	var patterns []string
+	for _, word := range strings.Fields(search) {
+		patterns = append(patterns, `\m`+regexp.QuoteMeta(word)+`\M`)
+	}
+	return patterns
+}
+
+// marcRecall compares the rows an engine's search matches with the
+// reviews that literally contain the search terms.
+func marcRecall(t *benchTarget, l marcLanguage, search string) (*manifest.Recall, error) {
+	relevant, err := firstColumnIDs(t.DB, marcRelevantSQL(l), []interface{}{pq.Array(marcPatterns(l, search))})
+	if err != nil {
+		return nil, err
+	}
+	matched, err := firstColumnIDs(t.DB, marcMatchSQL(t, l), []interface{}{search})
+	if err != nil {
+		return nil, err
+	}
+	hits := map[int64]bool{}
+	for _, id := range matched {
+		hits[id] = true
+	}
+	r := &manifest.Recall{Relevant: len(relevant), Matched: len(matched)}
+	for _, id := range relevant {
+		if hits[id] {
+			r.Found++
+		}
+	}
+	return r, nil
+}
+
+// runMultilingualScenario loads the reviews of each language of the
+// Multilingual Amazon Reviews Corpus into a table of its own, analyzed for
+// that language, then times workloads/marc.yaml, where each language is a
+// category, and measures each engine's recall: how many of the reviews
+// containing a search's words its search finds.
+func runMultilingualScenario(st *runState) error {
+	langs, err := selectedMarcLanguages()
+	if err != nil {
+		return err
+	}
+	if marcRows < 1 {
+		return fmt.Errorf("-marc-rows must be at least 1")
+	}
+	w, err := loadWorkload(marcWorkload)
+	if err != nil {
+		return err
+	}
+	byCode := map[string]marcLanguage{}
+	for _, l := range langs {
+		byCode[l.Code] = l
+	}
+	// Only the loaded languages are searched
+	var queries []WorkloadQuery
+	for _, q := range w.Queries {
+		if _, ok := byCode[q.Category]; ok {
+			queries = append(queries, q)
+		}
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("%s has no queries for %s", marcWorkload, marcLanguageList)
+	}
+	w.Queries = queries
+
+	analyzers := newConsoleTable("Language", "Engine", "Reviews", "Analyzer", "Load")
+	for _, l := range langs {
+		var reviews []marcReview
+		if !fakeEngines {
+			path := marcFile(l)
+			if err := downloadFile(fmt.Sprintf(marcURL, l.Code), path); err != nil {
+				return fmt.Errorf("failed to download the %s reviews: %v (point -marc-url at a mirror)", l.Code, err)
+			}
+			if reviews, err = readMarcReviews(path, marcRows); err != nil {
+				return err
+			}
+		}
+		for _, t := range st.Targets {
+			start := time.Now()
+			analyzer, err := loadMarcTable(t, l, reviews)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", t.Label, marcTable(l), err)
+			}
+			analyzers.addRow(l.Code, t.Label, fmt.Sprintf("%d", len(reviews)), analyzer, formatDuration(time.Since(start)))
+		}
+	}
+	fmt.Fprintln(progressOut, "\nMULTILINGUAL TABLES:")
+	analyzers.render(progressOut)
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	for i := range results {
+		r := &results[i]
+		if r.Error != "" || len(r.Args) != 1 {
+			continue
+		}
+		search, ok := r.Args[0].(string)
+		if !ok {
+			continue
+		}
+		for _, t := range st.Targets {
+			if t.Name != r.Engine {
+				continue
+			}
+			if r.Recall, err = marcRecall(t, byCode[r.Category], search); err != nil {
+				errorf("%s: Recall of %s %s failed: %v", t.Label, r.Query, argsLabel(r.Args), err)
+			}
+		}
+	}
+
+	printLanguageSummary(results, langs, st.Targets)
+	return saveResults(dataDir, "multilingual", manifest.KindQueries, st.Targets, results)
+}
+
+// printLanguageSummary prints, per language and engine, the geometric mean
+// latency of its searches and the recall over all of them.
+func printLanguageSummary(results []manifest.QueryResult, langs []marcLanguage, targets []*benchTarget) {
+	type key struct{ lang, engine string }
+	means, p95s := map[key][]time.Duration{}, map[key][]time.Duration{}
+	relevant, found := map[key]int{}, map[key]int{}
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		k := key{r.Category, r.Engine}
+		means[k] = append(means[k], r.Stats.Mean)
+		p95s[k] = append(p95s[k], r.Stats.P95)
+		if r.Recall != nil {
+			relevant[k] += r.Recall.Relevant
+			found[k] += r.Recall.Found
+		}
+	}
+
+	table := newConsoleTable("Language", "Engine", "Searches", "Mean (geo)", "p95 (geo)", "Recall")
+	for _, l := range langs {
+		for _, t := range targets {
+			k := key{l.Code, t.Name}
+			if len(means[k]) == 0 {
+				continue
+			}
+			recall := "-"
+			if relevant[k] > 0 {
+				recall = fmt.Sprintf("%.1f%% (%d/%d)", 100*float64(found[k])/float64(relevant[k]), found[k], relevant[k])
+			}
+			table.addRow(l.Code, t.Label, fmt.Sprintf("%d", len(means[k])),
+				formatDuration(time.Duration(geoMean(means[k]))), formatDuration(time.Duration(geoMean(p95s[k]))), recall)
+		}
+	}
+	fmt.Fprintln(progressOut, "\nLATENCY AND RECALL BY LANGUAGE:")
+	table.render(progressOut)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// neighborClients is -neighbor-clients: connections the noisy-neighbor
+	// scenario keeps busy in the neighbor database of every target
+	neighborClients = 4
+	// neighborRows is -neighbor-rows: the size of the neighbor's table,
+	// which its scans and updates churn through
+	neighborRows = 1000000
+	// neighborDatabase is created next to the benchmark database on every
+	// target and dropped afterwards
+	neighborDatabase = "pgsb_neighbor"
+)
+
+// neighborOps are what a neighbor client cycles through: hashing in a tight
+// loop for CPU, a sequential scan for reads that push pages out of shared
+// buffers, and a block of updates for WAL and dirty pages.
+var neighborOps = []string{
+	`SELECT sum(length(md5(i::text || random()::text))) FROM generate_series(1, 200000) i`,
+	`SELECT count(*) FROM neighbor WHERE pad LIKE '%zz%'`,
+	`UPDATE neighbor SET pad = md5(pad) || substr(pad, 33) WHERE id BETWEEN $1 AND $1 + 5000`,
+}
+
+// neighbor is the unrelated workload running on one target.
+type neighbor struct {
+	db     *sql.DB
+	ops    atomic.Int64
+	errors atomic.Int64
+}
+
+// openNeighbor creates the neighbor database on a target's instance and
+// fills its table. The database is recreated, so a run that was killed
+// leaves nothing behind for the next.
+func openNeighbor(t *benchTarget) (*neighbor, error) {
+	if err := dropNeighbor(t); err != nil {
+		return nil, err
+	}
+	if _, err := t.DB.Exec("CREATE DATABASE " + pq.QuoteIdentifier(neighborDatabase)); err != nil {
+		return nil, fmt.Errorf("could not create database %s: %v", neighborDatabase, err)
+	}
+	config := t.Config
+	config.Database = neighborDatabase
+	config.MaxOpenConns = neighborClients + 1
+	db, err := getDB(config)
+	if err != nil {
+		return nil, err
+	}
+	infof("%s: Filling %s with %d rows", t.Label, neighborDatabase, neighborRows)
+	if _, err := db.Exec(`
+		CREATE TABLE neighbor AS
+		SELECT i::bigint AS id, repeat(md5(i::text), 8) AS pad FROM generate_series(1, $1) i`, neighborRows); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec("ALTER TABLE neighbor ADD PRIMARY KEY (id)"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &neighbor{db: db}, nil
+}
+
+// dropNeighbor drops the neighbor database, disconnecting anything left in
+// it.
+func dropNeighbor(t *benchTarget) error {
+	_, err := t.DB.Exec("DROP DATABASE IF EXISTS " + pq.QuoteIdentifier(neighborDatabase) + " WITH (FORCE)")
+	return err
+}
+
+// run keeps neighborClients connections busy until stop is closed. Each
+// client starts at a different operation, so the mix is even from the
+// start. Errors are counted rather than stopping the neighbor; a server
+// short of resources is what the scenario is about.
+func (n *neighbor) run(stop <-chan struct{}) {
+	var wg sync.WaitGroup
+	for c := 0; c < neighborClients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			rng := seededRand("neighbor", int64(c))
+			for i := c; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				op := neighborOps[i%len(neighborOps)]
+				var err error
+				if i%len(neighborOps) == 2 {
+					_, err = n.db.Exec(op, rng.Intn(neighborRows)+1)
+				} else {
+					_, err = n.db.Exec(op)
+				}
+				if err != nil {
+					n.errors.Add(1)
+					continue
+				}
+				n.ops.Add(1)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// runNoisyNeighborScenario times the workload on a quiet instance, then
+// again while -neighbor-clients connections run CPU- and IO-heavy work in
+// another database of the same instance, as on a consolidated server. The
+// slowdown per query and engine shows how sensitive each engine is to
+// what else the server does. The neighbor database is dropped afterwards.
+func runNoisyNeighborScenario(st *runState) error {
+	if fakeEngines {
+		return fmt.Errorf("the noisy-neighbor scenario needs real servers to load, not -fake")
+	}
+	if neighborClients < 1 || neighborRows < 1 {
+		return fmt.Errorf("-neighbor-clients and -neighbor-rows must be at least 1")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+
+	infof("Workload on a quiet instance")
+	quiet := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+
+	var neighbors []*neighbor
+	defer func() {
+		for i, n := range neighbors {
+			n.db.Close()
+			if err := dropNeighbor(st.Targets[i]); err != nil {
+				warnf("%s: Warning: Could not drop database %s: %v", st.Targets[i].Label, neighborDatabase, err)
+			}
+		}
+	}()
+	for _, t := range st.Targets {
+		n, err := openNeighbor(t)
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		neighbors = append(neighbors, n)
+	}
+
+	infof("Workload next to %d busy neighbor connection(s)", neighborClients)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, n := range neighbors {
+		wg.Add(1)
+		go func(n *neighbor) {
+			defer wg.Done()
+			n.run(stop)
+		}(n)
+	}
+	start := time.Now()
+	noisy := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+	for i, n := range neighbors {
+		infof("%s: neighbor ran %d operations (%.1f/sec), %d failed",
+			st.Targets[i].Label, n.ops.Load(), float64(n.ops.Load())/elapsed.Seconds(), n.errors.Load())
+	}
+
+	quietByCell := map[string]manifest.QueryResult{}
+	for _, r := range quiet {
+		quietByCell[fmt.Sprintf("%s/%s/%v", r.Engine, r.Query, r.Args)] = r
+	}
+	table := newConsoleTable("Query", "Args", "Engine", "Quiet", "Noisy", "Slowdown")
+	quietTotal, noisyTotal := map[string]float64{}, map[string]float64{}
+	var results []manifest.QueryResult
+	for _, r := range noisy {
+		q, ok := quietByCell[fmt.Sprintf("%s/%s/%v", r.Engine, r.Query, r.Args)]
+		if !ok {
+			continue
+		}
+		slowdown := "-"
+		if r.Error == "" && q.Error == "" && q.Stats.Mean > 0 {
+			slowdown = fmt.Sprintf("%.2fx", float64(r.Stats.Mean)/float64(q.Stats.Mean))
+			quietTotal[r.Engine] += float64(q.Stats.Mean)
+			noisyTotal[r.Engine] += float64(r.Stats.Mean)
+		}
+		table.addRow(r.Query, argsLabel(r.Args), r.Engine, formatDuration(q.Stats.Mean), formatDuration(r.Stats.Mean), slowdown)
+
+		q.Query, r.Query = q.Query+"/quiet", r.Query+"/noisy"
+		results = append(results, q, r)
+	}
+	fmt.Fprintln(progressOut, "\nWORKLOAD ON A QUIET AND A BUSY INSTANCE:")
+	table.render(progressOut)
+	for _, t := range st.Targets {
+		if quietTotal[t.Name] > 0 {
+			fmt.Fprintf(progressOut, "%s: %.2fx summed mean latency next to the neighbor\n", t.Label, noisyTotal[t.Name]/quietTotal[t.Name])
+		}
+	}
+	return saveResults(dataDir, "noisy-neighbor", manifest.KindQueries, st.Targets, results)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// oltpScale is -oltp-scale: the TPC-B scale factor, as in pgbench -s;
+	// each unit is one branch, 10 tellers and 100,000 accounts
+	oltpScale = 10
+	// oltpClients is -oltp-clients: connections running TPC-B transactions
+	oltpClients = 4
+	// oltpDuration is -oltp-duration: how long TPC-B runs alone per target
+	oltpDuration = 30 * time.Second
+)
+
+const (
+	tpcbTellersPerBranch  = 10
+	tpcbAccountsPerBranch = 100000
+)
+
+// oltpTables are the TPC-B tables, as pgbench creates them but prefixed so
+// they cannot collide with an application's, in the order they are dropped.
+var oltpTables = []string{"pgsb_tpcb_history", "pgsb_tpcb_accounts", "pgsb_tpcb_tellers", "pgsb_tpcb_branches"}
+
+// oltpSetup creates and fills the TPC-B tables next to products.
+var oltpSetup = []string{
+	"CREATE TABLE pgsb_tpcb_branches (bid int PRIMARY KEY, bbalance int NOT NULL, filler char(88))",
+	"CREATE TABLE pgsb_tpcb_tellers (tid int PRIMARY KEY, bid int NOT NULL, tbalance int NOT NULL, filler char(84))",
+	"CREATE TABLE pgsb_tpcb_accounts (aid int PRIMARY KEY, bid int NOT NULL, abalance int NOT NULL, filler char(84))",
+	"CREATE TABLE pgsb_tpcb_history (tid int, bid int, aid int, delta int, mtime timestamp, filler char(22))",
+	"INSERT INTO pgsb_tpcb_branches SELECT b, 0 FROM generate_series(1, $1::int) b",
+	fmt.Sprintf("INSERT INTO pgsb_tpcb_tellers SELECT t, (t - 1) / %d + 1, 0 FROM generate_series(1, $1::int * %[1]d) t", tpcbTellersPerBranch),
+	fmt.Sprintf("INSERT INTO pgsb_tpcb_accounts SELECT a, (a - 1) / %d + 1, 0, '' FROM generate_series(1, $1::int * %[1]d) a", tpcbAccountsPerBranch),
+}
+
+// setupOLTP replaces the TPC-B tables on a target and vacuums them, as
+// pgbench -i does.
+func setupOLTP(t *benchTarget) error {
+	if err := dropOLTP(t); err != nil {
+		return err
+	}
+	infof("%s: Creating TPC-B tables at scale %d", t.Label, oltpScale)
+	for _, stmt := range oltpSetup {
+		var err error
+		if strings.Contains(stmt, "$1") {
+			_, err = t.DB.Exec(stmt, oltpScale)
+		} else {
+			_, err = t.DB.Exec(stmt)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.SplitN(stmt, " (", 2)[0], err)
+		}
+	}
+	for _, table := range oltpTables {
+		if _, err := t.DB.Exec("VACUUM ANALYZE " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dropOLTP(t *benchTarget) error {
+	_, err := t.DB.Exec("DROP TABLE IF EXISTS " + strings.Join(oltpTables, ", "))
+	return err
+}
+
+// tpcbClient runs the TPC-B transaction of pgbench's default script on one
+// connection of a target.
+type tpcbClient struct {
+	db        *sql.DB
+	rng       *rand.Rand
+	latencies []time.Duration
+	errors    int
+	lastError string
+}
+
+func (c *tpcbClient) transaction() error {
+	aid := c.rng.Intn(oltpScale*tpcbAccountsPerBranch) + 1
+	bid := c.rng.Intn(oltpScale) + 1
+	tid := c.rng.Intn(oltpScale*tpcbTellersPerBranch) + 1
+	delta := c.rng.Intn(10001) - 5000
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE pgsb_tpcb_accounts SET abalance = abalance + $1 WHERE aid = $2", delta, aid); err != nil {
+		return err
+	}
+	var balance int64
+	if err := tx.QueryRow("SELECT abalance FROM pgsb_tpcb_accounts WHERE aid = $1", aid).Scan(&balance); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE pgsb_tpcb_tellers SET tbalance = tbalance + $1 WHERE tid = $2", delta, tid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE pgsb_tpcb_branches SET bbalance = bbalance + $1 WHERE bid = $2", delta, bid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO pgsb_tpcb_history (tid, bid, aid, delta, mtime) VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)",
+		tid, bid, aid, delta); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// oltpRun is what -oltp-clients TPC-B clients did on one target.
+type oltpRun struct {
+	samples   []time.Duration
+	elapsed   time.Duration
+	errors    int
+	lastError string
+}
+
+// runOLTP runs TPC-B transactions on a target until stop is closed.
+func runOLTP(t *benchTarget, stop <-chan struct{}) oltpRun {
+	clients := make([]*tpcbClient, oltpClients)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range clients {
+		clients[i] = &tpcbClient{db: t.DB, rng: seededRand("oltp", int64(i))}
+		wg.Add(1)
+		go func(c *tpcbClient) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				txStart := time.Now()
+				if err := c.transaction(); err != nil {
+					c.errors++
+					c.lastError = err.Error()
+					continue
+				}
+				c.latencies = append(c.latencies, time.Since(txStart))
+			}
+		}(clients[i])
+	}
+	wg.Wait()
+
+	run := oltpRun{elapsed: time.Since(start)}
+	for _, c := range clients {
+		run.samples = append(run.samples, c.latencies...)
+		run.errors += c.errors
+		if c.lastError != "" {
+			run.lastError = c.lastError
+		}
+	}
+	return run
+}
+
+// result keys a TPC-B run as a query result of category oltp.
+func (run oltpRun) result(t *benchTarget, name string) manifest.QueryResult {
+	stats := summarize(run.samples)
+	r := manifest.QueryResult{
+		Query: name, Category: "oltp", Engine: t.Name, Args: []interface{}{fmt.Sprintf("scale=%d", oltpScale)},
+		Stats: stats, Workers: oltpClients, Throughput: float64(stats.Count) / run.elapsed.Seconds(),
+	}
+	if run.errors > 0 {
+		errorf("%s: %d TPC-B transaction(s) failed, last: %s", t.Label, run.errors, run.lastError)
+		if len(run.samples) == 0 {
+			r.Error = run.lastError
+		}
+	}
+	return r
+}
+
+// runOLTPScenario adds a pgbench-style TPC-B workload on tables of its own
+// next to products, as when search is added to an existing OLTP database.
+// It times the search workload alone, then on each target in turn runs
+// TPC-B alone for -oltp-duration and again while the search workload runs,
+// and reports both directions of the interference: how much slower the
+// searches get, and how much throughput TPC-B loses. The TPC-B tables are
+// dropped afterwards.
+func runOLTPScenario(st *runState) error {
+	if oltpScale < 1 || oltpClients < 1 || oltpDuration <= 0 {
+		return fmt.Errorf("-oltp-scale, -oltp-clients and -oltp-duration must be positive")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		for _, t := range st.Targets {
+			if err := dropOLTP(t); err != nil {
+				warnf("%s: Warning: Could not drop the TPC-B tables: %v", t.Label, err)
+			}
+		}
+	}()
+	for _, t := range st.Targets {
+		if err := setupOLTP(t); err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+	}
+
+	infof("Search workload alone")
+	alone := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+
+	var results, together []manifest.QueryResult
+	oltpTable := newConsoleTable("Engine", "TPC-B alone", "With search", "Change", "p95 alone", "p95 with search", "Errors")
+	for _, t := range st.Targets {
+		infof("%s: TPC-B alone, %d client(s) for %v", t.Label, oltpClients, oltpDuration)
+		stop := make(chan struct{})
+		timer := time.AfterFunc(oltpDuration, func() { close(stop) })
+		soloRun := runOLTP(t, stop)
+		timer.Stop()
+
+		infof("%s: search workload while TPC-B runs", t.Label)
+		stop = make(chan struct{})
+		done := make(chan oltpRun)
+		go func() { done <- runOLTP(t, stop) }()
+		together = append(together, runProfiledWorkload(w, []*benchTarget{t}, st.sampling(), planProblems)...)
+		close(stop)
+		mixedRun := <-done
+
+		solo, mixed := soloRun.result(t, "tpcb/alone"), mixedRun.result(t, "tpcb/with-search")
+		results = append(results, solo, mixed)
+		change := "-"
+		if solo.Throughput > 0 {
+			change = fmt.Sprintf("%+.1f%%", (mixed.Throughput/solo.Throughput-1)*100)
+		}
+		oltpTable.addRow(t.Label, fmt.Sprintf("%.0f tps", solo.Throughput), fmt.Sprintf("%.0f tps", mixed.Throughput), change,
+			formatDuration(solo.Stats.P95), formatDuration(mixed.Stats.P95), fmt.Sprintf("%d", soloRun.errors+mixedRun.errors))
+	}
+
+	aloneByCell := map[string]manifest.QueryResult{}
+	for _, r := range alone {
+		aloneByCell[fmt.Sprintf("%s/%s/%v", r.Engine, r.Query, r.Args)] = r
+	}
+	searchTable := newConsoleTable("Query", "Args", "Engine", "Alone", "With TPC-B", "Slowdown")
+	for _, r := range together {
+		a, ok := aloneByCell[fmt.Sprintf("%s/%s/%v", r.Engine, r.Query, r.Args)]
+		if !ok {
+			continue
+		}
+		slowdown := "-"
+		if r.Error == "" && a.Error == "" && a.Stats.Mean > 0 {
+			slowdown = fmt.Sprintf("%.2fx", float64(r.Stats.Mean)/float64(a.Stats.Mean))
+		}
+		searchTable.addRow(r.Query, argsLabel(r.Args), r.Engine, formatDuration(a.Stats.Mean), formatDuration(r.Stats.Mean), slowdown)
+
+		a.Query, r.Query = a.Query+"/alone", r.Query+"/with-oltp"
+		results = append(results, a, r)
+	}
+	fmt.Fprintln(progressOut, "\nSEARCH ALONE AND NEXT TO TPC-B:")
+	searchTable.render(progressOut)
+	fmt.Fprintf(progressOut, "\nTPC-B (scale %d, %d client(s)) ALONE AND NEXT TO SEARCH:\n", oltpScale, oltpClients)
+	oltpTable.render(progressOut)
+	return saveResults(dataDir, "oltp", manifest.KindQueries, st.Targets, results)
+}
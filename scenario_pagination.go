@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// paginationRounds is how often each ranked query is paged per engine.
+var paginationRounds = 10
+
+// pageIDs runs stmt with its trailing LIMIT replaced and returns the ids in
+// the first column, in order.
+func pageIDs(db *sql.DB, stmt string, args []interface{}, limit, offset int) ([]int64, error) {
+	paged := limitClause.ReplaceAllString(strings.TrimSpace(stmt), fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset))
+	return firstColumnIDs(db, paged, args)
+}
+
+// firstColumnIDs runs a query and returns its first column, which must be
+// the product id, in order.
+func firstColumnIDs(db *sql.DB, query string, args []interface{}) ([]int64, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		dest := make([]interface{}, len(cols))
+		dest[0] = &id
+		for i := 1; i < len(cols); i++ {
+			dest[i] = new(interface{})
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// pageProblems compares pages 1 and 2 with the top 2n fetched right after
+// them. Rows newer than maxID were written during the check and may
+// legitimately shift the pages, so they don't count as gaps.
+func pageProblems(page1, page2, reference []int64, maxID int64) (duplicates, gaps int) {
+	seen := map[int64]bool{}
+	for _, id := range page1 {
+		seen[id] = true
+	}
+	for _, id := range page2 {
+		if seen[id] {
+			duplicates++
+		}
+		seen[id] = true
+	}
+	for _, id := range reference {
+		if id <= maxID && !seen[id] {
+			gaps++
+		}
+	}
+	return duplicates, gaps
+}
+
+// runPaginationScenario pages every ranked query with OFFSET, as an
+// application's "next page" link does, while -write-rate rows/sec are
+// inserted, and checks that page 2 repeats nothing from page 1 and skips
+// nothing the top 2n contains. Queries whose ORDER BY has ties and no tie
+// breaker, or engines whose snapshots move between statements, fail it.
+func runPaginationScenario(st *runState) error {
+	if paginationRounds < 1 {
+		return fmt.Errorf("-pagination-rounds must be at least 1")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	var writers sync.WaitGroup
+	for _, t := range st.Targets {
+		writers.Add(1)
+		go func(db *sql.DB) {
+			defer writers.Done()
+			backgroundWriter(db, st.WriteRate, 0, stop)
+		}(t.DB)
+	}
+
+	var results []manifest.QueryResult
+	table := newConsoleTable("Query", "Args", "Engine", "Rounds", "Duplicates", "Gaps", "Page 2 mean")
+	failed := 0
+	for _, q := range w.Queries {
+		for _, args := range q.Args {
+			for _, t := range st.Targets {
+				stmt, ok := q.SQL[t.sqlName()]
+				if !ok {
+					continue
+				}
+				stmt = strings.TrimSpace(stmt)
+				m := limitClause.FindStringSubmatch(stmt)
+				if m == nil || !orderByClause.MatchString(stmt) {
+					continue
+				}
+				n, _ := strconv.Atoi(m[1])
+				bound := q.bind(t.sqlName(), args)
+
+				res := manifest.QueryResult{Query: q.Name, Category: "pagination", Engine: t.Name, Args: args}
+				var maxID int64
+				if err := t.DB.QueryRow("SELECT COALESCE(max(id), 0) FROM " + productsTable).Scan(&maxID); err != nil {
+					close(stop)
+					writers.Wait()
+					return fmt.Errorf("%s: %v", t.Label, err)
+				}
+				var page2Times []time.Duration
+				duplicates, gaps := 0, 0
+				for round := 0; round < paginationRounds && res.Error == ""; round++ {
+					page1, err := pageIDs(t.DB, stmt, bound, n, 0)
+					if err != nil {
+						res.Error = err.Error()
+						break
+					}
+					start := time.Now()
+					page2, err := pageIDs(t.DB, stmt, bound, n, n)
+					if err != nil {
+						res.Error = err.Error()
+						break
+					}
+					page2Times = append(page2Times, time.Since(start))
+					reference, err := pageIDs(t.DB, stmt, bound, 2*n, 0)
+					if err != nil {
+						res.Error = err.Error()
+						break
+					}
+					d, g := pageProblems(page1, page2, reference, maxID)
+					duplicates += d
+					gaps += g
+				}
+				if res.Error == "" && duplicates+gaps > 0 {
+					res.Error = fmt.Sprintf("%d duplicate(s) and %d gap(s) between pages 1 and 2 over %d round(s)", duplicates, gaps, paginationRounds)
+				}
+				if res.Error != "" {
+					failed++
+				}
+				res.Stats = summarize(page2Times)
+				res.Rows = len(page2Times)
+				results = append(results, res)
+				table.addRow(q.Name, argsLabel(args), t.Label, fmt.Sprintf("%d", len(page2Times)),
+					fmt.Sprintf("%d", duplicates), fmt.Sprintf("%d", gaps), formatDuration(res.Stats.Mean))
+			}
+		}
+	}
+	close(stop)
+	writers.Wait()
+
+	fmt.Fprintf(progressOut, "\nPAGINATION (LIMIT n OFFSET n under %d rows/sec of writes):\n", st.WriteRate)
+	table.render(progressOut)
+	for _, t := range st.Targets {
+		if _, err := t.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%"); err != nil {
+			warnf("%s: Warning: Could not delete inserted rows: %v", t.Label, err)
+		}
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("%s has no ranked queries (ORDER BY ... LIMIT n) to page", w.Name)
+	}
+	if err := saveResults(dataDir, "pagination", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			errorf("❌ %s %s [%s]: %s", r.Query, argsLabel(r.Args), r.Engine, r.Error)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("pagination broke for %d query, argument and engine combination(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// PartitionedTable is the copy of products the partition scenario searches,
+// range-partitioned by month on a synthetic created_at.
+const PartitionedTable = "products_timed"
+
+var (
+	// partitionYears is how far back the synthetic created_at values reach
+	partitionYears = 3
+	// partitionWorkload is timed by the partition scenario against
+	// products_timed
+	partitionWorkload = "workloads/recency.yaml"
+)
+
+// partitionBounds returns the first instant of every month from years
+// before now to the month after now, so every created_at has a partition.
+func partitionBounds(now time.Time, years int) []time.Time {
+	month := time.Date(now.Year()-years, now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(now.Year(), now.Month()+2, 1, 0, 0, 0, 0, time.UTC)
+	var bounds []time.Time
+	for ; !month.After(end); month = month.AddDate(0, 1, 0) {
+		bounds = append(bounds, month)
+	}
+	return bounds
+}
+
+// partitionName names the partition holding the month starting at m.
+func partitionName(m time.Time) string {
+	return fmt.Sprintf("%s_y%04dm%02d", PartitionedTable, m.Year(), int(m.Month()))
+}
+
+// setupPartitionedTable copies products into monthly partitions with
+// created_at spread evenly over the last -partition-years years by a hash
+// of the ASIN, so every engine dates the same product the same way. Vanilla
+// gets its indexes on the parent, which Postgres builds on every partition;
+// ParadeDB gets one BM25 index per partition.
+func setupPartitionedTable(t *benchTarget, now time.Time, years int) error {
+	bounds := partitionBounds(now, years)
+	span := int64(years) * 365 * 24 * 3600
+	steps := []string{
+		"DROP TABLE IF EXISTS " + PartitionedTable,
+		fmt.Sprintf(`CREATE TABLE %s (LIKE products INCLUDING DEFAULTS, PRIMARY KEY (id, created_at))
+			PARTITION BY RANGE (created_at)`, PartitionedTable),
+	}
+	for i := 0; i+1 < len(bounds); i++ {
+		steps = append(steps, fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			partitionName(bounds[i]), PartitionedTable, bounds[i].Format("2006-01-02"), bounds[i+1].Format("2006-01-02")))
+	}
+	steps = append(steps,
+		fmt.Sprintf(`INSERT INTO %s (id, asin, title, description, price, brand, categories, sales_rank, image_url, provenance, created_at)
+			SELECT id, asin, title, description, price, brand, categories, sales_rank, image_url, provenance,
+			       '%s'::timestamp - (abs(hashtext(asin)::bigint) %% %d) * interval '1 second'
+			FROM products`, PartitionedTable, now.Format("2006-01-02 15:04:05"), span),
+		fmt.Sprintf("CREATE INDEX %[1]s_created_at ON %[1]s (created_at)", PartitionedTable),
+	)
+	if t.IsParadeDB {
+		for i := 0; i+1 < len(bounds); i++ {
+			steps = append(steps, strings.TrimSpace(fmt.Sprintf(t.bm25().Index, partitionName(bounds[i]))))
+		}
+	} else {
+		steps = append(steps,
+			fmt.Sprintf("CREATE INDEX %[1]s_fulltext ON %[1]s USING gin(to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, '')))", PartitionedTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_title_trgm ON %[1]s USING gin (title gin_trgm_ops)", PartitionedTable),
+		)
+	}
+	steps = append(steps, "ANALYZE "+PartitionedTable)
+
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(productsSQL(stmt)); err != nil {
+			fields := strings.Fields(stmt)
+			return fmt.Errorf("%s %s: %v", fields[0], fields[1], err)
+		}
+	}
+	return nil
+}
+
+// runPartitionScenario times recency-filtered searches against a table
+// partitioned by month, so the workload can check that a "last 30 days"
+// filter prunes partitions on both engines before the text index is used.
+func runPartitionScenario(st *runState) error {
+	if partitionYears < 1 {
+		return fmt.Errorf("-partition-years must be at least 1, got %d", partitionYears)
+	}
+	w, err := loadWorkload(partitionWorkload)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	months := len(partitionBounds(now, partitionYears)) - 1
+	for _, t := range st.Targets {
+		infof("%s: Copying products to %s, %d monthly partitions over %d year(s)...", t.Label, PartitionedTable, months, partitionYears)
+		start := time.Now()
+		if err := setupPartitionedTable(t, now, partitionYears); err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		var recent int64
+		err := t.DB.QueryRow("SELECT count(*) FROM " + PartitionedTable + " WHERE created_at >= now() - interval '30 days'").Scan(&recent)
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		infof("%s: Built in %v, %d rows in the last 30 days", t.Label, time.Since(start).Round(time.Second), recent)
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "partition", manifest.KindQueries, st.Targets, results)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// memoryLimits are the container memory limits the memory-pressure
+// scenario steps through, largest first; shared_buffers is a quarter of
+// each.
+var memoryLimits = "2g,1g,512m"
+
+// restartTimeout bounds how long a recreated container may take to accept
+// connections.
+const restartTimeout = 2 * time.Minute
+
+// memoryStep is one memory limit of the memory-pressure scenario.
+type memoryStep struct {
+	Label string
+	Bytes int64
+}
+
+// parseMemoryLimits reads limits such as "2g,512m" in Docker's units.
+func parseMemoryLimits(spec string) ([]memoryStep, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	var steps []memoryStep
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		unit, ok := units[part[len(part)-1]]
+		if !ok {
+			return nil, fmt.Errorf("invalid memory limit %q (want a number with k, m or g)", part)
+		}
+		n, err := strconv.ParseInt(part[:len(part)-1], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid memory limit %q", part)
+		}
+		// shared_buffers needs at least 128kB; keep well clear of it
+		if n*unit < 64<<20 {
+			return nil, fmt.Errorf("memory limit %q is below 64m", part)
+		}
+		steps = append(steps, memoryStep{Label: part, Bytes: n * unit})
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no memory limits given")
+	}
+	return steps, nil
+}
+
+// withServerSettings returns a postgres command line with the given -c
+// settings replaced or added.
+func withServerSettings(cmd []string, settings map[string]string) []string {
+	out := append([]string(nil), cmd...)
+	done := map[string]bool{}
+	for i := 0; i+1 < len(out); i++ {
+		if out[i] != "-c" {
+			continue
+		}
+		name := strings.SplitN(out[i+1], "=", 2)[0]
+		if value, ok := settings[name]; ok {
+			out[i+1] = name + "=" + value
+			done[name] = true
+		}
+	}
+	for name, value := range settings {
+		if !done[name] {
+			out = append(out, "-c", name+"="+value)
+		}
+	}
+	return out
+}
+
+// constrainService recreates a compose service with a memory limit (swap
+// included, so the server cannot page out instead) and shared_buffers and
+// effective_cache_size sized to it. The command line is the container's
+// own with those settings replaced, given to docker compose in an override
+// file; the data volume is kept.
+func constrainService(service string, step memoryStep) error {
+	id, err := serviceContainer(service)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("docker", "inspect", "-f", "{{json .Config.Cmd}}", id).Output()
+	if err != nil {
+		return fmt.Errorf("docker inspect %s: %v", service, commandError(err))
+	}
+	var cmd []string
+	if err := json.Unmarshal(out, &cmd); err != nil {
+		return fmt.Errorf("docker inspect %s: %v", service, err)
+	}
+	cmd = withServerSettings(cmd, map[string]string{
+		"shared_buffers":       fmt.Sprintf("%dkB", step.Bytes/4>>10),
+		"effective_cache_size": fmt.Sprintf("%dkB", step.Bytes/2>>10),
+	})
+
+	// JSON is YAML, so the override needs no YAML encoder
+	override, err := json.Marshal(map[string]interface{}{
+		"services": map[string]interface{}{
+			service: map[string]interface{}{"command": cmd, "mem_limit": step.Bytes, "memswap_limit": step.Bytes},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("pgsb-%s-%s.yml", service, step.Label))
+	if err := os.WriteFile(path, override, 0o644); err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	return recreateService(service, "-f", "docker-compose.yml", "-f", path)
+}
+
+// recreateService recreates a compose service, with extra compose file
+// arguments or, without any, as docker-compose.yml defines it.
+func recreateService(service string, files ...string) error {
+	args := append([]string{"compose"}, files...)
+	args = append(args, "up", "-d", "--force-recreate", service)
+	if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("docker compose up %s: %v: %s", service, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// reconnectTarget waits for a recreated target to accept connections and
+// replaces its pool, whose connections died with the old container.
+func reconnectTarget(t *benchTarget) error {
+	deadline := time.Now().Add(restartTimeout)
+	for exec.Command("docker", "compose", "exec", "-T", t.Service, "pg_isready", "-q", "-U", t.Config.User).Run() != nil {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not accept connections within %v", t.Service, restartTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+	db, err := getDB(t.Config)
+	if err != nil {
+		return err
+	}
+	t.DB.Close()
+	t.DB = db
+	return nil
+}
+
+// indexBytes is the size of the indexes on products.
+func indexBytes(t *benchTarget) (int64, error) {
+	var n int64
+	err := t.DB.QueryRow("SELECT COALESCE(sum(pg_relation_size(indexrelid)), 0)::bigint FROM pg_index WHERE indrelid = $1::regclass", productsTable).Scan(&n)
+	return n, err
+}
+
+// runMemoryPressureScenario recreates every target's container at each
+// -memory-limits step, with shared_buffers a quarter of the limit, and
+// times the workload from cold, so the curve shows how each engine
+// degrades once its indexes no longer fit in memory. The containers are
+// recreated as docker-compose.yml defines them afterwards.
+func runMemoryPressureScenario(st *runState) error {
+	if fakeEngines || ephemeralTargets {
+		return fmt.Errorf("memory-pressure recreates the docker-compose containers, so it can't run with -fake or -ephemeral")
+	}
+	steps, err := parseMemoryLimits(memoryLimits)
+	if err != nil {
+		return fmt.Errorf("-memory-limits: %v", err)
+	}
+	for _, t := range st.Targets {
+		if t.Service == "" {
+			return fmt.Errorf("%s is not a docker-compose service, so its memory can't be constrained", t.Label)
+		}
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, t := range st.Targets {
+			infof("Restoring %s as docker-compose.yml defines it...", t.Service)
+			if err := recreateService(t.Service); err != nil {
+				warnf("%s: Warning: Could not restore the container: %v", t.Label, err)
+				continue
+			}
+			if err := reconnectTarget(t); err != nil {
+				warnf("%s: Warning: %v", t.Label, err)
+			}
+		}
+	}()
+
+	sizes := map[string]int64{}
+	for _, t := range st.Targets {
+		n, err := indexBytes(t)
+		if err != nil {
+			return fmt.Errorf("%s: could not size the indexes: %v", t.Label, err)
+		}
+		sizes[t.Name] = n
+		infof("%s: indexes on products take %s", t.Label, formatBytes(uint64(n)))
+	}
+
+	var results []manifest.QueryResult
+	means := map[string]map[string]manifest.LatencyStats{}
+	for _, step := range steps {
+		infof("--- memory limit %s, shared_buffers %s ---", step.Label, formatBytes(uint64(step.Bytes/4)))
+		for _, t := range st.Targets {
+			if err := constrainService(t.Service, step); err != nil {
+				return fmt.Errorf("%s: %v", t.Label, err)
+			}
+			if err := reconnectTarget(t); err != nil {
+				return fmt.Errorf("%s: %v", t.Label, err)
+			}
+		}
+		planProblems, err := checkPlansOrFail(w, st.Targets)
+		if err != nil {
+			return err
+		}
+		for _, r := range runProfiledWorkload(w, st.Targets, st.sampling(), planProblems) {
+			key := fmt.Sprintf("%s %s", r.Query, argsLabel(r.Args))
+			if means[key] == nil {
+				means[key] = map[string]manifest.LatencyStats{}
+			}
+			means[key][r.Engine+"/"+step.Label] = r.Stats
+			r.Query = r.Query + "/mem=" + step.Label
+			results = append(results, r)
+		}
+	}
+
+	headers := []string{"Query", "Engine"}
+	for _, step := range steps {
+		headers = append(headers, step.Label)
+	}
+	headers = append(headers, "Slowdown")
+	table := newConsoleTable(headers...)
+	for _, q := range w.Queries {
+		for _, args := range q.Args {
+			key := fmt.Sprintf("%s %s", q.Name, argsLabel(args))
+			for _, t := range st.Targets {
+				row := []string{key, t.Label}
+				first, last := means[key][t.Name+"/"+steps[0].Label], means[key][t.Name+"/"+steps[len(steps)-1].Label]
+				for _, step := range steps {
+					s, ok := means[key][t.Name+"/"+step.Label]
+					if !ok {
+						row = append(row, "-")
+						continue
+					}
+					row = append(row, formatDuration(s.Mean))
+				}
+				slowdown := "-"
+				if first.Mean > 0 && last.Mean > 0 {
+					slowdown = fmt.Sprintf("%.1fx", float64(last.Mean)/float64(first.Mean))
+				}
+				table.addRow(append(row, slowdown)...)
+			}
+		}
+	}
+	fmt.Fprintln(progressOut, "\nMEAN LATENCY BY CONTAINER MEMORY LIMIT (cold start at each step):")
+	table.render(progressOut)
+	for _, t := range st.Targets {
+		for _, step := range steps {
+			if sizes[t.Name] > step.Bytes/4 {
+				fmt.Fprintf(progressOut, "%s: indexes (%s) exceed shared_buffers from %s down\n", t.Label, formatBytes(uint64(sizes[t.Name])), step.Label)
+				break
+			}
+		}
+	}
+
+	return saveResults(dataDir, "memory-pressure", manifest.KindQueries, st.Targets, results)
+}
+
+// commandError adds the stderr of a failed command to its error.
+func commandError(err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// restartRounds is how many times the restart scenario restarts each
+// target.
+var restartRounds = 3
+
+// restartTiming is one restart of one target, each phase measured from
+// the moment the stop was issued or the start was issued.
+type restartTiming struct {
+	Stop       time.Duration
+	Ready      time.Duration
+	FirstQuery time.Duration
+	// FirstLatency and WarmLatency time the probe search itself: the
+	// first execution after the restart and the one after it
+	FirstLatency time.Duration
+	WarmLatency  time.Duration
+}
+
+// restartTarget stops and starts a target's container and times how long
+// until it accepts connections and until the probe search succeeds.
+func restartTarget(t *benchTarget, probeStmt string, probeArgs []interface{}) (restartTiming, error) {
+	var rt restartTiming
+	t.DB.Close()
+
+	start := time.Now()
+	if out, err := exec.Command("docker", "compose", "stop", t.Service).CombinedOutput(); err != nil {
+		return rt, fmt.Errorf("docker compose stop %s: %v: %s", t.Service, err, strings.TrimSpace(string(out)))
+	}
+	rt.Stop = time.Since(start)
+
+	start = time.Now()
+	if out, err := exec.Command("docker", "compose", "start", t.Service).CombinedOutput(); err != nil {
+		return rt, fmt.Errorf("docker compose start %s: %v: %s", t.Service, err, strings.TrimSpace(string(out)))
+	}
+	deadline := start.Add(restartTimeout)
+	for exec.Command("docker", "compose", "exec", "-T", t.Service, "pg_isready", "-q", "-U", t.Config.User).Run() != nil {
+		if time.Now().After(deadline) {
+			return rt, fmt.Errorf("%s did not accept connections within %v", t.Service, restartTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	rt.Ready = time.Since(start)
+
+	db, err := getDB(t.Config)
+	if err != nil {
+		return rt, err
+	}
+	t.DB = db
+	for {
+		d, _, err := timeQuery(t.DB, probeStmt, probeArgs)
+		if err == nil {
+			rt.FirstQuery, rt.FirstLatency = time.Since(start), d
+			break
+		}
+		if time.Now().After(deadline) {
+			return rt, fmt.Errorf("probe search still failing %v after the start: %v", restartTimeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if rt.WarmLatency, _, err = timeQuery(t.DB, probeStmt, probeArgs); err != nil {
+		return rt, err
+	}
+	return rt, nil
+}
+
+// runRestartScenario restarts every target's container -restart-rounds
+// times with its indexes built and times the stop (including the shutdown
+// checkpoint), the start until connections are accepted, and the start
+// until the first search of the workload succeeds, since opening large
+// BM25 indexes after a restart is an operational cost of its own.
+func runRestartScenario(st *runState) error {
+	if fakeEngines || ephemeralTargets {
+		return fmt.Errorf("restart restarts the docker-compose containers, so it can't run with -fake or -ephemeral")
+	}
+	if restartRounds < 1 {
+		return fmt.Errorf("-restart-rounds must be positive")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	if len(w.Queries) == 0 || len(w.Queries[0].Args) == 0 {
+		return fmt.Errorf("%s has no query to probe the restarted targets with", w.Name)
+	}
+	probe := w.Queries[0]
+
+	var results []manifest.QueryResult
+	table := newConsoleTable("Engine", "Stop", "Accepting connections", "First search ok", "First search", "Next search")
+	for _, t := range st.Targets {
+		stmt, ok := probe.SQL[t.sqlName()]
+		if !ok {
+			continue
+		}
+		args := probe.bind(t.sqlName(), probe.Args[0])
+		var stops, ready, first, firstLatency, warmLatency []time.Duration
+		for round := 1; round <= restartRounds; round++ {
+			infof("%s: restart %d/%d...", t.Label, round, restartRounds)
+			rt, err := restartTarget(t, stmt, args)
+			if err != nil {
+				return fmt.Errorf("%s: %v", t.Label, err)
+			}
+			stops = append(stops, rt.Stop)
+			ready = append(ready, rt.Ready)
+			first = append(first, rt.FirstQuery)
+			firstLatency = append(firstLatency, rt.FirstLatency)
+			warmLatency = append(warmLatency, rt.WarmLatency)
+		}
+		phases := []struct {
+			name    string
+			samples []time.Duration
+		}{
+			{"stop", stops}, {"ready", ready}, {"first-query", first},
+			{"first-latency", firstLatency}, {"warm-latency", warmLatency},
+		}
+		row := []string{t.Label}
+		for _, p := range phases {
+			stats := summarize(p.samples)
+			row = append(row, formatDuration(stats.P50))
+			results = append(results, manifest.QueryResult{
+				Query: "restart/" + p.name, Category: "restart", Engine: t.Name,
+				Args: probe.Args[0], Stats: stats,
+			})
+		}
+		table.addRow(row...)
+	}
+	fmt.Fprintf(progressOut, "\nRESTART WITH BUILT INDEXES (median of %d, probed with %s):\n", restartRounds, probe.Name)
+	table.render(progressOut)
+
+	return saveResults(dataDir, "restart", manifest.KindQueries, st.Targets, results)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// RetentionTable is the copy of products the retention scenario deletes
+// from, so the main table and its indexes stay as the other scenarios
+// expect.
+const RetentionTable = "products_retention"
+
+var (
+	// retentionFraction of rows, the oldest by created_at, is deleted
+	retentionFraction = 0.5
+	// retentionDays is how far back the synthetic created_at values reach
+	retentionDays = 365
+)
+
+// setupRetentionTable copies products with created_at spread evenly over
+// the last -retention-days days by a hash of the ASIN, so every engine
+// dates the same product the same way, and builds the engine's search
+// indexes and a created_at index on the copy.
+func setupRetentionTable(t *benchTarget, now time.Time) error {
+	steps := []string{
+		"DROP TABLE IF EXISTS " + RetentionTable,
+		fmt.Sprintf(`CREATE TABLE %s AS
+			SELECT id, asin, title, description, price, brand, categories, sales_rank, image_url, provenance,
+			       '%s'::timestamp - (abs(hashtext(asin)::bigint) %% %d) * interval '1 second' AS created_at
+			FROM products`, RetentionTable, now.Format("2006-01-02 15:04:05"), int64(retentionDays)*24*3600),
+		fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (id)", RetentionTable),
+		fmt.Sprintf("CREATE INDEX %[1]s_created_at ON %[1]s (created_at)", RetentionTable),
+	}
+	if t.IsParadeDB {
+		steps = append(steps, strings.TrimSpace(fmt.Sprintf(t.bm25().Index, RetentionTable)))
+	} else {
+		steps = append(steps,
+			fmt.Sprintf("CREATE INDEX %[1]s_fulltext ON %[1]s USING gin(to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, '')))", RetentionTable),
+			fmt.Sprintf("CREATE INDEX %[1]s_title_trgm ON %[1]s USING gin (title gin_trgm_ops)", RetentionTable),
+		)
+	}
+	steps = append(steps, "ANALYZE "+RetentionTable)
+
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(productsSQL(stmt)); err != nil {
+			fields := strings.Fields(stmt)
+			return fmt.Errorf("%s %s: %v", fields[0], fields[1], err)
+		}
+	}
+	return nil
+}
+
+// runRetentionScenario applies a retention policy to a dated copy of
+// products: it deletes the oldest -retention-fraction of rows, then runs
+// VACUUM, which makes the space reusable, and VACUUM FULL, which rewrites
+// the table and rebuilds its indexes to return the space to the operating
+// system. Each step is timed, and the table and index sizes after it show
+// how much disk each engine actually gives back and when.
+func runRetentionScenario(st *runState) error {
+	if retentionFraction <= 0 || retentionFraction >= 1 {
+		return fmt.Errorf("-retention-fraction must be above 0 and below 1, got %v", retentionFraction)
+	}
+	if retentionDays < 1 {
+		return fmt.Errorf("-retention-days must be at least 1")
+	}
+	now := time.Now().UTC()
+	cutoff := now.Add(-time.Duration(float64(retentionDays) * (1 - retentionFraction) * float64(24*time.Hour)))
+
+	var results []manifest.ChurnResult
+	table := newConsoleTable("Engine", "Step", "Rows", "Time", "Table", "Indexes", "Reclaimed")
+	for _, t := range st.Targets {
+		var copied manifest.ChurnResult
+		step := func(op string, fn func() (int64, error)) error {
+			start := time.Now()
+			rows, err := fn()
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", t.Label, op, err)
+			}
+			r := manifest.ChurnResult{Engine: t.Name, Operation: op, Rows: rows, Duration: time.Since(start)}
+			if r.TableBytes, r.IndexBytes, err = catalogOf(t.DB).relationSizes(RetentionTable); err != nil {
+				return fmt.Errorf("%s: %v", t.Label, err)
+			}
+			reclaimed := "-"
+			if op == "copy" {
+				copied = r
+			} else {
+				freed := max(copied.TableBytes+copied.IndexBytes-r.TableBytes-r.IndexBytes, 0)
+				reclaimed = fmt.Sprintf("%s (%.0f%%)", formatBytes(uint64(freed)),
+					100*float64(freed)/float64(max(copied.TableBytes+copied.IndexBytes, 1)))
+			}
+			table.addRow(t.Label, op, fmt.Sprintf("%d", r.Rows), formatDuration(r.Duration),
+				formatBytes(uint64(r.TableBytes)), formatBytes(uint64(r.IndexBytes)), reclaimed)
+			results = append(results, r)
+			return nil
+		}
+		exec := func(stmt string) func() (int64, error) {
+			return func() (int64, error) {
+				res, err := t.DB.Exec(stmt)
+				if err != nil {
+					return 0, err
+				}
+				return res.RowsAffected()
+			}
+		}
+
+		infof("%s: Copying products to %s with created_at over the last %d days...", t.Label, RetentionTable, retentionDays)
+		err := step("copy", func() (int64, error) {
+			if err := setupRetentionTable(t, now); err != nil {
+				return 0, err
+			}
+			var n int64
+			err := t.DB.QueryRow("SELECT count(*) FROM " + RetentionTable).Scan(&n)
+			return n, err
+		})
+		if err != nil {
+			return err
+		}
+		infof("%s: Deleting rows created before %s...", t.Label, cutoff.Format(time.RFC3339))
+		if err := step("delete", exec(fmt.Sprintf("DELETE FROM %s WHERE created_at < '%s'", RetentionTable, cutoff.Format("2006-01-02 15:04:05")))); err != nil {
+			return err
+		}
+		if err := step("vacuum", exec("VACUUM "+RetentionTable)); err != nil {
+			return err
+		}
+		if err := step("vacuum full", exec("VACUUM FULL "+RetentionTable)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(progressOut, "\nRETENTION (%.0f%% of rows deleted by created_at):\n", retentionFraction*100)
+	table.render(progressOut)
+	return saveResults(dataDir, "retention", manifest.KindChurn, st.Targets, results)
+}
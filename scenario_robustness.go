@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+// degenerateCategory is the category the robustness scenario files its
+// results under.
+const degenerateCategory = "degenerate"
+
+// degenerateInputs are search strings real traffic sends constantly and
+// that query parsers tend to trip over.
+var degenerateInputs = []string{
+	"",
+	"   ",
+	"the",
+	"the and of to a",
+	"a",
+	"x",
+	"!!!",
+	"&|!():*'\"",
+	"-",
+	"\"",
+	"%_\\",
+	"'; DROP TABLE products; --",
+	"title:foo OR id:1",
+	"foo) | (bar",
+	"AND OR NOT",
+	strings.TrimSpace(strings.Repeat("wireless headphones ", 150)),
+}
+
+// degenerateLabel shortens an input for the console.
+func degenerateLabel(s string) string {
+	if len(s) > 24 {
+		return fmt.Sprintf("%q (%d chars)", s[:20]+"...", len(s))
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// degenerateWorkload binds every single-argument query of w to the
+// degenerate inputs instead of its own. Plan expectations are dropped:
+// they describe real searches, and an empty query may rightly skip the index.
+func degenerateWorkload(w *Workload) *Workload {
+	out := &Workload{Name: w.Name + "-" + degenerateCategory}
+	for _, q := range w.Queries {
+		if len(q.Args) == 0 || len(q.Args[0]) != 1 {
+			continue
+		}
+		dq := WorkloadQuery{Name: q.Name, Category: degenerateCategory, SQL: q.SQL, Escape: q.Escape}
+		for _, input := range degenerateInputs {
+			dq.Args = append(dq.Args, []interface{}{input})
+		}
+		out.Queries = append(out.Queries, dq)
+	}
+	return out
+}
+
+// runRobustnessScenario sends degenerate searches (empty, stopwords only,
+// single characters, punctuation, very long strings) through the workload's
+// queries and fails if any engine errors on one, recording latencies too.
+func runRobustnessScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	dw := degenerateWorkload(w)
+	if len(dw.Queries) == 0 {
+		return fmt.Errorf("%s has no single-argument queries to send degenerate input through", w.Name)
+	}
+
+	results := runWorkload(dw, st.Targets, st.sampling(), nil)
+	if err := saveResults(dataDir, "robustness", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+
+	headers := []string{"Query", "Input"}
+	for _, t := range st.Targets {
+		headers = append(headers, t.Label)
+	}
+	table := newConsoleTable(headers...)
+	byCell := map[string]manifest.QueryResult{}
+	for _, r := range results {
+		byCell[fmt.Sprintf("%s/%v/%s", r.Query, r.Args[0], r.Engine)] = r
+	}
+	var failures []string
+	for _, q := range dw.Queries {
+		for _, args := range q.Args {
+			row := []string{q.Name, degenerateLabel(args[0].(string))}
+			for _, t := range st.Targets {
+				r, ok := byCell[fmt.Sprintf("%s/%v/%s", q.Name, args[0], t.Name)]
+				switch {
+				case !ok:
+					row = append(row, "-")
+				case r.Error != "":
+					row = append(row, "ERROR")
+					failures = append(failures, fmt.Sprintf("%s %s [%s]: %s", q.Name, degenerateLabel(args[0].(string)), t.Name, r.Error))
+				default:
+					row = append(row, fmt.Sprintf("%s (%d)", formatDuration(r.Stats.Mean), r.Rows))
+				}
+			}
+			table.addRow(row...)
+		}
+	}
+	fmt.Fprintln(progressOut, "\nDEGENERATE INPUT:")
+	table.render(progressOut)
+
+	if len(failures) > 0 {
+		for _, f := range failures {
+			errorf("❌ %s", f)
+		}
+		return fmt.Errorf("%d degenerate search(es) failed", len(failures))
+	}
+	return nil
+}
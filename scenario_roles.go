@@ -0,0 +1,189 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"pg_search_benchmark/manifest"
+)
+
+// restrictedRole is the login role the roles scenario creates on every
+// target and searches as.
+var restrictedRole = "pgsb_reader"
+
+// restrictedSuffix marks the engine names of targets searched as the
+// restricted role.
+const restrictedSuffix = "-restricted"
+
+// createRestrictedRole (re)creates the restricted role on a target with a
+// fresh password and grants it nothing beyond connecting, the public schema
+// and SELECT on products. Any leftover role from an earlier run is dropped
+// first.
+func createRestrictedRole(t *benchTarget, password string) error {
+	if err := dropRestrictedRole(t); err != nil {
+		return err
+	}
+	role := pq.QuoteIdentifier(restrictedRole)
+	stmts := []string{
+		"CREATE ROLE " + role + " LOGIN NOSUPERUSER NOCREATEDB NOCREATEROLE NOINHERIT NOBYPASSRLS PASSWORD " + pq.QuoteLiteral(password),
+		"GRANT CONNECT ON DATABASE " + pq.QuoteIdentifier(t.Config.Database) + " TO " + role,
+		"GRANT USAGE ON SCHEMA public TO " + role,
+		"GRANT SELECT ON " + productsTable + " TO " + role,
+	}
+	if dbSchema != "" {
+		stmts = append(stmts, "GRANT USAGE ON SCHEMA "+dbSchema+" TO "+role)
+	}
+	for _, stmt := range stmts {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+	return nil
+}
+
+// dropRestrictedRole removes the restricted role and its grants, if it exists.
+func dropRestrictedRole(t *benchTarget) error {
+	var n int
+	if err := t.DB.QueryRow("SELECT count(*) FROM pg_roles WHERE rolname = $1", restrictedRole).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+	role := pq.QuoteIdentifier(restrictedRole)
+	for _, stmt := range []string{"DROP OWNED BY " + role, "DROP ROLE " + role} {
+		if _, err := t.DB.Exec(stmt); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+	return nil
+}
+
+// connectRestricted opens a target as the restricted role and checks the
+// session really is not a superuser.
+func connectRestricted(t *benchTarget, password string) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	if fakeEngines {
+		db, err = openFakeEngine(t.Name)
+	} else {
+		config := t.Config
+		config.User, config.Password = restrictedRole, password
+		db, err = getDB(config)
+	}
+	if err != nil {
+		return nil, err
+	}
+	super, err := catalogOf(db).isSuperuser()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if super {
+		db.Close()
+		return nil, fmt.Errorf("%s is a superuser", restrictedRole)
+	}
+	return db, nil
+}
+
+// runRolesScenario creates a login role on every target that may only
+// SELECT from products, times the workload as the owner and as that role,
+// and fails if a query works for the owner but not for the role, for
+// example a pg_search function the role may not execute. The latency
+// difference is the cost of the permission checks, which are not cached
+// across executions. The role is dropped afterwards.
+func runRolesScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	password := hex.EncodeToString(secret)
+
+	var restricted []*benchTarget
+	defer func() {
+		for _, r := range restricted {
+			r.DB.Close()
+		}
+		for _, t := range st.Targets {
+			if err := dropRestrictedRole(t); err != nil {
+				warnf("%s: Warning: Could not drop role %s: %v", t.Label, restrictedRole, err)
+			}
+		}
+	}()
+	for _, t := range st.Targets {
+		if err := createRestrictedRole(t, password); err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		db, err := connectRestricted(t, password)
+		if err != nil {
+			return fmt.Errorf("%s: could not connect as %s: %v", t.Label, restrictedRole, err)
+		}
+		restricted = append(restricted, &benchTarget{
+			Name: t.Name + restrictedSuffix, Label: t.Label + " as " + restrictedRole,
+			Service: t.Service, Config: t.Config, IsParadeDB: t.IsParadeDB, DB: db, SQLEngine: t.sqlName(),
+		})
+	}
+
+	infof("Workload as the owner")
+	ownerProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	owner := runProfiledWorkload(w, st.Targets, st.sampling(), ownerProblems)
+	infof("Workload as %s", restrictedRole)
+	restrictedProblems := checkWorkloadPlans(w, restricted)
+	asRole := runProfiledWorkload(w, restricted, st.sampling(), restrictedProblems)
+
+	ownerByCell := map[string]manifest.QueryResult{}
+	for _, r := range owner {
+		ownerByCell[fmt.Sprintf("%s/%v/%s", r.Query, r.Args, r.Engine)] = r
+	}
+	var failures []string
+	table := newConsoleTable("Query", "Args", "Engine", "Owner", restrictedRole, "Change")
+	for _, r := range asRole {
+		o, ok := ownerByCell[fmt.Sprintf("%s/%v/%s", r.Query, r.Args, strings.TrimSuffix(r.Engine, restrictedSuffix))]
+		if !ok {
+			continue
+		}
+		change := "-"
+		roleMean := formatDuration(r.Stats.Mean)
+		switch {
+		case r.Error != "" && o.Error == "":
+			roleMean = "ERROR"
+			failures = append(failures, fmt.Sprintf("%s %s [%s]: %s", r.Query, argsLabel(r.Args), r.Engine, r.Error))
+		case r.Error == "" && o.Error == "" && o.Stats.Mean > 0:
+			change = fmt.Sprintf("%+.1f%%", (float64(r.Stats.Mean)/float64(o.Stats.Mean)-1)*100)
+		}
+		table.addRow(r.Query, argsLabel(r.Args), engineLabel(o.Engine, st.Targets), formatDuration(o.Stats.Mean), roleMean, change)
+	}
+	fmt.Fprintf(progressOut, "\nWORKLOAD AS THE OWNER AND AS %s (SELECT on products only):\n", restrictedRole)
+	table.render(progressOut)
+	for key, problems := range restrictedProblems {
+		if len(ownerProblems[strings.TrimSuffix(key, restrictedSuffix)]) > 0 {
+			continue
+		}
+		for _, p := range problems {
+			failures = append(failures, fmt.Sprintf("%s: %s", key, p))
+		}
+	}
+
+	if err := saveResults(dataDir, "roles", manifest.KindQueries, append(st.Targets, restricted...), append(owner, asRole...)); err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		for _, f := range failures {
+			errorf("❌ %s", f)
+		}
+		return fmt.Errorf("%d problem(s) searching as %s", len(failures), restrictedRole)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// selectivityTerms is how many terms the selectivity scenario searches
+	// per bucket
+	selectivityTerms = 5
+	// selectivityCategory is the workload category whose queries the
+	// selectivity scenario sends the bucketed terms through
+	selectivityCategory = "fulltext"
+)
+
+// selectivityBuckets are the document shares the selectivity scenario
+// draws terms from, in the order they are timed: a term belongs to the
+// first bucket whose bound its share is under.
+var selectivityBuckets = []struct {
+	Name  string
+	Below float64
+}{
+	{"rare", 0.001},
+	{"medium", 0.01},
+	{"common", 1.01},
+}
+
+// englishStopwords are PostgreSQL's english stop words; the vanilla
+// queries drop them, so they would match nothing there.
+var englishStopwords = stopwordSet(`i me my myself we our ours ourselves you your yours yourself yourselves
+	he him his himself she her hers herself it its itself they them their theirs themselves what which
+	who whom this that these those am is are was were be been being have has had having do does did doing
+	a an the and but if or because as until while of at by for with about against between into through
+	during before after above below to from up down in out on off over under again further then once here
+	there when where why how all any both each few more most other some such no nor not only own same so
+	than too very s t can will just don should now`)
+
+func stopwordSet(words string) map[string]bool {
+	set := map[string]bool{}
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// searchableTerm is a dictionary term worth a query of its own: a word of
+// three letters or more that no engine drops as a stop word.
+func searchableTerm(term string) bool {
+	if len(term) < 3 || englishStopwords[term] {
+		return false
+	}
+	for _, r := range term {
+		if !unicode.IsLetter(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// bucketTerms picks n searchable terms per bucket, spread evenly over the
+// bucket's range of document frequencies. Terms in a single document are
+// left out of rare, as they are mostly typos.
+func bucketTerms(d *termDictionary, n int) map[string][]termStat {
+	byBucket := map[string][]termStat{}
+	for _, s := range d.sorted() {
+		if !searchableTerm(s.Term) || s.DF < 2 {
+			continue
+		}
+		share := float64(s.DF) / float64(d.Documents)
+		for _, b := range selectivityBuckets {
+			if share < b.Below {
+				byBucket[b.Name] = append(byBucket[b.Name], s)
+				break
+			}
+		}
+	}
+	picked := map[string][]termStat{}
+	for name, terms := range byBucket {
+		switch {
+		case len(terms) <= n:
+			picked[name] = terms
+		case n == 1:
+			picked[name] = terms[len(terms)/2 : len(terms)/2+1]
+		default:
+			for i := 0; i < n; i++ {
+				picked[name] = append(picked[name], terms[i*(len(terms)-1)/(n-1)])
+			}
+		}
+	}
+	return picked
+}
+
+// selectivityWorkload binds the single-argument queries of the category to
+// the bucketed terms, one category per bucket. Plan expectations are
+// dropped: a rare term may rightly be served another way than a common one.
+func selectivityWorkload(w *Workload, picked map[string][]termStat) *Workload {
+	out := &Workload{Name: w.Name + "-selectivity"}
+	for _, b := range selectivityBuckets {
+		for _, q := range w.Queries {
+			if q.Category != selectivityCategory || len(q.Args) == 0 || len(q.Args[0]) != 1 {
+				continue
+			}
+			sq := WorkloadQuery{Name: q.Name, Category: b.Name, SQL: q.SQL, Escape: q.Escape}
+			for _, s := range picked[b.Name] {
+				sq.Args = append(sq.Args, []interface{}{s.Term})
+			}
+			if len(sq.Args) > 0 {
+				out.Queries = append(out.Queries, sq)
+			}
+		}
+	}
+	return out
+}
+
+// runSelectivityScenario times the workload's -selectivity-category queries
+// with terms drawn from the term dictionary at rare, medium and common
+// document frequencies, and reports latency per bucket and engine: where
+// one engine overtakes another depends on how much a search matches.
+func runSelectivityScenario(st *runState) error {
+	if termDictionaryFile == "" {
+		return fmt.Errorf("no term dictionary for this dataset and sample size; run analyze with the -dataset and -sample-size the data was loaded with")
+	}
+	d, err := readTermDictionary(termDictionaryFile)
+	if err != nil {
+		return err
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+	picked := bucketTerms(d, selectivityTerms)
+	sw := selectivityWorkload(w, picked)
+	if len(sw.Queries) == 0 {
+		return fmt.Errorf("%s has no single-argument %s queries to send the terms through", w.Name, selectivityCategory)
+	}
+
+	table := newConsoleTable("Bucket", "Term", "Documents", "Share")
+	for _, b := range selectivityBuckets {
+		for _, s := range picked[b.Name] {
+			table.addRow(b.Name, s.Term, fmt.Sprintf("%d", s.DF), fmt.Sprintf("%.3f%%", 100*float64(s.DF)/float64(d.Documents)))
+		}
+	}
+	fmt.Fprintf(progressOut, "Terms from %s (%d documents):\n", termDictionaryFile, d.Documents)
+	table.render(progressOut)
+
+	results := runWorkload(sw, st.Targets, st.sampling(), nil)
+	if err := saveResults(dataDir, "selectivity", manifest.KindQueries, st.Targets, results); err != nil {
+		return err
+	}
+	printReadSummary("LATENCY BY SELECTIVITY", results, st.Targets, nil)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+// SoftDeleteTable is the copy of products the soft-delete scenario searches,
+// so the main table and its indexes stay as the other scenarios expect.
+const SoftDeleteTable = "products_soft"
+
+// softDeleteWorkload is timed by the soft-delete scenario; its queries must
+// exclude deleted rows with "deleted_at IS NULL" to use the partial indexes.
+var softDeleteWorkload = "workloads/soft-delete.yaml"
+
+// softDeletePredicate is the predicate of every partial index.
+const softDeletePredicate = "deleted_at IS NULL"
+
+// partialIndex renders the BM25 index DDL for table restricted to rows
+// matching predicate. pg_search accepts a WHERE clause on CREATE INDEX; the
+// procedure syntax of older releases has no equivalent here.
+func (t bm25Template) partialIndex(table, predicate string) (string, error) {
+	ddl := strings.TrimSpace(fmt.Sprintf(t.Index, table))
+	if !strings.HasPrefix(ddl, "CREATE INDEX") {
+		return "", fmt.Errorf("partial BM25 indexes need the CREATE INDEX syntax (pg_search 0.13+), not %s", t.Name)
+	}
+	return ddl + "\nWHERE " + predicate, nil
+}
+
+// setupSoftDeleteTable copies products with a deleted_at column set on
+// fraction of the rows and builds the indexes over live rows only. Rows are
+// picked by a hash of the ASIN, so every engine deletes the same products.
+func setupSoftDeleteTable(t *benchTarget, fraction float64) error {
+	steps := []string{
+		"DROP TABLE IF EXISTS " + SoftDeleteTable,
+		fmt.Sprintf(`CREATE TABLE %s AS
+			SELECT p.*, CASE WHEN abs(hashtext(asin)::bigint) %% 10000 < %d THEN now() END AS deleted_at
+			FROM products p`, SoftDeleteTable, int(fraction*10000)),
+		fmt.Sprintf("ALTER TABLE %s ADD PRIMARY KEY (id)", SoftDeleteTable),
+	}
+	if t.IsParadeDB {
+		ddl, err := t.bm25().partialIndex(SoftDeleteTable, softDeletePredicate)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, ddl)
+	} else {
+		steps = append(steps,
+			fmt.Sprintf("CREATE INDEX %[1]s_live_fulltext ON %[1]s USING gin(to_tsvector('english', COALESCE(title, '') || ' ' || COALESCE(description, '') || ' ' || COALESCE(brand, ''))) WHERE %[2]s", SoftDeleteTable, softDeletePredicate),
+			fmt.Sprintf("CREATE INDEX %[1]s_live_title_trgm ON %[1]s USING gin (title gin_trgm_ops) WHERE %[2]s", SoftDeleteTable, softDeletePredicate),
+		)
+	}
+	steps = append(steps, "ANALYZE "+SoftDeleteTable)
+
+	for _, stmt := range steps {
+		if _, err := t.DB.Exec(productsSQL(stmt)); err != nil {
+			fields := strings.Fields(stmt)
+			return fmt.Errorf("%s %s: %v", fields[0], fields[1], err)
+		}
+	}
+	return nil
+}
+
+// runSoftDeleteScenario times searches that must skip soft-deleted rows,
+// against partial indexes on both engines.
+func runSoftDeleteScenario(st *runState) error {
+	if st.DeletedFraction < 0 || st.DeletedFraction >= 1 {
+		return fmt.Errorf("-deleted-fraction must be at least 0 and below 1, got %v", st.DeletedFraction)
+	}
+	w, err := loadWorkload(softDeleteWorkload)
+	if err != nil {
+		return err
+	}
+
+	deleted := map[string]int64{}
+	for _, t := range st.Targets {
+		infof("%s: Copying products to %s with %.0f%% of rows soft-deleted...", t.Label, SoftDeleteTable, st.DeletedFraction*100)
+		if err := setupSoftDeleteTable(t, st.DeletedFraction); err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		var total, n int64
+		err := t.DB.QueryRow("SELECT count(*), count(deleted_at) FROM "+SoftDeleteTable).Scan(&total, &n)
+		if err != nil {
+			return fmt.Errorf("%s: %v", t.Label, err)
+		}
+		deleted[t.Name] = n
+		infof("%s: %d of %d rows deleted", t.Label, n, total)
+	}
+	for _, t := range st.Targets[1:] {
+		if deleted[t.Name] != deleted[st.Targets[0].Name] {
+			warnf("⚠️  %s and %s deleted different numbers of rows; their data differs", st.Targets[0].Label, t.Label)
+		}
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	return saveResults(dataDir, "soft-delete", manifest.KindQueries, st.Targets, results)
+}
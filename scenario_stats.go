@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"pg_search_benchmark/manifest"
+)
+
+var (
+	// statisticsTargets are the default_statistics_target values the
+	// statistics scenario analyzes the vanilla table with
+	statisticsTargets = "10,100,1000"
+	// extendedStats also measures each target with extended statistics on
+	// brand and categories
+	extendedStats = true
+	// statisticsWorkload has the search+filter queries the statistics
+	// scenario times
+	statisticsWorkload = "workloads/filtered.yaml"
+)
+
+// extendedStatsName is the extended statistics object the scenario creates.
+const extendedStatsName = "products_brand_categories_stats"
+
+// analyzeWith re-analyzes products with a statistics target, with or
+// without extended statistics on brand and categories. SET LOCAL keeps the
+// target to the ANALYZE in the same transaction.
+func analyzeWith(t *benchTarget, target int, extended bool) error {
+	tx, err := t.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmts := []string{"DROP STATISTICS IF EXISTS " + extendedStatsName}
+	if extended {
+		stmts = append(stmts, "CREATE STATISTICS "+extendedStatsName+" (ndistinct, dependencies, mcv) ON brand, categories FROM products")
+	}
+	stmts = append(stmts, fmt.Sprintf("SET LOCAL default_statistics_target = %d", target), "ANALYZE products")
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(productsSQL(stmt)); err != nil {
+			return fmt.Errorf("%s: %v", stmt, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// statisticsSetting labels one analyzed state of the table.
+func statisticsSetting(target int, extended bool) string {
+	if extended {
+		return fmt.Sprintf("stats=%d+ext", target)
+	}
+	return fmt.Sprintf("stats=%d", target)
+}
+
+// runStatisticsScenario re-analyzes the vanilla table at each
+// -statistics-targets value, with and without extended statistics, and
+// times the search+filter workload after each. Every result keeps the
+// estimated and actual rows of the table scan from EXPLAIN ANALYZE, so a
+// plan change between settings can be traced to the estimate behind it.
+func runStatisticsScenario(st *runState) error {
+	targets, err := parseLimits(statisticsTargets)
+	if err != nil {
+		return fmt.Errorf("-statistics-targets: %v", err)
+	}
+	var vanilla *benchTarget
+	for _, t := range st.Targets {
+		if !t.IsParadeDB {
+			vanilla = t
+			break
+		}
+	}
+	if vanilla == nil {
+		return fmt.Errorf("no vanilla target to analyze")
+	}
+	w, err := loadWorkload(statisticsWorkload)
+	if err != nil {
+		return err
+	}
+	variants := []bool{false}
+	if extendedStats {
+		variants = append(variants, true)
+	}
+
+	var results []manifest.QueryResult
+	table := newConsoleTable("Query", "Args", "Statistics", "Scan", "Estimated", "Actual", "Off by", "Mean")
+	for _, target := range targets {
+		for _, extended := range variants {
+			setting := statisticsSetting(target, extended)
+			infof("%s: ANALYZE with %s", vanilla.Label, setting)
+			if err := analyzeWith(vanilla, target, extended); err != nil {
+				return fmt.Errorf("%s: %v", vanilla.Label, err)
+			}
+			for _, r := range runWorkload(w, []*benchTarget{vanilla}, st.sampling(), nil) {
+				q := workloadQuery(w, r.Query)
+				r.Query = r.Query + "/" + setting
+				scan := "-"
+				est := manifest.RowEstimate{}
+				if r.Error == "" && q != nil {
+					plan, err := explainAnalyzePlan(vanilla.DB, q.SQL[vanilla.sqlName()], q.bind(vanilla.sqlName(), r.Args))
+					if err != nil {
+						errorf("%s: EXPLAIN ANALYZE of %s failed: %v", vanilla.Label, r.Query, err)
+					} else if e, ok := plan.scanEstimate(); ok {
+						est = e
+						r.RowEstimates = []manifest.RowEstimate{e}
+						scan = e.Node
+					}
+				}
+				results = append(results, r)
+				estimated, actual, offBy := "-", "-", "-"
+				if len(r.RowEstimates) > 0 {
+					estimated, actual = fmt.Sprintf("%.0f", est.Estimated), fmt.Sprintf("%.0f", est.Actual)
+					offBy = fmt.Sprintf("%.1fx", est.Error())
+				}
+				table.addRow(strings.TrimSuffix(r.Query, "/"+setting), argsLabel(r.Args), setting, scan,
+					estimated, actual, offBy, formatDuration(r.Stats.Mean))
+			}
+		}
+	}
+
+	// Leave the table analyzed the way the other scenarios found it
+	if _, err := vanilla.DB.Exec("DROP STATISTICS IF EXISTS " + extendedStatsName); err != nil {
+		warnf("%s: Warning: Could not drop %s: %v", vanilla.Label, extendedStatsName, err)
+	}
+	if _, err := vanilla.DB.Exec("ANALYZE " + productsTable); err != nil {
+		warnf("%s: Warning: Could not re-analyze products: %v", vanilla.Label, err)
+	}
+
+	fmt.Fprintln(progressOut, "\nPLANNER ESTIMATES BY STATISTICS TARGET:")
+	table.render(progressOut)
+	return saveResults(dataDir, "statistics", manifest.KindQueries, st.Targets, results)
+}
+
+// workloadQuery finds a query of w by name.
+func workloadQuery(w *Workload, name string) *WorkloadQuery {
+	for i := range w.Queries {
+		if w.Queries[i].Name == name {
+			return &w.Queries[i]
+		}
+	}
+	return nil
+}
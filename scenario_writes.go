@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// backgroundWriter inserts synthetic products at roughly rate rows/sec until
+// stop is closed and returns how many rows it wrote. Calls against the same
+// table without a cleanup between them pass different n, as the same n
+// writes the same ASINs again.
+func backgroundWriter(db *sql.DB, rate int, n int64, stop <-chan struct{}) int64 {
+	if rate <= 0 {
+		return 0
+	}
+	faker := seededFaker("background-writes", n)
+	perTick := rate / 10
+	if perTick < 1 {
+		perTick = 1
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var written int64
+	for {
+		select {
+		case <-stop:
+			return written
+		case <-ticker.C:
+			if err := insertBatch(db, syntheticProducts(faker, perTick)); err != nil {
+				errorf("Background write failed: %v", err)
+				continue
+			}
+			written += int64(perTick)
+		}
+	}
+}
+
+func runMixedScenario(st *runState) error {
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	planProblems, err := checkPlansOrFail(w, st.Targets)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	written := make([]int64, len(st.Targets))
+	var wg sync.WaitGroup
+	for i, t := range st.Targets {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			written[i] = backgroundWriter(db, st.WriteRate, 0, stop)
+		}(i, t.DB)
+	}
+
+	start := time.Now()
+	results := runProfiledWorkload(w, st.Targets, st.sampling(), planProblems)
+	close(stop)
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	for i, t := range st.Targets {
+		infof("%s: %d rows written during the run (%.0f rows/sec)", t.Label, written[i], float64(written[i])/elapsed.Seconds())
+		if _, err := t.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%"); err != nil {
+			warnf("%s: Warning: Could not delete the rows written during the run: %v", t.Label, err)
+		}
+	}
+	return saveResults(dataDir, "mixed", manifest.KindQueries, st.Targets, results)
+}
+
+func runChurnScenario(st *runState) error {
+	var results []manifest.ChurnResult
+
+	for _, t := range st.Targets {
+		// Every target churns the same rows
+		faker := seededFaker("churn", 0)
+		infof("%s: Churning %d rows", t.Label, st.ChurnRows)
+		timed := func(op string, fn func() (int64, error)) error {
+			start := time.Now()
+			rows, err := fn()
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", t.Label, op, err)
+			}
+			r := manifest.ChurnResult{Engine: t.Name, Operation: op, Rows: rows, Duration: time.Since(start)}
+			infof("  %s: %d rows in %s", op, r.Rows, formatDuration(r.Duration))
+			results = append(results, r)
+			return nil
+		}
+
+		err := timed("insert", func() (int64, error) {
+			for done := 0; done < st.ChurnRows; done += BatchSize {
+				n := BatchSize
+				if st.ChurnRows-done < n {
+					n = st.ChurnRows - done
+				}
+				if err := insertBatch(t.DB, syntheticProducts(faker, n)); err != nil {
+					return int64(done), err
+				}
+			}
+			return int64(st.ChurnRows), nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Only the rows just inserted are updated and then deleted, so the
+		// corpus the other scenarios search is left as it was
+		err = timed("update", func() (int64, error) {
+			res, err := t.DB.Exec(productsSQL(`
+				UPDATE products SET title = title || ' (refreshed)' WHERE asin LIKE $1
+			`), syntheticASINPrefix+"%")
+			if err != nil {
+				return 0, err
+			}
+			return res.RowsAffected()
+		})
+		if err != nil {
+			return err
+		}
+
+		err = timed("delete", func() (int64, error) {
+			res, err := t.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%")
+			if err != nil {
+				return 0, err
+			}
+			return res.RowsAffected()
+		})
+		if err != nil {
+			return err
+		}
+
+		err = timed("vacuum", func() (int64, error) {
+			_, err := t.DB.Exec("VACUUM " + productsTable)
+			return 0, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return saveResults(dataDir, "churn", manifest.KindChurn, st.Targets, results)
+}
@@ -0,0 +1,88 @@
+// Package searchquery turns raw user input into text each engine's query
+// parser accepts, so hostile or malformed search boxes can't change what a
+// query means or make it fail to parse. The results are still meant to be
+// bound as parameters; this package does not make string concatenation
+// into SQL safe.
+package searchquery
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Modes lists the escapings Escape accepts.
+var Modes = []string{"tsquery", "tantivy", "tantivy-phrase"}
+
+// Escape applies the named escaping to input.
+func Escape(mode, input string) (string, error) {
+	switch mode {
+	case "tsquery":
+		return TSQuery(input), nil
+	case "tantivy":
+		return Tantivy(input), nil
+	case "tantivy-phrase":
+		return TantivyPhrase(input), nil
+	}
+	return "", fmt.Errorf("unknown escaping %q (want %s)", mode, strings.Join(Modes, ", "))
+}
+
+// TSQuery returns a to_tsquery argument matching every whitespace-separated
+// word of input. Each word is quoted, so &, |, !, <->, :* and parentheses
+// are searched as text rather than read as operators; the text search
+// parser then drops punctuation as it would for plainto_tsquery. Control
+// characters separate words. Input without words gives "", which
+// to_tsquery treats as an empty query.
+func TSQuery(input string) string {
+	words := strings.Fields(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, input))
+	for i, w := range words {
+		w = strings.ReplaceAll(w, `\`, `\\`)
+		words[i] = "'" + strings.ReplaceAll(w, "'", "''") + "'"
+	}
+	return strings.Join(words, " & ")
+}
+
+// tantivySpecial are the characters the pg_search query parser (Tantivy's)
+// gives meaning to.
+const tantivySpecial = `+-&|!(){}[]^"'~*?:\/<>=`
+
+// tantivyOperators are words the parser reads as operators when uppercase.
+var tantivyOperators = map[string]bool{"AND": true, "OR": true, "NOT": true, "TO": true, "IN": true}
+
+// Tantivy returns a paradedb.parse argument searching the words of input.
+// Special characters become spaces rather than escapes, since the escape
+// rules changed across Tantivy releases and the tokenizer would drop them
+// anyway; operator keywords are lowercased so they are searched as words.
+func Tantivy(input string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(tantivySpecial, r) || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, input)
+	words := strings.Fields(cleaned)
+	for i, w := range words {
+		if tantivyOperators[w] {
+			words[i] = strings.ToLower(w)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// TantivyPhrase returns text that can sit between double quotes in a
+// paradedb.parse phrase such as title:"%s". Quotes and backslashes, which
+// would end or escape the phrase, become spaces.
+func TantivyPhrase(input string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, input)
+	return strings.Join(strings.Fields(cleaned), " ")
+}
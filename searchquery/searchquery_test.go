@@ -0,0 +1,77 @@
+package searchquery
+
+import "testing"
+
+func TestTSQuery(t *testing.T) {
+	for _, tc := range []struct{ name, input, want string }{
+		{"empty", "", ""},
+		{"whitespace only", " \t\n ", ""},
+		{"words", "smart  speaker", `'smart' & 'speaker'`},
+		{"apostrophe", "Levi's jeans", `'Levi''s' & 'jeans'`},
+		{"double quote", `"quoted"`, `'"quoted"'`},
+		{"backslash", `C:\path\`, `'C:\\path\\'`},
+		{"operators", "a&b | !c (d) e:* f<->g <h>", `'a&b' & '|' & '!c' & '(d)' & 'e:*' & 'f<->g' & '<h>'`},
+		{"control characters", "nul\x00bell\x07 tab\tend\x7f", `'nul' & 'bell' & 'tab' & 'end'`},
+		{"bare keywords", "AND OR NOT", `'AND' & 'OR' & 'NOT'`},
+		{"unicode", "café 日本", `'café' & '日本'`},
+	} {
+		if got := TSQuery(tc.input); got != tc.want {
+			t.Errorf("%s: TSQuery(%q) = %q, want %q", tc.name, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestTantivy(t *testing.T) {
+	for _, tc := range []struct{ name, input, want string }{
+		{"empty", "", ""},
+		{"whitespace only", " \t\n ", ""},
+		{"words", "smart  speaker", "smart speaker"},
+		{"apostrophe", "Levi's jeans", "Levi s jeans"},
+		{"double quote", `say "hi"`, "say hi"},
+		{"backslash", `C:\path\`, "C path"},
+		{"operators", "a&b | !c (d) e:* f<->g <h>", "a b c d e f g h"},
+		{"query syntax", `title:foo +bar -baz [1 TO 5] {x} ^2 ~1 fo? a/b c=d`, "title foo bar baz 1 to 5 x 2 1 fo a b c d"},
+		{"control characters", "nul\x00bell\x07end\x7f", "nul bell end"},
+		{"bare keywords", "AND OR NOT TO IN", "and or not to in"},
+		{"lowercase keywords", "and or not", "and or not"},
+		{"keyword inside a word", "ANDROID NOTES", "ANDROID NOTES"},
+		{"unicode", "café 日本", "café 日本"},
+	} {
+		if got := Tantivy(tc.input); got != tc.want {
+			t.Errorf("%s: Tantivy(%q) = %q, want %q", tc.name, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestTantivyPhrase(t *testing.T) {
+	for _, tc := range []struct{ name, input, want string }{
+		{"empty", "", ""},
+		{"whitespace only", " \t\n ", ""},
+		{"words", " smart  speaker ", "smart speaker"},
+		{"apostrophe", "Levi's jeans", "Levi's jeans"},
+		{"double quote", `say "hi"`, "say hi"},
+		{"closing the phrase", `x" OR title:"y`, "x OR title: y"},
+		{"backslash", `C:\path\`, "C: path"},
+		{"operators", "a&b | !c (d) e:*", "a&b | !c (d) e:*"},
+		{"control characters", "nul\x00bell\x07end\x7f", "nul bell end"},
+		{"bare keywords", "AND OR NOT", "AND OR NOT"},
+	} {
+		if got := TantivyPhrase(tc.input); got != tc.want {
+			t.Errorf("%s: TantivyPhrase(%q) = %q, want %q", tc.name, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	for _, mode := range Modes {
+		if _, err := Escape(mode, "x"); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+	if got, err := Escape("tsquery", "a b"); err != nil || got != `'a' & 'b'` {
+		t.Errorf("tsquery: %q, %v", got, err)
+	}
+	if _, err := Escape("plain", "x"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+// runSeed is -seed, the root of every random choice a run makes: execution
+// shuffles, generated rows, the rows churn updates, the mix and lag
+// scenarios' picks and the fake engines' latencies. Two runs with the same
+// seed send the same workload. The smoke corpus and the synthetic logs
+// keep seeds of their own, as their workloads are written against them.
+var runSeed = int64(1)
+
+// seedFor derives the seed of one use of randomness from runSeed, so each
+// use draws its own reproducible sequence however many others a run makes.
+// It is never 0, which gofakeit takes as a request for a random seed.
+func seedFor(use string, n int64) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(use))
+	s := int64(h.Sum64()>>1) ^ (runSeed*1_000_003 + n)
+	if s == 0 {
+		s = 1
+	}
+	return s
+}
+
+// seededRand is a random source for one use, see seedFor.
+func seededRand(use string, n int64) *rand.Rand {
+	return rand.New(rand.NewSource(seedFor(use, n)))
+}
+
+// seededFaker generates rows for one use, see seedFor.
+func seededFaker(use string, n int64) *gofakeit.Faker {
+	return gofakeit.New(seedFor(use, n))
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// captureServerLogs is -server-logs: read every target's server log for the
+// timed run and attach the errors and warnings it holds to the queries that
+// caused them.
+var captureServerLogs = false
+
+// runID tells this run's sessions apart from others in a server's log and
+// pg_stat_activity; it is part of every connection's application_name and
+// of the results' metadata.
+var runID = time.Now().UTC().Format("20060102T150405")
+
+// applicationName is the application_name of the run's connections, unless
+// a target's connection string or settings name one.
+func applicationName() string {
+	return "pgsb-" + runID
+}
+
+// serverLogTime is the timestamp of the default log_line_prefix, %m or %t;
+// fractional seconds are accepted when present.
+const serverLogTime = "2006-01-02 15:04:05 MST"
+
+// serverLogSlack widens a query's window for messages logged just outside
+// it, as the client and server clocks are read at different moments.
+const serverLogSlack = 100 * time.Millisecond
+
+var serverLogLine = regexp.MustCompile(`^(\d{4}-\d\d-\d\d \d\d:\d\d:\d\d(?:\.\d+)? \S+)\s*(?:\[(\d+)\])?(.*?)\b(ERROR|WARNING|FATAL|PANIC|STATEMENT|DETAIL|HINT|CONTEXT):\s+(.*)$`)
+
+// loggedMessage is one error or warning scraped from a server log.
+type loggedMessage struct {
+	manifest.ServerMessage
+	At        time.Time
+	PID       string
+	Statement string
+	// Parameters are the statement's arguments, as a CONTEXT line logs them
+	// under log_parameter_max_length_on_error
+	Parameters string
+}
+
+// parseServerLog finds ERROR, WARNING, FATAL and PANIC entries and the
+// STATEMENT and CONTEXT lines that follow them. Entries whose prefix names
+// the application of another benchmark run are left out.
+func parseServerLog(log []byte) []loggedMessage {
+	var msgs []loggedMessage
+	// The message a STATEMENT, CONTEXT or continuation line belongs to
+	last := -1
+	// Where continuation lines go: the message, its statement, or nowhere
+	// for the DETAIL, HINT and CONTEXT lines that aren't kept
+	var more *string
+
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if last >= 0 && strings.HasPrefix(line, "\t") {
+			if more != nil {
+				*more += "\n" + strings.TrimPrefix(line, "\t")
+			}
+			continue
+		}
+		more = nil
+		m := serverLogLine.FindStringSubmatch(line)
+		if m == nil {
+			last = -1
+			continue
+		}
+		switch m[4] {
+		case "STATEMENT":
+			if last >= 0 && msgs[last].PID == m[2] {
+				msgs[last].Statement = m[5]
+				more = &msgs[last].Statement
+			}
+			continue
+		case "CONTEXT":
+			// unnamed portal with parameters: $1 = 'usb', $2 = '10'
+			if _, params, ok := strings.Cut(m[5], "with parameters: "); ok && last >= 0 && msgs[last].PID == m[2] {
+				msgs[last].Parameters = params
+			}
+			continue
+		case "DETAIL", "HINT":
+			continue
+		}
+		if prefix := m[3]; strings.Contains(prefix, "pgsb-") && !strings.Contains(prefix, applicationName()) {
+			last = -1
+			continue
+		}
+		at, err := time.Parse(serverLogTime, m[1])
+		if err != nil {
+			last = -1
+			continue
+		}
+		msgs = append(msgs, loggedMessage{
+			ServerMessage: manifest.ServerMessage{Level: m[4], Message: m[5], LoggedAt: m[1]},
+			At:            at,
+			PID:           m[2],
+		})
+		last = len(msgs) - 1
+		more = &msgs[last].Message
+	}
+	return msgs
+}
+
+// scrapeServerLog reads the errors and warnings a service logged since a
+// point in time.
+func scrapeServerLog(service string, since time.Time) ([]loggedMessage, error) {
+	out, err := exec.Command("docker", "compose", "logs", "--no-log-prefix", "--since", since.UTC().Format(time.RFC3339), service).Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not read logs of %s: %v", service, err)
+	}
+	return parseServerLog(out), nil
+}
+
+// cellWindow is when one query/engine cell of the workload ran.
+type cellWindow struct {
+	Engine, Query, Args string
+	From, To            time.Time
+}
+
+var (
+	cellWindowsMu sync.Mutex
+	cellWindows   []cellWindow
+)
+
+// recordCellWindow keeps the window of a finished cell for -server-logs.
+func recordCellWindow(res manifest.QueryResult, from, to time.Time) {
+	cellWindowsMu.Lock()
+	defer cellWindowsMu.Unlock()
+	cellWindows = append(cellWindows, cellWindow{Engine: res.Engine, Query: res.Query, Args: argsLabel(res.Args), From: from, To: to})
+}
+
+// takeCellWindows returns the recorded windows and forgets them.
+func takeCellWindows() []cellWindow {
+	cellWindowsMu.Lock()
+	defer cellWindowsMu.Unlock()
+	windows := cellWindows
+	cellWindows = nil
+	return windows
+}
+
+// cellAt is the one window of engine, and of query when it is known, that
+// was running at t. Overlapping windows, as interleaved or concurrent cells
+// leave, don't say which query a message came from.
+func cellAt(windows []cellWindow, engine, query string, t time.Time) (cellWindow, bool) {
+	var near, within []cellWindow
+	for _, w := range windows {
+		if w.Engine != engine || (query != "" && w.Query != query) {
+			continue
+		}
+		if t.Before(w.From.Add(-serverLogSlack)) || t.After(w.To.Add(serverLogSlack)) {
+			continue
+		}
+		near = append(near, w)
+		if !t.Before(w.From) && !t.After(w.To) {
+			within = append(within, w)
+		}
+	}
+	switch {
+	case len(near) == 1:
+		return near[0], true
+	case len(within) == 1:
+		return within[0], true
+	}
+	return cellWindow{}, false
+}
+
+// attachServerMessages attaches a target's logged messages to the results
+// of the queries that caused them: by the logged statement, which is the
+// target's statement of a workload query, and then by the logged arguments
+// as bound or, without them, by when it was logged, which picks the
+// argument set or, for warnings logged without their statement, the query
+// itself. Messages that can't be placed are returned.
+func attachServerMessages(results []manifest.QueryResult, w *Workload, t *benchTarget, windows []cellWindow, msgs []loggedMessage) []loggedMessage {
+	queryBySQL := map[string]WorkloadQuery{}
+	for _, q := range w.Queries {
+		if stmt, ok := q.SQL[t.sqlName()]; ok {
+			queryBySQL[strings.TrimSpace(stmt)] = q
+		}
+	}
+	var unplaced []loggedMessage
+	for _, m := range msgs {
+		var query *WorkloadQuery
+		if m.Statement != "" {
+			q, ok := queryBySQL[strings.TrimSpace(m.Statement)]
+			if !ok {
+				unplaced = append(unplaced, m)
+				continue
+			}
+			query = &q
+		}
+		name := ""
+		if query != nil {
+			name = query.Name
+		}
+		target := -1
+		if query != nil && m.Parameters != "" {
+			for i, r := range results {
+				if r.Engine == t.Name && r.Query == name && m.Parameters == pgParameters(query.bind(t.sqlName(), r.Args)) {
+					target = i
+					break
+				}
+			}
+		}
+		if target < 0 {
+			if cell, ok := cellAt(windows, t.Name, name, m.At); ok {
+				for i, r := range results {
+					if r.Engine == t.Name && r.Query == cell.Query && argsLabel(r.Args) == cell.Args {
+						target = i
+						break
+					}
+				}
+			} else if query != nil {
+				for i, r := range results {
+					if r.Engine == t.Name && r.Query == name {
+						target = i
+						break
+					}
+				}
+			}
+		}
+		if target < 0 {
+			unplaced = append(unplaced, m)
+			continue
+		}
+		results[target].ServerMessages = append(results[target].ServerMessages, m.ServerMessage)
+	}
+	return unplaced
+}
+
+// correlateServerLogs reads each target's log for the run that started at
+// since and attaches its errors and warnings to the results.
+func correlateServerLogs(results []manifest.QueryResult, w *Workload, targets []*benchTarget, since time.Time) {
+	windows := takeCellWindows()
+	for _, t := range targets {
+		msgs, err := scrapeServerLog(t.Service, since)
+		if err != nil {
+			warnf("⚠️  %s: %v", t.Label, err)
+			continue
+		}
+		unplaced := attachServerMessages(results, w, t, windows, msgs)
+		infof("📋 %s: %d error(s) and warning(s) logged, %d attached to queries", t.Label, len(msgs), len(msgs)-len(unplaced))
+		for _, m := range unplaced {
+			infof("   %s %s: %s", m.LoggedAt, m.Level, firstLine(m.Message))
+		}
+	}
+}
+
+// firstLine is s up to its first line break.
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// printServerMessages lists the errors and warnings attached to results,
+// if the run used -server-logs and any were logged.
+func printServerMessages(results []manifest.QueryResult, targets []*benchTarget) {
+	table := newConsoleTable("Query", "Args", "Engine", "Level", "Logged at", "Message")
+	rows := 0
+	for _, r := range results {
+		for _, m := range r.ServerMessages {
+			table.addRow(r.Query, argsLabel(r.Args), engineLabel(r.Engine, targets), m.Level, m.LoggedAt, firstLine(m.Message))
+			rows++
+		}
+	}
+	if rows == 0 {
+		return
+	}
+	fmt.Printf("\nSERVER ERRORS AND WARNINGS (%d):\n", rows)
+	table.render(os.Stdout)
+}
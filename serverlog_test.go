@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"pg_search_benchmark/manifest"
+	"pg_search_benchmark/searchquery"
+)
+
+// TestAttachServerMessages places errors logged by a config target, whose
+// statements are the parade ones and whose arguments are escaped before
+// binding, by the arguments the server logged with them.
+func TestAttachServerMessages(t *testing.T) {
+	stmt := "SELECT id FROM products WHERE id @@@ paradedb.parse($1) LIMIT 10"
+	w := &Workload{Queries: []WorkloadQuery{{
+		Name:   "fulltext",
+		SQL:    map[string]string{"parade": stmt},
+		Args:   [][]interface{}{{"usb-c cable"}, {"AND OR"}},
+		Escape: map[string]string{"parade": "tantivy"},
+	}}}
+	target := &benchTarget{Name: "parade-tuned", SQLEngine: "parade"}
+	results := []manifest.QueryResult{
+		{Query: "fulltext", Engine: "parade-tuned", Args: []interface{}{"usb-c cable"}},
+		{Query: "fulltext", Engine: "parade-tuned", Args: []interface{}{"AND OR"}},
+	}
+	log := "2026-10-17 12:00:00.100 UTC [42] pgsb-" + runID + " ERROR:  could not parse query\n" +
+		"2026-10-17 12:00:00.100 UTC [42] pgsb-" + runID + " CONTEXT:  unnamed portal with parameters: " +
+		pgParameters([]interface{}{searchquery.Tantivy("AND OR")}) + "\n" +
+		"2026-10-17 12:00:00.100 UTC [42] pgsb-" + runID + " STATEMENT:  " + stmt + "\n"
+	msgs := parseServerLog([]byte(log))
+	if len(msgs) != 1 || msgs[0].Statement != stmt {
+		t.Fatalf("parsed %+v, want one message with its statement", msgs)
+	}
+
+	// Both argument sets ran at the time it was logged
+	at := msgs[0].At
+	windows := []cellWindow{
+		{Engine: "parade-tuned", Query: "fulltext", Args: argsLabel(results[0].Args), From: at.Add(-time.Second), To: at.Add(time.Second)},
+		{Engine: "parade-tuned", Query: "fulltext", Args: argsLabel(results[1].Args), From: at.Add(-time.Second), To: at.Add(time.Second)},
+	}
+	if unplaced := attachServerMessages(results, w, target, windows, msgs); len(unplaced) != 0 {
+		t.Fatalf("unplaced %+v", unplaced)
+	}
+	if len(results[0].ServerMessages) != 0 || len(results[1].ServerMessages) != 1 {
+		t.Errorf("messages %+v and %+v, want the one on AND OR", results[0].ServerMessages, results[1].ServerMessages)
+	}
+}
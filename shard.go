@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// shardDSNs lists the vanilla shards of the sharded scenario, one
+// connection string per shard.
+var shardDSNs = ""
+
+// shardedEngine is the engine name of the sharded vanilla target; it runs
+// the vanilla statements of the workload.
+const shardedEngine = "vanilla-sharded"
+
+// shardFor routes a product to a shard by a hash of its ASIN, so every run
+// places the same products on the same shard.
+func shardFor(asin string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(asin))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// parseShardDSNs splits -shards into connection strings.
+func parseShardDSNs(spec string) ([]string, error) {
+	var dsns []string
+	for _, part := range strings.Split(spec, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			dsns = append(dsns, part)
+		}
+	}
+	if len(dsns) < 2 {
+		return nil, fmt.Errorf("-shards needs at least two connection strings, got %d", len(dsns))
+	}
+	return dsns, nil
+}
+
+// openDSN connects to a database given by connection string rather than by
+// DBConfig, such as a shard or the Citus coordinator.
+func openDSN(dsn string) (*sql.DB, error) {
+	if fakeEngines {
+		return openFakeEngine("vanilla")
+	}
+	db, err := sql.Open(sqlDriverName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	DBConfig{}.configurePool(db)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// limitClause finds a trailing literal LIMIT, which a gather has to apply
+// again to the merged rows.
+var limitClause = regexp.MustCompile(`(?i)\bLIMIT\s+(\d+)\s*$`)
+
+// scatterGather runs a query on every shard at once and returns the wall
+// time until the slowest shard finished and the merged row count. Rows are
+// merged by count only: shards rank independently, so with a LIMIT the
+// gather keeps that many rows without re-ranking them.
+func scatterGather(shards []*sql.DB, query string, args []interface{}) (time.Duration, int, error) {
+	start := time.Now()
+	rows := make([]int, len(shards))
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, db := range shards {
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			_, rows[i], errs[i] = timeQuery(db, query, args)
+		}(i, db)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	n := 0
+	for i := range shards {
+		if errs[i] != nil {
+			return 0, 0, fmt.Errorf("shard %d: %v", i, errs[i])
+		}
+		n += rows[i]
+	}
+	if m := limitClause.FindStringSubmatch(strings.TrimSpace(query)); m != nil {
+		if limit, err := strconv.Atoi(m[1]); err == nil && n > limit {
+			n = limit
+		}
+	}
+	return elapsed, n, nil
+}
+
+// loadShards reads the dump once and inserts each product into the shard
+// its ASIN hashes to, one writer per shard.
+func loadShards(shards []*sql.DB, filename string) ([]int, error) {
+	counts := make([]int, len(shards))
+	chans := make([]chan []Product, len(shards))
+	var wg sync.WaitGroup
+	for i, db := range shards {
+		chans[i] = make(chan []Product, 10)
+		wg.Add(1)
+		go func(i int, db *sql.DB) {
+			defer wg.Done()
+			for products := range chans[i] {
+				if err := insertBatch(db, products); err != nil {
+					degrade(fmt.Sprintf("Shard %d", i), fmt.Sprintf("insert batch of %d rows", len(products)), err)
+				}
+			}
+		}(i, db)
+	}
+
+	batches := make([][]Product, len(shards))
+	loaded := 0
+	err := streamCorpus(filename, func(product Product, err error) error {
+		if err != nil {
+			return nil
+		}
+		i := shardFor(product.ASIN, len(shards))
+		batches[i] = append(batches[i], product)
+		counts[i]++
+		loaded++
+		if len(batches[i]) >= BatchSize {
+			chans[i] <- batches[i]
+			batches[i] = nil
+			debugf("Shard %d: %d products queued", i, counts[i])
+		}
+		if SampleSize > 0 && loaded >= int(SampleSize) {
+			return errStopStream
+		}
+		return nil
+	})
+	for i := range shards {
+		if len(batches[i]) > 0 {
+			chans[i] <- batches[i]
+		}
+		close(chans[i])
+	}
+	wg.Wait()
+	return counts, err
+}
+
+// runShardedScenario loads the dump across the -shards vanilla databases,
+// indexes each like the vanilla target, and times the workload against
+// them with scatter-gather next to the single-node ParadeDB target.
+func runShardedScenario(st *runState) error {
+	dsns, err := parseShardDSNs(shardDSNs)
+	if err != nil {
+		return err
+	}
+	var parade *benchTarget
+	for _, t := range st.Targets {
+		if t.IsParadeDB {
+			parade = t
+		}
+	}
+	if parade == nil {
+		return fmt.Errorf("no ParadeDB target to compare the shards with")
+	}
+	w, err := st.loadWorkload()
+	if err != nil {
+		return err
+	}
+
+	shards := make([]*sql.DB, len(dsns))
+	shardTargets := make([]*benchTarget, len(dsns))
+	for i, dsn := range dsns {
+		db, err := openDSN(dsn)
+		if err != nil {
+			return fmt.Errorf("failed to connect to shard %d: %v", i, err)
+		}
+		defer db.Close()
+		shards[i] = db
+		shardTargets[i] = &benchTarget{Name: shardedEngine, Label: fmt.Sprintf("Shard %d", i), Config: vanillaConfig, DB: db, SQLEngine: "vanilla"}
+		if err := setupTables(shardTargets[i]); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+
+	start := time.Now()
+	infof("Loading %s across %d shards by hash of asin...", st.MetadataPath, len(shards))
+	if err := prepareCorpus(st.MetadataPath); err != nil {
+		return err
+	}
+	counts, err := loadShards(shards, st.MetadataPath)
+	if err != nil {
+		return err
+	}
+	for i, n := range counts {
+		infof("Shard %d: %d products", i, n)
+	}
+	var wg sync.WaitGroup
+	for _, t := range shardTargets {
+		wg.Add(1)
+		go finalizeTable(t, &wg)
+	}
+	wg.Wait()
+	infof("Shards loaded and indexed in %v", time.Since(start).Round(time.Second))
+
+	sharded := &benchTarget{
+		Name: shardedEngine, Label: fmt.Sprintf("Vanilla x%d", len(shards)),
+		Config: vanillaConfig, DB: shards[0], Shards: shards, SQLEngine: "vanilla",
+	}
+	targets := []*benchTarget{sharded, parade}
+	planProblems, err := checkPlansOrFail(w, targets)
+	if err != nil {
+		return err
+	}
+	results := runProfiledWorkload(w, targets, st.sampling(), planProblems)
+	if sampleMemory {
+		infof("%s: Memory is sampled on shard 0 only", sharded.Label)
+	}
+	return saveResults(dataDir, "sharded", manifest.KindQueries, st.Targets, results)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+// keepSlowest is how many of each query and engine's slowest executions are
+// stored with its results.
+const keepSlowest = 20
+
+// slowestExecutions returns the n slowest executions, slowest first.
+func slowestExecutions(executions []manifest.Execution, n int) []manifest.Execution {
+	sorted := append([]manifest.Execution(nil), executions...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// autoExplainTime is the default log_line_prefix timestamp (%m).
+const autoExplainTime = "2006-01-02 15:04:05.000 MST"
+
+// matchPlan finds the auto_explain capture logged when an execution ended,
+// if there is one within a second.
+func matchPlan(r manifest.QueryResult, e manifest.Execution) *manifest.SlowPlan {
+	var best *manifest.SlowPlan
+	bestGap := time.Second
+	for i, p := range r.SlowPlans {
+		logged, err := time.Parse(autoExplainTime, p.LoggedAt)
+		if err != nil {
+			continue
+		}
+		gap := logged.Sub(e.StartedAt.Add(e.Duration))
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= bestGap {
+			best, bestGap = &r.SlowPlans[i], gap
+		}
+	}
+	return best
+}
+
+// slowestCommand implements "slowest": the slowest individual executions of
+// a stored run, instead of aggregate percentiles.
+func slowestCommand(args []string) {
+	fs := flag.NewFlagSet("slowest", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	run := fs.String("run", "", "baseline name to read instead of the latest results")
+	scenario := fs.String("scenario", "read-only", "query scenario to read (read-only or mixed)")
+	n := fs.Int("n", 20, "number of executions to list")
+	showPlans := fs.Bool("plans", false, "print the captured auto_explain plan under each execution")
+	fs.Parse(args)
+
+	dir := dataDir
+	if *run != "" {
+		path, err := baselinePath(dataDir, *run)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		dir = path
+	}
+	f, err := loadResults(dir, *scenario)
+	if err != nil {
+		log.Fatalf("Failed to load results: %v", err)
+	}
+	results, err := f.Queries()
+	if err != nil {
+		log.Fatalf("Failed to load results: %v", err)
+	}
+
+	type row struct {
+		Result    manifest.QueryResult
+		Execution manifest.Execution
+	}
+	var rows []row
+	for _, r := range results {
+		for _, e := range r.Slowest {
+			rows = append(rows, row{r, e})
+		}
+	}
+	if len(rows) == 0 {
+		log.Fatalf("%s has no per-execution timings; it was saved by an older version", resultsFile(dir, *scenario))
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Execution.Duration > rows[j].Execution.Duration })
+	if len(rows) > *n {
+		rows = rows[:*n]
+	}
+
+	targets := defaultTargets()
+	fmt.Printf("Slowest %d executions in %s (saved %s):\n", len(rows), *scenario, f.Metadata.SavedAt.Format(time.RFC3339))
+	table := newConsoleTable("#", "Time", "Query", "Args", "Engine", "Started", "Plan")
+	var plans []string
+	for i, r := range rows {
+		planCell := "-"
+		if p := matchPlan(r.Result, r.Execution); p != nil {
+			planCell = fmt.Sprintf("[%d] server %s", len(plans)+1, formatDuration(p.Duration))
+			plans = append(plans, fmt.Sprintf("[%d] %s %s %s\n%s", len(plans)+1, r.Result.Query, argsLabel(r.Result.Args), r.Result.Engine, p.Plan))
+		}
+		table.addRow(fmt.Sprintf("%d", i+1), formatDuration(r.Execution.Duration), r.Result.Query, argsLabel(r.Result.Args),
+			engineLabel(r.Result.Engine, targets), r.Execution.StartedAt.Format("15:04:05.000"), planCell)
+	}
+	table.render(os.Stdout)
+	if len(plans) == 0 {
+		fmt.Println("No auto_explain plans captured for these executions (run with -auto-explain)")
+	} else if *showPlans {
+		fmt.Println()
+		fmt.Println(strings.Join(plans, "\n\n"))
+	} else {
+		fmt.Println("Add -plans to print the captured plans")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+
+	"pg_search_benchmark/manifest"
+)
+
+const (
+	// SmokeCorpusFile is written to the smoke data directory instead of
+	// downloading the SNAP dump.
+	SmokeCorpusFile = "smoke-corpus.json.gz"
+	smokeCorpusRows = 5000
+	// smokeSeed fixes the generated corpus, so smoke results stay comparable
+	// between runs and machines.
+	smokeSeed = 475
+	// smokeBudget is how long an end-to-end smoke run is expected to take.
+	smokeBudget = 2 * time.Minute
+)
+
+// smokeScenarios is the graph "smoke" runs against the fixture corpus.
+var smokeScenarios = []string{"schema", "ingest", "index", "read-only", "report"}
+
+// smokeDefaults are prepended to the smoke command's arguments, so flags the
+// user passes still win.
+var smokeDefaults = []string{"-workload", "workloads/smoke.yaml", "-iterations", "3", "-warmup", "1"}
+
+// writeSmokeCorpus writes the fixture products in the layout of the SNAP
+// dump, one record per line, so the ingest scenario loads them through the
+// same parser, which reads JSON as well as Python literals.
+func writeSmokeCorpus(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	enc := json.NewEncoder(gz)
+	for i, p := range syntheticProducts(gofakeit.New(smokeSeed), smokeCorpusRows) {
+		p.ASIN = fmt.Sprintf("SMOKE%05d", i)
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// smokeIndexes must exist and be valid on each engine after a smoke load.
+var smokeIndexes = map[string][]string{
+	"vanilla": {"idx_combined_fulltext", "idx_title_trgm"},
+	"parade":  {"products_search_idx"},
+}
+
+// verifySmoke checks that every target holds exactly the fixture corpus with
+// valid indexes, and that every smoke query ran and, except for fuzzy ones
+// whose hits depend on similarity thresholds, found something.
+func verifySmoke(st *runState, results []manifest.QueryResult) (junitSuite, error) {
+	suite := junitSuite{Name: "smoke"}
+	if err := st.connect(); err != nil {
+		return suite, err
+	}
+
+	for _, t := range st.Targets {
+		class := "smoke." + t.Name
+		var count int
+		failure := ""
+		if err := t.DB.QueryRow("SELECT COUNT(*) FROM " + productsTable).Scan(&count); err != nil {
+			failure = err.Error()
+		} else if count != smokeCorpusRows {
+			failure = fmt.Sprintf("%d rows, want %d", count, smokeCorpusRows)
+		}
+		suite.add(class, "row count", failure, "")
+
+		valid := map[string]bool{}
+		rows, err := t.DB.Query(`
+			SELECT c.relname, i.indisvalid AND i.indisready
+			FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+			WHERE i.indrelid = $1::regclass`, productsTable)
+		if err != nil {
+			return suite, fmt.Errorf("%s: %v", t.Label, err)
+		}
+		for rows.Next() {
+			var name string
+			var ok bool
+			if err := rows.Scan(&name, &ok); err != nil {
+				rows.Close()
+				return suite, fmt.Errorf("%s: %v", t.Label, err)
+			}
+			valid[name] = ok
+		}
+		rows.Close()
+		for _, idx := range smokeIndexes[t.sqlName()] {
+			idx = productsSQL(idx)
+			ok, exists := valid[idx]
+			failure := ""
+			switch {
+			case !exists:
+				failure = "index is missing"
+			case !ok:
+				failure = "index is invalid"
+			}
+			suite.add(class, "index "+idx, failure, "")
+		}
+	}
+
+	for _, r := range results {
+		failure, detail := "", ""
+		switch {
+		case r.Error != "":
+			failure, detail = "query failed", r.Error
+		case r.Rows == 0 && r.Category != "fuzzy":
+			failure = "query returned no rows"
+		}
+		suite.add("smoke."+r.Engine, fmt.Sprintf("%s %s", r.Query, argsLabel(r.Args)), failure, detail)
+	}
+	return suite, nil
+}
+
+// checkSmokeBudget warns when the smoke run took longer than it should; CI
+// machines vary too much to fail on it.
+func checkSmokeBudget(elapsed time.Duration) {
+	if elapsed > smokeBudget {
+		warnf("Warning: Smoke run took %v, more than the %v budget", elapsed.Round(time.Second), smokeBudget)
+		return
+	}
+	infof("✅ Smoke run finished in %v", elapsed.Round(time.Second))
+}
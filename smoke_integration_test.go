@@ -0,0 +1,103 @@
+//go:build integration
+
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ory/dockertest/v3"
+
+	"pg_search_benchmark/manifest"
+)
+
+// TestSmokeEphemeral runs smoke -ephemeral end to end: it starts throwaway
+// vanilla and ParadeDB containers, ingests the generated fixture, and checks
+// the row counts, index validity and query results smoke asserts, along with
+// the stored results of both engines. It needs Docker:
+//
+//	go test -tags integration -run TestSmokeEphemeral -timeout 15m .
+func TestSmokeEphemeral(t *testing.T) {
+	pool, err := dockertest.NewPool("")
+	if err == nil {
+		err = pool.Client.Ping()
+	}
+	if err != nil {
+		t.Skipf("docker is not available: %v", err)
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "pg_search_benchmark")
+	if out, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	junit := filepath.Join(dir, "smoke.xml")
+	cmd := exec.Command(bin, "smoke", "-ephemeral", "-plain", "-data-dir", dir, "-junit", junit)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("smoke: %v\n%s", err, out)
+	}
+
+	data, err := os.ReadFile(junit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatal(err)
+	}
+	var smoke *junitSuite
+	for i := range suites.Suites {
+		if suites.Suites[i].Name == "smoke" {
+			smoke = &suites.Suites[i]
+		}
+	}
+	if smoke == nil {
+		t.Fatalf("no smoke suite in %s", junit)
+	}
+	checked := map[string]int{}
+	for _, c := range smoke.Cases {
+		if c.Failure != nil {
+			t.Errorf("%s %s: %s %s", c.Classname, c.Name, c.Failure.Message, c.Failure.Body)
+		}
+		switch {
+		case c.Name == "row count":
+			checked["row count"]++
+		case strings.HasPrefix(c.Name, "index "):
+			checked["index"]++
+		default:
+			checked["query"]++
+		}
+	}
+	if checked["row count"] != 2 {
+		t.Errorf("row counts checked on %d target(s), want 2", checked["row count"])
+	}
+	if want := len(smokeIndexes["vanilla"]) + len(smokeIndexes["parade"]); checked["index"] != want {
+		t.Errorf("%d index(es) checked, want %d", checked["index"], want)
+	}
+	if checked["query"] == 0 {
+		t.Error("no query results checked")
+	}
+
+	f, err := manifest.Read(filepath.Join(dir, "smoke", "results-read-only.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := f.Queries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engines := map[string]int{}
+	for _, r := range results {
+		engines[r.Engine]++
+	}
+	for _, e := range []string{"vanilla", "parade"} {
+		if engines[e] == 0 {
+			t.Errorf("no read-only results for %s", e)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+func summarize(samples []time.Duration) manifest.LatencyStats {
+	if len(samples) == 0 {
+		return manifest.LatencyStats{}
+	}
+
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	return manifest.LatencyStats{
+		Count: len(sorted),
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 50),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Min:   sorted[0],
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile uses the nearest-rank method on already sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// p95Interval is a distribution-free 95% confidence interval for the p95,
+// from the normal approximation to the binomial distribution of order
+// statistics. ok is false until there are enough samples for both ends.
+func p95Interval(sorted []time.Duration) (lo, hi time.Duration, ok bool) {
+	const p, z = 0.95, 1.96
+	n := float64(len(sorted))
+	spread := z * math.Sqrt(n*p*(1-p))
+	loRank := int(math.Floor(n*p - spread))
+	hiRank := int(math.Ceil(n*p + spread))
+	if loRank < 1 || hiRank > len(sorted) {
+		return 0, 0, false
+	}
+	return sorted[loRank-1], sorted[hiRank-1], true
+}
+
+// p95RelativeWidth is the width of the p95 confidence interval relative to
+// the p95 itself.
+func p95RelativeWidth(samples []time.Duration) (float64, bool) {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	lo, hi, ok := p95Interval(sorted)
+	p95 := percentile(sorted, 95)
+	if !ok || p95 <= 0 {
+		return 0, false
+	}
+	return float64(hi-lo) / float64(p95), true
+}
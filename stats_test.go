@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"pg_search_benchmark/manifest"
+)
+
+func millis(n ...int) []time.Duration {
+	d := make([]time.Duration, len(n))
+	for i, v := range n {
+		d[i] = time.Duration(v) * time.Millisecond
+	}
+	return d
+}
+
+// upTo returns 1ms..n ms, descending, so summarize has to sort them.
+func upTo(n int) []time.Duration {
+	var d []time.Duration
+	for i := n; i >= 1; i-- {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	return d
+}
+
+func TestSummarize(t *testing.T) {
+	samples := upTo(100)
+	got := summarize(samples)
+	want := manifest.LatencyStats{
+		Count: 100, Mean: 50500 * time.Microsecond,
+		P50: 50 * time.Millisecond, P95: 95 * time.Millisecond, P99: 99 * time.Millisecond,
+		Min: time.Millisecond, Max: 100 * time.Millisecond,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if samples[0] != 100*time.Millisecond {
+		t.Error("summarize sorted the caller's samples")
+	}
+	if got := summarize(nil); got != (manifest.LatencyStats{}) {
+		t.Errorf("no samples: got %+v", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := millis(10, 20, 30, 40)
+	for _, tc := range []struct {
+		p    float64
+		want int
+	}{{0, 10}, {25, 10}, {26, 20}, {50, 20}, {75, 30}, {76, 40}, {100, 40}} {
+		if got := percentile(sorted, tc.p); got != time.Duration(tc.want)*time.Millisecond {
+			t.Errorf("p%v: got %v, want %dms", tc.p, got, tc.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("no samples: got %v", got)
+	}
+}
+
+func TestP95Interval(t *testing.T) {
+	if _, _, ok := p95Interval(millis(1, 2, 3, 4, 5, 6, 7, 8, 9, 10)); ok {
+		t.Error("10 samples gave an interval for the p95")
+	}
+
+	// n=1000: 950 ± 1.96·√(1000·0.95·0.05) = 950 ± 13.5, ranks 936 and 964
+	samples := upTo(1000)
+	sorted := append([]time.Duration(nil), samples...)
+	for i, j := 0, len(sorted)-1; i < j; i, j = i+1, j-1 {
+		sorted[i], sorted[j] = sorted[j], sorted[i]
+	}
+	lo, hi, ok := p95Interval(sorted)
+	if !ok || lo != 936*time.Millisecond || hi != 964*time.Millisecond {
+		t.Errorf("got %v..%v (%v), want 936ms..964ms", lo, hi, ok)
+	}
+	width, ok := p95RelativeWidth(samples)
+	if want := 28.0 / 950; !ok || width != want {
+		t.Errorf("relative width %v (%v), want %v", width, ok, want)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"pg_search_benchmark/manifest"
+)
+
+// NATS source of the stream scenario and the publish command.
+var (
+	natsURL        = nats.DefaultURL
+	natsSubject    = "products"
+	streamDuration = time.Minute
+)
+
+// publishedAtHeader carries the publish time (RFC 3339, nanoseconds) so lag
+// covers the queue too; messages without it are timed from receipt.
+const publishedAtHeader = "Published-At"
+
+// streamFlushEvery bounds how long a received document waits to be written.
+const streamFlushEvery = 100 * time.Millisecond
+
+// streamDoc is one received product with the time its lag is measured from.
+type streamDoc struct {
+	Product     Product
+	PublishedAt time.Time
+}
+
+// streamWriter inserts the batches for one engine and records each
+// document's publish-to-indexed lag. Both engines maintain their search
+// indexes in the inserting transaction, so a committed row is indexed.
+type streamWriter struct {
+	t       *benchTarget
+	batches chan []streamDoc
+	lags    []time.Duration
+	failed  int
+}
+
+func (w *streamWriter) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+	for docs := range w.batches {
+		products := make([]Product, len(docs))
+		for i, d := range docs {
+			products[i] = d.Product
+		}
+		if err := insertBatch(w.t.DB, products); err != nil {
+			errorf("%s: Stream batch of %d failed: %v", w.t.Label, len(docs), err)
+			w.failed += len(docs)
+			continue
+		}
+		committed := time.Now()
+		for _, d := range docs {
+			w.lags = append(w.lags, committed.Sub(d.PublishedAt))
+		}
+	}
+}
+
+// runStreamScenario consumes product documents from a NATS subject for
+// -stream-duration and writes them to every engine, as a continuous
+// indexing pipeline would, timing publish to indexed per document.
+func runStreamScenario(st *runState) error {
+	// A flush blocks while a writer is behind, and the client then drops
+	// what overflows the channel; those messages are counted, not timed.
+	nc, err := nats.Connect(natsURL, nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+		warnf("NATS: %v", err)
+	}))
+	if err != nil {
+		return fmt.Errorf("connect to %s: %v", natsURL, err)
+	}
+	defer nc.Close()
+	msgs := make(chan *nats.Msg, 64*1024)
+	sub, err := nc.ChanSubscribe(natsSubject, msgs)
+	if err != nil {
+		return fmt.Errorf("subscribe to %s: %v", natsSubject, err)
+	}
+	defer sub.Unsubscribe()
+
+	writers := make([]*streamWriter, len(st.Targets))
+	var wg sync.WaitGroup
+	for i, t := range st.Targets {
+		writers[i] = &streamWriter{t: t, batches: make(chan []streamDoc, 100)}
+		wg.Add(1)
+		go writers[i].run(&wg)
+	}
+
+	infof("Consuming %s on %s for %v", natsSubject, natsURL, streamDuration)
+	ticker := time.NewTicker(streamFlushEvery)
+	defer ticker.Stop()
+	deadline := time.After(streamDuration)
+	var batch []streamDoc
+	received, malformed := 0, 0
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, w := range writers {
+			w.batches <- batch
+		}
+		batch = nil
+	}
+
+consume:
+	for {
+		select {
+		case msg := <-msgs:
+			received++
+			doc, err := decodeStreamMsg(msg, received)
+			if err != nil {
+				malformed++
+				continue
+			}
+			batch = append(batch, doc)
+			if len(batch) >= BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-deadline:
+			break consume
+		}
+	}
+	flush()
+	for _, w := range writers {
+		close(w.batches)
+	}
+	wg.Wait()
+	dropped, err := sub.Dropped()
+	if err != nil {
+		return fmt.Errorf("count dropped messages on %s: %v", natsSubject, err)
+	}
+	infof("Received %d documents (%d malformed)", received, malformed)
+	if dropped > 0 {
+		warnf("%d documents were dropped because the writers fell behind; their lag is not in the results", dropped)
+	}
+
+	var results []manifest.QueryResult
+	table := newConsoleTable("Engine", "Indexed", "Failed", "Mean", "p50", "p95", "p99", "Max")
+	for _, w := range writers {
+		stats := summarize(w.lags)
+		results = append(results, manifest.QueryResult{
+			Query: "publish-to-indexed", Category: "stream", Engine: w.t.Name,
+			Args: []interface{}{natsSubject}, Rows: len(w.lags), Stats: stats, Dropped: dropped,
+		})
+		table.addRow(w.t.Label, fmt.Sprintf("%d", len(w.lags)), fmt.Sprintf("%d", w.failed),
+			formatDuration(stats.Mean), formatDuration(stats.P50), formatDuration(stats.P95), formatDuration(stats.P99), formatDuration(stats.Max))
+	}
+	fmt.Fprintln(progressOut, "\nPUBLISH → INDEXED LAG:")
+	table.render(progressOut)
+
+	// Streamed rows carry the synthetic prefix; leave the table as loaded
+	for _, t := range st.Targets {
+		if _, err := t.DB.Exec(productsSQL("DELETE FROM products WHERE asin LIKE $1"), syntheticASINPrefix+"%"); err != nil {
+			warnf("%s: Warning: Could not delete streamed rows: %v", t.Label, err)
+		}
+	}
+	return saveResults(dataDir, "stream", manifest.KindQueries, st.Targets, results)
+}
+
+// decodeStreamMsg parses a message body like a dump line. The ASIN is
+// replaced so the rows never collide with loaded products and are cleaned
+// up with the other synthetic rows.
+func decodeStreamMsg(msg *nats.Msg, n int) (streamDoc, error) {
+	doc := streamDoc{PublishedAt: time.Now()}
+	if v := msg.Header.Get(publishedAtHeader); v != "" {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			doc.PublishedAt = t
+		}
+	}
+	p, err := parseProductLine(string(msg.Data))
+	if err != nil {
+		return doc, err
+	}
+	if p.Title == "" {
+		return doc, fmt.Errorf("no title")
+	}
+	p.ASIN = fmt.Sprintf("%s%09d", syntheticASINPrefix, n)
+	doc.Product = p
+	return doc, nil
+}
+
+// publishCommand implements "publish": it replays dump lines onto a NATS
+// subject at a fixed rate, stamped with their publish time, to feed the
+// stream scenario.
+func publishCommand(args []string) {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	fs.StringVar(&natsURL, "nats-url", natsURL, "NATS server to publish to")
+	fs.StringVar(&natsSubject, "nats-subject", natsSubject, "subject to publish product documents on")
+	source := fs.String("source", "", "dump to replay, gzipped if it ends in .gz (default: the SNAP dump in -data-dir or the cache)")
+	rate := fs.Int("rate", 500, "documents per second")
+	limit := fs.Int("n", 0, "stop after this many documents (0 = whole file)")
+	fs.Parse(args)
+	if *source == "" {
+		*source = cachedDatasetPath("amazon", dataDir)
+	}
+	if *rate <= 0 {
+		log.Fatalf("-rate must be positive")
+	}
+
+	nc, err := nats.Connect(natsURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", natsURL, err)
+	}
+	defer nc.Close()
+
+	// Gzipped only when it ends in .gz, like a loaded corpus
+	file, err := openDump(*source)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", *source, err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	ticker := time.NewTicker(time.Second / time.Duration(*rate))
+	defer ticker.Stop()
+	sent := 0
+	for scanner.Scan() && (*limit == 0 || sent < *limit) {
+		if scanner.Text() == "" {
+			continue
+		}
+		<-ticker.C
+		msg := nats.NewMsg(natsSubject)
+		msg.Data = append([]byte(nil), scanner.Bytes()...)
+		msg.Header.Set(publishedAtHeader, time.Now().UTC().Format(time.RFC3339Nano))
+		if err := nc.PublishMsg(msg); err != nil {
+			log.Fatalf("Failed to publish: %v", err)
+		}
+		sent++
+		if sent%10000 == 0 {
+			fmt.Printf("Published %d documents\n", sent)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read %s: %v", *source, err)
+	}
+	if err := nc.Flush(); err != nil {
+		log.Fatalf("Failed to flush: %v", err)
+	}
+	fmt.Printf("✅ Published %d documents to %s\n", sent, natsSubject)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brianvoe/gofakeit/v6"
+)
+
+// syntheticASINPrefix marks rows written by the benchmark itself so churn and
+// cleanup can find them again.
+const syntheticASINPrefix = "SYN"
+
+// syntheticProducts generates products shaped like the SNAP records.
+func syntheticProducts(f *gofakeit.Faker, n int) []Product {
+	products := make([]Product, n)
+	for i := range products {
+		info := f.Product()
+		categories := make([]interface{}, len(info.Categories))
+		for j, c := range info.Categories {
+			categories[j] = c
+		}
+		products[i] = Product{
+			ASIN:        syntheticASINPrefix + strings.ToUpper(f.LetterN(7)),
+			Title:       info.Name,
+			Description: info.Description,
+			Price:       fmt.Sprintf("%.2f", info.Price),
+			Brand:       f.Company(),
+			Categories:  []interface{}{categories},
+		}
+	}
+	return products
+}
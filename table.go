@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// plainOutput renders ASCII tables without color, for CI logs
+	plainOutput = false
+	noColor     = false
+)
+
+const (
+	ansiReset = "\033[0m"
+	ansiGreen = "\033[32m"
+	ansiRed   = "\033[31m"
+)
+
+// colorEnabled is false for -plain, -no-color, NO_COLOR and non-terminals.
+func colorEnabled() bool {
+	if plainOutput || noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func colorize(s, color string) string {
+	if !colorEnabled() || color == "" {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// formatDuration picks µs, ms or s so every value has about three
+// significant digits.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < 10*time.Millisecond:
+		return fmt.Sprintf("%.2fms", float64(d)/float64(time.Millisecond))
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
+
+// formatSpeedup renders base/other as "3.2x", green when other is at least
+// 10% faster and red when it is at least 10% slower.
+func formatSpeedup(base, other time.Duration) string {
+	if base <= 0 || other <= 0 {
+		return "-"
+	}
+	ratio := float64(base) / float64(other)
+	s := fmt.Sprintf("%.2fx", ratio)
+	switch {
+	case ratio >= 1.1:
+		return colorize(s, ansiGreen)
+	case ratio <= 0.9:
+		return colorize(s, ansiRed)
+	}
+	return s
+}
+
+// consoleTable renders aligned columns; the first column is left aligned and
+// the rest right aligned, as they hold numbers.
+type consoleTable struct {
+	headers []string
+	rows    [][]string
+}
+
+func newConsoleTable(headers ...string) *consoleTable {
+	return &consoleTable{headers: headers}
+}
+
+func (t *consoleTable) addRow(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// visibleWidth ignores ANSI color sequences.
+func visibleWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+func pad(s string, width int, left bool) string {
+	fill := strings.Repeat(" ", width-visibleWidth(s))
+	if left {
+		return s + fill
+	}
+	return fill + s
+}
+
+func (t *consoleTable) render(w io.Writer) {
+	widths := make([]int, len(t.headers))
+	for i, h := range t.headers {
+		widths[i] = visibleWidth(h)
+	}
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i < len(widths) && visibleWidth(cell) > widths[i] {
+				widths[i] = visibleWidth(cell)
+			}
+		}
+	}
+
+	vert, horiz := "│", "─"
+	top, mid, bottom := [3]string{"┌", "┬", "┐"}, [3]string{"├", "┼", "┤"}, [3]string{"└", "┴", "┘"}
+	if plainOutput {
+		vert, horiz = "|", "-"
+		top, mid, bottom = [3]string{"+", "+", "+"}, [3]string{"+", "+", "+"}, [3]string{"+", "+", "+"}
+	}
+
+	rule := func(c [3]string) {
+		parts := make([]string, len(widths))
+		for i, wd := range widths {
+			parts[i] = strings.Repeat(horiz, wd+2)
+		}
+		fmt.Fprintln(w, c[0]+strings.Join(parts, c[1])+c[2])
+	}
+	line := func(cells []string) {
+		parts := make([]string, len(widths))
+		for i, wd := range widths {
+			cell := ""
+			if i < len(cells) {
+				cell = cells[i]
+			}
+			parts[i] = " " + pad(cell, wd, i == 0) + " "
+		}
+		fmt.Fprintln(w, vert+strings.Join(parts, vert)+vert)
+	}
+
+	rule(top)
+	line(t.headers)
+	rule(mid)
+	for _, row := range t.rows {
+		line(row)
+	}
+	rule(bottom)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsoleTablePlain(t *testing.T) {
+	defer func(p bool) { plainOutput = p }(plainOutput)
+	plainOutput = true
+
+	table := newConsoleTable("Category", "Vanilla", "ParadeDB")
+	table.addRow("fulltext", formatDuration(12300*time.Microsecond), formatDuration(850*time.Microsecond))
+	table.addRow("exact", formatDuration(2*time.Second), "")
+	var b strings.Builder
+	table.render(&b)
+	want := `+----------+---------+----------+
+| Category | Vanilla | ParadeDB |
++----------+---------+----------+
+| fulltext |  12.3ms |    850µs |
+| exact    |   2.00s |          |
++----------+---------+----------+
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestVisibleWidth(t *testing.T) {
+	if got := visibleWidth(ansiGreen + "3.20x" + ansiReset); got != 5 {
+		t.Errorf("colored: got %d, want 5", got)
+	}
+	if got := visibleWidth("850µs"); got != 5 {
+		t.Errorf("µs: got %d, want 5", got)
+	}
+}
+
+func TestFormatSpeedup(t *testing.T) {
+	defer func(p bool) { plainOutput = p }(plainOutput)
+	plainOutput = true
+	for _, tc := range []struct {
+		base, other time.Duration
+		want        string
+	}{
+		{10 * time.Millisecond, 2 * time.Millisecond, "5.00x"},
+		{time.Millisecond, 4 * time.Millisecond, "0.25x"},
+		{0, time.Millisecond, "-"},
+	} {
+		if got := formatSpeedup(tc.base, tc.other); got != tc.want {
+			t.Errorf("%v/%v: got %s, want %s", tc.base, tc.other, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// shareResultsURL is -share-results: where to POST an anonymized summary of
+// the read-only results after the run, or - to print it. Unset, nothing
+// leaves the machine.
+var shareResultsURL = ""
+
+// sharedSummarySchema is the version of the sharedSummary payload.
+const sharedSummarySchema = 1
+
+// sharedSummary is what -share-results sends. It holds no host names,
+// DSNs, target names, query names or query text: engines are known by kind
+// and number, queries only by category, and the row count is rounded.
+type sharedSummary struct {
+	SchemaVersion int            `json:"schema_version"`
+	Dataset       string         `json:"dataset"`
+	Rows          int64          `json:"rows"`
+	Hardware      sharedHardware `json:"hardware"`
+	Execution     string         `json:"execution"`
+	SharedHost    bool           `json:"shared_host"`
+	Degraded      bool           `json:"degraded"`
+	Engines       []sharedEngine `json:"engines"`
+	// MeanMs maps each category to each engine's mean latency
+	MeanMs map[string]map[string]float64 `json:"mean_ms"`
+	// Speedups are each engine's geometric mean speedup over the baseline
+	// engine across their shared categories
+	Baseline string             `json:"baseline"`
+	Speedups map[string]float64 `json:"speedups,omitempty"`
+}
+
+// sharedHardware is the client's hardware class.
+type sharedHardware struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+	CPUs string `json:"cpus"`
+}
+
+// sharedEngine is one target by kind, as vanilla, parade or parade-2.
+type sharedEngine struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Version string `json:"version,omitempty"`
+}
+
+// cpuClass buckets a CPU count so it doesn't single out a machine.
+func cpuClass(n int) string {
+	lower := 1
+	for _, upper := range []int{2, 4, 8, 16, 32, 64} {
+		if n <= upper {
+			return fmt.Sprintf("%d-%d", lower, upper)
+		}
+		lower = upper + 1
+	}
+	return "65+"
+}
+
+// roundRows keeps the two leading digits of a row count.
+func roundRows(n int64) int64 {
+	if n < 100 {
+		return n
+	}
+	scale := math.Pow(10, math.Floor(math.Log10(float64(n)))-1)
+	return int64(math.Round(float64(n)/scale) * scale)
+}
+
+// buildSharedSummary anonymizes the read-only results in dir.
+func buildSharedSummary(dir string, targets []*benchTarget) (*sharedSummary, error) {
+	f, err := loadResults(dir, "read-only")
+	if err != nil {
+		return nil, err
+	}
+	if f.Metadata.Fake {
+		return nil, fmt.Errorf("results from -fake engines are not shared")
+	}
+	results, err := f.Queries()
+	if err != nil {
+		return nil, err
+	}
+
+	cpus := runtime.NumCPU()
+	s := &sharedSummary{
+		SchemaVersion: sharedSummarySchema,
+		Dataset:       f.Metadata.Dataset,
+		Hardware:      sharedHardware{OS: runtime.GOOS, Arch: runtime.GOARCH, CPUs: cpuClass(cpus)},
+		Execution:     f.Metadata.Execution,
+		SharedHost:    len(f.Metadata.SharedHost) > 0,
+		MeanMs:        map[string]map[string]float64{},
+	}
+	if status, err := loadIngestStatus(dir); err == nil {
+		s.Degraded = status.Status == "degraded"
+	}
+
+	ids, kinds := map[string]string{}, map[string]int{}
+	for _, t := range targets {
+		kind := engineKey(t.IsParadeDB)
+		kinds[kind]++
+		id := kind
+		if kinds[kind] > 1 {
+			id = fmt.Sprintf("%s-%d", kind, kinds[kind])
+		}
+		ids[t.Name] = id
+		s.Engines = append(s.Engines, sharedEngine{ID: id, Kind: kind, Version: f.Metadata.Versions[t.Name]})
+		if s.Rows == 0 && t.DB != nil {
+			var rows float64
+			if t.DB.QueryRow("SELECT reltuples FROM pg_class WHERE oid = to_regclass($1)", productsTable).Scan(&rows) == nil && rows > 0 {
+				s.Rows = roundRows(int64(rows))
+			}
+		}
+	}
+
+	sum := summarizeCategories(results)
+	for cat, means := range sum.Means {
+		for engine, mean := range means {
+			id, ok := ids[engine]
+			if !ok {
+				continue
+			}
+			if s.MeanMs[cat] == nil {
+				s.MeanMs[cat] = map[string]float64{}
+			}
+			s.MeanMs[cat][id] = float64(mean) / float64(time.Millisecond)
+		}
+	}
+	if len(sum.Engines) > 0 {
+		baseline := sum.baseline()
+		s.Baseline = ids[baseline]
+		for _, e := range sum.Engines {
+			if e == baseline || ids[e] == "" {
+				continue
+			}
+			if speedup, n := sum.relativeSpeed(e, baseline); n > 0 {
+				if s.Speedups == nil {
+					s.Speedups = map[string]float64{}
+				}
+				s.Speedups[ids[e]] = speedup
+			}
+		}
+	}
+	return s, nil
+}
+
+// shareResults sends the anonymized summary to shareResultsURL, or prints
+// it for -share-results -. A failed upload only warns; the run's own
+// results are already saved.
+func shareResults(dir string, targets []*benchTarget) {
+	s, err := buildSharedSummary(dir, targets)
+	if err != nil {
+		warnf("Warning: Not sharing results: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		warnf("Warning: Not sharing results: %v", err)
+		return
+	}
+	if shareResultsURL == "-" {
+		os.Stdout.Write(append(data, '\n'))
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(shareResultsURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		warnf("Warning: Could not share results: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		warnf("Warning: Could not share results: %s answered %s", shareResultsURL, resp.Status)
+		return
+	}
+	infof("📤 Shared an anonymized summary of the results with %s", shareResultsURL)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// zipfFitTerms is how many of the most frequent terms the Zipf exponent is
+// fitted over; the long tail of typos and ids bends the line.
+const zipfFitTerms = 10000
+
+// termDictionary is the document statistics of a corpus: for every term,
+// the documents it occurs in and its occurrences in all of them.
+type termDictionary struct {
+	Documents int64
+	Tokens    int64
+	DF        map[string]int64
+	CF        map[string]int64
+}
+
+// termStat is one dictionary entry, for sorting.
+type termStat struct {
+	Term   string
+	DF, CF int64
+}
+
+// termDictionaryPath is where the cache keeps the dictionary of the
+// dataset version with the given fingerprint at the current sample size.
+func termDictionaryPath(fingerprint string) string {
+	return derivedCachePath("terms", fingerprint, ".tsv.gz")
+}
+
+// termDictionaryFile is the cached dictionary of the data the run uses, or
+// empty when analyze has not built one; the results metadata names it.
+var termDictionaryFile = ""
+
+// tokenize splits text into the lowercased letter and digit runs a search
+// would index, dropping single characters.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := fields[:0]
+	for _, f := range fields {
+		if len([]rune(f)) > 1 {
+			tokens = append(tokens, f)
+		}
+	}
+	return tokens
+}
+
+// buildTermDictionary counts the terms of the searched columns over the
+// records a load would take from the corpus at path.
+func buildTermDictionary(path string) (*termDictionary, error) {
+	d := &termDictionary{DF: map[string]int64{}, CF: map[string]int64{}}
+	err := streamCorpus(path, func(p Product, err error) error {
+		if err != nil {
+			return nil
+		}
+		if SampleSize > 0 && d.Documents >= int64(SampleSize) {
+			return errStopStream
+		}
+		d.Documents++
+		seen := map[string]bool{}
+		for _, tok := range tokenize(p.Title + " " + p.Description + " " + p.Brand) {
+			d.Tokens++
+			d.CF[tok]++
+			if !seen[tok] {
+				seen[tok] = true
+				d.DF[tok]++
+			}
+		}
+		return nil
+	})
+	return d, err
+}
+
+// sorted lists the terms by document frequency, most common first.
+func (d *termDictionary) sorted() []termStat {
+	stats := make([]termStat, 0, len(d.DF))
+	for term, df := range d.DF {
+		stats = append(stats, termStat{term, df, d.CF[term]})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].DF != stats[j].DF {
+			return stats[i].DF > stats[j].DF
+		}
+		return stats[i].Term < stats[j].Term
+	})
+	return stats
+}
+
+// zipfExponent fits cf ∝ rank^-s over the most frequent terms by least
+// squares on the log-log curve; natural language lands near 1.
+func (d *termDictionary) zipfExponent() float64 {
+	cfs := make([]int64, 0, len(d.CF))
+	for _, cf := range d.CF {
+		cfs = append(cfs, cf)
+	}
+	sort.Slice(cfs, func(i, j int) bool { return cfs[i] > cfs[j] })
+	if len(cfs) > zipfFitTerms {
+		cfs = cfs[:zipfFitTerms]
+	}
+	if len(cfs) < 2 {
+		return 0
+	}
+	var sx, sy, sxx, sxy float64
+	for i, cf := range cfs {
+		x, y := math.Log(float64(i+1)), math.Log(float64(cf))
+		sx, sy, sxx, sxy = sx+x, sy+y, sxx+x*x, sxy+x*y
+	}
+	n := float64(len(cfs))
+	return -(n*sxy - sx*sy) / (n*sxx - sx*sx)
+}
+
+// write stores the dictionary as TSV, gzipped when path ends in .gz: a "#"
+// header with the corpus totals, then term, document frequency and
+// collection frequency, most common first.
+func (d *termDictionary) write(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+	var out io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(f)
+		out = gz
+	}
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "# dataset=%s fingerprint=%s documents=%d tokens=%d\n", datasetName, datasetFingerprint, d.Documents, d.Tokens)
+	fmt.Fprintln(w, "term\tdf\tcf")
+	for _, s := range d.sorted() {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", s.Term, s.DF, s.CF)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readTermDictionary loads a dictionary write stored.
+func readTermDictionary(path string) (*termDictionary, error) {
+	f, err := openDump(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := &termDictionary{DF: map[string]int64{}, CF: map[string]int64{}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			for _, kv := range strings.Fields(line[1:]) {
+				k, v, _ := strings.Cut(kv, "=")
+				switch k {
+				case "documents":
+					d.Documents, _ = strconv.ParseInt(v, 10, 64)
+				case "tokens":
+					d.Tokens, _ = strconv.ParseInt(v, 10, 64)
+				}
+			}
+			continue
+		}
+		parts := strings.Split(line, "\t")
+		if len(parts) != 3 || parts[0] == "term" {
+			continue
+		}
+		df, err1 := strconv.ParseInt(parts[1], 10, 64)
+		cf, err2 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s: bad line %q", path, line)
+		}
+		d.DF[parts[0]], d.CF[parts[0]] = df, cf
+	}
+	return d, scanner.Err()
+}
+
+// selectivity estimates the share of documents an argument matches when
+// all its terms must occur, bounded by its rarest term, with the terms'
+// document frequencies. Uppercase AND, OR and NOT are query syntax, not
+// terms.
+func (d *termDictionary) selectivity(arg string) (float64, []string) {
+	var terms []string
+	rarest := int64(-1)
+	for _, word := range strings.Fields(arg) {
+		if word == "AND" || word == "OR" || word == "NOT" {
+			continue
+		}
+		for _, tok := range tokenize(word) {
+			df := d.DF[tok]
+			terms = append(terms, fmt.Sprintf("%s:%d", tok, df))
+			if rarest < 0 || df < rarest {
+				rarest = df
+			}
+		}
+	}
+	if rarest < 0 || d.Documents == 0 {
+		return 0, terms
+	}
+	return float64(rarest) / float64(d.Documents), terms
+}
+
+// selectivityLabel buckets a selectivity for labeling queries.
+func selectivityLabel(s float64) string {
+	switch {
+	case s == 0:
+		return "no match"
+	case s < 0.001:
+		return "rare"
+	case s < 0.01:
+		return "selective"
+	case s < 0.1:
+		return "common"
+	default:
+		return "broad"
+	}
+}
+
+// analyzeCommand builds the term dictionary of the corpus a load would
+// take, keeps it in the cache next to the parsed records, and prints the
+// corpus statistics and, with -workload, how selective each argument is.
+func analyzeCommand(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	fs.StringVar(&datasetName, "dataset", datasetName, "corpus to analyze: "+strings.Join(datasetNames(), ", "))
+	fs.StringVar(&datasetFile, "dataset-file", datasetFile, "corpus file to analyze instead of the dataset's download")
+	fs.StringVar(&cacheDir, "cache-dir", cacheDir, "where downloaded datasets, parsed records and term dictionaries are kept")
+	fs.IntVar(&sampleSizeFlag, "sample-size", sampleSizeFlag, "products to analyze from the start of the dump, as a load of that size takes them, 0 for all")
+	fs.StringVar(&samplePreset, "preset", samplePreset, "sample size preset: small (10,000 products), medium (200,000) or full (all)")
+	out := fs.String("out", "", "also export the dictionary to this file (gzipped when it ends in .gz)")
+	workloadPath := fs.String("workload", "", "label every argument of this workload with its estimated selectivity")
+	top := fs.Int("top", 20, "most frequent terms to print")
+	rebuild := fs.Bool("rebuild", false, "count the terms again even if the cache has the dictionary")
+	fs.Parse(args)
+
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if err := applySampleFlags(setFlags); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := selectDataset(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	path := datasetPath(dataDir)
+	if datasetFile == "" {
+		if err := activeDataset.Download(path); err != nil {
+			log.Fatalf("Failed to download the %s dataset: %v", datasetName, err)
+		}
+	}
+	fp, err := activeDataset.Fingerprint(path)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	datasetFingerprint = fp
+
+	cached := ""
+	if cacheDir != "" {
+		cached = termDictionaryPath(fp)
+	} else if *out == "" {
+		log.Fatalf("-cache-dir is off, give -out to keep the dictionary")
+	}
+	var d *termDictionary
+	if _, err := os.Stat(cached); err == nil && !*rebuild {
+		fmt.Printf("Using the term dictionary cached in %s\n", cached)
+		if d, err = readTermDictionary(cached); err != nil {
+			log.Fatalf("%v", err)
+		}
+	} else {
+		if err := prepareCorpus(path); err != nil {
+			log.Fatalf("%v", err)
+		}
+		fmt.Printf("Counting terms in %s...\n", filepath.Base(path))
+		if d, err = buildTermDictionary(path); err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if cached != "" {
+			if err := d.write(cached); err != nil {
+				log.Fatalf("Failed to cache the dictionary: %v", err)
+			}
+			fmt.Printf("✅ Cached the term dictionary in %s\n", cached)
+		}
+	}
+	if *out != "" {
+		if err := d.write(*out); err != nil {
+			log.Fatalf("Failed to export the dictionary: %v", err)
+		}
+		fmt.Printf("✅ Exported the term dictionary to %s\n", *out)
+	}
+
+	fmt.Printf("\n%s: %d documents, %d tokens (%.1f per document), %d distinct terms, Zipf exponent %.2f\n",
+		datasetName, d.Documents, d.Tokens, float64(d.Tokens)/math.Max(float64(d.Documents), 1), len(d.DF), d.zipfExponent())
+	table := newConsoleTable("Rank", "Term", "Documents", "Share", "Occurrences")
+	for i, s := range d.sorted() {
+		if i >= *top {
+			break
+		}
+		table.addRow(fmt.Sprintf("%d", i+1), s.Term, fmt.Sprintf("%d", s.DF),
+			fmt.Sprintf("%.2f%%", 100*float64(s.DF)/float64(d.Documents)), fmt.Sprintf("%d", s.CF))
+	}
+	table.render(os.Stdout)
+
+	if *workloadPath == "" {
+		return
+	}
+	w, err := loadWorkload(*workloadPath)
+	if err != nil {
+		log.Fatalf("Failed to load workload: %v", err)
+	}
+	fmt.Printf("\nSELECTIVITY of %s (rarest term's share of documents, when all terms must match):\n", w.Name)
+	labels := newConsoleTable("Query", "Args", "Terms (documents)", "Selectivity", "Label")
+	for _, q := range w.Queries {
+		for _, a := range q.Args {
+			var parts []string
+			for _, v := range a {
+				if s, ok := v.(string); ok {
+					parts = append(parts, s)
+				}
+			}
+			if len(parts) == 0 {
+				continue
+			}
+			s, terms := d.selectivity(strings.Join(parts, " "))
+			labels.addRow(q.Name, argsLabel(a), strings.Join(terms, " "), fmt.Sprintf("%.4f%%", 100*s), selectivityLabel(s))
+		}
+	}
+	labels.render(os.Stdout)
+}
@@ -0,0 +1,54 @@
+#!/usr/bin/env python3
+"""Regenerates golden.jsonl, the documents Python reads from lines.txt.
+
+Each line of lines.txt is a Python 2 literal like those of the SNAP dump.
+The reference parse is ast.literal_eval with Python 2 str semantics: a
+literal without a u prefix holds bytes, read as UTF-8 when they are valid
+UTF-8 and as Latin-1 otherwise.
+
+    python3 testdata/pyliteral/golden.py
+"""
+import ast
+import json
+import os
+
+HERE = os.path.dirname(os.path.abspath(__file__))
+
+
+def py2_str(segment):
+    # Evaluate the literal as a bytes literal, escaping the raw non-ASCII
+    # bytes of the source, which is what Python 2 keeps in a str.
+    raw = segment.encode("utf-8")
+    text = "".join(chr(b) if b < 0x80 else "\\x%02x" % b for b in raw)
+    data = ast.literal_eval("b" + text.lstrip("bB"))
+    try:
+        return data.decode("utf-8")
+    except UnicodeDecodeError:
+        return data.decode("latin-1")
+
+
+class Py2Strings(ast.NodeTransformer):
+    def __init__(self, source):
+        self.source = source
+
+    def visit_Constant(self, node):
+        if isinstance(node.value, str) and node.kind != "u":
+            node.value = py2_str(ast.get_source_segment(self.source, node))
+        return node
+
+
+def parse(line):
+    tree = ast.parse(line, mode="eval")
+    return ast.literal_eval(Py2Strings(line).visit(tree))
+
+
+def main():
+    with open(os.path.join(HERE, "lines.txt"), encoding="utf-8") as f:
+        lines = [l.rstrip("\n") for l in f if l.strip()]
+    with open(os.path.join(HERE, "golden.jsonl"), "w", encoding="utf-8") as out:
+        for line in lines:
+            out.write(json.dumps({"line": line, "want": parse(line)}) + "\n")
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+var (
+	// batchSizeFlag and workersFlag are -batch-size and -workers; 0 keeps
+	// batch_size and workers from -config, or the defaults
+	batchSizeFlag = 0
+	workersFlag   = 0
+	// autoTune picks what the flags leave unset from probing the targets
+	autoTune = false
+)
+
+// Bounds of the auto-tuned load settings.
+const (
+	tuneProbes     = 20
+	minTuneWorkers = 1
+	// getDB opens at most 50 connections per target
+	maxTuneWorkers = 48
+	minTuneBatch   = 500
+	maxTuneBatch   = 20000
+	// tuneRowsPerMillisecond is how many rows a batch carries per
+	// millisecond of round trip: a COPY batch costs a few round trips however
+	// many rows it streams, so a slow link gets larger batches
+	tuneRowsPerMillisecond = 1000
+)
+
+// applyLoadFlags sets BatchSize and MaxWorkers from -batch-size and
+// -workers when given; they win over the config file and -auto-tune.
+func applyLoadFlags() error {
+	if batchSizeFlag < 0 || workersFlag < 0 {
+		return fmt.Errorf("-batch-size and -workers can't be negative")
+	}
+	if batchSizeFlag > 0 {
+		BatchSize = batchSizeFlag
+	}
+	if workersFlag > 0 {
+		MaxWorkers = workersFlag
+	}
+	return nil
+}
+
+// loadProbe is what autoTuneLoad measured on one target.
+type loadProbe struct {
+	MaxConnections int
+	Connections    int
+	RoundTrip      time.Duration
+}
+
+// probeLoad reads a target's connection limit and current connections and
+// times a round trip, taking the median of several.
+func probeLoad(t *benchTarget) (loadProbe, error) {
+	var p loadProbe
+	err := t.DB.QueryRow(`SELECT current_setting('max_connections')::int - current_setting('superuser_reserved_connections')::int,
+		(SELECT count(*) FROM pg_stat_activity WHERE backend_type = 'client backend')`).Scan(&p.MaxConnections, &p.Connections)
+	if err != nil {
+		return p, err
+	}
+	trips := make([]time.Duration, tuneProbes)
+	for i := range trips {
+		start := time.Now()
+		if _, err := t.DB.Exec("SELECT 1"); err != nil {
+			return p, err
+		}
+		trips[i] = time.Since(start)
+	}
+	p.RoundTrip = summarize(trips).P50
+	return p, nil
+}
+
+// autoTuneLoad picks the insert workers and batch size the flags leave
+// unset from the targets: workers take at most half the free connections
+// of the most constrained target and four per client CPU, and batches grow
+// with the slowest round trip.
+func autoTuneLoad(targets []*benchTarget) error {
+	workers := min(maxTuneWorkers, 4*runtime.NumCPU())
+	var slowest time.Duration
+	for _, t := range targets {
+		p, err := probeLoad(t)
+		if err != nil {
+			return fmt.Errorf("%s: auto-tune probe failed: %v", t.Label, err)
+		}
+		free := p.MaxConnections - p.Connections
+		infof("🔧 %s: %d of %d connections free, %v round trip", t.Label, free, p.MaxConnections, p.RoundTrip.Round(time.Microsecond))
+		workers = min(workers, free/2, t.Config.maxOpenConns())
+		slowest = max(slowest, p.RoundTrip)
+	}
+	if workersFlag == 0 {
+		MaxWorkers = max(workers, minTuneWorkers)
+	}
+	if batchSizeFlag == 0 {
+		BatchSize = minTuneBatch
+		if slowest > 0 {
+			perBatch := int(float64(tuneRowsPerMillisecond) * slowest.Seconds() * 1000)
+			BatchSize = min(max(perBatch, minTuneBatch), maxTuneBatch)
+		}
+	}
+	infof("🔧 Loading with %d workers and batches of %d rows", MaxWorkers, BatchSize)
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// minServerVersion is the oldest PostgreSQL validate-config accepts, the
+// oldest release the PostgreSQL project still supports.
+const minServerVersion = 140000
+
+// configCheck is one row of the validate-config table.
+type configCheck struct {
+	Target, Check, Status, Detail string
+}
+
+// validateTarget runs the checks of one target: it can be reached, runs a
+// supported server version, has the search extension the engine needs,
+// and lets the benchmark user create its tables.
+func validateTarget(t *benchTarget) []configCheck {
+	row := func(check string, err error, detail string) configCheck {
+		if err != nil {
+			return configCheck{t.Label, check, "FAIL", err.Error()}
+		}
+		return configCheck{t.Label, check, "PASS", detail}
+	}
+
+	db, err := getDB(t.Config)
+	if err != nil {
+		return []configCheck{row("connect", err, "")}
+	}
+	defer db.Close()
+	checks := []configCheck{row("connect", nil, fmt.Sprintf("%s:%d/%s as %s", t.Config.Host, t.Config.Port, t.Config.Database, t.Config.User))}
+
+	var version, versionNum string
+	err = db.QueryRow("SELECT current_setting('server_version'), current_setting('server_version_num')").Scan(&version, &versionNum)
+	if n, _ := strconv.Atoi(versionNum); err == nil && n < minServerVersion {
+		err = fmt.Errorf("PostgreSQL %s is older than %d", version, minServerVersion/10000)
+	}
+	checks = append(checks, row("server version", err, "PostgreSQL "+version))
+
+	if t.IsParadeDB {
+		err = checkParadeDBCompat(t, db)
+		checks = append(checks, row("pg_search", err, "available, preloaded, "+t.bm25().Name+" index syntax"))
+	} else {
+		err = checkVanillaCompat(db)
+		checks = append(checks, row("pg_trgm", err, "available"))
+	}
+
+	checks = append(checks, row("create table", checkCreateTable(db), "allowed in "+tableLocation()))
+	return checks
+}
+
+// tableLocation names where the benchmark's tables go.
+func tableLocation() string {
+	if dbSchema != "" {
+		return "schema " + dbSchema
+	}
+	return "the search_path"
+}
+
+// checkCreateTable creates the schema, if one is set and missing, and a
+// scratch table in a transaction that is always rolled back.
+func checkCreateTable(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if dbSchema != "" {
+		if _, err := tx.Exec("CREATE SCHEMA IF NOT EXISTS " + dbSchema); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("CREATE TABLE " + productsTable + "_validate (id int)")
+	return err
+}
+
+// validateDisk checks -db-volume has room for every target's copy of the
+// dataset, as far as it can be estimated before downloading.
+func validateDisk(targets []*benchTarget) configCheck {
+	check := configCheck{Target: "database volume", Check: "disk space"}
+	if dbVolume == "" {
+		check.Status, check.Detail = "SKIP", "give -db-volume to check it"
+		return check
+	}
+	free, err := freeDiskBytes(dbVolume)
+	if err != nil {
+		check.Status, check.Detail = "FAIL", err.Error()
+		return check
+	}
+	path := datasetPath(dataDir)
+	est, err := estimateDataset(path)
+	switch activeDataset.(type) {
+	case amazonDataset, customDataset:
+	default:
+		err = fmt.Errorf("no size estimate for the %s dataset", datasetName)
+	}
+	if err != nil {
+		check.Status, check.Detail = "PASS", fmt.Sprintf("%s free on %s; load size unknown (%v)", formatBytes(free), dbVolume, err)
+		return check
+	}
+	var need uint64
+	for _, t := range targets {
+		need += requiredDBBytes(est, t.IsParadeDB)
+	}
+	need = uint64(float64(need) * diskSafetyMarginRatio)
+	check.Detail = fmt.Sprintf("%s free on %s, load needs ~%s", formatBytes(free), dbVolume, formatBytes(need))
+	check.Status = "PASS"
+	if free < need {
+		check.Status = "FAIL"
+	}
+	return check
+}
+
+// validateConfigCommand checks every target before a long run and prints
+// a pass/fail table, exiting non-zero if anything failed.
+func validateConfigCommand(args []string) {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	fs.StringVar(&configPath, "config", configPath, "YAML or TOML (.toml) run configuration file")
+	fs.StringVar(&dbVolume, "db-volume", dbVolume, "local path on the volume backing the databases, checked for room for the load")
+	fs.StringVar(&datasetName, "dataset", datasetName, "dataset the load would use, for the disk estimate: "+strings.Join(datasetNames(), ", "))
+	fs.StringVar(&datasetFile, "dataset-file", datasetFile, "corpus file the load would use instead of the dataset's download")
+	fs.StringVar(&cacheDir, "cache-dir", cacheDir, "where downloaded datasets are kept")
+	fs.IntVar(&sampleSizeFlag, "sample-size", sampleSizeFlag, "products the load would take, 0 for all (overrides sample_size in -config)")
+	fs.StringVar(&samplePreset, "preset", samplePreset, "sample size preset: small (10,000 products), medium (200,000) or full (all)")
+	fs.Parse(args)
+
+	var err error
+	if runConfig, err = loadRunConfig(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := configureTargets(fs, runConfig); err != nil {
+		log.Fatalf("%v", err)
+	}
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if err := applySampleFlags(setFlags); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := selectDataset(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	targets := defaultTargets()
+	var checks []configCheck
+	for _, t := range targets {
+		checks = append(checks, validateTarget(t)...)
+	}
+	checks = append(checks, validateDisk(targets))
+
+	table := newConsoleTable("Target", "Check", "Result", "Detail")
+	failed := 0
+	for _, c := range checks {
+		table.addRow(c.Target, c.Check, c.Status, c.Detail)
+		if c.Status == "FAIL" {
+			failed++
+		}
+	}
+	fmt.Println()
+	table.render(os.Stdout)
+	if failed > 0 {
+		fmt.Printf("❌ %d check(s) failed\n", failed)
+		os.Exit(1)
+	}
+	fmt.Println("✅ All targets are ready")
+}
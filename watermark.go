@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// IngestWatermarksFile records how far each target's load got, so -resume
+// can skip what was committed without reading back every row.
+const IngestWatermarksFile = "ingest-watermarks.json"
+
+// loadProgress is a target's place in the corpus. Records are counted in
+// stream order, skipping the ones that can't be loaded; every record
+// before Watermark is committed, and so are the Committed ranges past it,
+// left by batches that finished out of order.
+type loadProgress struct {
+	Watermark int       `json:"watermark"`
+	Committed [][2]int  `json:"committed,omitempty"`
+	Rows      int64     `json:"rows"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// done reports whether record i is committed.
+func (p *loadProgress) done(i int) bool {
+	if i < p.Watermark {
+		return true
+	}
+	for _, r := range p.Committed {
+		if i >= r[0] && i < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// commit records records [first, end) and rows of them as committed, and
+// advances the watermark over the ranges that now join it.
+func (p *loadProgress) commit(first, end int, rows int) {
+	p.Committed = append(p.Committed, [2]int{first, end})
+	sort.Slice(p.Committed, func(i, j int) bool { return p.Committed[i][0] < p.Committed[j][0] })
+	kept := p.Committed[:0]
+	for _, r := range p.Committed {
+		if r[0] <= p.Watermark {
+			p.Watermark = max(p.Watermark, r[1])
+		} else {
+			kept = append(kept, r)
+		}
+	}
+	p.Committed = kept
+	p.Rows += int64(rows)
+	p.UpdatedAt = time.Now().UTC()
+}
+
+// ingestWatermarks are the loadProgress of every target for one version of
+// the corpus.
+type ingestWatermarks struct {
+	DatasetFingerprint string                   `json:"dataset_fingerprint"`
+	Table              string                   `json:"table"`
+	Targets            map[string]*loadProgress `json:"targets"`
+}
+
+var (
+	watermarksMu sync.Mutex
+	watermarks   *ingestWatermarks
+)
+
+// openWatermarks reads the watermarks in dir; those of another corpus
+// version or table are discarded.
+func openWatermarks(dir string) {
+	watermarksMu.Lock()
+	defer watermarksMu.Unlock()
+	watermarks = &ingestWatermarks{DatasetFingerprint: datasetFingerprint, Table: productsTable, Targets: map[string]*loadProgress{}}
+	data, err := os.ReadFile(filepath.Join(dir, IngestWatermarksFile))
+	if err != nil {
+		return
+	}
+	var saved ingestWatermarks
+	if json.Unmarshal(data, &saved) != nil || saved.DatasetFingerprint != datasetFingerprint || saved.Table != productsTable || saved.Targets == nil {
+		return
+	}
+	watermarks.Targets = saved.Targets
+}
+
+// resumeWatermark returns a target's progress if it accounts for every row
+// the table holds; otherwise rows were committed without being recorded,
+// and the load must resume from the rows themselves.
+func resumeWatermark(t *benchTarget) *loadProgress {
+	watermarksMu.Lock()
+	p := watermarks.Targets[t.Name]
+	watermarksMu.Unlock()
+	// Without a fingerprint the corpus may have changed under the numbering
+	if p == nil || datasetFingerprint == "" {
+		return nil
+	}
+	var rows int64
+	if err := t.DB.QueryRow(productsSQL("SELECT count(*) FROM products")).Scan(&rows); err != nil || rows != p.Rows {
+		return nil
+	}
+	return p
+}
+
+// startWatermark begins recording a target's load from scratch.
+func startWatermark(t *benchTarget) *loadProgress {
+	watermarksMu.Lock()
+	defer watermarksMu.Unlock()
+	p := &loadProgress{UpdatedAt: time.Now().UTC()}
+	watermarks.Targets[t.Name] = p
+	return p
+}
+
+// commitWatermark records a committed batch of a target and saves the
+// watermarks.
+func commitWatermark(p *loadProgress, first, end, rows int) {
+	watermarksMu.Lock()
+	defer watermarksMu.Unlock()
+	p.commit(first, end, rows)
+	if err := saveWatermarks(dataDir); err != nil {
+		warnf("Warning: Could not save %s: %v", IngestWatermarksFile, err)
+	}
+}
+
+// saveWatermarks writes the watermarks next to the data, replacing the file
+// whole so a crash leaves the previous version. Callers hold watermarksMu.
+func saveWatermarks(dir string) error {
+	data, err := json.MarshalIndent(watermarks, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, IngestWatermarksFile)
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace %s: %v", path, err)
+	}
+	return nil
+}
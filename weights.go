@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// categoryWeights overrides the workload's weights from -weights.
+var categoryWeights = ""
+
+// parseWeights reads "fulltext=40,exact=20" into a weight map.
+func parseWeights(spec string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid weight %q, want category=weight", part)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight %q", part)
+		}
+		weights[strings.TrimSpace(name)] = w
+	}
+	return weights, nil
+}
+
+// compositeScore is the weighted mean latency of an engine: the expected
+// latency of a query drawn from the weighted mix. It is not comparable if the
+// engine has no result for a weighted category.
+func compositeScore(sum categorySummary, weights map[string]float64, engine string) (time.Duration, bool) {
+	var total, weightSum float64
+	for cat, w := range weights {
+		if w == 0 {
+			continue
+		}
+		d, ok := sum.Means[cat][engine]
+		if !ok {
+			return 0, false
+		}
+		total += w * float64(d)
+		weightSum += w
+	}
+	if weightSum == 0 {
+		return 0, false
+	}
+	return time.Duration(total / weightSum), true
+}
+
+func printCompositeScores(sum categorySummary, weights map[string]float64, baseline string, targets []*benchTarget) {
+	if len(weights) == 0 {
+		return
+	}
+
+	cats := make([]string, 0, len(weights))
+	var weightSum float64
+	for cat, w := range weights {
+		cats = append(cats, cat)
+		weightSum += w
+	}
+	sort.Strings(cats)
+	mix := make([]string, 0, len(cats))
+	for _, cat := range cats {
+		mix = append(mix, fmt.Sprintf("%s %.0f%%", cat, weights[cat]/weightSum*100))
+	}
+
+	baseScore, baseOK := compositeScore(sum, weights, baseline)
+	table := newConsoleTable("Engine", "Weighted latency", "vs "+engineLabel(baseline, targets))
+	for _, e := range sum.Engines {
+		score, ok := compositeScore(sum, weights, e)
+		if !ok {
+			table.addRow(engineLabel(e, targets), "incomplete", "-")
+			continue
+		}
+		rel := "-"
+		if baseOK && e != baseline {
+			rel = formatSpeedup(baseScore, score)
+		}
+		table.addRow(engineLabel(e, targets), formatDuration(score), rel)
+	}
+	fmt.Printf("\nCOMPOSITE SCORE (%s):\n", strings.Join(mix, ", "))
+	table.render(os.Stdout)
+	fmt.Println("Weighted latency: expected mean latency for this traffic mix, lower is better")
+}
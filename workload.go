@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"pg_search_benchmark/searchquery"
+)
+
+// Workload is a set of benchmark queries loaded from a YAML or annotated SQL
+// file (see parseSQLWorkload). Each query carries one SQL statement per
+// engine; the same argument sets are bound to every engine so results stay
+// comparable.
+type Workload struct {
+	Name string `yaml:"name"`
+	// PlanMismatch is "flag" (default: time the query but mark it) or "fail"
+	// (abort before any timing starts).
+	PlanMismatch string `yaml:"plan_mismatch"`
+	// Weights give each category's share of real traffic for the composite
+	// score; categories without a weight are left out of it.
+	Weights map[string]float64 `yaml:"weights"`
+	// Claims are speed relationships the report checks the results
+	// against, such as "parade >= 5x vanilla on fulltext" (see speedClaim).
+	Claims  []string        `yaml:"claims"`
+	Queries []WorkloadQuery `yaml:"queries"`
+
+	claims []speedClaim
+}
+
+type WorkloadQuery struct {
+	Name       string                     `yaml:"name"`
+	Category   string                     `yaml:"category"`
+	SQL        map[string]string          `yaml:"sql"`
+	Args       [][]interface{}            `yaml:"args"`
+	ExpectPlan map[string]PlanExpectation `yaml:"expect_plan"`
+	// Escape names, per engine, how string arguments are converted into
+	// that engine's query syntax before binding (see searchquery.Modes).
+	Escape map[string]string `yaml:"escape"`
+}
+
+// bind returns the arguments as the statement for engine receives them.
+// Modes are checked when the workload is loaded.
+func (q WorkloadQuery) bind(engine string, args []interface{}) []interface{} {
+	mode, ok := q.Escape[engine]
+	if !ok {
+		return args
+	}
+	bound := make([]interface{}, len(args))
+	for i, a := range args {
+		bound[i] = a
+		if s, ok := a.(string); ok {
+			bound[i], _ = searchquery.Escape(mode, s)
+		}
+	}
+	return bound
+}
+
+// PlanExpectation lists plan features an engine's EXPLAIN must show.
+type PlanExpectation struct {
+	UsesIndex []string `yaml:"uses_index"`
+	NodeTypes []string `yaml:"node_types"`
+	NoSeqScan bool     `yaml:"no_seq_scan"`
+	// MaxRelations bounds the tables and partitions scanned, to check
+	// partition pruning
+	MaxRelations int `yaml:"max_relations"`
+}
+
+// loadWorkload reads a YAML workload, or an annotated SQL one when the file
+// ends in .sql.
+func loadWorkload(path string) (*Workload, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var w Workload
+	if strings.EqualFold(filepath.Ext(path), ".sql") {
+		parsed, err := parseSQLWorkload(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		w = *parsed
+	} else if err := yaml.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	switch w.PlanMismatch {
+	case "":
+		w.PlanMismatch = "flag"
+	case "flag", "fail":
+	default:
+		return nil, fmt.Errorf("%s: plan_mismatch must be flag or fail, got %q", path, w.PlanMismatch)
+	}
+	for cat, weight := range w.Weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("%s: weight for %s is negative", path, cat)
+		}
+	}
+	for _, text := range w.Claims {
+		c, err := parseClaim(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		w.claims = append(w.claims, c)
+	}
+	for i, q := range w.Queries {
+		if q.Name == "" {
+			return nil, fmt.Errorf("%s: query %d has no name", path, i+1)
+		}
+		if len(q.SQL) == 0 {
+			return nil, fmt.Errorf("%s: query %s has no sql", path, q.Name)
+		}
+		for engine, mode := range q.Escape {
+			if _, err := searchquery.Escape(mode, ""); err != nil {
+				return nil, fmt.Errorf("%s: query %s, %s: %v", path, q.Name, engine, err)
+			}
+		}
+		if len(q.Args) == 0 {
+			w.Queries[i].Args = [][]interface{}{nil}
+		}
+		for engine, stmt := range q.SQL {
+			q.SQL[engine] = productsSQL(stmt)
+		}
+		for _, expect := range q.ExpectPlan {
+			for j, idx := range expect.UsesIndex {
+				expect.UsesIndex[j] = productsSQL(idx)
+			}
+		}
+	}
+	return &w, nil
+}
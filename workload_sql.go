@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseSQLWorkload reads a workload written as plain SQL with magic comments,
+// so queries can be contributed without touching YAML:
+//
+//	-- workload: amazon
+//	-- weights: fulltext=50,fuzzy=50
+//	-- claim: parade >= 2x vanilla on fulltext
+//
+//	-- name: fulltext
+//	-- category: fulltext
+//	-- params: ["wireless headphones"]
+//	-- params: ["apple iphone"]
+//	-- expect_plan: parade {uses_index: [products_search_idx], no_seq_scan: true}
+//	-- escape: parade tantivy
+//	-- engine: vanilla
+//	SELECT ... WHERE ... @@ plainto_tsquery('english', $1) LIMIT 10;
+//	-- engine: parade
+//	SELECT ... WHERE id @@@ paradedb.match('title', $1) LIMIT 10;
+//
+// Annotations before the first "-- name:" apply to the whole workload. The
+// statement after "-- engine:" runs until the next annotation; a trailing
+// semicolon is dropped. Other comments are kept as part of the SQL.
+func parseSQLWorkload(src string) (*Workload, error) {
+	w := &Workload{}
+	var q *WorkloadQuery
+	engine := ""
+	var stmt strings.Builder
+
+	flush := func() {
+		if q != nil && engine != "" {
+			sql := strings.TrimSpace(stmt.String())
+			q.SQL[engine] = strings.TrimSpace(strings.TrimSuffix(sql, ";"))
+		}
+		engine = ""
+		stmt.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(src))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		key, value, ok := sqlAnnotation(line)
+		if !ok {
+			if engine != "" {
+				stmt.WriteString(line)
+				stmt.WriteByte('\n')
+			}
+			continue
+		}
+
+		flush()
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("line %d: %s", lineNo, fmt.Sprintf(format, args...))
+		}
+		switch key {
+		case "workload":
+			w.Name = value
+		case "plan_mismatch":
+			w.PlanMismatch = value
+		case "weights":
+			weights, err := parseWeights(value)
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			w.Weights = weights
+		case "claim":
+			w.Claims = append(w.Claims, value)
+		case "name":
+			w.Queries = append(w.Queries, WorkloadQuery{Name: value, SQL: map[string]string{}})
+			q = &w.Queries[len(w.Queries)-1]
+		default:
+			if q == nil {
+				return nil, fail("-- %s: before the first -- name:", key)
+			}
+			switch key {
+			case "category":
+				q.Category = value
+			case "engine":
+				if _, dup := q.SQL[value]; dup {
+					return nil, fail("query %s has two statements for %s", q.Name, value)
+				}
+				engine = value
+			case "params":
+				var args []interface{}
+				if err := yaml.Unmarshal([]byte(value), &args); err != nil {
+					return nil, fail("params must be a list like [\"a\", 1]: %v", err)
+				}
+				q.Args = append(q.Args, args)
+			case "expect_plan":
+				target, spec, _ := strings.Cut(value, " ")
+				var expect PlanExpectation
+				if err := yaml.Unmarshal([]byte(spec), &expect); err != nil {
+					return nil, fail("expect_plan must be '<engine> {uses_index: [...]}': %v", err)
+				}
+				if q.ExpectPlan == nil {
+					q.ExpectPlan = map[string]PlanExpectation{}
+				}
+				q.ExpectPlan[target] = expect
+			case "escape":
+				target, mode, ok := strings.Cut(value, " ")
+				if !ok {
+					return nil, fail("escape must be '<engine> <mode>'")
+				}
+				if q.Escape == nil {
+					q.Escape = map[string]string{}
+				}
+				q.Escape[target] = strings.TrimSpace(mode)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	for _, q := range w.Queries {
+		for engine, sql := range q.SQL {
+			if sql == "" {
+				return nil, fmt.Errorf("query %s has an empty statement for %s", q.Name, engine)
+			}
+		}
+	}
+	return w, nil
+}
+
+var sqlAnnotationKeys = map[string]bool{
+	"workload": true, "plan_mismatch": true, "weights": true, "claim": true, "name": true,
+	"category": true, "engine": true, "params": true, "expect_plan": true,
+	"escape": true,
+}
+
+// sqlAnnotation splits a "-- key: value" line. Comments that don't start
+// with a known key are ordinary SQL comments.
+func sqlAnnotation(line string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(line), "--")
+	if !ok {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(rest, ":")
+	key = strings.TrimSpace(key)
+	if !ok || !sqlAnnotationKeys[key] {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(value), true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sqlWorkloadExample = `-- workload: amazon
+-- plan_mismatch: fail
+-- weights: fulltext=50,fuzzy=50
+-- claim: parade >= 2x vanilla on fulltext
+
+-- name: fulltext
+-- category: fulltext
+-- params: ["wireless headphones"]
+-- params: ["apple iphone", 10]
+-- expect_plan: parade {uses_index: [products_search_idx], no_seq_scan: true}
+-- escape: parade tantivy
+-- engine: vanilla
+SELECT id FROM products
+-- ranked by title only
+WHERE to_tsvector('english', title) @@ plainto_tsquery('english', $1)
+LIMIT 10;
+-- engine: parade
+SELECT id FROM products WHERE id @@@ paradedb.match('title', $1) LIMIT 10;
+
+-- name: fuzzy
+-- category: fuzzy
+-- engine: vanilla
+SELECT id FROM products WHERE title % $1 LIMIT 10
+`
+
+func TestParseSQLWorkload(t *testing.T) {
+	w, err := parseSQLWorkload(sqlWorkloadExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Name != "amazon" || w.PlanMismatch != "fail" {
+		t.Errorf("name %q, plan_mismatch %q", w.Name, w.PlanMismatch)
+	}
+	if !reflect.DeepEqual(w.Weights, map[string]float64{"fulltext": 50, "fuzzy": 50}) {
+		t.Errorf("weights %v", w.Weights)
+	}
+	if !reflect.DeepEqual(w.Claims, []string{"parade >= 2x vanilla on fulltext"}) {
+		t.Errorf("claims %q", w.Claims)
+	}
+	if len(w.Queries) != 2 {
+		t.Fatalf("%d queries, want 2", len(w.Queries))
+	}
+
+	fulltext := w.Queries[0]
+	if fulltext.Name != "fulltext" || fulltext.Category != "fulltext" {
+		t.Errorf("query %q in %q", fulltext.Name, fulltext.Category)
+	}
+	wantSQL := map[string]string{
+		"vanilla": "SELECT id FROM products\n-- ranked by title only\n" +
+			"WHERE to_tsvector('english', title) @@ plainto_tsquery('english', $1)\nLIMIT 10",
+		"parade": "SELECT id FROM products WHERE id @@@ paradedb.match('title', $1) LIMIT 10",
+	}
+	if !reflect.DeepEqual(fulltext.SQL, wantSQL) {
+		t.Errorf("sql %q, want %q", fulltext.SQL, wantSQL)
+	}
+	wantArgs := [][]interface{}{{"wireless headphones"}, {"apple iphone", 10}}
+	if !reflect.DeepEqual(fulltext.Args, wantArgs) {
+		t.Errorf("args %v, want %v", fulltext.Args, wantArgs)
+	}
+	wantPlan := map[string]PlanExpectation{"parade": {UsesIndex: []string{"products_search_idx"}, NoSeqScan: true}}
+	if !reflect.DeepEqual(fulltext.ExpectPlan, wantPlan) {
+		t.Errorf("expect_plan %+v, want %+v", fulltext.ExpectPlan, wantPlan)
+	}
+	if !reflect.DeepEqual(fulltext.Escape, map[string]string{"parade": "tantivy"}) {
+		t.Errorf("escape %v", fulltext.Escape)
+	}
+
+	fuzzy := w.Queries[1]
+	if !reflect.DeepEqual(fuzzy.SQL, map[string]string{"vanilla": "SELECT id FROM products WHERE title % $1 LIMIT 10"}) {
+		t.Errorf("fuzzy sql %q", fuzzy.SQL)
+	}
+	if fuzzy.Args != nil || fuzzy.Escape != nil {
+		t.Errorf("fuzzy args %v, escape %v", fuzzy.Args, fuzzy.Escape)
+	}
+}
+
+func TestParseSQLWorkloadComments(t *testing.T) {
+	// Comments between queries are dropped, and comments that don't start
+	// with a known key stay in the statement.
+	src := "-- Benchmarks for the docs\n" +
+		"-- name: q\n" +
+		"-- engine: vanilla\n" +
+		"-- note: keep the limit small\n" +
+		"SELECT 1;\n"
+	w, err := parseSQLWorkload(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "-- note: keep the limit small\nSELECT 1"
+	if got := w.Queries[0].SQL["vanilla"]; got != want {
+		t.Errorf("sql %q, want %q", got, want)
+	}
+}
+
+func TestParseSQLWorkloadErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "annotation before name",
+			src:  "-- workload: w\n-- engine: vanilla\nSELECT 1\n",
+			want: "line 2: -- engine: before the first -- name:",
+		},
+		{
+			name: "duplicate engine",
+			src:  "-- name: q\n-- engine: vanilla\nSELECT 1\n-- engine: vanilla\nSELECT 2\n",
+			want: "line 4: query q has two statements for vanilla",
+		},
+		{
+			name: "params not a list",
+			src:  "-- name: q\n-- params: {a: 1}\n",
+			want: "line 2: params must be a list",
+		},
+		{
+			name: "bad expect_plan",
+			src:  "-- name: q\n-- expect_plan: parade [oops\n",
+			want: "line 2: expect_plan must be",
+		},
+		{
+			name: "escape without mode",
+			src:  "-- name: q\n-- escape: parade\n",
+			want: "line 2: escape must be '<engine> <mode>'",
+		},
+		{
+			name: "bad weights",
+			src:  "-- weights: fulltext\n",
+			want: "line 1: ",
+		},
+		{
+			name: "empty statement",
+			src:  "-- name: q\n-- engine: vanilla\n;\n-- engine: parade\nSELECT 1\n",
+			want: "query q has an empty statement for vanilla",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSQLWorkload(tc.src)
+			if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+				t.Errorf("error %v, want prefix %q", err, tc.want)
+			}
+		})
+	}
+}
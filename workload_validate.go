@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// workloadCheckTimeout is the statement_timeout each validation run of a
+// query gets, so one slow statement does not hold up the rest
+var workloadCheckTimeout = 30 * time.Second
+
+var placeholderRE = regexp.MustCompile(`\$(\d+)`)
+
+// workloadProblem is one row of the workload validate table: FAIL would
+// break the run, WARN would leave something out of it.
+type workloadProblem struct {
+	Query, Engine, Status, Detail string
+}
+
+// maxPlaceholder is the highest $N a statement binds.
+func maxPlaceholder(stmt string) int {
+	n := 0
+	for _, m := range placeholderRE.FindAllStringSubmatch(stmt, -1) {
+		if i, _ := strconv.Atoi(m[1]); i > n {
+			n = i
+		}
+	}
+	return n
+}
+
+// staticWorkloadProblems checks what needs no database: every argument set
+// binds exactly the placeholders of each statement, weights and claims
+// name categories that exist, and plan expectations and escapes name
+// engines the query has statements for.
+func staticWorkloadProblems(w *Workload, targets []*benchTarget) []workloadProblem {
+	var problems []workloadProblem
+	add := func(query, engine, status, format string, args ...interface{}) {
+		problems = append(problems, workloadProblem{query, engine, status, fmt.Sprintf(format, args...)})
+	}
+
+	categories := map[string]bool{}
+	for _, q := range w.Queries {
+		categories[q.Category] = true
+		for _, engine := range sortedKeys(q.SQL) {
+			want := maxPlaceholder(q.SQL[engine])
+			for _, args := range q.Args {
+				if len(args) != want {
+					add(q.Name, engine, "FAIL", "statement binds %d parameter(s), argument set %s has %d", want, argsLabel(args), len(args))
+				}
+			}
+		}
+		for _, engine := range sortedKeys(q.Escape) {
+			if _, ok := q.SQL[engine]; !ok {
+				add(q.Name, engine, "WARN", "escape given for an engine without a statement")
+			}
+		}
+		for engine := range q.ExpectPlan {
+			if _, ok := q.SQL[engine]; ok {
+				continue
+			}
+			known := false
+			for _, t := range targets {
+				known = known || (t.Name == engine && q.SQL[t.sqlName()] != "")
+			}
+			if !known {
+				add(q.Name, engine, "WARN", "expect_plan given for an engine without a statement")
+			}
+		}
+		for _, t := range targets {
+			if _, ok := q.SQL[t.sqlName()]; !ok {
+				add(q.Name, t.Name, "WARN", "no %s statement, the query is not timed on %s", t.sqlName(), t.Label)
+			}
+		}
+	}
+	for _, cat := range sortedKeys(w.Weights) {
+		if !categories[cat] {
+			add("", "", "WARN", "weight for category %s, which no query has", cat)
+		}
+	}
+	for _, c := range w.claims {
+		if c.Category != "all" && !categories[c.Category] {
+			add("", "", "WARN", "claim %q is about category %s, which no query has", c.Text, c.Category)
+		}
+		for _, engine := range []string{c.Engine, c.Than} {
+			known := false
+			for _, t := range targets {
+				known = known || t.Name == engine
+			}
+			if !known {
+				add("", engine, "WARN", "claim %q names %s, which is not among the targets", c.Text, engine)
+			}
+		}
+	}
+	return problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// inRolledBackTx runs f in a transaction under workloadCheckTimeout and
+// rolls it back, so validation leaves nothing behind even for writes.
+func inRolledBackTx(db *sql.DB, f func(*sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", workloadCheckTimeout.Milliseconds())); err != nil {
+		return err
+	}
+	return f(tx)
+}
+
+// limitOne wraps a query so it returns at most one row; other statements
+// are run as they are.
+func limitOne(stmt string) string {
+	head := strings.ToUpper(strings.TrimSpace(stmt))
+	for _, kw := range []string{"SELECT", "WITH", "VALUES", "TABLE"} {
+		if strings.HasPrefix(head, kw) {
+			return "SELECT * FROM (" + stmt + "\n) AS workload_validate LIMIT 1"
+		}
+	}
+	return stmt
+}
+
+// liveWorkloadProblems checks the workload against one target: each
+// statement the target runs is parsed and planned by the server, run once
+// per argument set with LIMIT 1, and the indexes its plan expectations
+// name exist.
+func liveWorkloadProblems(w *Workload, t *benchTarget) []workloadProblem {
+	var problems []workloadProblem
+	fail := func(query string, err error, format string, args ...interface{}) {
+		problems = append(problems, workloadProblem{query, t.Name, "FAIL", fmt.Sprintf(format, args...) + ": " + err.Error()})
+	}
+
+	for _, q := range w.Queries {
+		stmt, ok := q.SQL[t.sqlName()]
+		if !ok {
+			continue
+		}
+		err := inRolledBackTx(t.DB, func(tx *sql.Tx) error {
+			prepared, err := tx.Prepare(stmt)
+			if err == nil {
+				prepared.Close()
+			}
+			return err
+		})
+		if err != nil {
+			fail(q.Name, err, "does not parse")
+			continue
+		}
+		for _, args := range q.Args {
+			err := inRolledBackTx(t.DB, func(tx *sql.Tx) error {
+				rows, err := tx.Query(limitOne(stmt), q.bind(t.sqlName(), args)...)
+				if err != nil {
+					return err
+				}
+				for rows.Next() {
+				}
+				rows.Close()
+				return rows.Err()
+			})
+			if err != nil {
+				fail(q.Name, err, "fails for %s", argsLabel(args))
+			}
+		}
+		for _, idx := range q.ExpectPlan[t.Name].UsesIndex {
+			var exists bool
+			if err := t.DB.QueryRow("SELECT to_regclass($1) IS NOT NULL", idx).Scan(&exists); err != nil {
+				fail(q.Name, err, "cannot look up index %s", idx)
+			} else if !exists {
+				problems = append(problems, workloadProblem{q.Name, t.Name, "FAIL", fmt.Sprintf("expect_plan names index %s, which does not exist", idx)})
+			}
+		}
+	}
+	return problems
+}
+
+// workloadCommand dispatches the workload subcommands; validate is the
+// only one.
+func workloadCommand(args []string) {
+	fs := flag.NewFlagSet("workload", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", dataDir, "directory where the dataset, status and results are kept")
+	addTargetFlags(fs)
+	fs.StringVar(&configPath, "config", configPath, "YAML or TOML (.toml) run configuration file")
+	fs.StringVar(&datasetName, "dataset", datasetName, "dataset whose workload is validated when no file is given: "+strings.Join(datasetNames(), ", "))
+	fs.DurationVar(&workloadCheckTimeout, "timeout", workloadCheckTimeout, "statement_timeout of each validation run of a query")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go run . workload validate [FILE] [flags]")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "validate" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	args = args[1:]
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+
+	var err error
+	if runConfig, err = loadRunConfig(configPath); err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := configureTargets(fs, runConfig); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if path == "" {
+		if err := selectDataset(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		if path = activeDataset.Workload(); path == "" {
+			log.Fatalf("the %s dataset has no workload of its own, name the file to validate", datasetName)
+		}
+	}
+	w, err := loadWorkload(path)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	targets := defaultTargets()
+	problems := staticWorkloadProblems(w, targets)
+	for _, t := range targets {
+		fmt.Printf("Checking %s against %s...\n", w.Name, t.Label)
+		db, err := getDB(t.Config)
+		if err != nil {
+			problems = append(problems, workloadProblem{"", t.Name, "FAIL", "cannot connect: " + err.Error()})
+			continue
+		}
+		t.DB = db
+		problems = append(problems, liveWorkloadProblems(w, t)...)
+		db.Close()
+	}
+
+	failed := 0
+	for _, p := range problems {
+		if p.Status == "FAIL" {
+			failed++
+		}
+	}
+	if len(problems) > 0 {
+		table := newConsoleTable("Query", "Engine", "Result", "Problem")
+		for _, p := range problems {
+			table.addRow(p.Query, p.Engine, p.Status, p.Detail)
+		}
+		fmt.Println()
+		table.render(os.Stdout)
+	}
+	if failed > 0 {
+		fmt.Printf("❌ %s: %d problem(s) would break the run\n", path, failed)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ %s: %d queries parse and run on every target\n", path, len(w.Queries))
+}
@@ -0,0 +1,40 @@
+-- Typo-tolerant search, as an example of an annotated SQL workload:
+--   go run . bench -workload workloads/amazon-fuzzy.sql
+-- workload: amazon-fuzzy
+-- plan_mismatch: flag
+
+-- name: fuzzy-title
+-- category: fuzzy
+-- params: ["samsu"]
+-- params: ["iphon"]
+-- params: ["wireles heaphones"]
+-- params: ["blutooth speker"]
+-- expect_plan: parade {uses_index: [products_search_idx], no_seq_scan: true}
+-- engine: vanilla
+SELECT id, title, brand, price, similarity(title, $1) AS sim
+FROM products
+WHERE title % $1
+ORDER BY sim DESC
+LIMIT 10;
+
+-- engine: parade
+SELECT id, title, brand, price
+FROM products
+WHERE id @@@ paradedb.match(field => 'title', value => $1, distance => 2)
+LIMIT 10;
+
+-- name: fuzzy-brand
+-- category: fuzzy
+-- params: ["samsnug"]
+-- params: ["logitec"]
+-- engine: vanilla
+SELECT id, title, brand, price
+FROM products
+WHERE brand % $1
+LIMIT 10;
+
+-- engine: parade
+SELECT id, title, brand, price
+FROM products
+WHERE id @@@ paradedb.match(field => 'brand', value => $1, distance => 2)
+LIMIT 10;